@@ -0,0 +1,39 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//Package admin provides the JSON API used by the admin panel: bundle management
+//and other operator tasks that would otherwise require shell access to the server.
+//All routes require the 'admin' role
+package admin
+
+import (
+	"net/http"
+
+	"github.com/jpincas/ghost/ghost"
+)
+
+//authMiddleware is the authentication chain supplied on activation
+var authMiddleware []func(http.Handler) http.Handler
+
+//Activate is the main package activation function.
+//Pass the middleware which authenticates the request and sets the 'role'
+//on the request context (e.g. the JWT middleware followed by auth.Authorizator)
+func Activate(authentication ...func(http.Handler) http.Handler) error {
+	ghost.Log("ADMIN", true, "Activating...", nil)
+	authMiddleware = authentication
+	ghost.OnBundlesChanged(invalidatePanelConfig)
+	//Set the routes for the package
+	setRoutes()
+	return nil
+}
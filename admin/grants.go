@@ -0,0 +1,141 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/pressly/chi"
+)
+
+const (
+	sqlToListRoles = `SELECT r.rolname AS name, r.rolcanlogin AS "canLogin", r.rolbypassrls AS "bypassRLS",
+	ARRAY(SELECT m.rolname FROM pg_auth_members am JOIN pg_roles m ON m.oid = am.roleid WHERE am.member = r.oid) AS "memberOf"
+	FROM pg_roles r WHERE r.rolname NOT LIKE '%s' ORDER BY r.rolname`
+
+	sqlToListRoleGrants = `SELECT n.nspname AS schema, c.relname AS table, g.privilege_type AS privilege, g.is_grantable AS grantable
+	FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	CROSS JOIN LATERAL aclexplode(c.relacl) g JOIN pg_roles r ON r.oid = g.grantee
+	WHERE r.rolname = '%s' AND c.relkind IN ('r', 'v', 'm') ORDER BY 1, 2, 3`
+)
+
+//grantsRequest is the body of a request to change permissions.
+//Revocations are applied before grants, all in a single transaction
+type grantsRequest struct {
+	Grant  []ghost.Grant `json:"grant"`
+	Revoke []ghost.Grant `json:"revoke"`
+}
+
+//listRoles returns all database roles other than the built-in pg_ roles
+func listRoles(w http.ResponseWriter, r *http.Request) {
+
+	respondWithQuery(w, &ghost.Query{
+		BaseSQL: sqlToListRoles,
+		SQLArgs: []interface{}{"pg_%"},
+		IsList:  true,
+		Role:    "admin",
+	})
+
+}
+
+//listRoleGrants returns every table privilege held by a role
+func listRoleGrants(w http.ResponseWriter, r *http.Request) {
+
+	role := chi.URLParam(r, "role")
+	if !ghost.IsValidIdentifier(role) {
+		ghost.WriteError(w, http.StatusBadRequest, "Invalid role name")
+		return
+	}
+
+	respondWithQuery(w, &ghost.Query{
+		BaseSQL: sqlToListRoleGrants,
+		SQLArgs: []interface{}{role},
+		IsList:  true,
+		Role:    "admin",
+	})
+
+}
+
+//applyGrants generates and executes GRANT and REVOKE statements.
+//Only the super user can reliably grant on tables created by bundle installation,
+//so the statements are run over a temporary super user connection
+func applyGrants(w http.ResponseWriter, r *http.Request) {
+
+	var body grantsRequest
+	if r.Body == nil {
+		ghost.WriteError(w, http.StatusBadRequest, "Invalid or absent request body")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		ghost.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	//Generate all the SQL up front so that nothing is run if any of it is invalid
+	var statements []string
+	for _, g := range body.Revoke {
+		sql, err := g.RevokeSQL()
+		if err != nil {
+			ghost.WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		statements = append(statements, sql)
+	}
+	for _, g := range body.Grant {
+		sql, err := g.GrantSQL()
+		if err != nil {
+			ghost.WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		statements = append(statements, sql)
+	}
+
+	if len(statements) == 0 {
+		ghost.WriteError(w, http.StatusBadRequest, "Nothing to grant or revoke")
+		return
+	}
+
+	db, err := ghost.SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		ghost.WriteError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		ghost.WriteError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			ghost.WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		ghost.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ghost.Log("ADMIN", true, "Permissions updated", nil)
+	ghost.WriteJSON(w, http.StatusOK, statements)
+
+}
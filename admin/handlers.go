@@ -0,0 +1,294 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/pressly/chi"
+)
+
+//bundleRequest is the body for a bundle installation request
+type bundleRequest struct {
+	Name     string `json:"name"`
+	DemoData bool   `json:"demodata"`
+}
+
+//listBundles returns the list of installed bundles
+func listBundles(w http.ResponseWriter, r *http.Request) {
+
+	ghost.WriteJSON(w, http.StatusOK, ghost.InstalledBundles())
+
+}
+
+//installBundle installs a bundle which is already present in the bundles folder.
+//Its public assets are served as soon as the installation completes
+func installBundle(w http.ResponseWriter, r *http.Request) {
+
+	var body bundleRequest
+	if r.Body == nil {
+		ghost.WriteError(w, http.StatusBadRequest, "Invalid or absent request body")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		ghost.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.Name == "" {
+		ghost.WriteError(w, http.StatusBadRequest, "No bundle name provided")
+		return
+	}
+
+	if err := ghost.InstallBundle(body.Name, body.DemoData); err != nil {
+		ghost.Log("ADMIN", false, "Installation of bundle "+body.Name+" failed", err)
+		ghost.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	ghost.Log("ADMIN", true, "Installation of bundle "+body.Name+" completed", nil)
+	ghost.WriteJSON(w, http.StatusCreated, ghost.InstalledBundles())
+
+}
+
+//unInstallBundle drops a bundle's schema and removes it from the installed list
+func unInstallBundle(w http.ResponseWriter, r *http.Request) {
+
+	bundleName := chi.URLParam(r, "name")
+	if !ghost.IsBundleInstalled(bundleName) {
+		ghost.WriteError(w, http.StatusNotFound, "Bundle is not installed")
+		return
+	}
+
+	if err := ghost.UninstallBundle(bundleName); err != nil {
+		ghost.Log("ADMIN", false, "Uninstallation of bundle "+bundleName+" failed", err)
+		ghost.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	ghost.Log("ADMIN", true, "Uninstallation of bundle "+bundleName+" completed", nil)
+	ghost.WriteJSON(w, http.StatusOK, ghost.InstalledBundles())
+
+}
+
+//bundleState reports whether a bundle is switched on
+type bundleState struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+//enableBundle switches a disabled bundle back on
+func enableBundle(w http.ResponseWriter, r *http.Request) {
+	setBundleEnabled(w, r, true)
+}
+
+//disableBundle switches a bundle off without uninstalling it
+func disableBundle(w http.ResponseWriter, r *http.Request) {
+	setBundleEnabled(w, r, false)
+}
+
+func setBundleEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+
+	bundleName := chi.URLParam(r, "name")
+	if !ghost.IsBundleInstalled(bundleName) {
+		ghost.WriteError(w, http.StatusNotFound, "Bundle is not installed")
+		return
+	}
+
+	if err := ghost.SetBundleEnabled(bundleName, enabled); err != nil {
+		ghost.Log("ADMIN", false, "Could not change state of bundle "+bundleName, err)
+		ghost.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+
+	ghost.Log("ADMIN", true, "Bundle "+bundleName+" is now "+state, nil)
+	ghost.WriteJSON(w, http.StatusOK, bundleState{bundleName, ghost.IsBundleEnabled(bundleName)})
+
+}
+
+//reloadConfig re-reads the config file and returns the config now in effect
+func reloadConfig(w http.ResponseWriter, r *http.Request) {
+
+	if err := ghost.App.ReloadConfig(); err != nil {
+		ghost.Log("ADMIN", false, "Config reload failed", err)
+		ghost.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ghost.WriteJSON(w, http.StatusOK, ghost.App.Config())
+
+}
+
+//maintenanceState is returned by the maintenance endpoints
+type maintenanceState struct {
+	Maintenance bool `json:"maintenance"`
+}
+
+//showMaintenance reports whether the server is in maintenance mode
+func showMaintenance(w http.ResponseWriter, r *http.Request) {
+	ghost.WriteJSON(w, http.StatusOK, maintenanceState{ghost.InMaintenance()})
+}
+
+//startMaintenance puts the server into maintenance mode
+func startMaintenance(w http.ResponseWriter, r *http.Request) {
+	ghost.SetMaintenanceMode(true)
+	ghost.WriteJSON(w, http.StatusOK, maintenanceState{ghost.InMaintenance()})
+}
+
+//endMaintenance takes the server out of maintenance mode
+func endMaintenance(w http.ResponseWriter, r *http.Request) {
+	ghost.SetMaintenanceMode(false)
+	ghost.WriteJSON(w, http.StatusOK, maintenanceState{ghost.InMaintenance()})
+}
+
+//listScheduledJobs reports on the scheduled jobs and how their last runs went
+func listScheduledJobs(w http.ResponseWriter, r *http.Request) {
+	ghost.WriteJSON(w, http.StatusOK, ghost.ScheduledJobs())
+}
+
+//runScheduledJob starts a scheduled job straight away.  It runs in the background,
+//so check on it with GET /admin/jobs
+func runScheduledJob(w http.ResponseWriter, r *http.Request) {
+
+	switch err := ghost.RunScheduledJob(chi.URLParam(r, "name")); err {
+	case nil:
+		ghost.WriteJSON(w, http.StatusAccepted, map[string]string{"message": "Job started"})
+	case ghost.ErrJobNotFound:
+		ghost.WriteError(w, http.StatusNotFound, err.Error())
+	case ghost.ErrJobRunning:
+		ghost.WriteError(w, http.StatusConflict, err.Error())
+	default:
+		ghost.WriteError(w, http.StatusInternalServerError, err.Error())
+	}
+
+}
+
+//showJobCounts counts the jobs in the queue of each type, by status
+func showJobCounts(w http.ResponseWriter, r *http.Request) {
+
+	counts, err := ghost.JobCounts()
+	if err != nil {
+		ghost.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ghost.WriteJSON(w, http.StatusOK, counts)
+
+}
+
+//listQueuedJobs lists the latest jobs in the queue, e.g. ?status=failed&type=email&limit=20
+func listQueuedJobs(w http.ResponseWriter, r *http.Request) {
+
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			ghost.WriteError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+	}
+
+	jobs, err := ghost.ListJobs(r.URL.Query().Get("status"), r.URL.Query().Get("type"), limit)
+	if err != nil {
+		ghost.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ghost.WriteJSON(w, http.StatusOK, jobs)
+
+}
+
+//listAudit returns entries from the audit trail, newest first, filtered by the query
+//parameters schema, table, record, user, operation, since and until (RFC 3339 times).
+//Pass the ID of the last entry as before to get the next page
+func listAudit(w http.ResponseWriter, r *http.Request) {
+
+	params := r.URL.Query()
+	q := ghost.AuditQuery{
+		Schema:    params.Get("schema"),
+		Table:     params.Get("table"),
+		RecordID:  params.Get("record"),
+		UserID:    params.Get("user"),
+		Operation: params.Get("operation"),
+	}
+
+	var err error
+	if l := params.Get("limit"); l != "" {
+		if q.Limit, err = strconv.Atoi(l); err != nil || q.Limit < 1 {
+			ghost.WriteError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+	}
+	if b := params.Get("before"); b != "" {
+		if q.Before, err = strconv.ParseInt(b, 10, 64); err != nil || q.Before < 1 {
+			ghost.WriteError(w, http.StatusBadRequest, "Invalid before")
+			return
+		}
+	}
+	for param, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := params.Get(param); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				ghost.WriteError(w, http.StatusBadRequest, "Invalid "+param+", use a time like 2017-06-01T00:00:00Z")
+				return
+			}
+		}
+	}
+
+	entries, err := ghost.ListAudit(q)
+	if err != nil {
+		ghost.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ghost.WriteJSON(w, http.StatusOK, entries)
+
+}
+
+//retryQueuedJob puts a job back in the queue to be tried again
+func retryQueuedJob(w http.ResponseWriter, r *http.Request) {
+	changeQueuedJob(w, r, ghost.RetryJob, "Job queued")
+}
+
+//deleteQueuedJob takes a job out of the queue
+func deleteQueuedJob(w http.ResponseWriter, r *http.Request) {
+	changeQueuedJob(w, r, ghost.DeleteJob, "Job deleted")
+}
+
+func changeQueuedJob(w http.ResponseWriter, r *http.Request, change func(int64) error, message string) {
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		ghost.WriteError(w, http.StatusBadRequest, "Invalid job id")
+		return
+	}
+
+	switch err := change(id); err {
+	case nil:
+		ghost.WriteJSON(w, http.StatusOK, map[string]string{"message": message})
+	case ghost.ErrJobNotQueued:
+		ghost.WriteError(w, http.StatusNotFound, err.Error())
+	default:
+		ghost.WriteError(w, http.StatusInternalServerError, err.Error())
+	}
+
+}
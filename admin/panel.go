@@ -0,0 +1,115 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/afero"
+)
+
+//panelConfig is the admin panel configuration contributed by all installed bundles:
+//each bundle's view.json keyed by bundle name, and all of their menu.json entries
+//concatenated into a single menu
+type panelConfig struct {
+	Views map[string]json.RawMessage `json:"views"`
+	Menu  []json.RawMessage          `json:"menu"`
+}
+
+//panelConfigCache holds the marshalled panel config.  Building it means reading two
+//files per bundle, so it is only rebuilt after the installed bundles change
+var panelConfigCache struct {
+	sync.Mutex
+	json []byte
+}
+
+//invalidatePanelConfig clears the cache so that the next request rebuilds it
+func invalidatePanelConfig() {
+
+	panelConfigCache.Lock()
+	panelConfigCache.json = nil
+	panelConfigCache.Unlock()
+
+}
+
+//showPanelConfig returns the admin panel configuration for the installed bundles
+func showPanelConfig(w http.ResponseWriter, r *http.Request) {
+
+	panelConfigCache.Lock()
+	defer panelConfigCache.Unlock()
+
+	if panelConfigCache.json == nil {
+		b, err := json.Marshal(buildPanelConfig())
+		if err != nil {
+			ghost.WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		panelConfigCache.json = b
+	}
+
+	w.Header().Set("Content-Type", ghost.ContentTypeJSON)
+	w.Write(panelConfigCache.json)
+
+}
+
+//buildPanelConfig reads view.json and menu.json from the admin-panel folder of each
+//enabled bundle.  Bundles without them are skipped, as are files which aren't valid JSON
+func buildPanelConfig() panelConfig {
+
+	config := panelConfig{
+		Views: map[string]json.RawMessage{},
+		Menu:  []json.RawMessage{},
+	}
+
+	for _, bundleName := range ghost.EnabledBundles() {
+
+		if view, ok := readBundleJSON(bundleName, "view.json"); ok {
+			config.Views[bundleName] = view
+		}
+
+		if menu, ok := readBundleJSON(bundleName, "menu.json"); ok {
+			//A menu can be a single entry or a list of them
+			var entries []json.RawMessage
+			if err := json.Unmarshal(menu, &entries); err != nil {
+				entries = []json.RawMessage{menu}
+			}
+			config.Menu = append(config.Menu, entries...)
+		}
+
+	}
+
+	return config
+
+}
+
+//readBundleJSON reads a JSON file from a bundle's admin-panel folder
+func readBundleJSON(bundleName, fileName string) (json.RawMessage, bool) {
+
+	b, err := afero.ReadFile(ghost.App.FileSystem, ghost.BundlePath(bundleName, "admin-panel", fileName))
+	if err != nil {
+		return nil, false
+	}
+
+	if !json.Valid(b) {
+		ghost.Log("ADMIN", false, "Ignoring invalid "+fileName+" in bundle "+bundleName, nil)
+		return nil, false
+	}
+
+	return json.RawMessage(b), true
+
+}
@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/afero"
+)
+
+func TestPanelConfig(t *testing.T) {
+
+	ghost.App.FileSystem = afero.NewMemMapFs()
+	afero.WriteFile(ghost.App.FileSystem, "bundles/shop/admin-panel/view.json", []byte(`{"title":"Shop"}`), 0644)
+	afero.WriteFile(ghost.App.FileSystem, "bundles/shop/admin-panel/menu.json", []byte(`[{"label":"Products"},{"label":"Orders"}]`), 0644)
+	afero.WriteFile(ghost.App.FileSystem, "bundles/blog/admin-panel/menu.json", []byte(`{"label":"Posts"}`), 0644)
+	afero.WriteFile(ghost.App.FileSystem, "bundles/notinstalled/admin-panel/menu.json", []byte(`{"label":"Hidden"}`), 0644)
+	c := *ghost.App.Config()
+	c.BundlesInstalled = ghost.Bundles{"shop", "blog"}
+	ghost.App.SetConfig(c)
+
+	expected := `{"views":{"shop":{"title":"Shop"}},"menu":[{"label":"Products"},{"label":"Orders"},{"label":"Posts"}]}`
+
+	rr := httptest.NewRecorder()
+	showPanelConfig(rr, httptest.NewRequest("GET", "/admin/config/panel", nil))
+	if rr.Body.String() != expected {
+		ghost.TestErrorFatal(t, "Only installed bundles are included", rr.Body.String(), expected)
+	}
+
+	//Served from the cache until invalidated
+	c.BundlesInstalled = ghost.Bundles{"blog"}
+	ghost.App.SetConfig(c)
+	rr = httptest.NewRecorder()
+	showPanelConfig(rr, httptest.NewRequest("GET", "/admin/config/panel", nil))
+	if rr.Body.String() != expected {
+		ghost.TestErrorFatal(t, "Cached result is returned", rr.Body.String(), expected)
+	}
+
+	invalidatePanelConfig()
+	expected = `{"views":{},"menu":[{"label":"Posts"}]}`
+	rr = httptest.NewRecorder()
+	showPanelConfig(rr, httptest.NewRequest("GET", "/admin/config/panel", nil))
+	if rr.Body.String() != expected {
+		ghost.TestErrorFatal(t, "Result is rebuilt after invalidation", rr.Body.String(), expected)
+	}
+
+}
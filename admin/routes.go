@@ -0,0 +1,95 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/pressly/chi"
+)
+
+//panelPath is where the admin panel single page app is served
+const panelPath = "/admin/panel"
+
+//SetRoutes adds the routes the router
+func setRoutes() {
+
+	//The admin panel itself is public - it is just static files,
+	//and it authenticates against the API below
+	ghost.RegisterWebRoutes(panelPath + "/")
+	ghost.App.Router.Route(panelPath, func(r chi.Router) {
+
+		//Extra static folders (e.g. a vendor or node_modules folder) can be mounted
+		//alongside the panel, in whatever layout the panel's build tool produces
+		for mountPath, dir := range ghost.App.Config().AdminPanelMounts {
+			prefix := path.Join(panelPath, "/", mountPath)
+			r.Mount("/"+strings.Trim(mountPath, "/"), http.StripPrefix(prefix, ghost.StaticHandler(dir)))
+		}
+
+		r.Mount("/", http.StripPrefix(panelPath, ghost.SPAHandler(ghost.App.Config().AdminPanelDir)))
+
+	})
+
+	ghost.App.Router.Route("/admin", func(r chi.Router) {
+
+		for _, m := range authMiddleware {
+			r.Use(m)
+		}
+		r.Use(ghost.RequireRole("admin"))
+
+		r.Get("/bundles", listBundles)
+		r.Post("/bundles", installBundle)
+		r.Delete("/bundles/{name}", unInstallBundle)
+		r.Post("/bundles/{name}/enable", enableBundle)
+		r.Post("/bundles/{name}/disable", disableBundle)
+
+		r.Post("/config/reload", reloadConfig)
+		r.Get("/config/panel", showPanelConfig)
+
+		//Maintenance mode
+		r.Get("/maintenance", showMaintenance)
+		r.Post("/maintenance", startMaintenance)
+		r.Delete("/maintenance", endMaintenance)
+
+		//Scheduled jobs
+		r.Get("/jobs", listScheduledJobs)
+		r.Post("/jobs/{name}/run", runScheduledJob)
+
+		//Job queue
+		r.Get("/queue", showJobCounts)
+		r.Get("/queue/jobs", listQueuedJobs)
+		r.Post("/queue/jobs/{id}/retry", retryQueuedJob)
+		r.Delete("/queue/jobs/{id}", deleteQueuedJob)
+
+		//Audit trail
+		r.Get("/audit", listAudit)
+
+		//Database browser
+		r.Get("/schemas", listSchemas)
+		r.Get("/schemas/{schema}/tables", listTables)
+		r.Get("/schemas/{schema}/tables/{table}", describeTable)
+		r.Get("/schemas/{schema}/tables/{table}/columns", listColumns)
+		r.Get("/schemas/{schema}/tables/{table}/grants", listTableGrants)
+
+		//Roles and permissions
+		r.Get("/roles", listRoles)
+		r.Get("/roles/{role}/grants", listRoleGrants)
+		r.Post("/grants", applyGrants)
+
+	})
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"net/http"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/pressly/chi"
+)
+
+//SQL for the schema browser. All queries go through the catalog so that they work
+//regardless of the privileges on the tables themselves
+const (
+	sqlToListSchemas = `SELECT n.nspname AS name, r.rolname AS owner,
+	(SELECT count(*) FROM pg_class c WHERE c.relnamespace = n.oid AND c.relkind IN ('r', 'v', 'm')) AS tables
+	FROM pg_namespace n JOIN pg_roles r ON r.oid = n.nspowner
+	WHERE n.nspname NOT LIKE '%s' AND n.nspname <> '%s' ORDER BY n.nspname`
+
+	sqlToListTables = `SELECT c.relname AS name,
+	CASE c.relkind WHEN 'r' THEN 'table' WHEN 'v' THEN 'view' WHEN 'm' THEN 'materialized view' END AS type,
+	COALESCE(s.n_live_tup, 0) AS rows, obj_description(c.oid, 'pg_class') AS description
+	FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	LEFT JOIN pg_stat_user_tables s ON s.relid = c.oid
+	WHERE n.nspname = '%s' AND c.relkind IN ('r', 'v', 'm') ORDER BY c.relname`
+
+	sqlToDescribeTable = `SELECT n.nspname AS schema, c.relname AS name,
+	CASE c.relkind WHEN 'r' THEN 'table' WHEN 'v' THEN 'view' WHEN 'm' THEN 'materialized view' END AS type,
+	COALESCE(s.n_live_tup, 0) AS rows, obj_description(c.oid, 'pg_class') AS description
+	FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	LEFT JOIN pg_stat_user_tables s ON s.relid = c.oid
+	WHERE n.nspname = '%s' AND c.relname = '%s'`
+
+	sqlToListColumns = `SELECT a.attname AS name, format_type(a.atttypid, a.atttypmod) AS type,
+	NOT a.attnotnull AS nullable, pg_get_expr(d.adbin, d.adrelid) AS default,
+	COALESCE((SELECT true FROM pg_index i WHERE i.indrelid = c.oid AND i.indisprimary AND a.attnum = ANY(i.indkey)), false) AS "primaryKey",
+	col_description(c.oid, a.attnum) AS description
+	FROM pg_attribute a JOIN pg_class c ON c.oid = a.attrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+	LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+	WHERE n.nspname = '%s' AND c.relname = '%s' AND a.attnum > 0 AND NOT a.attisdropped ORDER BY a.attnum`
+
+	sqlToListTableGrants = `SELECT COALESCE(r.rolname, 'PUBLIC') AS role, g.privilege_type AS privilege, g.is_grantable AS grantable
+	FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	CROSS JOIN LATERAL aclexplode(c.relacl) g LEFT JOIN pg_roles r ON r.oid = g.grantee
+	WHERE n.nspname = '%s' AND c.relname = '%s' ORDER BY 1, 2`
+)
+
+//listSchemas returns all user schemas with their owner and number of tables
+func listSchemas(w http.ResponseWriter, r *http.Request) {
+
+	respondWithQuery(w, &ghost.Query{
+		BaseSQL: sqlToListSchemas,
+		SQLArgs: []interface{}{"pg_%", "information_schema"},
+		IsList:  true,
+		Role:    "admin",
+	})
+
+}
+
+//listTables returns the tables and views in a schema with estimated row counts
+func listTables(w http.ResponseWriter, r *http.Request) {
+
+	schema, _, ok := schemaAndTable(w, r, false)
+	if !ok {
+		return
+	}
+
+	respondWithQuery(w, &ghost.Query{
+		BaseSQL: sqlToListTables,
+		SQLArgs: []interface{}{schema},
+		IsList:  true,
+		Role:    "admin",
+	})
+
+}
+
+//describeTable returns a single table with its exact row count
+func describeTable(w http.ResponseWriter, r *http.Request) {
+
+	schema, table, ok := schemaAndTable(w, r, true)
+	if !ok {
+		return
+	}
+
+	respondWithQuery(w, &ghost.Query{
+		BaseSQL: sqlToDescribeTable,
+		SQLArgs: []interface{}{schema, table},
+		Role:    "admin",
+	})
+
+}
+
+//listColumns returns the columns of a table
+func listColumns(w http.ResponseWriter, r *http.Request) {
+
+	schema, table, ok := schemaAndTable(w, r, true)
+	if !ok {
+		return
+	}
+
+	respondWithQuery(w, &ghost.Query{
+		BaseSQL: sqlToListColumns,
+		SQLArgs: []interface{}{schema, table},
+		IsList:  true,
+		Role:    "admin",
+	})
+
+}
+
+//listTableGrants returns the privileges each role holds on a table
+func listTableGrants(w http.ResponseWriter, r *http.Request) {
+
+	schema, table, ok := schemaAndTable(w, r, true)
+	if !ok {
+		return
+	}
+
+	respondWithQuery(w, &ghost.Query{
+		BaseSQL: sqlToListTableGrants,
+		SQLArgs: []interface{}{schema, table},
+		IsList:  true,
+		Role:    "admin",
+	})
+
+}
+
+//schemaAndTable reads and validates the schema (and optionally table) URL parameters.
+//If they are not valid identifiers, an error response is written and ok is false
+func schemaAndTable(w http.ResponseWriter, r *http.Request, withTable bool) (schema, table string, ok bool) {
+
+	schema = ghost.HyphensToUnderscores(chi.URLParam(r, "schema"))
+	if !ghost.IsValidIdentifier(schema) {
+		ghost.WriteError(w, http.StatusBadRequest, "Invalid schema name")
+		return "", "", false
+	}
+
+	if withTable {
+		table = ghost.HyphensToUnderscores(chi.URLParam(r, "table"))
+		if !ghost.IsValidIdentifier(table) {
+			ghost.WriteError(w, http.StatusBadRequest, "Invalid table name")
+			return "", "", false
+		}
+	}
+
+	return schema, table, true
+
+}
+
+//respondWithQuery executes the query and writes the JSON returned by Postgres
+func respondWithQuery(w http.ResponseWriter, q *ghost.Query) {
+
+	result, err := ghost.App.Store.Execute(q)
+	if err != nil {
+		ghost.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	//Empty results come back as a blank string
+	if result == "" {
+		if !q.IsList {
+			ghost.WriteError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		result = "[]"
+	}
+
+	w.Header().Set("Content-Type", ghost.ContentTypeJSON)
+	w.Write([]byte(result))
+
+}
@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	ghost "github.com/jpincas/ghost/tools"
 	uuid "github.com/satori/go.uuid"
@@ -184,6 +185,7 @@ func requestLogin(w http.ResponseWriter, r *http.Request) {
 
 		}
 
+		ghost.PublishEvent(ghost.UserLoggedIn{UserID: id, Email: email.(string), Time: time.Now()})
 		b, _ := json.Marshal(map[string]string{
 			"token": tokenString,
 		})
@@ -206,6 +208,7 @@ func requestLogin(w http.ResponseWriter, r *http.Request) {
 
 		}
 
+		ghost.PublishEvent(ghost.UserLoggedIn{UserID: id, Email: email.(string), Time: time.Now()})
 		b, _ := json.Marshal(map[string]string{
 			"token": tokenString,
 		})
@@ -0,0 +1,74 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+//sqlToCreateOrPromoteAdmin adds an admin user, or makes an existing user an admin.
+//xmax is only 0 for a row which has just been inserted
+const sqlToCreateOrPromoteAdmin = `INSERT INTO users(email, role) VALUES ($1, 'admin')
+	ON CONFLICT (email) DO UPDATE SET role = 'admin'
+	RETURNING (xmax = 0);`
+
+func init() {
+	RootCmd.AddCommand(createAdminCmd)
+}
+
+// createAdminCmd represents the createadmin command
+var createAdminCmd = &cobra.Command{
+	Use:   "createadmin [email]",
+	Short: "Create an admin user, or make an existing user an admin",
+	Long: `Creates a user with the admin role, e.g. to bootstrap the first admin after
+	'ghost init'.  If a user with the email address already exists, they are given the
+	admin role instead`,
+	RunE: createAdmin,
+}
+
+func createAdmin(cmd *cobra.Command, args []string) error {
+
+	if len(args) != 1 || !strings.Contains(args[0], "@") {
+		return errors.New("the admin's email address must be provided")
+	}
+
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	//Establish a temporary connection as the super user
+	db, err := ghost.SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		ghost.LogFatal("NEW", false, "Could not connect to the database", err)
+	}
+	defer db.Close()
+
+	var created bool
+	if err := db.QueryRow(sqlToCreateOrPromoteAdmin, strings.TrimSpace(args[0])).Scan(&created); err != nil {
+		ghost.LogFatal("NEW", false, "Could not create admin user. Has 'ghost init' been run?", err)
+	}
+
+	if created {
+		ghost.Log("NEW", true, "Created admin user "+args[0], nil)
+	} else {
+		ghost.Log("NEW", true, "Existing user "+args[0]+" is now an admin", nil)
+	}
+
+	return nil
+
+}
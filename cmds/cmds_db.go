@@ -0,0 +1,224 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var importMappings []string
+var importFormat, importDelimiter string
+var importBatchSize int
+var importDryRun bool
+var exportFilters []string
+var exportFormat, exportDir string
+var exportToStorage bool
+
+func init() {
+	RootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbImportCmd)
+	dbImportCmd.Flags().StringArrayVarP(&importMappings, "map", "m", nil, "Map a file column to a table column, e.g. --map \"Email Address=email\".  Leave the table column empty to skip a file column")
+	dbImportCmd.Flags().StringVar(&importFormat, "format", "", "csv or json (default from the file extension)")
+	dbImportCmd.Flags().StringVar(&importDelimiter, "delimiter", ",", "Field delimiter for CSV files")
+	dbImportCmd.Flags().IntVar(&importBatchSize, "batch", 1000, "Number of rows sent to the database at a time")
+	dbImportCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "Check the file loads without keeping any of the data")
+	dbCmd.AddCommand(dbExportCmd)
+	dbExportCmd.Flags().StringArrayVarP(&exportFilters, "filter", "f", nil, "Only export rows with this column value, e.g. --filter user_id=42.  Rows matching any filter are exported")
+	dbExportCmd.Flags().StringVar(&exportFormat, "format", "csv", "csv or json")
+	dbExportCmd.Flags().StringVarP(&exportDir, "output", "o", "export", "Folder to write the files to")
+	dbExportCmd.Flags().BoolVar(&exportToStorage, "storage", false, "Write the files to the configured storage, e.g. an S3 bucket, rather than local disk")
+}
+
+// dbCmd represents the db command
+var dbCmd = &cobra.Command{
+	Use:   "db [command]",
+	Short: "Move data in and out of the database",
+}
+
+// dbImportCmd represents the db import command
+var dbImportCmd = &cobra.Command{
+	Use:   "import [schema.table] [file]",
+	Short: "Import a CSV or JSON file into a table",
+	Long: `Loads a CSV file, with a header row, or a JSON file, holding an array of objects
+	or one object per line, into a table.  File columns go into the table columns of
+	the same name unless mapped with --map.  Values are converted to the column types,
+	so that e.g. 'yes' goes into a boolean column and an empty CSV field is NULL in any
+	but a text column.  Rows are sent in batches using COPY, all in one transaction, so
+	nothing is imported if any row fails.  --dry-run does the whole import and then
+	rolls it back`,
+	RunE: dbImport,
+}
+
+// dbExportCmd represents the db export command
+var dbExportCmd = &cobra.Command{
+	Use:   "export [schema|schema.table...]",
+	Short: "Export tables to CSV or JSON files",
+	Long: `Writes each table named, or every table in each schema named, to its own file,
+	e.g. export/shop.orders.csv.  With --filter, only rows in which one of the filter
+	columns has the value given are exported, and tables with none of the filter
+	columns are left out - e.g. 'ghost db export public shop --filter id=42 --filter
+	user_id=42' gathers everything held about a user for a subject access request.
+	With --storage, the files go to the storage set up in the config, so exports
+	can be kept as backups in a bucket`,
+	RunE: dbExport,
+}
+
+func dbImport(cmd *cobra.Command, args []string) error {
+
+	if len(args) != 2 {
+		return errors.New("a table, as schema.table, and a file must be provided")
+	}
+
+	table := strings.SplitN(args[0], ".", 2)
+	if len(table) != 2 {
+		return errors.New("the table must be given as schema.table")
+	}
+
+	opts, err := importOptions(args[1])
+	if err != nil {
+		return err
+	}
+
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	file, err := os.Open(args[1])
+	if err != nil {
+		ghost.LogFatal("IMPORT", false, "Could not open "+args[1], err)
+	}
+	defer file.Close()
+
+	result, err := ghost.ImportData(table[0], table[1], file, opts)
+	if err != nil {
+		ghost.LogFatal("IMPORT", false, "Import into "+args[0]+" failed. Nothing was imported", err)
+	}
+
+	if opts.DryRun {
+		ghost.Log("IMPORT", true, "Dry run: "+strconv.Itoa(result.Rows)+" rows would be imported into "+args[0]+" ("+strings.Join(result.Columns, ", ")+")", nil)
+		return nil
+	}
+
+	ghost.Log("IMPORT", true, "Imported "+strconv.Itoa(result.Rows)+" rows into "+args[0]+" in "+strconv.Itoa(result.Batches)+" batches", nil)
+	return nil
+
+}
+
+//importOptions reads the import flags
+func importOptions(fileName string) (ghost.ImportOptions, error) {
+
+	opts := ghost.ImportOptions{
+		Format:    importFormat,
+		Mapping:   map[string]string{},
+		BatchSize: importBatchSize,
+		DryRun:    importDryRun,
+	}
+
+	if opts.Format == "" {
+		format, err := ghost.ImportFormat(fileName)
+		if err != nil {
+			return opts, err
+		}
+		opts.Format = format
+	}
+
+	if importDelimiter == `\t` {
+		importDelimiter = "\t"
+	}
+	if utf8.RuneCountInString(importDelimiter) != 1 {
+		return opts, errors.New("the delimiter must be a single character")
+	}
+	opts.Delimiter, _ = utf8.DecodeRuneInString(importDelimiter)
+
+	for _, mapping := range importMappings {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 {
+			return opts, errors.New("mappings are written file_column=table_column, not '" + mapping + "'")
+		}
+		opts.Mapping[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return opts, nil
+
+}
+
+func dbExport(cmd *cobra.Command, args []string) error {
+
+	if len(args) == 0 {
+		return errors.New("the schemas, or schema.tables, to export must be provided")
+	}
+
+	opts := ghost.ExportOptions{
+		Format: exportFormat,
+		Filter: map[string]string{},
+		Dir:    exportDir,
+	}
+	for _, filter := range exportFilters {
+		parts := strings.SplitN(filter, "=", 2)
+		if len(parts) != 2 {
+			return errors.New("filters are written column=value, not '" + filter + "'")
+		}
+		opts.Filter[strings.TrimSpace(parts[0])] = parts[1]
+	}
+
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	//Exports for storage are written in memory, then copied across
+	var fs afero.Fs = afero.NewOsFs()
+	if exportToStorage {
+		fs = afero.NewMemMapFs()
+	}
+
+	results, err := ghost.ExportData(fs, args, opts)
+	for _, result := range results {
+		if exportToStorage {
+			if err := storeExport(fs, result.File); err != nil {
+				ghost.LogFatal("EXPORT", false, "Could not write "+result.File+" to storage", err)
+			}
+		}
+		ghost.Log("EXPORT", true, "Exported "+strconv.Itoa(result.Rows)+" rows from "+result.Table+" to "+result.File, nil)
+	}
+	if err != nil {
+		ghost.LogFatal("EXPORT", false, "Export failed", err)
+	}
+	if len(results) == 0 {
+		ghost.Log("EXPORT", false, "No tables have the filter columns, so nothing was exported", nil)
+	}
+
+	return nil
+
+}
+
+//storeExport copies an export file into the configured storage
+func storeExport(fs afero.Fs, fileName string) error {
+
+	f, err := fs.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return ghost.App.Storage.Put(context.Background(), filepath.ToSlash(fileName), f, "")
+
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"errors"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var isForceDemoData bool
+
+func init() {
+	bundleCmd.AddCommand(bundleDemoDataCmd)
+	bundleDemoDataCmd.Flags().BoolVarP(&isForceDemoData, "force", "f", false, "Load demo data even if it has been loaded before")
+}
+
+// bundleDemoDataCmd represents the bundle demodata command
+var bundleDemoDataCmd = &cobra.Command{
+	Use:   "demodata [bundle...]",
+	Short: "Load demo data for installed bundles",
+	Long: `Loads the files in each bundle's demodata folder, in name order, in a single transaction.
+	SQL files are run as they are.  JSON files hold an array of records for the table they are
+	named after, with an optional numeric prefix for ordering: demodata/01_products.json is
+	loaded into the bundle's products table.
+	Demo data is only loaded once per bundle unless --force is used.  Starting the server with
+	--demomode loads demo data for every installed bundle which hasn't had it yet`,
+	RunE: loadDemoData,
+}
+
+func loadDemoData(cmd *cobra.Command, args []string) error {
+
+	if len(args) < 1 {
+		return errors.New("a bundle name must be provided")
+	}
+
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	for _, bundleName := range args {
+		if err := ghost.LoadBundleDemoData(bundleName, isForceDemoData); err != nil {
+			ghost.LogFatal("DEMODATA", false, "Demo data for bundle "+bundleName+" could not be loaded", err)
+		}
+		ghost.Log("DEMODATA", true, "Demo data loaded for bundle "+bundleName, nil)
+	}
+
+	return nil
+
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().String("smtppw", "", "SMTP server password for outgoing mail")
+	doctorCmd.Flags().StringP("secret", "s", "", "Secure secret for signing JWT, if not in GHOST_SECRET or the config")
+}
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the configuration and environment",
+	Long: `Checks everything the server needs before it starts: that the config file has
+	every setting, that the database can be reached and has been initialised, that the
+	secret is strong, that the SMTP server accepts the email settings, that the installed
+	bundles are valid and that the ports are free.  Pass --pgpw, --smtppw and --secret as
+	you would to the server.  Exits with an error if there are any problems`,
+	RunE: doctor,
+}
+
+func doctor(cmd *cobra.Command, args []string) error {
+
+	//Bound here rather than in init, so as not to take the flags over from serve
+	viper.BindPFlag("smtppw", cmd.Flags().Lookup("smtppw"))
+	if cmd.Flags().Changed("secret") {
+		viper.BindPFlag("secret", cmd.Flags().Lookup("secret"))
+	}
+
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	configFile, err := ioutil.ReadFile(viper.ConfigFileUsed())
+	if err != nil {
+		ghost.LogFatal("DOCTOR", false, "Could not read the config file", err)
+	}
+
+	problems := 0
+	for _, result := range ghost.RunDoctor(configFile) {
+
+		label := map[string]string{ghost.DoctorOK: " OK ", ghost.DoctorWarning: "WARN", ghost.DoctorProblem: "FAIL"}[result.Status]
+		fmt.Printf("[%s] %s: %s\n", label, result.Check, strings.Replace(result.Detail, "\n", "\n       ", -1))
+
+		if result.Status == ghost.DoctorProblem {
+			problems++
+		}
+
+	}
+
+	if problems > 0 {
+		fmt.Printf("\n%d problem(s) found\n", problems)
+		os.Exit(1)
+	}
+
+	return nil
+
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RootCmd.AddCommand(emailCmd)
+	emailCmd.AddCommand(emailTestCmd)
+	emailTestCmd.Flags().String("smtppw", "", "SMTP server password for outgoing mail")
+}
+
+// emailCmd groups the email commands
+var emailCmd = &cobra.Command{
+	Use:   "email",
+	Short: "Check the email system",
+}
+
+// emailTestCmd represents the email test command
+var emailTestCmd = &cobra.Command{
+	Use:   "test [address]",
+	Short: "Send a test email",
+	Long: `Checks the email settings in the config and sends a test message to the address,
+	using the SMTP password given with --smtppw, as for serve.  If it fails, every step
+	of the conversation with the SMTP server is printed, showing where it went wrong`,
+	RunE: testEmail,
+}
+
+func testEmail(cmd *cobra.Command, args []string) error {
+
+	if len(args) != 1 {
+		return errors.New("an email address must be provided")
+	}
+
+	//Bound here rather than in init, so as not to take the flag over from serve
+	viper.BindPFlag("smtppw", cmd.Flags().Lookup("smtppw"))
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	transcript, err := ghost.SendTestEmail(args[0])
+	if err != nil {
+		for _, step := range transcript {
+			fmt.Println("  " + step)
+		}
+		ghost.LogFatal("EMAIL", false, "Test email could not be sent", err)
+	}
+
+	ghost.Log("EMAIL", true, "Test email sent to "+args[0], nil)
+	return nil
+
+}
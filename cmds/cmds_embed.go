@@ -0,0 +1,81 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"os"
+	"strings"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	embedOutput  string
+	embedPackage string
+	embedFolders string
+)
+
+func init() {
+	bundleCmd.AddCommand(bundleEmbedCmd)
+	bundleEmbedCmd.Flags().StringVarP(&embedOutput, "output", "o", "bundles_embedded.go", "File to write the generated Go source to")
+	bundleEmbedCmd.Flags().StringVar(&embedPackage, "package", "main", "Package name for the generated Go source")
+	bundleEmbedCmd.Flags().StringVar(&embedFolders, "folders", "", "Comma separated bundle folders to embed, e.g. templates,public (default everything)")
+}
+
+// bundleEmbedCmd represents the bundle embed command
+var bundleEmbedCmd = &cobra.Command{
+	Use:   "embed [bundle...]",
+	Short: "Compile bundle files into the binary",
+	Long: `Generates a Go source file which compiles bundle files into your binary, so that
+	a deployment can be a single executable with no bundles folder.  Build your program
+	with the generated file and the embedded files are read as if they were in the bundles
+	folder.  Files that are on disk take precedence over embedded ones.
+	With no bundles named, every installed bundle is embedded.  Regenerate the file
+	whenever the bundles change`,
+	RunE: embedBundles,
+}
+
+func embedBundles(cmd *cobra.Command, args []string) error {
+
+	bundleNames := args
+	if len(bundleNames) == 0 {
+		ghost.App.Setup(viper.GetString("configfile"))
+		bundleNames = ghost.InstalledBundles()
+	}
+
+	var folders []string
+	if embedFolders != "" {
+		folders = strings.Split(embedFolders, ",")
+	}
+
+	f, err := os.Create(embedOutput)
+	if err != nil {
+		ghost.LogFatal("EMBED", false, "Could not create "+embedOutput, err)
+	}
+	defer f.Close()
+
+	if err := ghost.GenerateEmbeddedBundles(afero.NewOsFs(), f, embedPackage, bundleNames, folders); err != nil {
+		f.Close()
+		os.Remove(embedOutput)
+		ghost.LogFatal("EMBED", false, "Could not embed bundles", err)
+	}
+
+	ghost.Log("EMBED", true, "Bundles "+strings.Join(bundleNames, ", ")+" embedded in "+embedOutput, nil)
+	return nil
+
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"errors"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	bundleCmd.AddCommand(bundleEnableCmd)
+	bundleCmd.AddCommand(bundleDisableCmd)
+}
+
+// bundleEnableCmd represents the bundle enable command
+var bundleEnableCmd = &cobra.Command{
+	Use:   "enable [bundle...]",
+	Short: "Switch disabled bundles back on",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setBundlesEnabled(args, true)
+	},
+}
+
+// bundleDisableCmd represents the bundle disable command
+var bundleDisableCmd = &cobra.Command{
+	Use:   "disable [bundle...]",
+	Short: "Switch bundles off without uninstalling them",
+	Long: `Switches bundles off without dropping their tables.  A disabled bundle's public
+	assets, admin panel configuration, hooks and routes are unavailable until it is
+	enabled again.  A running server picks up the change when its config is reloaded
+	(send it SIGHUP), or make the change through the admin API to apply it immediately`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setBundlesEnabled(args, false)
+	},
+}
+
+func setBundlesEnabled(bundleNames []string, enabled bool) error {
+
+	if len(bundleNames) < 1 {
+		return errors.New("a bundle name must be provided")
+	}
+
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+
+	for _, bundleName := range bundleNames {
+		if err := ghost.SetBundleEnabled(bundleName, enabled); err != nil {
+			ghost.LogFatal("BUNDLE", false, "Bundle "+bundleName+" could not be "+state, err)
+		}
+		ghost.Log("BUNDLE", true, "Bundle "+bundleName+" "+state, nil)
+	}
+
+	return nil
+
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var writeSecret, generateRSA bool
+var rsaBits int
+var rsaKeyDir string
+
+func init() {
+	RootCmd.AddCommand(genSecretCmd)
+	genSecretCmd.Flags().BoolVar(&writeSecret, "write", false, "Write the secret into the config file instead of printing it")
+	genSecretCmd.Flags().BoolVar(&generateRSA, "rsa", false, "Generate an RSA key pair for RS256 instead of a secret")
+	genSecretCmd.Flags().IntVar(&rsaBits, "bits", 2048, "Size of the RSA key")
+	genSecretCmd.Flags().StringVar(&rsaKeyDir, "dir", ".", "Folder to write the RSA key pair to")
+}
+
+// genSecretCmd represents the gensecret command
+var genSecretCmd = &cobra.Command{
+	Use:   "gensecret",
+	Short: "Generate a secret for signing JWTs",
+	Long: `Generates a random 256 bit secret for signing JWTs and prints an export
+	statement for the GHOST_SECRET environment variable, which 'ghost serve' reads
+	when there is no --secret flag.  With --write, the secret goes into the config
+	file instead.  With --rsa, an RSA key pair for RS256 is written to jwt_private.pem
+	and jwt_public.pem`,
+	RunE: genSecret,
+}
+
+func genSecret(cmd *cobra.Command, args []string) error {
+
+	if generateRSA {
+
+		private, public, err := ghost.GenerateRSAKeys(rsaBits)
+		if err != nil {
+			ghost.LogFatal("SECRET", false, "Could not generate RSA keys", err)
+		}
+
+		privateFile := filepath.Join(rsaKeyDir, "jwt_private.pem")
+		publicFile := filepath.Join(rsaKeyDir, "jwt_public.pem")
+		if err := ioutil.WriteFile(privateFile, private, 0600); err != nil {
+			ghost.LogFatal("SECRET", false, "Could not write "+privateFile, err)
+		}
+		if err := ioutil.WriteFile(publicFile, public, 0644); err != nil {
+			ghost.LogFatal("SECRET", false, "Could not write "+publicFile, err)
+		}
+
+		ghost.Log("SECRET", true, "RSA keys written to "+privateFile+" and "+publicFile, nil)
+		return nil
+
+	}
+
+	secret, err := ghost.GenerateSecret()
+	if err != nil {
+		ghost.LogFatal("SECRET", false, "Could not generate a secret", err)
+	}
+
+	if !writeSecret {
+		fmt.Printf("export %s=%s\n", ghost.SecretEnvVar, secret)
+		return nil
+	}
+
+	viper.AddConfigPath(".")
+	viper.SetConfigName(viper.GetString("configfile"))
+	if err := viper.ReadInConfig(); err != nil {
+		ghost.LogFatal("SECRET", false, "Config file not found", err)
+	}
+
+	if err := ghost.WriteSecretToConfig(viper.ConfigFileUsed(), secret); err != nil {
+		ghost.LogFatal("SECRET", false, "Could not write the secret to "+viper.ConfigFileUsed(), err)
+	}
+
+	ghost.Log("SECRET", true, "New secret written to "+viper.ConfigFileUsed()+". Tokens signed with the old secret are no longer valid", nil)
+	return nil
+
+}
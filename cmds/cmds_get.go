@@ -0,0 +1,73 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"errors"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var isInstallAfterGet bool
+var getBundleName string
+
+func init() {
+	bundleCmd.AddCommand(bundleGetCmd)
+	bundleGetCmd.Flags().BoolVarP(&isInstallAfterGet, "install", "i", false, "Install the bundle once downloaded")
+	bundleGetCmd.Flags().StringVar(&getBundleName, "name", "", "Name for the bundle (defaults to the repository or archive name)")
+}
+
+// bundleGetCmd represents the bundle get command
+var bundleGetCmd = &cobra.Command{
+	Use:   "get [source]",
+	Short: "Download a bundle into the bundles folder",
+	Long: `Downloads a bundle from a git repository or an archive into the bundles folder
+	and checks its bundle.json.  Sources can be given as:
+	  github.com/org/bundle            (cloned over https)
+	  github.com/org/bundle@v1.2.0     (a particular branch or tag)
+	  git@github.com:org/bundle.git    (any git URL)
+	  https://example.com/bundle.zip   (a .zip, .tar.gz or .tgz archive, or a local path to one)
+	Hyphens in the name are replaced with underscores, since the bundle name is used as
+	the schema name.  Use --install to install the bundle straight away`,
+	RunE: getBundle,
+}
+
+func getBundle(cmd *cobra.Command, args []string) error {
+
+	if len(args) < 1 {
+		return errors.New("a bundle source must be provided")
+	}
+
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	bundleName, err := ghost.FetchBundle(args[0], getBundleName)
+	if err != nil {
+		ghost.LogFatal("GET", false, "Could not get bundle", err)
+	}
+
+	ghost.Log("GET", true, "Bundle "+bundleName+" downloaded to "+ghost.BundlePath(bundleName), nil)
+
+	if isInstallAfterGet {
+		if err := ghost.InstallBundle(bundleName, false); err != nil {
+			ghost.LogFatal("INSTALL", false, "Installation of bundle "+bundleName+" failed", err)
+		}
+		ghost.Log("INSTALL", true, "Installation of bundle "+bundleName+" completed", nil)
+	}
+
+	return nil
+
+}
@@ -15,9 +15,11 @@
 package cmds
 
 import (
+	"database/sql"
 	"os"
 
 	"github.com/jpincas/ghost/ghost"
+	"github.com/lib/pq"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -101,19 +103,7 @@ func initDB(cmd *cobra.Command, args []string) error {
 	db := ghost.SuperUserDBConfig.ReturnDBConnection("")
 	defer db.Close()
 
-	//Run initialisation SQL
-	var err error
-	_, err = db.Exec(sqlToCreateAdminRole)
-	_, err = db.Exec(sqlToGrantAdminPermissions) //Do this first so everything created after will have correct admin permissions by default
-	_, err = db.Exec(sqlToCreateUUIDExtension)
-	_, err = db.Exec(sqlToCreateUsersTable)
-	_, err = db.Exec(sqlToCreateFuncToGenerateNewUserID)
-	_, err = db.Exec(sqlToCreateTriggerOnNewUserInsert)
-	_, err = db.Exec(sqlToCreateServerRole)
-	_, err = db.Exec(sqlToCreateAnonRole)
-	_, err = db.Exec(sqlToGrantBuiltInPermissions)
-
-	if err != nil {
+	if err := runInitSQL(db); err != nil {
 		ghost.LogFatal("INIT", false, "Could not complete database setup", err)
 	}
 
@@ -122,6 +112,47 @@ func initDB(cmd *cobra.Command, args []string) error {
 
 }
 
+//runInitSQL runs the initialisation SQL, skipping anything which already exists,
+//so that it is safe to run again on a database which has already been initialised
+func runInitSQL(db *sql.DB) error {
+
+	statements := []string{
+		sqlToCreateAdminRole,
+		sqlToGrantAdminPermissions, //Do this first so everything created after will have correct admin permissions by default
+		sqlToCreateUUIDExtension,
+		sqlToCreateUsersTable,
+		sqlToCreateFuncToGenerateNewUserID,
+		sqlToCreateTriggerOnNewUserInsert,
+		sqlToCreateServerRole,
+		sqlToCreateAnonRole,
+		sqlToGrantBuiltInPermissions,
+		ghost.SQLToCreateBundleRegistry,
+	}
+
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil && !alreadyExists(err) {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+//alreadyExists is true for the errors Postgres gives when creating a role, table or function which already exists
+func alreadyExists(err error) bool {
+
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case "42710", "42P07", "42723":
+			return true
+		}
+	}
+
+	return false
+
+}
+
 //initFolders initialises the filesystem used by ghost
 func initFolders(cmd *cobra.Command, args []string) error {
 
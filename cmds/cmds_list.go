@@ -0,0 +1,100 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	bundleCmd.AddCommand(bundleListCmd)
+}
+
+// bundleListCmd represents the bundle list command
+var bundleListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List bundles and their status",
+	Long: `Lists every bundle in the bundles folder or the bundle registry, showing
+	whether it is on disk, its installed and packaged versions, whether it is enabled,
+	the tables it declares and whether its migrations are up to date`,
+	RunE: listBundles,
+}
+
+func listBundles(cmd *cobra.Command, args []string) error {
+
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	statuses, err := ghost.BundleStatuses()
+	if err != nil {
+		ghost.LogFatal("BUNDLE", false, "Could not read bundle status", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BUNDLE\tON DISK\tINSTALLED\tPACKAGED\tENABLED\tTABLES\tMIGRATIONS")
+
+	for _, s := range statuses {
+
+		migrations := "-"
+		switch {
+		case s.Problem != "":
+			migrations = "problem: " + strings.Split(s.Problem, "\n")[0]
+		case s.InstalledVersion != "" && s.MigrationsUpToDate():
+			migrations = "up to date"
+		case s.InstalledVersion != "":
+			migrations = strconv.Itoa(len(s.PendingMigrations)) + " pending"
+		}
+
+		enabled := "-"
+		if s.InstalledVersion != "" {
+			enabled = yesNo(s.Enabled)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.Name,
+			yesNo(s.OnDisk),
+			orDash(s.InstalledVersion),
+			orDash(s.PackagedVersion),
+			enabled,
+			orDash(strings.Join(s.Tables, ", ")),
+			migrations,
+		)
+
+	}
+
+	return w.Flush()
+
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
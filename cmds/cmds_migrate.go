@@ -0,0 +1,162 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RootCmd.AddCommand(migrateCmd)
+	migrateCmd.AddCommand(migrateUpCmd)
+	migrateCmd.AddCommand(migrateDownCmd)
+	migrateCmd.AddCommand(migrateStatusCmd)
+	migrateCmd.AddCommand(migrateCreateCmd)
+}
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate [command]",
+	Short: "Manage bundle schema migrations",
+	Long: `Manages the migrations in each bundle's 'migrations' folder.  Migration files
+	are named after the bundle version they upgrade to, e.g. 1.1.0_add_stock_levels.sql,
+	with an optional 1.1.0_add_stock_levels.down.sql to undo them`,
+}
+
+// migrateUpCmd represents the migrate up command
+var migrateUpCmd = &cobra.Command{
+	Use:   "up [bundle...]",
+	Short: "Run pending migrations",
+	Long: `Runs the migrations between each bundle's installed version and the version in
+	its bundle.json, in a single transaction per bundle, as 'ghost bundle upgrade' does.
+	With no bundles named, every installed bundle is migrated`,
+	RunE: upgradeBundles,
+}
+
+// migrateDownCmd represents the migrate down command
+var migrateDownCmd = &cobra.Command{
+	Use:   "down [bundle]",
+	Short: "Undo a bundle's last migration",
+	Long: `Runs the .down.sql file of the migration which brought the bundle to its
+	installed version, and sets the installed version back to the migration before.
+	Running 'ghost migrate up' afterwards runs the migration again, unless the version
+	in bundle.json has been lowered`,
+	RunE: migrateDown,
+}
+
+// migrateStatusCmd represents the migrate status command
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status [bundle...]",
+	Short: "Show pending migrations",
+	RunE:  migrateStatus,
+}
+
+// migrateCreateCmd represents the migrate create command
+var migrateCreateCmd = &cobra.Command{
+	Use:   "create [bundle] [name]",
+	Short: "Create a new migration",
+	Long: `Creates an empty migration, and the .down.sql file to undo it, in the bundle's
+	'migrations' folder, numbered with the next patch version.  The version in the
+	bundle's bundle.json is moved on to match, so the next 'ghost migrate up' runs it`,
+	RunE: migrateCreate,
+}
+
+func migrateDown(cmd *cobra.Command, args []string) error {
+
+	if len(args) != 1 {
+		return errors.New("a bundle name must be provided")
+	}
+
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	//If user has used -noprompt flag then we don't prompt for confirmation
+	if !viper.GetBool("noprompt") && !ghost.AskForConfirmation("This will undo the last migration of "+args[0]+", which may lose data.  Are you sure you want to do this?") {
+		return nil
+	}
+
+	if _, _, err := ghost.DowngradeBundle(args[0]); err != nil {
+		ghost.LogFatal("MIGRATE", false, "Rollback of bundle "+args[0]+" failed", err)
+	}
+
+	return nil
+
+}
+
+func migrateStatus(cmd *cobra.Command, args []string) error {
+
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	bundleNames := args
+	if len(bundleNames) == 0 {
+		bundleNames = ghost.InstalledBundles()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BUNDLE\tINSTALLED\tPACKAGED\tPENDING")
+
+	for _, bundleName := range bundleNames {
+
+		upgrade, err := ghost.CheckBundleUpgrade(bundleName)
+		if err != nil {
+			fmt.Fprintf(w, "%s\t%s\t%s\tproblem: %s\n", bundleName, orDash(upgrade.InstalledVersion), orDash(upgrade.PackagedVersion), strings.Split(err.Error(), "\n")[0])
+			continue
+		}
+
+		pending := "up to date"
+		if !upgrade.UpToDate() {
+			var files []string
+			for _, file := range upgrade.Migrations {
+				files = append(files, path.Base(file))
+			}
+			pending = orDash(strings.Join(files, ", "))
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", bundleName, upgrade.InstalledVersion, upgrade.PackagedVersion, pending)
+
+	}
+
+	return w.Flush()
+
+}
+
+func migrateCreate(cmd *cobra.Command, args []string) error {
+
+	if len(args) < 2 {
+		return errors.New("a bundle name and a migration name must be provided")
+	}
+
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	files, err := ghost.CreateMigration(args[0], strings.Join(args[1:], "_"))
+	if err != nil {
+		ghost.LogFatal("MIGRATE", false, "Could not create migration", err)
+	}
+
+	for _, file := range files {
+		ghost.Log("MIGRATE", true, "Created "+file, nil)
+	}
+
+	return nil
+
+}
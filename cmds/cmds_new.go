@@ -15,11 +15,8 @@
 package cmds
 
 import (
-	"fmt"
-	"os"
-	"path"
-
 	"errors"
+	"fmt"
 
 	"github.com/jpincas/ghost/ghost"
 	"github.com/spf13/afero"
@@ -35,6 +32,8 @@ func init() {
 	RootCmd.AddCommand(newCmd)
 	newCmd.AddCommand(newUserCmd)
 	newCmd.AddCommand(newBundleCmd)
+	newCmd.AddCommand(newProjectCmd)
+	bundleCmd.AddCommand(bundleNewCmd)
 	newUserCmd.Flags().BoolVar(&isAdmin, "admin", false, "Create user with admin role")
 
 }
@@ -42,7 +41,10 @@ func init() {
 // newCmd represents the new command
 var newCmd = &cobra.Command{
 	Use:   "new [object]",
-	Short: "Create new files, users and bundles",
+	Short: "Create new projects, users and bundles",
+	Long: `Creates new projects, users and bundles.  'ghost new [folder]' is short for
+	'ghost new project [folder]'`,
+	RunE: createNewProject,
 }
 
 var newUserCmd = &cobra.Command{
@@ -57,7 +59,17 @@ var newUserCmd = &cobra.Command{
 var newBundleCmd = &cobra.Command{
 	Use:   "bundle [name]",
 	Short: "Create a new ghost Bundle",
-	Long:  `Scaffolds a new bundle including folder structure and required files`,
+	Long: `Scaffolds a new bundle in the bundles folder: bundle.json, install.sql,
+	uninstall.sql, demodata, migrations, templates, public and admin-panel.
+	Same as 'ghost bundle new'`,
+	RunE: createNewBundle,
+}
+
+// bundleNewCmd represents the bundle new command
+var bundleNewCmd = &cobra.Command{
+	Use:   "new [name]",
+	Short: "Create a new ghost Bundle",
+	Long:  newBundleCmd.Long,
 	RunE:  createNewBundle,
 }
 
@@ -93,37 +105,16 @@ func createNewUser(cmd *cobra.Command, args []string) error {
 
 func createNewBundle(cmd *cobra.Command, args []string) error {
 
-	fs := afero.NewOsFs()
-
 	//Check for bundle name
 	if len(args) < 1 {
 		return errors.New("a bundle name must be provided")
 	}
 
-	//Check that bundle doesn't already exists
-	basePath := path.Join("bundles", args[0])
-	exists, _ := afero.IsDir(fs, basePath)
-	if exists {
-		ghost.LogFatal("NEW", true, "Bundle "+args[0]+" already exists. Please provide a different name", nil)
-	}
-
-	//Create the folder structure
-	err := os.MkdirAll(path.Join(basePath, "install"), os.ModePerm)
-	err = os.MkdirAll(path.Join(basePath, "demodata"), os.ModePerm)
-
-	if err != nil {
-		ghost.LogFatal("NEW", true, "Could not complete folder setup", err)
-	}
-
-	_, err = os.Create(path.Join(basePath, "install", "00_install.sql"))
-	_, err = os.Create(path.Join(basePath, "demodata", "00_demodata.sql"))
-
-	if err != nil {
-		ghost.LogFatal("NEW", true, "Could not complete folder setup", err)
+	if err := ghost.ScaffoldBundle(afero.NewOsFs(), args[0]); err != nil {
+		ghost.LogFatal("NEW", false, "Could not create bundle "+args[0], err)
 	}
 
-	//Creates the bundles
-	ghost.Log("NEW", true, "Successfully created bundle "+args[0], err)
+	ghost.Log("NEW", true, "Successfully created bundle "+args[0]+" in "+ghost.BundlePath(args[0]), nil)
 	return nil
 
 }
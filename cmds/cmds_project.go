@@ -0,0 +1,94 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var starterBundle string
+var initProject bool
+
+func init() {
+	for _, cmd := range []*cobra.Command{newCmd, newProjectCmd} {
+		cmd.Flags().StringVar(&starterBundle, "bundle", "app", "Name of the starter bundle")
+		cmd.Flags().BoolVar(&initProject, "init", false, "Set up the database and install the starter bundle straight away")
+	}
+}
+
+// newProjectCmd represents the new project command
+var newProjectCmd = &cobra.Command{
+	Use:   "project [folder]",
+	Short: "Create a new project",
+	Long: `Creates a ready to run project in a new folder: a config.json built from your
+	answers to a few questions (or the defaults with --noprompt), main.go, a .gitignore
+	and a bundles folder with a starter bundle.  With --init, the database is set up and
+	the starter bundle installed as well, as 'ghost init' and 'ghost bundle install' do`,
+	RunE: createNewProject,
+}
+
+func createNewProject(cmd *cobra.Command, args []string) error {
+
+	if len(args) != 1 {
+		return errors.New("the new project's folder must be provided")
+	}
+	dir := args[0]
+
+	c := ghost.Defaults
+	if !viper.GetBool("noprompt") {
+		c.Host = ghost.AskForString("Host name the site will be served on", c.Host)
+		c.ApiPort = ghost.AskForString("Port to serve on", c.ApiPort)
+		c.PgServer = ghost.AskForString("PostgreSQL server", c.PgServer)
+		c.PgPort = ghost.AskForString("PostgreSQL port", c.PgPort)
+		c.PgDBName = ghost.AskForString("Database name", c.PgDBName)
+		c.PgSuperUser = ghost.AskForString("PostgreSQL super user", c.PgSuperUser)
+	}
+
+	if err := ghost.ScaffoldProject(afero.NewOsFs(), dir, c, starterBundle); err != nil {
+		ghost.LogFatal("NEW", false, "Could not create project", err)
+	}
+	ghost.Log("NEW", true, "Created project in "+dir, nil)
+
+	if !initProject {
+		ghost.Log("NEW", true, "Next, cd "+dir+" and run 'ghost init' and 'ghost bundle install "+starterBundle+"'", nil)
+		return nil
+	}
+
+	//The rest happens inside the project, using its config
+	if err := os.Chdir(dir); err != nil {
+		ghost.LogFatal("NEW", false, "Could not enter "+dir, err)
+	}
+	viper.Set("configfile", "config")
+
+	if err := initDB(cmd, nil); err != nil {
+		return err
+	}
+	ghost.App.Setup("config")
+	if err := ghost.InstallBundles([]string{starterBundle}, false); err != nil {
+		ghost.LogFatal("NEW", false, "Could not install the starter bundle", err)
+	}
+
+	abs, _ := filepath.Abs(".")
+	ghost.Log("NEW", true, "Project in "+abs+" is ready. Create an admin with 'ghost createadmin', then go build and run it", nil)
+	return nil
+
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"os"
+	"strings"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var setupAnswersFile string
+
+func init() {
+	RootCmd.AddCommand(setupCmd)
+	setupCmd.Flags().StringVar(&setupAnswersFile, "answers", "", "JSON file of answers, to set up without prompting")
+}
+
+// setupCmd represents the setup command
+var setupCmd = &cobra.Command{
+	Use:   "setup",
+	Short: "Set up a new installation step by step",
+	Long: `Asks for the database connection, email settings and first admin's email address,
+	then connects to the database, initialises it, generates a secret, tests the SMTP server,
+	creates the admin and writes the config file, checking each step before moving on.
+	The config file is only written once every step has passed.  With --answers, the answers are read
+	from a JSON file instead, e.g. {"pgDBName": "mydb", "pgPassword": "...", "adminEmail":
+	"me@example.com"}, with any left out taking their default`,
+	RunE: setup,
+}
+
+func setup(cmd *cobra.Command, args []string) error {
+
+	configFile := viper.GetString("configfile") + ".json"
+	if _, err := os.Stat(configFile); err == nil {
+		if setupAnswersFile != "" && !viper.GetBool("noprompt") {
+			ghost.LogFatal("SETUP", false, configFile+" already exists. Use --noprompt to replace it", nil)
+		}
+		if setupAnswersFile == "" && !viper.GetBool("noprompt") &&
+			!strings.HasPrefix(strings.ToLower(ghost.AskForString(configFile+" already exists. Replace it, with a new secret? (y/n)", "n")), "y") {
+			ghost.Log("SETUP", false, "Aborted by user", nil)
+			return nil
+		}
+	}
+
+	var answers ghost.SetupAnswers
+	if setupAnswersFile != "" {
+		var err error
+		if answers, err = ghost.ReadSetupAnswers(afero.NewOsFs(), setupAnswersFile); err != nil {
+			ghost.LogFatal("SETUP", false, "Invalid answers file", err)
+		}
+	} else {
+		answers = askSetupAnswers()
+		if err := answers.Validate(); err != nil {
+			ghost.LogFatal("SETUP", false, "Invalid answer", err)
+		}
+	}
+	if answers.PgPassword == "" {
+		answers.PgPassword = viper.GetString("pgpw")
+	}
+	answers.Apply()
+
+	//Database credentials
+	db, err := ghost.SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		ghost.LogFatal("SETUP", false, "Could not connect to database "+answers.PgDBName+" on "+answers.PgServer+":"+answers.PgPort+". Check that it exists and the credentials are right", err)
+	}
+	defer db.Close()
+	ghost.Log("SETUP", true, "Connected to the database as "+answers.PgSuperUser, nil)
+
+	//Built in roles and tables
+	if err := runInitSQL(db); err != nil {
+		ghost.LogFatal("SETUP", false, "Could not initialise the database", err)
+	}
+	if err := ghost.VerifyDatabaseSetup(db); err != nil {
+		ghost.LogFatal("SETUP", false, "Database initialisation did not complete", err)
+	}
+	ghost.Log("SETUP", true, "Built in roles and tables are in place", nil)
+
+	//Secret for signing JWTs
+	secret, err := ghost.GenerateSecret()
+	if err != nil {
+		ghost.LogFatal("SETUP", false, "Could not generate a secret", err)
+	}
+	ghost.Log("SETUP", true, "Generated a secret for signing JWTs", nil)
+
+	//Email
+	if answers.ActivateEmail {
+		if err := ghost.App.SetupMailServer(); err != nil {
+			ghost.LogFatal("SETUP", false, "Could not use the SMTP server. Run 'ghost email test' once setup is done to see the conversation with it", err)
+		}
+	}
+
+	//First admin
+	var created bool
+	if err := db.QueryRow(sqlToCreateOrPromoteAdmin, answers.AdminEmail).Scan(&created); err != nil {
+		ghost.LogFatal("SETUP", false, "Could not create admin user", err)
+	}
+	if created {
+		ghost.Log("SETUP", true, "Created admin user "+answers.AdminEmail, nil)
+	} else {
+		ghost.Log("SETUP", true, "Existing user "+answers.AdminEmail+" is now an admin", nil)
+	}
+
+	//Config file and folders
+	if err := ghost.WriteConfigFile(afero.NewOsFs(), configFile, answers.Config()); err != nil {
+		ghost.LogFatal("SETUP", false, "Could not write "+configFile, err)
+	}
+	if err := ghost.WriteSecretToConfig(configFile, secret); err != nil {
+		ghost.LogFatal("SETUP", false, "Could not write the secret to "+configFile, err)
+	}
+	if err := os.MkdirAll("./bundles", os.ModePerm); err != nil {
+		ghost.LogFatal("SETUP", false, "Could not create the bundles folder", err)
+	}
+	ghost.Log("SETUP", true, "Wrote "+configFile, nil)
+
+	passwords := "--pgpw"
+	if answers.ActivateEmail {
+		passwords += " and --smtppw"
+	}
+	ghost.Log("SETUP", true, "Setup complete. Start the server with "+passwords+", which are not kept in the config", nil)
+	return nil
+
+}
+
+//askSetupAnswers prompts for each answer, suggesting the defaults
+func askSetupAnswers() ghost.SetupAnswers {
+
+	a := ghost.DefaultSetupAnswers()
+
+	a.PgServer = ghost.AskForString("Postgres server", a.PgServer)
+	a.PgPort = ghost.AskForString("Postgres port", a.PgPort)
+	a.PgDBName = ghost.AskForString("Database name", a.PgDBName)
+	a.PgSuperUser = ghost.AskForString("Postgres super user", a.PgSuperUser)
+	a.PgPassword = ghost.AskForString("Password for "+a.PgSuperUser, viper.GetString("pgpw"))
+	a.Host = ghost.AskForString("Host name", a.Host)
+	a.ApiPort = ghost.AskForString("API port", a.ApiPort)
+
+	if strings.HasPrefix(strings.ToLower(ghost.AskForString("Send email (y/n)", "n")), "y") {
+		a.ActivateEmail = true
+		a.SmtpHost = ghost.AskForString("SMTP server", a.SmtpHost)
+		a.SmtpPort = ghost.AskForString("SMTP port", a.SmtpPort)
+		a.SmtpUserName = ghost.AskForString("SMTP user name", a.SmtpUserName)
+		a.SmtpPassword = ghost.AskForString("SMTP password", "")
+		a.SmtpFrom = ghost.AskForString("Send email from", a.SmtpFrom)
+	}
+
+	a.AdminEmail = ghost.AskForString("First admin's email address", "")
+
+	return a
+
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	bundleCmd.AddCommand(bundleUpgradeCmd)
+}
+
+// bundleUpgradeCmd represents the bundle upgrade command
+var bundleUpgradeCmd = &cobra.Command{
+	Use:   "upgrade [bundle...]",
+	Short: "Upgrade installed bundles to the version in the bundles folder",
+	Long: `Compares the installed version of each bundle with the version in its
+	bundle.json and runs the migrations in its 'migrations' folder which fall between
+	the two, in a single transaction.  Migration files are named after the version they
+	upgrade to, e.g. 1.1.0.sql or 1.1.0_add_stock_levels.sql.
+	With no bundles named, every installed bundle is upgraded`,
+	RunE: upgradeBundles,
+}
+
+func upgradeBundles(cmd *cobra.Command, args []string) error {
+
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	bundleNames := args
+	if len(bundleNames) == 0 {
+		bundleNames = ghost.InstalledBundles()
+	}
+
+	for _, bundleName := range bundleNames {
+
+		upgrade, err := ghost.UpgradeBundle(bundleName)
+		if err != nil {
+			ghost.LogFatal("UPGRADE", false, "Upgrade of bundle "+bundleName+" failed", err)
+		}
+
+		if upgrade.UpToDate() {
+			ghost.Log("UPGRADE", true, "Bundle "+bundleName+" is up to date at version "+upgrade.InstalledVersion, nil)
+		}
+
+	}
+
+	return nil
+
+}
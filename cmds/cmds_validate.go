@@ -0,0 +1,71 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	bundleCmd.AddCommand(bundleValidateCmd)
+}
+
+// bundleValidateCmd represents the bundle validate command
+var bundleValidateCmd = &cobra.Command{
+	Use:   "validate [bundle...]",
+	Short: "Check bundles for problems",
+	Long: `Checks that each bundle has a valid bundle.json and installation files.
+	With no bundles named, every bundle in the bundles folder is checked.
+	The same checks are made on installed bundles when the server starts`,
+	RunE: validateBundles,
+}
+
+func validateBundles(cmd *cobra.Command, args []string) error {
+
+	ghost.App.Setup(viper.GetString("configfile"))
+
+	bundleNames := args
+	if len(bundleNames) == 0 {
+		folders, err := afero.ReadDir(ghost.App.FileSystem, "bundles")
+		if err != nil {
+			ghost.LogFatal("VALIDATE", false, "Could not read the bundles folder", err)
+		}
+		for _, folder := range folders {
+			if folder.IsDir() {
+				bundleNames = append(bundleNames, folder.Name())
+			}
+		}
+	}
+
+	valid := true
+	for _, bundleName := range bundleNames {
+		if err := ghost.ValidateBundle(bundleName); err != nil {
+			ghost.Log("VALIDATE", false, "Bundle "+bundleName+" is invalid", err)
+			valid = false
+		} else {
+			ghost.Log("VALIDATE", true, "Bundle "+bundleName+" is valid", nil)
+		}
+	}
+
+	if !valid {
+		ghost.LogFatal("VALIDATE", false, "Some bundles are invalid", nil)
+	}
+
+	return nil
+
+}
@@ -0,0 +1,37 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(versionCmd)
+}
+
+// versionCmd represents the version command
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Show the version and build details",
+	Long: `Shows the version, git commit, build date and Go version, as set with -ldflags
+	when building, e.g. -X github.com/jpincas/ghost/ghost.Version=1.2.0`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("ghost " + ghost.VersionString())
+	},
+}
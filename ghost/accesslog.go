@@ -0,0 +1,171 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+//Access log formats
+const (
+	accessLogCommon   = "common"
+	accessLogCombined = "combined"
+	accessLogStdout   = "stdout"
+
+	//clfTimeFormat is the timestamp format of the Common Log Format
+	clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+//accessLogWriter opens the access log named in the config: stdout, or a file which is
+//rotated once it reaches accessLogMaxSize megabytes.  It returns nil if access logging is off
+func accessLogWriter(c config) (io.Writer, error) {
+
+	if c.AccessLog == "" {
+		return nil, nil
+	}
+
+	if c.AccessLogFormat != accessLogCommon && c.AccessLogFormat != accessLogCombined {
+		return nil, errors.New("accessLogFormat must be 'common' or 'combined'")
+	}
+
+	if c.AccessLog == accessLogStdout {
+		return os.Stdout, nil
+	}
+
+	return &lumberjack.Logger{
+		Filename:   c.AccessLog,
+		MaxSize:    c.AccessLogMaxSize,
+		MaxBackups: c.AccessLogMaxBackups,
+		MaxAge:     c.AccessLogMaxAge,
+	}, nil
+
+}
+
+//withAccessLog writes a line to the access log for every request, in the Common or
+//Combined Log Format, so that standard log analysis tools can read it
+func withAccessLog(out io.Writer, format string, next http.Handler) http.Handler {
+
+	if out == nil {
+		return next
+	}
+
+	var mutex sync.Mutex
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+		recorder := &accessLogRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		line := accessLogLine(r, start, recorder.status, recorder.size, format == accessLogCombined)
+
+		mutex.Lock()
+		io.WriteString(out, line)
+		mutex.Unlock()
+
+	})
+}
+
+//accessLogLine formats a request as a line of the Common or Combined Log Format
+func accessLogLine(r *http.Request, start time.Time, status, size int, combined bool) string {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	user, _, _ := r.BasicAuth()
+
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.Itoa(size)
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s",
+		orHyphen(host), orHyphen(user), start.Format(clfTimeFormat),
+		r.Method+" "+r.URL.RequestURI()+" "+r.Proto, status, bytes)
+
+	if combined {
+		line += fmt.Sprintf(" %q %q", orHyphen(r.Referer()), orHyphen(r.UserAgent()))
+	}
+
+	return line + "\n"
+
+}
+
+func orHyphen(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+//accessLogRecorder records the status and size of a response
+type accessLogRecorder struct {
+	http.ResponseWriter
+	status, size int
+	wroteHeader  bool
+}
+
+func (w *accessLogRecorder) WriteHeader(code int) {
+
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+
+}
+
+func (w *accessLogRecorder) Write(b []byte) (int, error) {
+
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+
+}
+
+//Flush lets streaming responses through
+func (w *accessLogRecorder) Flush() {
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+
+}
+
+//Hijack lets websocket upgrades through
+func (w *accessLogRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.status = http.StatusSwitchingProtocols
+		return h.Hijack()
+	}
+
+	return nil, nil, errors.New("Response does not support hijacking")
+
+}
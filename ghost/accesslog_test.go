@@ -0,0 +1,51 @@
+package ghost
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAccessLogLine(t *testing.T) {
+
+	start := time.Date(2017, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60))
+
+	r := httptest.NewRequest("GET", "/shop/products?page=2", nil)
+	r.RemoteAddr = "192.0.2.1:51234"
+	r.Header.Set("Referer", "https://example.com/")
+	r.Header.Set("User-Agent", "Mozilla/5.0")
+
+	testCases := []struct {
+		description string
+		size        int
+		combined    bool
+		expected    string
+	}{
+		{"Common", 2326, false, `192.0.2.1 - - [10/Oct/2017:13:55:36 -0700] "GET /shop/products?page=2 HTTP/1.1" 200 2326` + "\n"},
+		{"Combined, empty body", 0, true, `192.0.2.1 - - [10/Oct/2017:13:55:36 -0700] "GET /shop/products?page=2 HTTP/1.1" 200 - "https://example.com/" "Mozilla/5.0"` + "\n"},
+	}
+
+	for _, c := range testCases {
+		if got := accessLogLine(r, start, http.StatusOK, c.size, c.combined); got != c.expected {
+			TestErrorFatal(t, c.description, got, c.expected)
+		}
+	}
+
+}
+
+func TestWithAccessLog(t *testing.T) {
+
+	var out bytes.Buffer
+	handler := withAccessLog(&out, accessLogCommon, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+
+	if !bytes.Contains(out.Bytes(), []byte(`"GET /missing HTTP/1.1" 404 19`)) {
+		TestErrorFatal(t, "Status and size are logged", out.String(), `"GET /missing HTTP/1.1" 404 19`)
+	}
+
+}
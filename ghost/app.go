@@ -15,51 +15,349 @@
 package ghost
 
 import (
+	"context"
 	"database/sql"
-	"time"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
 
-	"github.com/diegobernardes/ttlcache"
 	"github.com/pressly/chi"
 	"github.com/spf13/afero"
+	"github.com/spf13/viper"
 )
 
 //App is the container for the app-wide constructs like database, router and mailserver
-var App application
+var App Application
 
-type application struct {
-	//Mailserver is the app-wide SMTP server construct for sending email
-	MailServer smtpServer
+//ErrServed is returned by Serve if the application has already been served since New
+var ErrServed = errors.New("The application has already been served. Call New to set it up again")
+
+//Application holds the app-wide constructs.  There is one, App, per process
+type Application struct {
+	//mailServer is the app-wide SMTP server, a *smtpServer, replaced whole when it is set up again
+	mailServer atomic.Value
 	//Router is the main router - hook into it with custom routes
 	Router *chi.Mux
 	//DB is the main database connection pool
 	DB *sql.DB
-	//Config is the main application configuration object
-	Config config
+	//liveConfig is the main application configuration, a *config, replaced whole when it changes
+	liveConfig atomic.Value
 	//FileSystem is the main FileSystem
 	FileSystem afero.Fs
+	//Storage is where files the application is given or generates are kept, on local disk or in a bucket
+	Storage Storage
 	//Store is the data abstraction layer
 	//Normally your applications would interact with Store rather than DB or Cache
 	Store store
-	//Cache is the app wide cache for SQL queries
-	Cache *ttlcache.Cache
+	//Cache is the app wide cache for SQL queries, in memory or in Redis
+	Cache ResultCache
+
+	//served is set once Serve has been called, since the routes can only be added once
+	served int32
+}
+
+//configMutex stops changes to the config overwriting each other
+var configMutex sync.Mutex
+
+//Config returns the application configuration.  It is shared by everything running, so
+//don't change it - use SetConfig to replace it
+func (a *Application) Config() *Config {
+
+	if c, ok := a.liveConfig.Load().(*config); ok {
+		return c
+	}
+	return &config{}
+
+}
+
+//SetConfig replaces the application configuration.  Anything already running, such as
+//a request, carries on with the config it started with
+func (a *Application) SetConfig(c Config) {
+
+	a.liveConfig.Store(&c)
+
+}
+
+//updateConfig makes a change to a copy of the config and, if it succeeds, puts it in place
+func (a *Application) updateConfig(change func(c *config) error) error {
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	c := *a.Config()
+	if err := change(&c); err != nil {
+		return err
+	}
+	a.SetConfig(c)
+
+	return nil
+
+}
+
+//MailServer returns the SMTP server for sending email
+func (a *Application) MailServer() *smtpServer {
+
+	if s, ok := a.mailServer.Load().(*smtpServer); ok {
+		return s
+	}
+	return &smtpServer{}
+
+}
+
+//SetupMailServer sets up a new SMTP server from the config and puts it in place of the
+//old one, so that emails being sent aren't affected.  It is only marked as working if
+//its connection test passes
+func (a *Application) SetupMailServer() error {
+
+	s := &smtpServer{}
+	err := s.Setup()
+	a.mailServer.Store(s)
+
+	return err
+
 }
 
 //Setup bootstraps the whole application
-func (a *application) Setup(configFileName string) {
+func (a *Application) Setup(configFileName string) {
 
 	//Setup the config
-	a.Config.Setup(configFileName)
+	var c config
+	c.Setup(configFileName)
+	a.SetConfig(c)
+	a.configure()
+
+}
+
+//configure sets up everything which depends on the config
+func (a *Application) configure() {
+
+	applyCors()
+	applyGlobalMiddleware()
+	SetMaintenanceMode(a.Config().Maintenance)
 
 	//Initialise the db config structs for later use
 	SuperUserDBConfig.SetupConnection(true)
 	ServerUserDBConfig.SetupConnection(false)
 
-	//Initialise the filesysem
-	a.FileSystem = afero.NewOsFs()
+	//Initialise the filesysem, including any bundle files compiled into the binary
+	a.FileSystem = newFileSystem()
+
+	//Initialise the file storage
+	storage, err := newStorage(*a.Config())
+	if err != nil {
+		LogFatal("STORAGE", false, "Error setting up file storage", err)
+	}
+	a.Storage = storage
 
 	//Initialise the cache
-	//TODO: Reimplement the cache with a new library
-	a.Cache = ttlcache.NewCache()
-	a.Cache.SetTTL(time.Duration(5 * time.Second))
+	a.Cache = newResultCache(*a.Config())
+
+}
+
+//Secrets are the settings kept out of the config file, which the command line takes as
+//flags or environment variables.  Any left empty are not changed
+type Secrets struct {
+	//JWTSecret signs tokens
+	JWTSecret string
+	//PgPassword is the Postgres super user's password
+	PgPassword string
+	//SMTPPassword is the password for the SMTP server
+	SMTPPassword string
+	//StorageAccessKey and StorageSecretKey are the keys for an S3 or GCS bucket
+	StorageAccessKey, StorageSecretKey string
+}
+
+//New sets up the application from a config, for programs which embed the server rather
+//than run it with 'ghost serve'.  Start from the defaults, e.g.
+//	c := ghost.Defaults
+//	c.ApiPort = "8080"
+//	app := ghost.New(c)
+//There is one application per process, so New returns App, set up afresh.  Add routes
+//to app.Router and middleware with RegisterAPIMiddleware, then call Serve, once
+func New(c Config) *Application {
+
+	App.SetConfig(c)
+	App.Router = newRouter()
+	atomic.StoreInt32(&App.served, 0)
+	App.configure()
+
+	return &App
+
+}
+
+//SetSecrets sets the secrets the application needs, e.g. from the embedding program's
+//own configuration.  Call it before Serve
+func (a *Application) SetSecrets(s Secrets) {
+
+	for key, value := range map[string]string{
+		"secret":           s.JWTSecret,
+		"pgpw":             s.PgPassword,
+		"smtpPW":           s.SMTPPassword,
+		"storageAccessKey": s.StorageAccessKey,
+		"storageSecretKey": s.StorageSecretKey,
+	} {
+		if value != "" {
+			viper.Set(key, value)
+		}
+	}
+
+	//The super user connection settings include the password
+	SuperUserDBConfig.SetupConnection(true)
+
+}
+
+//Serve connects to the database, sets up the routes and runs the server until the context
+//is done, then shuts it down gracefully and returns nil.  It returns an error if the
+//server can't start or stops unexpectedly, rather than exiting as 'ghost serve' does.
+//Scheduled jobs, job workers and the change feed run for as long as the server does.
+//The routes are added to the router, so it can only be called once: call New again
+//to serve again, or ErrServed is returned
+func (a *Application) Serve(ctx context.Context) error {
+
+	if !atomic.CompareAndSwapInt32(&a.served, 0, 1) {
+		return ErrServed
+	}
+
+	if err := a.prepare(); err != nil {
+		return err
+	}
+
+	err := startServer(ctx)
+
+	atomic.StoreInt32(&bundlesLoaded, 0)
+	a.DB.Close()
+	return err
+
+}
+
+//restartSettings are the settings which can't be changed without a restart
+var restartSettings = map[string]bool{
+	"apiPort":                 true,
+	"accessLog":               true,
+	"basePath":                true,
+	"bindAddress":             true,
+	"tlsCertFile":             true,
+	"tlsKeyFile":              true,
+	"autocertDomains":         true,
+	"autocertEmail":           true,
+	"autocertCacheDir":        true,
+	"httpRedirectPort":        true,
+	"accessLogFormat":         true,
+	"accessLogMaxSize":        true,
+	"accessLogMaxBackups":     true,
+	"accessLogMaxAge":         true,
+	"adminPanelDir":           true,
+	"adminPanelMounts":        true,
+	"jobWorkers":              true,
+	"jobPollInterval":         true,
+	"watchConfig":             true,
+	"serverReadTimeout":       true,
+	"serverReadHeaderTimeout": true,
+	"serverWriteTimeout":      true,
+	"serverIdleTimeout":       true,
+	"maxHeaderBytes":          true,
+	"http2":                   true,
+	"cache":                   true,
+	"redisURL":                true,
+	"storage":                 true,
+	"storageDir":              true,
+	"storageBucket":           true,
+	"storageRegion":           true,
+	"storageEndpoint":         true,
+	"pgSuperUser":             true,
+	"pgDBName":                true,
+	"pgPort":                  true,
+	"pgServer":                true,
+	"pgDisableSSL":            true,
+}
+
+//reloadMutex stops overlapping reloads, e.g. from several file change events for one save
+var reloadMutex sync.Mutex
+
+//ReloadConfig re-reads the config file and applies the settings that can safely change
+//on a running server, such as CORS, middleware, the installed bundle list, scheduled jobs
+//and email.  The restartSettings, like ports and database connection settings, are left
+//as they are, so that App.Config() still describes the running server
+func (a *Application) ReloadConfig() error {
+
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	if err := viper.ReadInConfig(); err != nil {
+		return err
+	}
+
+	var fresh config
+	if err := viper.Unmarshal(&fresh); err != nil {
+		return err
+	}
+
+	var bundlesWereChanged bool
+	err := a.updateConfig(func(c *config) error {
+
+		//Report what has changed, and what will have to wait for a restart
+		var applied, ignored []string
+		for _, setting := range changedSettings(*c, fresh) {
+			if restartSettings[setting] {
+				ignored = append(ignored, setting)
+			} else {
+				applied = append(applied, setting)
+			}
+		}
+		if len(applied) > 0 {
+			Log("CONFIG", true, "Settings changed: "+strings.Join(applied, ", "), nil)
+		}
+		if len(ignored) > 0 {
+			Log("CONFIG", false, "Settings changed which need a restart to take effect: "+strings.Join(ignored, ", "), nil)
+		}
+
+		//Keep the settings which can't be changed without a restart
+		keepSettings(&fresh, *c, restartSettings)
+
+		//Only a change to the setting overrides maintenance mode switched at runtime
+		if fresh.Maintenance != c.Maintenance {
+			SetMaintenanceMode(fresh.Maintenance)
+		}
+
+		bundlesWereChanged = !compareBundles(c.BundlesInstalled, fresh.BundlesInstalled)
+		*c = fresh
+		return nil
+
+	})
+	if err != nil {
+		return err
+	}
+
+	applyCors()
+	applyGlobalMiddleware()
+
+	//Pick up bundles enabled or disabled from the command line
+	if a.DB != nil {
+		if err := refreshBundleStates(); err != nil {
+			Log("CONFIG", false, "Could not reload bundle states", err)
+		} else {
+			bundlesWereChanged = true
+		}
+	}
+
+	if bundlesWereChanged {
+		bundlesChanged()
+	}
+
+	//Pick up changes to the scheduled jobs, in the config or the jobs table
+	reloadScheduler()
+
+	//Restart or stop the email system
+	if a.Config().ActivateEmail {
+		if err := a.SetupMailServer(); err != nil {
+			Log("CONFIG", false, "Email system will not function", err)
+		}
+	} else {
+		a.mailServer.Store(&smtpServer{})
+	}
+
+	Log("CONFIG", true, "Config reloaded from "+viper.ConfigFileUsed(), nil)
+	return nil
 
 }
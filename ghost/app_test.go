@@ -0,0 +1,53 @@
+package ghost
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestNewAndServe(t *testing.T) {
+
+	saved := App
+	defer func() { App = saved }()
+
+	c := Defaults
+	c.ApiPort = "8123"
+	c.Storage = storageMemory
+	app := New(c)
+
+	if app != &App || App.Config().ApiPort != "8123" {
+		TestErrorFatal(t, "New sets up the application from the config", App.Config().ApiPort, "8123")
+	}
+
+	//Without a secret the server can't start, which is reported rather than exiting
+	viper.Set("secret", "")
+	err := app.Serve(context.Background())
+	exp := "No signing secret provided. Use 'ghost gensecret' to make one"
+	if got := errorString(err); got != exp {
+		TestErrorFatal(t, "Serve returns errors", got, exp)
+	}
+
+	//The routes can only be added once, so serving again needs New
+	if err := app.Serve(context.Background()); err != ErrServed {
+		TestErrorFatal(t, "Serve can only be called once", errorString(err), errorString(ErrServed))
+	}
+	app = New(c)
+	if err := app.Serve(context.Background()); err == ErrServed {
+		TestErrorFatal(t, "New allows Serve again", errorString(err), exp)
+	}
+
+}
+
+//changeConfig changes the config for a test, returning a function to put it back
+func changeConfig(change func(c *config)) func() {
+
+	old := *App.Config()
+	c := old
+	change(&c)
+	App.SetConfig(c)
+
+	return func() { App.SetConfig(old) }
+
+}
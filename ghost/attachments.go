@@ -0,0 +1,210 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"path"
+	"strings"
+)
+
+//Attachment is a file sent with an email.  Inline attachments are embedded images,
+//which the email's HTML refers to by name, e.g. <img src="cid:logo.png">
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	Inline      bool
+}
+
+//InlineImage is a convenience for an image embedded in an email
+func InlineImage(name string, data []byte) Attachment {
+	return Attachment{Name: name, Data: data, Inline: true}
+}
+
+//StoredAttachment reads an attachment from storage, e.g. a product image uploaded to
+//products/1.jpg, so that emails don't depend on the local file system
+func StoredAttachment(ctx context.Context, name string, inline bool) (Attachment, error) {
+
+	r, file, err := App.Storage.Get(ctx, name)
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	return Attachment{Name: path.Base(file.Name), ContentType: file.ContentType, Data: data, Inline: inline}, nil
+
+}
+
+func (a Attachment) contentType() string {
+
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+	if t := mime.TypeByExtension(path.Ext(a.Name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+
+}
+
+//buildMessage adds attachments to an email rendered from a template, by turning it into a
+//multipart message: the body and inline images go in a multipart/related part, and other
+//attachments alongside that in a multipart/mixed message.  The template's headers are kept,
+//apart from its Content-Type, which moves to the body part
+func buildMessage(rendered []byte, attachments []Attachment) ([]byte, error) {
+
+	if len(attachments) == 0 {
+		return rendered, nil
+	}
+
+	headers, body, err := splitMessage(rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyType := "text/html; charset=\"UTF-8\""
+	var kept []string
+	for _, h := range headers {
+		if strings.HasPrefix(strings.ToLower(h), "content-type:") {
+			bodyType = strings.TrimSpace(h[len("content-type:"):])
+			continue
+		}
+		kept = append(kept, h)
+	}
+
+	var inline, attached []Attachment
+	for _, a := range attachments {
+		if a.Inline {
+			inline = append(inline, a)
+		} else {
+			attached = append(attached, a)
+		}
+	}
+
+	//The body, with any inline images
+	content := &bytes.Buffer{}
+	contentType := bodyType
+	if len(inline) == 0 {
+		content.Write(body)
+	} else {
+		related := multipart.NewWriter(content)
+		contentType = "multipart/related; boundary=" + related.Boundary()
+		if err := writeBodyPart(related, bodyType, body); err != nil {
+			return nil, err
+		}
+		for _, a := range inline {
+			if err := writeAttachmentPart(related, a); err != nil {
+				return nil, err
+			}
+		}
+		related.Close()
+	}
+
+	//and any other attachments
+	if len(attached) > 0 {
+		mixedContent := &bytes.Buffer{}
+		mixed := multipart.NewWriter(mixedContent)
+		if err := writeBodyPart(mixed, contentType, content.Bytes()); err != nil {
+			return nil, err
+		}
+		for _, a := range attached {
+			if err := writeAttachmentPart(mixed, a); err != nil {
+				return nil, err
+			}
+		}
+		mixed.Close()
+		content, contentType = mixedContent, "multipart/mixed; boundary="+mixed.Boundary()
+	}
+
+	message := &bytes.Buffer{}
+	for _, h := range kept {
+		message.WriteString(h + "\r\n")
+	}
+	message.WriteString("Content-Type: " + contentType + "\r\n\r\n")
+	message.Write(content.Bytes())
+
+	return message.Bytes(), nil
+
+}
+
+//splitMessage separates the header lines of an email from its body
+func splitMessage(message []byte) ([]string, []byte, error) {
+
+	for _, separator := range []string{"\r\n\r\n", "\n\n"} {
+		if i := bytes.Index(message, []byte(separator)); i >= 0 {
+			lines := strings.Split(strings.Replace(string(message[:i]), "\r\n", "\n", -1), "\n")
+			return lines, message[i+len(separator):], nil
+		}
+	}
+
+	return nil, nil, errors.New("Email template has no blank line between the headers and the body")
+
+}
+
+func writeBodyPart(w *multipart.Writer, contentType string, body []byte) error {
+
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(body)
+	return err
+
+}
+
+func writeAttachmentPart(w *multipart.Writer, a Attachment) error {
+
+	header := textproto.MIMEHeader{
+		"Content-Type":              {a.contentType()},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+	if a.Inline {
+		header.Set("Content-ID", "<"+a.Name+">")
+		header.Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", a.Name))
+	} else {
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", a.Name))
+	}
+
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	//Base64 lines must be no longer than 76 characters
+	encoded := base64.StdEncoding.EncodeToString(a.Data)
+	for len(encoded) > 76 {
+		if _, err := part.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = part.Write([]byte(encoded + "\r\n"))
+	return err
+
+}
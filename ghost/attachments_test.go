@@ -0,0 +1,98 @@
+package ghost
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestBuildMessage(t *testing.T) {
+
+	rendered := []byte("To: customer@example.com\nSubject: Your receipt\nMIME-version: 1.0\nContent-Type: text/html; charset=\"UTF-8\"\n\n<img src=\"cid:logo.png\">")
+
+	//Without attachments, the message is sent as rendered
+	if got, _ := buildMessage(rendered, nil); !bytes.Equal(got, rendered) {
+		TestErrorFatal(t, "No attachments", string(got), string(rendered))
+	}
+
+	message, err := buildMessage(rendered, []Attachment{
+		InlineImage("logo.png", []byte("PNG")),
+		{Name: "receipt.pdf", Data: []byte("PDF")},
+	})
+	if err != nil {
+		TestErrorFatal(t, "Building the message", err.Error(), "no error")
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		TestErrorFatal(t, "Parsing the message", err.Error(), "no error")
+	}
+	if m.Header.Get("Subject") != "Your receipt" {
+		TestErrorFatal(t, "Headers kept", m.Header.Get("Subject"), "Your receipt")
+	}
+
+	//Walk the parts, noting each one's type and how it is identified
+	var got []string
+	var walk func(contentType string, body []byte)
+	walk = func(contentType string, body []byte) {
+		mediaType, params, _ := mime.ParseMediaType(contentType)
+		if !strings.HasPrefix(mediaType, "multipart/") {
+			return
+		}
+		got = append(got, mediaType)
+		r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			p, err := r.NextPart()
+			if err != nil {
+				return
+			}
+			b, _ := ioutil.ReadAll(p)
+			if id := p.Header.Get("Content-ID"); id != "" {
+				got = append(got, id)
+			} else if p.FileName() != "" {
+				got = append(got, p.FileName())
+			} else if !strings.HasPrefix(p.Header.Get("Content-Type"), "multipart/") {
+				got = append(got, p.Header.Get("Content-Type"))
+			}
+			walk(p.Header.Get("Content-Type"), b)
+		}
+	}
+	body, _ := ioutil.ReadAll(m.Body)
+	walk(m.Header.Get("Content-Type"), body)
+
+	exp := `multipart/mixed multipart/related text/html; charset="UTF-8" <logo.png> receipt.pdf`
+	if strings.Join(got, " ") != exp {
+		TestErrorFatal(t, "Message structure", strings.Join(got, " "), exp)
+	}
+
+}
+
+func TestStoredAttachment(t *testing.T) {
+
+	old := App.Storage
+	App.Storage = fsStorage{afero.NewMemMapFs()}
+	defer func() { App.Storage = old }()
+
+	ctx := context.Background()
+	App.Storage.Put(ctx, "products/1.jpg", strings.NewReader("JPG"), "")
+
+	a, err := StoredAttachment(ctx, "products/1.jpg", true)
+	if err != nil {
+		TestErrorFatal(t, "Reading a stored attachment", err.Error(), "no error")
+	}
+	if got, exp := a.Name+" "+a.contentType()+" "+string(a.Data), "1.jpg image/jpeg JPG"; got != exp || !a.Inline {
+		TestErrorFatal(t, "Stored attachment", got, exp)
+	}
+
+	if _, err := StoredAttachment(ctx, "products/2.jpg", false); err != ErrFileNotFound {
+		TestErrorFatal(t, "Missing stored attachment", errorString(err), ErrFileNotFound.Error())
+	}
+
+}
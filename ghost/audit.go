@@ -0,0 +1,333 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+//The audit trail is a table which can only be added to: triggers stop rows being changed
+//or removed, even by its owner, and the server role is only granted SELECT and INSERT.
+//Entries are written in the transaction making the change, after going back to the
+//server role, so the roles making changes need no privileges on it
+const (
+	sqlToCreateAuditTrail = `CREATE TABLE IF NOT EXISTS public.ghost_audit (id bigserial PRIMARY KEY, at timestamptz NOT NULL DEFAULT now(), request_id text NOT NULL DEFAULT '', user_id text NOT NULL DEFAULT '', role text NOT NULL DEFAULT '', schema_name text NOT NULL, table_name text NOT NULL, record_id text NOT NULL DEFAULT '', operation text NOT NULL, diff jsonb NOT NULL DEFAULT '{}');
+	CREATE INDEX IF NOT EXISTS ghost_audit_record ON public.ghost_audit (schema_name, table_name, record_id);
+	CREATE INDEX IF NOT EXISTS ghost_audit_user ON public.ghost_audit (user_id);
+	CREATE OR REPLACE FUNCTION public.ghost_audit_append_only() RETURNS trigger LANGUAGE plpgsql AS $$
+	BEGIN
+		RAISE EXCEPTION 'The audit trail can only be added to';
+	END;
+	$$;
+	DROP TRIGGER IF EXISTS ghost_audit_append_only ON public.ghost_audit;
+	CREATE TRIGGER ghost_audit_append_only BEFORE UPDATE OR DELETE ON public.ghost_audit FOR EACH ROW EXECUTE PROCEDURE public.ghost_audit_append_only();
+	DROP TRIGGER IF EXISTS ghost_audit_no_truncate ON public.ghost_audit;
+	CREATE TRIGGER ghost_audit_no_truncate BEFORE TRUNCATE ON public.ghost_audit FOR EACH STATEMENT EXECUTE PROCEDURE public.ghost_audit_append_only();
+	REVOKE ALL ON public.ghost_audit FROM PUBLIC;
+	GRANT SELECT, INSERT ON public.ghost_audit TO server;
+	GRANT USAGE ON SEQUENCE public.ghost_audit_id_seq TO server;`
+
+	//sqlToCreateAuditTrigger creates the trigger function which audits changes made other
+	//than through the Store, e.g. by bundle SQL called through the API, which sets the user
+	//in my.user_id.  Attach it to each table to be audited with
+	//	CREATE TRIGGER orders_audit AFTER INSERT OR UPDATE OR DELETE ON shop.orders
+	//	FOR EACH ROW EXECUTE PROCEDURE public.ghost_audit_change();
+	//It runs as its owner, so that any role can add to the audit trail, and records the
+	//role the change was made as.  Changes the Store has already audited are skipped
+	sqlToCreateAuditTrigger = `CREATE OR REPLACE FUNCTION public.ghost_audit_change() RETURNS trigger
+	LANGUAGE plpgsql SECURITY DEFINER SET search_path = pg_catalog, public AS $$
+DECLARE
+	o jsonb := '{}';
+	n jsonb := '{}';
+	diff jsonb := '{}';
+	entry jsonb;
+	field text;
+BEGIN
+	IF current_setting('ghost.store_audit', true) = 'on' THEN
+		RETURN NULL;
+	END IF;
+	IF TG_OP <> 'INSERT' THEN
+		o := to_jsonb(OLD);
+	END IF;
+	IF TG_OP <> 'DELETE' THEN
+		n := to_jsonb(NEW);
+	END IF;
+	FOR field IN SELECT jsonb_object_keys(o || n) LOOP
+		IF (o -> field) IS DISTINCT FROM (n -> field) THEN
+			entry := '{}';
+			IF TG_OP <> 'INSERT' THEN
+				entry := entry || jsonb_build_object('old', o -> field);
+			END IF;
+			IF TG_OP <> 'DELETE' THEN
+				entry := entry || jsonb_build_object('new', n -> field);
+			END IF;
+			diff := diff || jsonb_build_object(field, entry);
+		END IF;
+	END LOOP;
+	IF TG_OP = 'UPDATE' AND diff = '{}' THEN
+		RETURN NULL;
+	END IF;
+	INSERT INTO public.ghost_audit (user_id, role, schema_name, table_name, record_id, operation, diff)
+	VALUES (COALESCE(current_setting('my.user_id', true), ''), COALESCE(NULLIF(current_setting('role'), 'none'), session_user), TG_TABLE_SCHEMA, TG_TABLE_NAME, COALESCE(n ->> 'id', o ->> 'id', ''), lower(TG_OP), diff);
+	RETURN NULL;
+END;
+$$;`
+
+	//sqlToAttachAuditTriggers attaches the audit trigger, as ghost_audit, to each table in a
+	//bundle's schema which doesn't have it yet, and sqlToDetachAuditTriggers takes it off again.
+	//Triggers attached by hand under other names are left alone
+	sqlToAttachAuditTriggers = `DO $$
+DECLARE
+	t record;
+BEGIN
+	FOR t IN SELECT c.relname FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = '%[1]s' AND c.relkind = 'r'
+	AND NOT EXISTS (SELECT 1 FROM pg_trigger g WHERE g.tgrelid = c.oid AND g.tgname = 'ghost_audit') LOOP
+		EXECUTE format('CREATE TRIGGER ghost_audit AFTER INSERT OR UPDATE OR DELETE ON %%I.%%I FOR EACH ROW EXECUTE PROCEDURE public.ghost_audit_change()', '%[1]s', t.relname);
+	END LOOP;
+END;
+$$;`
+
+	sqlToDetachAuditTriggers = `DO $$
+DECLARE
+	t record;
+BEGIN
+	FOR t IN SELECT c.relname FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	JOIN pg_trigger g ON g.tgrelid = c.oid AND g.tgname = 'ghost_audit'
+	WHERE n.nspname = '%[1]s' LOOP
+		EXECUTE format('DROP TRIGGER ghost_audit ON %%I.%%I', '%[1]s', t.relname);
+	END LOOP;
+END;
+$$;`
+
+	//sqlToMarkStoreAudit stops the audit trigger repeating entries the Store makes
+	sqlToMarkStoreAudit = `SELECT set_config('ghost.store_audit', 'on', true);`
+
+	//sqlToLockRecord reads a record before it is updated, so the change can be audited
+	sqlToLockRecord = `SELECT row_to_json(%s) FROM %s.%s WHERE id = $1 FOR UPDATE;`
+
+	sqlToResetRole = `RESET ROLE;`
+
+	sqlToAuditChange = `INSERT INTO public.ghost_audit (request_id, user_id, role, schema_name, table_name, record_id, operation, diff) VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`
+
+	sqlToListAudit = `SELECT id, at, request_id, user_id, role, schema_name, table_name, record_id, operation, diff FROM public.ghost_audit
+	WHERE ($1 = '' OR schema_name = $1) AND ($2 = '' OR table_name = $2) AND ($3 = '' OR record_id = $3) AND ($4 = '' OR user_id = $4) AND ($5 = '' OR operation = $5)
+	AND ($6::timestamptz IS NULL OR at >= $6) AND ($7::timestamptz IS NULL OR at < $7) AND ($8 = 0 OR id < $8)
+	ORDER BY id DESC LIMIT $9;`
+)
+
+//Limits on the number of audit entries returned at once
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+//AuditEntry is a change recorded in the audit trail.  Diff has an entry for each field
+//which changed, with its "old" and "new" values - only "new" for inserts, and only "old" for deletes
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	At        time.Time       `json:"at"`
+	RequestID string          `json:"requestID"`
+	UserID    string          `json:"userID"`
+	Role      string          `json:"role"`
+	Schema    string          `json:"schema"`
+	Table     string          `json:"table"`
+	RecordID  string          `json:"recordID"`
+	Operation string          `json:"operation"`
+	Diff      json.RawMessage `json:"diff"`
+}
+
+//AuditQuery picks entries from the audit trail.  Empty fields match everything.  Entries
+//come newest first, so pass the ID of the last one returned as Before to get the next page
+type AuditQuery struct {
+	Schema, Table, RecordID, UserID, Operation string
+	Since, Until                               time.Time
+	Before                                     int64
+	Limit                                      int
+}
+
+//setupAuditTrail creates the audit table, if need be, and the audit trigger function, as
+//the super user.  They are always there, so that the audit setting can be switched on
+//without a restart.  The trigger is attached to the installed bundles' tables when audit
+//is on, and taken off when it is off, so that changes made by bundle SQL are audited too
+func setupAuditTrail(db execer) error {
+
+	if _, err := db.Exec(sqlToCreateAuditTrail); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(sqlToCreateAuditTrigger); err != nil {
+		return err
+	}
+
+	audit := App.Config().Audit
+	for _, bundleName := range App.Config().BundlesInstalled {
+		if err := auditBundleTables(db, bundleName, audit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+//auditBundleTables attaches the audit trigger to every table in a bundle's schema, or
+//takes it off them
+func auditBundleTables(db execer, bundleName string, audit bool) error {
+
+	if !IsValidIdentifier(bundleName) {
+		return errors.New("Invalid bundle name '" + bundleName + "'")
+	}
+
+	_, err := db.Exec(auditTriggersSQL(bundleName, audit))
+	return err
+
+}
+
+//auditTriggersSQL returns the SQL which attaches or detaches the audit triggers on a schema
+func auditTriggersSQL(schemaName string, audit bool) string {
+
+	if audit {
+		return fmt.Sprintf(sqlToAttachAuditTriggers, schemaName)
+	}
+
+	return fmt.Sprintf(sqlToDetachAuditTriggers, schemaName)
+
+}
+
+//auditChange records a change made through the Store in the audit trail.  The record
+//before the change is given for updates, and the result is the record returned by the write
+func auditChange(tx execer, operation string, e *RecordEvent, before, result string) error {
+
+	oldRecord, newRecord := before, result
+	switch operation {
+	case OperationInsert:
+		oldRecord = ""
+	case OperationDelete:
+		oldRecord, newRecord = result, ""
+	}
+
+	diff, err := auditDiff(oldRecord, newRecord)
+	if err != nil {
+		return err
+	}
+
+	//Updates which don't change anything aren't worth recording
+	if diff == "{}" && operation == OperationUpdate {
+		return nil
+	}
+
+	if _, err := tx.Exec(sqlToResetRole); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(sqlToAuditChange, e.RequestID, e.UserID, e.Role, e.Schema, e.Table, recordID(e, result), operation, diff)
+	return err
+
+}
+
+//auditDiff returns the fields which differ between two records, as JSON, with their
+//old and new values.  Either record can be "", for inserts and deletes
+func auditDiff(oldRecord, newRecord string) (string, error) {
+
+	oldFields, err := decodeAuditRecord(oldRecord)
+	if err != nil {
+		return "", err
+	}
+	newFields, err := decodeAuditRecord(newRecord)
+	if err != nil {
+		return "", err
+	}
+
+	diff := map[string]map[string]interface{}{}
+	for field, value := range oldFields {
+		if newValue, ok := newFields[field]; !ok || !reflect.DeepEqual(value, newValue) {
+			diff[field] = map[string]interface{}{"old": value}
+		}
+	}
+	for field, value := range newFields {
+		if oldValue, ok := oldFields[field]; !ok || !reflect.DeepEqual(value, oldValue) {
+			if diff[field] == nil {
+				diff[field] = map[string]interface{}{}
+			}
+			diff[field]["new"] = value
+		}
+	}
+
+	b, err := json.Marshal(diff)
+	return string(b), err
+
+}
+
+func decodeAuditRecord(record string) (map[string]interface{}, error) {
+
+	fields := map[string]interface{}{}
+	if record == "" {
+		return fields, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(record)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("Could not read record for the audit trail: %s", err)
+	}
+
+	return fields, nil
+
+}
+
+//ListAudit returns entries from the audit trail, newest first
+func ListAudit(q AuditQuery) ([]AuditEntry, error) {
+
+	if q.Limit <= 0 {
+		q.Limit = defaultAuditLimit
+	}
+	if q.Limit > maxAuditLimit {
+		q.Limit = maxAuditLimit
+	}
+
+	var since, until interface{}
+	if !q.Since.IsZero() {
+		since = q.Since
+	}
+	if !q.Until.IsZero() {
+		until = q.Until
+	}
+
+	rows, err := App.DB.Query(sqlToListAudit, q.Schema, q.Table, q.RecordID, q.UserID, q.Operation, since, until, q.Before, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var diff string
+		if err := rows.Scan(&e.ID, &e.At, &e.RequestID, &e.UserID, &e.Role, &e.Schema, &e.Table, &e.RecordID, &e.Operation, &diff); err != nil {
+			return nil, err
+		}
+		e.Diff = json.RawMessage(diff)
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+
+}
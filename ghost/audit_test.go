@@ -0,0 +1,74 @@
+package ghost
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAuditDiff(t *testing.T) {
+
+	testCases := []struct {
+		description, oldRecord, newRecord, exp string
+	}{
+		{"Inserts have new values", "", `{"id":1,"name":"Pen"}`, `{"id":{"new":1},"name":{"new":"Pen"}}`},
+		{"Deletes have old values", `{"id":1,"name":"Pen"}`, "", `{"id":{"old":1},"name":{"old":"Pen"}}`},
+		{"Updates have changed fields only", `{"id":1,"name":"Pen","price":1.50}`, `{"id":1,"name":"Pencil","price":1.50}`, `{"name":{"new":"Pencil","old":"Pen"}}`},
+		{"Nulls are values", `{"id":1,"note":null}`, `{"id":1,"note":"Fragile"}`, `{"note":{"new":"Fragile","old":null}}`},
+		{"Nested values are compared whole", `{"id":1,"tags":["a"]}`, `{"id":1,"tags":["a","b"]}`, `{"tags":{"new":["a","b"],"old":["a"]}}`},
+		{"Unchanged records have no diff", `{"id":1}`, `{"id":1}`, `{}`},
+	}
+
+	for _, c := range testCases {
+		got, err := auditDiff(c.oldRecord, c.newRecord)
+		if err != nil {
+			got = err.Error()
+		}
+		if got != c.exp {
+			TestErrorFatal(t, c.description, got, c.exp)
+		}
+	}
+
+}
+
+func TestRecordID(t *testing.T) {
+
+	testCases := []struct {
+		id, result, exp string
+	}{
+		{"7", `{"id":8}`, "7"},
+		{"", `{"id":8}`, "8"},
+		{"", `{"id":"a1b2"}`, "a1b2"},
+		{"", `{"name":"Pen"}`, ""},
+	}
+
+	for _, c := range testCases {
+		if got := recordID(&RecordEvent{ID: c.id}, c.result); got != c.exp {
+			TestErrorFatal(t, c.result, got, c.exp)
+		}
+	}
+
+}
+
+func TestAuditTriggersSQL(t *testing.T) {
+
+	testCases := []struct {
+		desc  string
+		audit bool
+		exp   string
+	}{
+		{"Attach", true, "'CREATE TRIGGER ghost_audit AFTER INSERT OR UPDATE OR DELETE ON %I.%I FOR EACH ROW EXECUTE PROCEDURE public.ghost_audit_change()', 'shop', t.relname"},
+		{"Detach", false, "'DROP TRIGGER ghost_audit ON %I.%I', 'shop', t.relname"},
+	}
+
+	for _, c := range testCases {
+		got := auditTriggersSQL("shop", c.audit)
+		if !strings.Contains(got, c.exp) || !strings.Contains(got, "n.nspname = 'shop'") {
+			TestErrorFatal(t, c.desc, got, c.exp)
+		}
+	}
+
+	if err := auditBundleTables(nil, "shop; DROP TABLE x", true); err == nil {
+		TestErrorFatal(t, "Invalid bundle name", "no error", "an error")
+	}
+
+}
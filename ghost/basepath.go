@@ -0,0 +1,133 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+//normaliseBasePath turns the basePath setting into the form /app, or "" for the root
+func normaliseBasePath(basePath string) string {
+
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return ""
+	}
+
+	return "/" + basePath
+
+}
+
+//URL returns the path under which the application serves p, taking the basePath
+//setting into account.  Use it for every link and redirect the application generates
+func URL(p string) string {
+
+	return normaliseBasePath(App.Config().BasePath) + "/" + strings.TrimPrefix(p, "/")
+
+}
+
+//AbsoluteURL returns the full URL of p, using the protocol and host settings, e.g. for links in emails
+func AbsoluteURL(p string) string {
+
+	return App.Config().Protocol + "://" + App.Config().Host + URL(p)
+
+}
+
+//withBasePath mounts a handler under the base path.  The prefix is removed before the
+//handler sees the request, so routes are defined as if the application were at the root,
+//and added back to the Location header of any redirects.  Requests outside the base path get a 404
+func withBasePath(basePath string, next http.Handler) http.Handler {
+
+	basePath = normaliseBasePath(basePath)
+	if basePath == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path != basePath && !strings.HasPrefix(r.URL.Path, basePath+"/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		stripped := new(http.Request)
+		*stripped = *r
+		u := *r.URL
+		stripped.URL = &u
+		stripped.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, basePath), "/")
+		if r.URL.RawPath != "" {
+			stripped.URL.RawPath = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.RawPath, basePath), "/")
+		}
+
+		next.ServeHTTP(&basePathResponseWriter{ResponseWriter: w, basePath: basePath}, stripped)
+
+	})
+}
+
+//basePathResponseWriter adds the base path to root-relative redirects
+type basePathResponseWriter struct {
+	http.ResponseWriter
+	basePath    string
+	wroteHeader bool
+}
+
+func (w *basePathResponseWriter) WriteHeader(code int) {
+
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		location := w.Header().Get("Location")
+		if strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//") &&
+			location != w.basePath && !strings.HasPrefix(location, w.basePath+"/") {
+			w.Header().Set("Location", w.basePath+location)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+
+}
+
+func (w *basePathResponseWriter) Write(b []byte) (int, error) {
+
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+
+}
+
+//Flush lets streaming responses through
+func (w *basePathResponseWriter) Flush() {
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+
+}
+
+//Hijack lets websocket upgrades through
+func (w *basePathResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+
+	return nil, nil, errors.New("Response does not support hijacking")
+
+}
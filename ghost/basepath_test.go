@@ -0,0 +1,62 @@
+package ghost
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithBasePath(t *testing.T) {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shop/products", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/shop/products", http.StatusFound)
+	})
+	handler := withBasePath("app/", mux)
+
+	testCases := []struct {
+		description, path string
+		code              int
+		body, location    string
+	}{
+		{"Prefix is removed", "/app/shop/products", http.StatusOK, "/shop/products", ""},
+		{"Redirects get the prefix", "/app/login", http.StatusFound, "", "/app/shop/products"},
+		{"Outside the base path", "/shop/products", http.StatusNotFound, "", ""},
+		{"Similar prefix", "/apple/shop/products", http.StatusNotFound, "", ""},
+	}
+
+	for _, c := range testCases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.code {
+			TestErrorFatal(t, c.description, http.StatusText(w.Code), http.StatusText(c.code))
+		}
+		if c.body != "" && w.Body.String() != c.body {
+			TestErrorFatal(t, c.description, w.Body.String(), c.body)
+		}
+		if got := w.Header().Get("Location"); got != c.location {
+			TestErrorFatal(t, c.description, got, c.location)
+		}
+	}
+
+}
+
+func TestURL(t *testing.T) {
+
+	defer changeConfig(func(c *config) {})()
+
+	for _, c := range []struct{ basePath, path, expected string }{
+		{"", "/shop", "/shop"},
+		{"/app", "/shop", "/app/shop"},
+		{"app/", "shop", "/app/shop"},
+	} {
+		changeConfig(func(s *config) { s.BasePath = c.basePath })
+		if got := URL(c.path); got != c.expected {
+			TestErrorFatal(t, c.basePath+" "+c.path, got, c.expected)
+		}
+	}
+
+}
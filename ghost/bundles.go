@@ -0,0 +1,478 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+const (
+	sqlToDropSchema                  = `DROP SCHEMA IF EXISTS %s CASCADE;`
+	sqlToSetSearchPathForBundle      = `SET LOCAL search_path TO %s, public;`
+	sqlToCreateSchema                = `CREATE SCHEMA %s;`
+	sqlToGrantBundleAdminPermissions = `GRANT USAGE ON SCHEMA %s TO admin; ALTER DEFAULT PRIVILEGES IN SCHEMA %s GRANT ALL ON TABLES TO admin; ALTER DEFAULT PRIVILEGES IN SCHEMA %s GRANT USAGE ON SEQUENCES TO admin;`
+	sqlToRegisterBundle              = `INSERT INTO ghost_bundles(name, version, demodata_loaded) VALUES ($1, $2, $3);`
+	sqlToUnregisterBundle            = `DELETE FROM ghost_bundles WHERE name = $1;`
+
+	//Bundle installation files.  A single install.sql at the root of the bundle is
+	//preferred, but every file in an 'install' folder is also run, in name order
+	bundleInstallFile   = "install.sql"
+	bundleUninstallFile = "uninstall.sql"
+	bundleInstallFolder = "install"
+	bundleDemoFolder    = "demodata"
+)
+
+//bundlesMutex guards the bundle change hooks and which bundles are disabled.  The installed
+//bundle list, which can be changed at runtime through the admin API as well as from the
+//command line, is part of the config, so it is replaced along with it
+var bundlesMutex sync.RWMutex
+
+//bundleChangeHooks are called whenever the installed bundle list changes
+var bundleChangeHooks []func()
+
+//OnBundlesChanged registers a function to be called whenever a bundle is installed
+//or removed on a running server, or the bundle list is changed by a config reload.
+//Use it to invalidate anything derived from the installed bundles
+func OnBundlesChanged(f func()) {
+
+	bundlesMutex.Lock()
+	bundleChangeHooks = append(bundleChangeHooks, f)
+	bundlesMutex.Unlock()
+
+}
+
+//bundlesChanged runs the bundle change hooks
+func bundlesChanged() {
+
+	bundlesMutex.RLock()
+	hooks := bundleChangeHooks
+	bundlesMutex.RUnlock()
+
+	for _, f := range hooks {
+		f()
+	}
+
+}
+
+//BundlePath returns the path of a bundle's folder, or of a file or folder within it
+func BundlePath(bundleName string, elem ...string) string {
+
+	return path.Join(append([]string{"bundles", bundleName}, elem...)...)
+
+}
+
+//IsBundleInstalled reports whether the named bundle is in the installed bundle list
+func IsBundleInstalled(bundleName string) bool {
+
+	for _, b := range App.Config().BundlesInstalled {
+		if b == bundleName {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+//InstalledBundles returns a copy of the installed bundle list
+func InstalledBundles() Bundles {
+
+	installed := App.Config().BundlesInstalled
+	b := make(Bundles, len(installed))
+	copy(b, installed)
+	return b
+
+}
+
+//InstallBundle installs a single bundle, along with any bundles it depends on.
+//See InstallBundles
+func InstallBundle(bundleName string, withDemoData bool) error {
+
+	return InstallBundles([]string{bundleName}, withDemoData)
+
+}
+
+//InstallBundles performs the complete installation of bundles from the bundles folder.
+//Dependencies declared in the bundles' manifests are resolved first, and any which
+//are not yet installed are installed before the bundles that need them.  If anything
+//is missing or a version constraint can't be met, nothing is installed.
+//Demo data is only installed for the requested bundles, not their dependencies.
+//
+//All of the bundles are installed in a single transaction, so if one fails, none of
+//them are.  Unlike the command line installer of old, this never exits the process,
+//so it is safe to call from a running server
+func InstallBundles(bundleNames []string, withDemoData bool) error {
+
+	for _, bundleName := range bundleNames {
+		if !IsValidIdentifier(bundleName) {
+			return errors.New("Invalid bundle name '" + bundleName + "'")
+		}
+		if IsBundleInstalled(bundleName) {
+			return errors.New("Bundle '" + bundleName + "' is already installed")
+		}
+	}
+
+	//Establish a temporary connection as the super user
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	registered, err := registeredBundles(db)
+	if err != nil {
+		return err
+	}
+	installed := installedBundleVersions(registered, InstalledBundles(), readManifestFromBundlesFolder)
+
+	order, err := resolveInstallOrder(bundleNames, installed, readManifestFromBundlesFolder)
+	if err != nil {
+		return err
+	}
+
+	requested := map[string]bool{}
+	for _, bundleName := range bundleNames {
+		requested[bundleName] = true
+	}
+
+	manifests := map[string]BundleManifest{}
+	for _, bundleName := range order {
+		if manifests[bundleName], err = ReadBundleManifest(bundleName); err != nil {
+			return err
+		}
+	}
+
+	err = inTransaction(db, func(tx *sql.Tx) error {
+		for _, bundleName := range order {
+			if !requested[bundleName] {
+				Log("INSTALL", true, "Installing dependency '"+bundleName+"'", nil)
+			}
+			if err := installBundle(tx, bundleName, manifests[bundleName], withDemoData && requested[bundleName]); err != nil {
+				return fmt.Errorf("Could not install '%s', so no bundles were installed: %s", bundleName, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, bundleName := range order {
+		Log("INSTALL", true, "Bundle '"+bundleName+"' version "+manifests[bundleName].Version+" installed", nil)
+	}
+
+	//Update the bundles installed list
+	err = App.updateConfig(func(c *config) error {
+		for _, bundleName := range order {
+			if err := c.InstallBundle(bundleName); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		bundlesChanged()
+		err = App.Config().Save(viper.GetString("configfile"))
+	}
+	if err != nil {
+		return fmt.Errorf("Bundles %s were installed in the database, but the config could not be updated: %s", strings.Join(order, ", "), err)
+	}
+
+	return nil
+
+}
+
+//installBundle installs one bundle: schema creation, installation SQL, the permissions
+//declared in its manifest, optional demo data and recording in the bundle registry.
+//Adding it to the installed bundle list and rewriting the config file is left to
+//InstallBundles, once everything it is installing has been
+func installBundle(tx *sql.Tx, bundleName string, manifest BundleManifest, withDemoData bool) error {
+
+	if err := InstallBundleSchema(tx, bundleName); err != nil {
+		return err
+	}
+
+	if err := applyBundlePermissions(tx, bundleName, manifest); err != nil {
+		return err
+	}
+
+	if withDemoData {
+		if err := InstallBundleDemoData(tx, bundleName); err != nil {
+			return err
+		}
+	}
+
+	//Changes made by the bundle's SQL are audited as well as those made through the Store
+	if App.Config().Audit {
+		if err := auditBundleTables(tx, bundleName, true); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(SQLToCreateBundleRegistry); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(sqlToRegisterBundle, bundleName, manifest.Version, withDemoData)
+	return err
+
+}
+
+//UninstallBundle refuses to remove a bundle which isn't installed, so that a schema of the
+//same name is never dropped, or which other installed bundles depend on.  Otherwise, it runs the bundle's uninstall.sql (if it has one), drops its schema,
+//removes it from the bundle registry and installed bundle list and rewrites the config file
+func UninstallBundle(bundleName string) error {
+
+	if !IsValidIdentifier(bundleName) {
+		return errors.New("Invalid bundle name '" + bundleName + "'")
+	}
+
+	if !IsBundleInstalled(bundleName) {
+		return errors.New("Bundle '" + bundleName + "' is not installed")
+	}
+
+	if err := checkNoDependents(bundleName, readManifestFromBundlesFolder); err != nil {
+		return err
+	}
+
+	//Establish a temporary connection as the super user
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = inTransaction(db, func(tx *sql.Tx) error {
+
+		uninstallFile := BundlePath(bundleName, bundleUninstallFile)
+		if exists, _ := afero.Exists(App.FileSystem, uninstallFile); exists {
+			if err := runBundleFiles(tx, bundleName, []string{uninstallFile}); err != nil {
+				return err
+			}
+		}
+
+		//If the schema doesn't exist, it won't be dropped - no big deal
+		if err := DropBundleSchema(tx, bundleName); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(SQLToCreateBundleRegistry); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(sqlToUnregisterBundle, bundleName)
+		return err
+
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := App.updateConfig(func(c *config) error { return c.UnInstallBundle(bundleName) }); err != nil {
+		return err
+	}
+	bundlesChanged()
+
+	return App.Config().Save(viper.GetString("configfile"))
+
+}
+
+//UninstallBundles uninstalls those of the named bundles which are installed, e.g. before
+//reinstalling them.  Bundles are removed before the bundles they depend on, so that one
+//depending on another isn't refused.  The bundles uninstalled are returned
+func UninstallBundles(bundleNames []string) ([]string, error) {
+
+	order, err := resolveInstallOrder(bundleNames, map[string]string{}, readManifestFromBundlesFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	requested := map[string]bool{}
+	for _, name := range bundleNames {
+		requested[name] = true
+	}
+
+	var uninstalled []string
+	for i := len(order) - 1; i >= 0; i-- {
+		if !requested[order[i]] || !IsBundleInstalled(order[i]) {
+			continue
+		}
+		if err := UninstallBundle(order[i]); err != nil {
+			return uninstalled, err
+		}
+		uninstalled = append(uninstalled, order[i])
+	}
+
+	return uninstalled, nil
+
+}
+
+//DropBundleSchema drops the schema created for a bundle along with everything in it
+func DropBundleSchema(tx *sql.Tx, bundleName string) error {
+
+	_, err := tx.Exec(fmt.Sprintf(sqlToDropSchema, bundleName))
+	return err
+
+}
+
+//InstallBundleSchema creates the bundle's schema and runs its installation files
+func InstallBundleSchema(tx *sql.Tx, bundleName string) error {
+
+	files := bundleInstallFiles(bundleName)
+	if len(files) == 0 {
+		return errors.New("No installation files could be found for bundle '" + bundleName + "'")
+	}
+
+	Log("INSTALL", true, "Installing bundle '"+bundleName+"'", nil)
+
+	//Set up a schema for the bundle
+	if err := setupBundleSchema(tx, bundleName); err != nil {
+		return err
+	}
+
+	return runBundleFiles(tx, bundleName, files)
+
+}
+
+//bundleInstallFiles returns the installation files for a bundle, in the order they should run
+func bundleInstallFiles(bundleName string) []string {
+
+	var files []string
+
+	if exists, _ := afero.Exists(App.FileSystem, BundlePath(bundleName, bundleInstallFile)); exists {
+		files = append(files, BundlePath(bundleName, bundleInstallFile))
+	}
+
+	return append(files, sqlFilesInFolder(BundlePath(bundleName, bundleInstallFolder))...)
+
+}
+
+//sqlFilesInFolder lists the .sql files in a folder, sorted by name
+func sqlFilesInFolder(folder string) []string {
+
+	filesInDirectory, err := afero.ReadDir(App.FileSystem, folder)
+	if err != nil {
+		return nil
+	}
+
+	var files []string
+	for _, file := range filesInDirectory {
+		//Ignore directories and anything that isn't SQL
+		if !file.IsDir() && path.Ext(file.Name()) == ".sql" {
+			files = append(files, path.Join(folder, file.Name()))
+		}
+	}
+
+	sort.Strings(files)
+	return files
+
+}
+
+//runBundleFiles executes each SQL file with the search path set to the bundle's schema
+func runBundleFiles(tx *sql.Tx, bundleName string, files []string) error {
+
+	//Set the search path to the bundle schema so that all SQL commands take
+	//place within the schema
+	if _, err := tx.Exec(fmt.Sprintf(sqlToSetSearchPathForBundle, bundleName)); err != nil {
+		return fmt.Errorf("Failed to set schema search path: %s", err)
+	}
+
+	for _, file := range files {
+		//Attempt to processes the sqlfile
+		if err := processBundleFile(tx, file); err != nil {
+			return fmt.Errorf("Installation of '%s' failed: %s", path.Base(file), err)
+		}
+		Log("INSTALL", true, path.Base(file)+" installed OK", nil)
+	}
+
+	return nil
+
+}
+
+func processBundleFile(tx *sql.Tx, filename string) error {
+
+	//Attempt to read file
+	sqlBytes, err := afero.ReadFile(App.FileSystem, filename)
+	if err != nil {
+		return err
+	}
+
+	//Run the SQL
+	_, err = tx.Exec(string(sqlBytes))
+	return err
+
+}
+
+func setupBundleSchema(tx *sql.Tx, bundleName string) error {
+
+	//Attempt to create a schema matching the bundle's name,
+	if _, err := tx.Exec(fmt.Sprintf(sqlToCreateSchema, bundleName)); err != nil {
+		return err
+	}
+
+	//Set admin privileges for everything in this schema going forwards
+	_, err := tx.Exec(fmt.Sprintf(sqlToGrantBundleAdminPermissions, bundleName, bundleName, bundleName))
+	return err
+
+}
+
+//applyBundlePermissions grants the privileges declared in the bundle's manifest,
+//so that bundles don't need to maintain GRANT statements in their SQL
+func applyBundlePermissions(tx *sql.Tx, bundleName string, manifest BundleManifest) error {
+
+	statements, err := manifest.PermissionsSQL(bundleName)
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("Could not apply permissions: %s", err)
+		}
+	}
+
+	if len(statements) > 0 {
+		Log("INSTALL", true, "Permissions applied", nil)
+	}
+
+	return nil
+
+}
+
+//inTransaction runs f in a transaction, committing if it succeeds and rolling back if not
+func inTransaction(db *sql.DB, f func(tx *sql.Tx) error) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+
+}
@@ -0,0 +1,290 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+//Cache backends
+const (
+	cacheMemory = "memory"
+	cacheRedis  = "redis"
+)
+
+//redisCachePrefix starts the name of every key the cache uses in Redis
+const redisCachePrefix = "ghost:cache:"
+
+//ResultCache holds query results, tagged with the table they were read from (as
+//schema.table) so that they can all be dropped when the table changes.  Results
+//with no table are only dropped when they expire
+type ResultCache interface {
+	//Get returns a cached result or, if there isn't one, the slot to store it in
+	Get(table, key string) (string, bool, CacheSlot)
+	//Set stores a result in the slot from Get, unless the table has changed since
+	Set(slot CacheSlot, value string, ttl time.Duration)
+	//Invalidate drops the results from a table
+	Invalidate(table string)
+	//Purge drops everything
+	Purge()
+}
+
+//CacheSlot is where a result which wasn't in the cache goes once it has been read.  It
+//notes the table's generation when the lookup missed, so that a result read while the
+//table was being changed is never stored as the result from after the change
+type CacheSlot struct {
+	table, key string
+	generation int
+	purges     int
+	//resultKey is the key in Redis, which is made from the generations
+	resultKey string
+}
+
+//newResultCache returns the cache backend in the config, falling back to memory if
+//Redis isn't configured properly
+func newResultCache(c config) ResultCache {
+
+	if c.Cache != cacheRedis {
+		return newMemoryCache()
+	}
+
+	options, err := redis.ParseURL(c.RedisURL)
+	if err != nil {
+		Log("CACHE", false, "Invalid redisURL, caching in memory instead", err)
+		return newMemoryCache()
+	}
+
+	return &redisCache{client: redis.NewClient(options)}
+
+}
+
+//cacheTTL is how long results from a table are cached: the query's own expiry, the
+//table's entry in the cacheTables setting, or the general cacheTTL, in that order.
+//Zero means the results aren't cached
+func cacheTTL(c config, table string, queryExpiry int) time.Duration {
+
+	if queryExpiry > 0 {
+		return time.Duration(queryExpiry) * time.Second
+	}
+
+	if seconds, ok := c.CacheTables[table]; ok {
+		return time.Duration(seconds) * time.Second
+	}
+
+	return time.Duration(c.CacheTTL) * time.Second
+
+}
+
+//memoryCache keeps results in this server's memory.  When the change feed is running,
+//changes made on other servers invalidate it too
+type memoryCache struct {
+	mutex       sync.Mutex
+	entries     map[string]memoryCacheEntry
+	generations map[string]int
+	purges      int
+	lastSweep   time.Time
+}
+
+type memoryCacheEntry struct {
+	value, table string
+	generation   int
+	expires      time.Time
+}
+
+//memoryCacheSweep is how often expired entries are cleared out
+const memoryCacheSweep = time.Minute
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{
+		entries:     map[string]memoryCacheEntry{},
+		generations: map[string]int{},
+		lastSweep:   time.Now(),
+	}
+}
+
+func (m *memoryCache) Get(table, key string) (string, bool, CacheSlot) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	slot := CacheSlot{table: table, key: key, generation: m.generations[table], purges: m.purges}
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return "", false, slot
+	}
+
+	if time.Now().After(entry.expires) || entry.generation != m.generations[entry.table] {
+		delete(m.entries, key)
+		return "", false, slot
+	}
+
+	return entry.value, true, slot
+
+}
+
+func (m *memoryCache) Set(slot CacheSlot, value string, ttl time.Duration) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	//The table changed while the result was being read, so it may be out of date
+	if slot.generation != m.generations[slot.table] || slot.purges != m.purges {
+		return
+	}
+
+	now := time.Now()
+	m.entries[slot.key] = memoryCacheEntry{value, slot.table, slot.generation, now.Add(ttl)}
+
+	if now.Sub(m.lastSweep) > memoryCacheSweep {
+		for k, entry := range m.entries {
+			if now.After(entry.expires) || entry.generation != m.generations[entry.table] {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+}
+
+func (m *memoryCache) Invalidate(table string) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.generations[table]++
+
+}
+
+func (m *memoryCache) Purge() {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entries = map[string]memoryCacheEntry{}
+	m.purges++
+
+}
+
+//redisCache keeps results in Redis, shared by every server.  Each table has a
+//generation number which is part of its results' keys, so invalidating a table is
+//a matter of moving its generation on, and the old results expire by themselves
+type redisCache struct {
+	client *redis.Client
+}
+
+//generationKeys are the keys holding the generation of everything, for Purge, and of a table
+func (c *redisCache) generationKeys(table string) []string {
+	return []string{redisCachePrefix + "generation", redisCachePrefix + "generation:" + table}
+}
+
+//resultKey works out the key of a result from the current generations
+func (c *redisCache) resultKey(table, key string) (string, error) {
+
+	generations, err := c.client.MGet(c.generationKeys(table)...).Result()
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha1.Sum([]byte(key))
+	resultKey := redisCachePrefix + "result:" + table
+	for _, g := range generations {
+		generation, _ := g.(string)
+		resultKey += ":" + generation
+	}
+
+	return resultKey + ":" + hex.EncodeToString(hash[:]), nil
+
+}
+
+func (c *redisCache) Get(table, key string) (string, bool, CacheSlot) {
+
+	resultKey, err := c.resultKey(table, key)
+	if err != nil {
+		Log("CACHE", false, "Could not read from Redis", err)
+		return "", false, CacheSlot{}
+	}
+
+	//The key is made from the generations now, so a result stored in it after the
+	//table changes is under the old generation and is never read
+	slot := CacheSlot{table: table, key: key, resultKey: resultKey}
+
+	value, err := c.client.Get(resultKey).Result()
+	if err != nil {
+		if err != redis.Nil {
+			Log("CACHE", false, "Could not read from Redis", err)
+		}
+		return "", false, slot
+	}
+
+	return value, true, slot
+
+}
+
+func (c *redisCache) Set(slot CacheSlot, value string, ttl time.Duration) {
+
+	if slot.resultKey == "" {
+		return
+	}
+
+	if err := c.client.Set(slot.resultKey, value, ttl).Err(); err != nil {
+		Log("CACHE", false, "Could not write to Redis", err)
+	}
+
+}
+
+func (c *redisCache) Invalidate(table string) {
+
+	if err := c.client.Incr(c.generationKeys(table)[1]).Err(); err != nil {
+		Log("CACHE", false, "Could not invalidate "+table+" in Redis", err)
+	}
+
+}
+
+func (c *redisCache) Purge() {
+
+	if err := c.client.Incr(c.generationKeys("")[0]).Err(); err != nil {
+		Log("CACHE", false, "Could not purge the Redis cache", err)
+	}
+
+}
+
+//ping checks that Redis can be reached
+func (c *redisCache) ping() error {
+	return c.client.Ping().Err()
+}
+
+//invalidateCache drops the cached results from a table after it has changed
+func invalidateCache(schemaName, tableName string) {
+	if App.Cache != nil {
+		App.Cache.Invalidate(schemaName + "." + tableName)
+	}
+}
+
+//cacheTable is the table a query reads from, as schema.table, if it is known
+func (q *Query) cacheTable() string {
+
+	if q.Schema == "" || q.Table == "" {
+		return ""
+	}
+
+	return q.Schema + "." + q.Table
+
+}
@@ -0,0 +1,85 @@
+package ghost
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryCache(t *testing.T) {
+
+	cache := newMemoryCache()
+	set := func(table, key, value string, ttl time.Duration) {
+		_, _, slot := cache.Get(table, key)
+		cache.Set(slot, value, ttl)
+	}
+	set("shop.orders", "orders", "[1,2]", time.Minute)
+	set("shop.products", "products", "[3]", time.Minute)
+	set("", "custom", "[4]", time.Minute)
+	set("shop.orders", "expired", "[5]", -time.Second)
+
+	testCases := []struct {
+		desc, table, key string
+		invalidate       string
+		exp              string
+	}{
+		{"Cached", "shop.orders", "orders", "", "[1,2]"},
+		{"Not cached", "shop.orders", "other", "", ""},
+		{"Expired", "shop.orders", "expired", "", ""},
+		{"Invalidated", "shop.orders", "orders", "shop.orders", ""},
+		{"Other tables are kept", "shop.products", "products", "shop.orders", "[3]"},
+		{"Results without a table are kept", "", "custom", "shop.orders", "[4]"},
+	}
+
+	for _, c := range testCases {
+		if c.invalidate != "" {
+			cache.Invalidate(c.invalidate)
+		}
+		got, _, _ := cache.Get(c.table, c.key)
+		if got != c.exp {
+			TestErrorFatal(t, c.desc, got, c.exp)
+		}
+	}
+
+	//A result read while its table was changed isn't stored
+	_, _, slot := cache.Get("shop.products", "stale")
+	cache.Invalidate("shop.products")
+	cache.Set(slot, "[6]", time.Minute)
+	if got, _, _ := cache.Get("shop.products", "stale"); got != "" {
+		TestErrorFatal(t, "Read during a change", got, "")
+	}
+
+	_, _, slot = cache.Get("shop.products", "purged")
+	cache.Purge()
+	cache.Set(slot, "[7]", time.Minute)
+	if got, ok, _ := cache.Get("shop.products", "products"); ok {
+		TestErrorFatal(t, "Purged", got, "")
+	}
+	if got, _, _ := cache.Get("shop.products", "purged"); got != "" {
+		TestErrorFatal(t, "Read during a purge", got, "")
+	}
+
+}
+
+func TestCacheTTL(t *testing.T) {
+
+	conf := config{CacheTTL: 5, CacheTables: map[string]int{"shop.orders": 0, "shop.products": 60}}
+
+	testCases := []struct {
+		desc, table string
+		queryExpiry int
+		exp         time.Duration
+	}{
+		{"General", "shop.customers", 0, 5 * time.Second},
+		{"Table", "shop.products", 0, time.Minute},
+		{"Table not cached", "shop.orders", 0, 0},
+		{"Query's own expiry", "shop.orders", 30, 30 * time.Second},
+		{"No table", "", 0, 5 * time.Second},
+	}
+
+	for _, c := range testCases {
+		if got := cacheTTL(conf, c.table, c.queryExpiry); got != c.exp {
+			TestErrorFatal(t, c.desc, got.String(), c.exp.String())
+		}
+	}
+
+}
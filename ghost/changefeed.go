@@ -0,0 +1,466 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+//changeChannel is the Postgres notification channel which carries change events
+const changeChannel = "ghost_changes"
+
+//maxChangePayload keeps notifications under Postgres's 8000 byte limit.  Bigger events
+//are sent without the record
+const maxChangePayload = 7900
+
+const (
+	//sqlToNumberChange takes the next change event ID
+	sqlToNumberChange = `SELECT nextval('public.ghost_change_seq');`
+
+	//sqlToNotifyChange sends a change event to every server listening, exactly as encoded
+	sqlToNotifyChange = `SELECT pg_notify('` + changeChannel + `', $1);`
+
+	//Change events are numbered from a sequence, so that every server gives an event
+	//the same ID, and clients can pick up where they left off on any of them
+	sqlToCreateChangeSequence = `CREATE SEQUENCE IF NOT EXISTS public.ghost_change_seq;
+	GRANT USAGE ON SEQUENCE public.ghost_change_seq TO PUBLIC;`
+
+	//sqlToMarkStoreWrite stops the change trigger repeating events sent by the Store
+	sqlToMarkStoreWrite = `SELECT set_config('ghost.store_write', 'on', true);`
+
+	//sqlToCreateChangeTrigger creates the trigger function which sends change events for
+	//changes made other than through the Store.  Attach it to a table with
+	//	CREATE TRIGGER orders_changes AFTER INSERT OR UPDATE OR DELETE ON shop.orders
+	//	FOR EACH ROW EXECUTE PROCEDURE public.ghost_notify_change();
+	sqlToCreateChangeTrigger = `CREATE OR REPLACE FUNCTION public.ghost_notify_change() RETURNS trigger AS $$
+DECLARE
+	r json;
+	id bigint;
+	payload text;
+BEGIN
+	IF current_setting('ghost.store_write', true) = 'on' THEN
+		RETURN NULL;
+	END IF;
+	IF TG_OP = 'DELETE' THEN
+		r := row_to_json(OLD);
+	ELSE
+		r := row_to_json(NEW);
+	END IF;
+	id := nextval('public.ghost_change_seq');
+	payload := json_build_object('id', id, 'schema', TG_TABLE_SCHEMA, 'table', TG_TABLE_NAME, 'operation', lower(TG_OP), 'recordId', r->>'id', 'record', r)::text;
+	IF octet_length(payload) > 7900 THEN
+		payload := json_build_object('id', id, 'schema', TG_TABLE_SCHEMA, 'table', TG_TABLE_NAME, 'operation', lower(TG_OP), 'recordId', r->>'id')::text;
+	END IF;
+	PERFORM pg_notify('` + changeChannel + `', payload);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;`
+)
+
+//ChangeEvent describes a record which has been inserted, updated or deleted.  Record is
+//the whole record as JSON (as it was before a delete), unless it was too big to send.
+//Each event has its own ID
+type ChangeEvent struct {
+	ID        int64           `json:"id"`
+	Schema    string          `json:"schema"`
+	Table     string          `json:"table"`
+	Operation string          `json:"operation"`
+	RecordID  string          `json:"recordId"`
+	Record    json.RawMessage `json:"record,omitempty"`
+}
+
+//changeTopic is what a subscriber wants to hear about: changes to a table, optionally
+//only to records whose fields have the values in the filter
+type changeTopic struct {
+	Schema, Table string
+	Filter        map[string]string
+	//WithRecord is false for tables the subscriber may not see every record of,
+	//in which case only the record ID is sent
+	WithRecord bool
+}
+
+//matches reports whether an event is on the topic.  Filtered topics need the record
+//to check, so events without one are not matched
+func (t changeTopic) matches(e ChangeEvent, fields map[string]interface{}) bool {
+
+	if e.Schema != t.Schema || e.Table != t.Table {
+		return false
+	}
+
+	if len(t.Filter) == 0 {
+		return true
+	}
+	if fields == nil || !t.WithRecord {
+		return false
+	}
+
+	for field, value := range t.Filter {
+		v, ok := fields[field]
+		if !ok || v == nil || fmt.Sprint(v) != value {
+			return false
+		}
+	}
+
+	return true
+
+}
+
+//subscribedChange is a change sent to a subscriber, with the subscriptions it matched
+type subscribedChange struct {
+	Subscriptions []int
+	Event         ChangeEvent
+}
+
+//changeSubscriber is a client listening to the change feed, on any number of topics.
+//A subscriber which doesn't keep up is closed, rather than holding up everyone else
+type changeSubscriber struct {
+	mutex  sync.Mutex
+	topics map[int]changeTopic
+	events chan subscribedChange
+	closed bool
+}
+
+var (
+	changeSubscribers      = map[*changeSubscriber]bool{}
+	changeSubscribersMutex sync.RWMutex
+)
+
+//newChangeSubscriber adds a subscriber to the change feed.  Close it when done
+func newChangeSubscriber() *changeSubscriber {
+
+	s := &changeSubscriber{
+		topics: map[int]changeTopic{},
+		events: make(chan subscribedChange, 64),
+	}
+
+	changeSubscribersMutex.Lock()
+	changeSubscribers[s] = true
+	changeSubscribersMutex.Unlock()
+
+	return s
+
+}
+
+//subscribe adds or replaces a topic, under the subscription ID the client gave
+func (s *changeSubscriber) subscribe(id int, topic changeTopic) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.topics[id] = topic
+
+}
+
+//unsubscribe removes a topic, reporting whether there was one
+func (s *changeSubscriber) unsubscribe(id int) bool {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, ok := s.topics[id]
+	delete(s.topics, id)
+	return ok
+
+}
+
+//close takes the subscriber off the change feed and closes its events channel
+func (s *changeSubscriber) close() {
+
+	changeSubscribersMutex.Lock()
+	delete(changeSubscribers, s)
+	changeSubscribersMutex.Unlock()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+
+}
+
+//send passes an event to the subscriber if it matches any of its topics
+func (s *changeSubscriber) send(e ChangeEvent, fields map[string]interface{}) {
+
+	s.mutex.Lock()
+
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+
+	var matched []int
+	withRecord := true
+	for id, topic := range s.topics {
+		if topic.matches(e, fields) {
+			matched = append(matched, id)
+			withRecord = withRecord && topic.WithRecord
+		}
+	}
+
+	if len(matched) == 0 {
+		s.mutex.Unlock()
+		return
+	}
+
+	if !withRecord {
+		e.Record = nil
+	}
+
+	sort.Ints(matched)
+
+	select {
+	case s.events <- subscribedChange{matched, e}:
+		s.mutex.Unlock()
+	default:
+		//Too far behind, so give up on it
+		s.mutex.Unlock()
+		s.close()
+	}
+
+}
+
+//changeHistory keeps the latest events, so that clients which lose their connection
+//can catch up on what they missed
+var changeHistory = struct {
+	sync.RWMutex
+	events []ChangeEvent
+}{}
+
+//defaultChangeHistory is the number of events kept if the changeHistory setting isn't given
+const defaultChangeHistory = 1000
+
+//recordChange adds an event to the history, dropping the oldest once it is full
+func recordChange(e ChangeEvent) {
+
+	size := App.Config().ChangeHistory
+	if size <= 0 {
+		size = defaultChangeHistory
+	}
+
+	changeHistory.Lock()
+	defer changeHistory.Unlock()
+
+	changeHistory.events = append(changeHistory.events, e)
+	if extra := len(changeHistory.events) - size; extra > 0 {
+		changeHistory.events = append([]ChangeEvent(nil), changeHistory.events[extra:]...)
+	}
+
+}
+
+//changesSince returns the events which came after the one with the given ID.  Postgres
+//delivers notifications in the order their transactions commit, which every server
+//sees the same, but which isn't always the order of the IDs.  It reports false if the
+//event is no longer in the history, since then some events may be missing
+func changesSince(id int64) ([]ChangeEvent, bool) {
+
+	changeHistory.RLock()
+	defer changeHistory.RUnlock()
+
+	for i := len(changeHistory.events) - 1; i >= 0; i-- {
+		if changeHistory.events[i].ID == id {
+			return append([]ChangeEvent(nil), changeHistory.events[i+1:]...), true
+		}
+	}
+
+	return nil, false
+
+}
+
+//publishChange passes an event to every subscriber on this server
+func publishChange(e ChangeEvent) {
+
+	recordChange(e)
+
+	//Changes made on other servers, or outside the Store, invalidate the cache here too
+	invalidateCache(e.Schema, e.Table)
+
+	fields := recordFields(e.Record)
+
+	changeSubscribersMutex.RLock()
+	subscribers := make([]*changeSubscriber, 0, len(changeSubscribers))
+	for s := range changeSubscribers {
+		subscribers = append(subscribers, s)
+	}
+	changeSubscribersMutex.RUnlock()
+
+	for _, s := range subscribers {
+		s.send(e, fields)
+	}
+
+}
+
+//recordFields decodes a record in a change event, for filtering.  It returns nil if there is no record
+func recordFields(record json.RawMessage) map[string]interface{} {
+
+	var fields map[string]interface{}
+	if len(record) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(record))
+		decoder.UseNumber()
+		decoder.Decode(&fields)
+	}
+
+	return fields
+
+}
+
+//notifyChange sends a change made through the Store to the change feed.  It is sent
+//in the transaction making the change, so it only goes out if the change is committed
+func notifyChange(tx *sql.Tx, operation string, e *RecordEvent, result string) error {
+
+	event := ChangeEvent{
+		Schema:    e.Schema,
+		Table:     e.Table,
+		Operation: operation,
+		RecordID:  recordID(e, result),
+		Record:    json.RawMessage(result),
+	}
+
+	//The event is numbered first, so that its size is checked as it will be sent
+	if err := tx.QueryRow(sqlToNumberChange).Scan(&event.ID); err != nil {
+		return err
+	}
+
+	payload, err := changePayload(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(sqlToNotifyChange, payload)
+	return err
+
+}
+
+//recordID returns the ID of the record changed, which for inserts is in the result
+func recordID(e *RecordEvent, result string) string {
+
+	if e.ID != "" {
+		return e.ID
+	}
+
+	var record struct {
+		ID interface{} `json:"id"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(result))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err == nil && record.ID != nil {
+		return fmt.Sprint(record.ID)
+	}
+
+	return ""
+
+}
+
+//execer runs a statement, as a transaction or connection pool does
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+//changePayload encodes an event for a notification, leaving out the record if it is too big.
+//The record is compacted as it is encoded, so the payload is no bigger than it is measured
+func changePayload(e ChangeEvent) (string, error) {
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	if len(payload) > maxChangePayload {
+		e.Record = nil
+		if payload, err = json.Marshal(e); err != nil {
+			return "", err
+		}
+	}
+
+	return string(payload), nil
+
+}
+
+//startChangeFeed listens for change events and passes them to subscribers until the
+//context is done.  Events sent while the connection is down are lost
+func startChangeFeed(ctx context.Context) {
+
+	listener := pq.NewListener(SuperUserDBConfig.getDBConnectionString(""), 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected:
+			Log("CHANGES", false, "Lost the connection to the change feed, reconnecting", err)
+		case pq.ListenerEventReconnected:
+			Log("CHANGES", true, "Reconnected to the change feed", nil)
+		case pq.ListenerEventConnectionAttemptFailed:
+			Log("CHANGES", false, "Could not connect to the change feed", err)
+		}
+	})
+
+	if err := listener.Listen(changeChannel); err != nil {
+		Log("CHANGES", false, "Could not listen for changes.  Subscriptions will not receive any", err)
+		listener.Close()
+		return
+	}
+
+	go func() {
+
+		defer listener.Close()
+
+		for {
+			select {
+
+			case <-ctx.Done():
+				return
+
+			case n := <-listener.Notify:
+				//nil is sent after a reconnection
+				if n == nil {
+					continue
+				}
+				var e ChangeEvent
+				if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+					Log("CHANGES", false, "Invalid change event", err)
+					continue
+				}
+				publishChange(e)
+
+			case <-time.After(90 * time.Second):
+				//Check the connection is still alive when things are quiet
+				go listener.Ping()
+
+			}
+		}
+
+	}()
+
+}
+
+//setupChangeFeed creates the trigger function for sending change events, as the super user
+func setupChangeFeed(db execer) error {
+
+	if _, err := db.Exec(sqlToCreateChangeSequence); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(sqlToCreateChangeTrigger)
+	return err
+
+}
@@ -0,0 +1,120 @@
+package ghost
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChangeTopicMatches(t *testing.T) {
+
+	record := json.RawMessage(`{"id": 7, "status": "paid", "total": 10.5}`)
+	event := ChangeEvent{Schema: "shop", Table: "orders", Operation: OperationUpdate, RecordID: "7", Record: record}
+
+	testCases := []struct {
+		desc  string
+		topic changeTopic
+		event ChangeEvent
+		exp   bool
+	}{
+		{"Table", changeTopic{Schema: "shop", Table: "orders", WithRecord: true}, event, true},
+		{"Other table", changeTopic{Schema: "shop", Table: "products", WithRecord: true}, event, false},
+		{"Filter matches", changeTopic{Schema: "shop", Table: "orders", Filter: map[string]string{"status": "paid", "total": "10.5"}, WithRecord: true}, event, true},
+		{"Filter doesn't match", changeTopic{Schema: "shop", Table: "orders", Filter: map[string]string{"status": "new"}, WithRecord: true}, event, false},
+		{"Filter on missing field", changeTopic{Schema: "shop", Table: "orders", Filter: map[string]string{"customer": "1"}, WithRecord: true}, event, false},
+		{"Filter without record", changeTopic{Schema: "shop", Table: "orders", Filter: map[string]string{"status": "paid"}, WithRecord: true}, ChangeEvent{Schema: "shop", Table: "orders"}, false},
+		{"Row level security", changeTopic{Schema: "shop", Table: "orders"}, event, true},
+	}
+
+	for _, c := range testCases {
+
+		s := newChangeSubscriber()
+		s.subscribe(1, c.topic)
+		publishChange(c.event)
+		s.close()
+
+		var got []string
+		for change := range s.events {
+			got = append(got, fmt.Sprint(change.Subscriptions, " ", string(change.Event.Record)))
+		}
+
+		exp := 0
+		if c.exp {
+			exp = 1
+		}
+		if len(got) != exp {
+			TestErrorFatal(t, c.desc, strings.Join(got, ","), fmt.Sprint(c.exp))
+		}
+		if c.exp && !c.topic.WithRecord && strings.Contains(got[0], "status") {
+			TestErrorFatal(t, c.desc, got[0], "no record")
+		}
+
+	}
+
+}
+
+func TestSlowChangeSubscriber(t *testing.T) {
+
+	s := newChangeSubscriber()
+	s.subscribe(1, changeTopic{Schema: "shop", Table: "orders"})
+
+	for i := 0; i <= cap(s.events); i++ {
+		publishChange(ChangeEvent{Schema: "shop", Table: "orders", RecordID: fmt.Sprint(i)})
+	}
+
+	changeSubscribersMutex.RLock()
+	subscribed := changeSubscribers[s]
+	changeSubscribersMutex.RUnlock()
+
+	s.mutex.Lock()
+	closed := s.closed
+	s.mutex.Unlock()
+
+	if subscribed || !closed {
+		TestErrorFatal(t, "Subscriber which doesn't keep up is closed", "open", "closed")
+	}
+
+}
+
+func TestChangePayload(t *testing.T) {
+
+	testCases := []struct {
+		desc   string
+		record string
+		exp    string
+	}{
+		{"Small record", `{"id": 1}`, `{"id":1234567,"schema":"shop","table":"orders","operation":"insert","recordId":"1","record":{"id":1}}`},
+		{"Big record", `{"id":1,"notes":"` + strings.Repeat("x", maxChangePayload) + `"}`, `{"id":1234567,"schema":"shop","table":"orders","operation":"insert","recordId":"1"}`},
+	}
+
+	for _, c := range testCases {
+		got, err := changePayload(ChangeEvent{ID: 1234567, Schema: "shop", Table: "orders", Operation: OperationInsert, RecordID: "1", Record: json.RawMessage(c.record)})
+		if err != nil {
+			TestErrorFatal(t, c.desc, err.Error(), c.exp)
+		}
+		if got != c.exp {
+			TestErrorFatal(t, c.desc, got, c.exp)
+		}
+	}
+
+	//Records near the limit, including ones spaced out as Postgres returns them, are
+	//only sent if the payload, with its ID, is within the limit
+	envelope := len(`{"id":1234567,"schema":"shop","table":"orders","operation":"insert","recordId":"1","record":{"id":1,"notes":""}}`)
+	for _, size := range []int{maxChangePayload - envelope - 1, maxChangePayload - envelope, maxChangePayload - envelope + 1} {
+
+		record := `{"id": 1, "notes": "` + strings.Repeat("x", size) + `"}`
+		got, err := changePayload(ChangeEvent{ID: 1234567, Schema: "shop", Table: "orders", Operation: OperationInsert, RecordID: "1", Record: json.RawMessage(record)})
+		if err != nil {
+			TestErrorFatal(t, "Record near the limit", err.Error(), "")
+		}
+		if len(got) > maxChangePayload {
+			TestErrorFatal(t, "Record near the limit", fmt.Sprint(len(got)), fmt.Sprint(maxChangePayload))
+		}
+		if sent := strings.Contains(got, `"record"`); sent != (size <= maxChangePayload-envelope) {
+			TestErrorFatal(t, "Record near the limit sent", fmt.Sprint(sent), fmt.Sprint(!sent))
+		}
+
+	}
+
+}
@@ -18,13 +18,19 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"reflect"
+	"strings"
 
+	"github.com/spf13/afero"
 	"github.com/spf13/viper"
 )
 
 type Bundles []string
 
-//Config is the basic structure of the config.json file
+//Config is the basic structure of the config.json file.  Programs embedding the server
+//start from Defaults and pass it to New
+type Config = config
+
 type config struct {
 
 	//PG Settings
@@ -39,6 +45,22 @@ type config struct {
 	JWTRealm string `json:"jwtRealm"`
 	Host     string `json:"host"`
 	Protocol string `json:"protocol"`
+	//BasePath mounts the whole application under a prefix, e.g. /app
+	BasePath string `json:"basePath"`
+	//BindAddress limits the interface the server listens on, e.g. 127.0.0.1.
+	//Use unix:/path/to.sock to listen on a unix socket instead of apiPort
+	BindAddress string `json:"bindAddress"`
+
+	//TLS Settings
+	//Serve HTTPS using the certificate and key files, or with certificates obtained
+	//automatically from Let's Encrypt for the autocert domains
+	TLSCertFile      string   `json:"tlsCertFile"`
+	TLSKeyFile       string   `json:"tlsKeyFile"`
+	AutocertDomains  []string `json:"autocertDomains"`
+	AutocertEmail    string   `json:"autocertEmail"`
+	AutocertCacheDir string   `json:"autocertCacheDir"`
+	//HTTPRedirectPort, if set, is a port on which plain HTTP requests are redirected to HTTPS
+	HTTPRedirectPort string `json:"httpRedirectPort"`
 
 	//Email Settings
 	ActivateEmail bool   `json:"activateEmail"`
@@ -47,13 +69,104 @@ type config struct {
 	SmtpUserName  string `json:"smtpUserName"`
 	SmtpFrom      string `json:"smtpFrom"`
 	EmailFrom     string `json:"emailFrom"`
+	//SMTP connection: smtpTLS is "starttls", "tls" (implicit TLS) or "none", smtpAuth is
+	//"plain", "login", "cram-md5" or "none" and smtpTimeout is in seconds.
+	//smtpSkipVerify accepts any server certificate, so only use it on a trusted network
+	SmtpTLS        string `json:"smtpTLS"`
+	SmtpAuth       string `json:"smtpAuth"`
+	SmtpTimeout    int    `json:"smtpTimeout"`
+	SmtpSkipVerify bool   `json:"smtpSkipVerify"`
+	//EmailAPIRoles are the roles allowed to send email through POST /email
+	EmailAPIRoles []string `json:"emailAPIRoles"`
 
 	//Bundles installed
 	BundlesInstalled Bundles `json:"bundlesInstalled"`
 
+	//Admin panel
+	AdminPanelDir    string            `json:"adminPanelDir"`
+	AdminPanelMounts map[string]string `json:"adminPanelMounts"`
+
+	//Access log: "" for none, "stdout", or a file which is rotated once it reaches
+	//AccessLogMaxSize megabytes.  The format is "common" or "combined"
+	AccessLog           string `json:"accessLog"`
+	AccessLogFormat     string `json:"accessLogFormat"`
+	AccessLogMaxSize    int    `json:"accessLogMaxSize"`
+	AccessLogMaxBackups int    `json:"accessLogMaxBackups"`
+	AccessLogMaxAge     int    `json:"accessLogMaxAge"`
+
+	//Server timeouts in seconds (0 for none), the maximum size of request headers in bytes,
+	//and whether to offer HTTP/2 to clients connecting over TLS
+	ServerReadTimeout       int  `json:"serverReadTimeout"`
+	ServerReadHeaderTimeout int  `json:"serverReadHeaderTimeout"`
+	ServerWriteTimeout      int  `json:"serverWriteTimeout"`
+	ServerIdleTimeout       int  `json:"serverIdleTimeout"`
+	MaxHeaderBytes          int  `json:"maxHeaderBytes"`
+	HTTP2                   bool `json:"http2"`
+
+	//Cache is where query results are cached: "memory" or "redis", at RedisURL, e.g.
+	//redis://localhost:6379/0, which lets several servers share it.  Results are kept for
+	//CacheTTL seconds, or the number of seconds for their table, as schema.table, in
+	//CacheTables.  0 means not cached
+	Cache       string         `json:"cache"`
+	RedisURL    string         `json:"redisURL"`
+	CacheTTL    int            `json:"cacheTTL"`
+	CacheTables map[string]int `json:"cacheTables"`
+
+	//Storage is where the application keeps files: "local", in StorageDir, "memory", which
+	//is lost on restart, "s3" or "gcs", in StorageBucket.  StorageRegion and StorageEndpoint,
+	//e.g. http://localhost:9000, point to other S3 compatible services.  The keys go in
+	//the storageAccessKey and storageSecretKey settings.  FileRoles are the roles which
+	//can upload and delete files through /files
+	Storage         string   `json:"storage"`
+	StorageDir      string   `json:"storageDir"`
+	StorageBucket   string   `json:"storageBucket"`
+	StorageRegion   string   `json:"storageRegion"`
+	StorageEndpoint string   `json:"storageEndpoint"`
+	FileRoles       []string `json:"fileRoles"`
+
+	//Maximum request body sizes in bytes, for API calls and for file uploads.  0 means no limit
+	MaxBodySize   int64 `json:"maxBodySize"`
+	MaxUploadSize int64 `json:"maxUploadSize"`
+
+	//Maintenance mode turns away all but admin requests with a 503, asking clients to
+	//retry after MaintenanceRetryAfter seconds.  Browsers get MaintenanceTemplate
+	Maintenance           bool   `json:"maintenance"`
+	MaintenanceRetryAfter int    `json:"maintenanceRetryAfter"`
+	MaintenanceTemplate   string `json:"maintenanceTemplate"`
+
+	//ScheduledJobs run inside the server on a cron schedule, alongside any in the
+	//ghost_scheduled_jobs table
+	ScheduledJobs []ScheduledJob `json:"scheduledJobs"`
+
+	//JobWorkers is the number of workers running jobs from the job queue (0 for none),
+	//which check for jobs every JobPollInterval seconds and give each JobTimeout seconds
+	JobWorkers      int `json:"jobWorkers"`
+	JobPollInterval int `json:"jobPollInterval"`
+	JobTimeout      int `json:"jobTimeout"`
+
+	//ChangeHistory is the number of change events kept for event stream clients to catch up on
+	ChangeHistory int `json:"changeHistory"`
+
+	//Audit records every change made through the Store in the ghost_audit table, and every
+	//change to the installed bundles' tables, e.g. by bundle SQL called through the API, with
+	//the ghost_audit trigger.  The trigger is attached or removed when the server starts and
+	//when bundles are installed or upgraded
+	Audit bool `json:"audit"`
+
+	//VersionHeader adds the X-Ghost-Version header, with the build's version, to every response
+	VersionHeader bool `json:"versionHeader"`
+
+	//WatchConfig reloads the config whenever the config file changes
+	WatchConfig bool `json:"watchConfig"`
+
 	//Global middleware activation
 	GlobalMiddleware []string `json:"globalMiddleware"`
 	Timeout          int      `json:"timout"`
+	//Timeouts in seconds for groups of routes.  Requests are in the 'read' or 'write'
+	//group depending on their method, unless their path starts with one of the prefixes
+	//in TimeoutRoutes, e.g. {"/export": "export"}.  Groups not listed use Timeout
+	Timeouts      map[string]int    `json:"timeouts"`
+	TimeoutRoutes map[string]string `json:"timeoutRoutes"`
 
 	//CORS Settings
 	ActivateCors         bool     `json:"activateCors"`
@@ -69,14 +182,15 @@ type config struct {
 //TODO: Will overwrite existing config.json, so ask for confirmation
 func CreateDefaultConfigFile(configFileName string) error {
 
-	configJSON, _ := json.MarshalIndent(Defaults, "", "\t")
-	fileName := configFileName + ".json"
-	err := ioutil.WriteFile(fileName, configJSON, 0644)
-	if err != nil {
-		return err
-	}
+	return WriteConfigFile(afero.NewOsFs(), configFileName+".json", Defaults)
 
-	return nil
+}
+
+//WriteConfigFile writes a config out as JSON
+func WriteConfigFile(fs afero.Fs, fileName string, c Config) error {
+
+	configJSON, _ := json.MarshalIndent(c, "", "\t")
+	return afero.WriteFile(fs, fileName, configJSON, 0644)
 
 }
 
@@ -87,6 +201,9 @@ func (c *config) Setup(configFileName string) {
 	viper.AddConfigPath(".")
 	viper.SetConfigName(configFileName)
 
+	//Config files written before cacheTTL was a setting keep the 5 seconds they always had
+	viper.SetDefault("cacheTTL", Defaults.CacheTTL)
+
 	if err := viper.ReadInConfig(); err == nil {
 
 		//Unmarshall the whole config file into a config object
@@ -104,6 +221,29 @@ func (c *config) Setup(configFileName string) {
 
 }
 
+//Save writes the config object back out to the named config file (without extension)
+func (c *config) Save(configFileName string) error {
+
+	configJSON, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	//The secret isn't part of the config struct, so carry over any written by 'ghost gensecret'
+	fileName := configFileName + ".json"
+	if existing, err := ioutil.ReadFile(fileName); err == nil {
+		if secret, ok := readConfigSecret(existing); ok {
+			if configJSON, err = setConfigSecret(configJSON, secret); err != nil {
+				return err
+			}
+			return ioutil.WriteFile(fileName, configJSON, 0600)
+		}
+	}
+
+	return ioutil.WriteFile(fileName, configJSON, 0644)
+
+}
+
 func (c *config) InstallBundle(bundleName string) error {
 
 	b := c.BundlesInstalled
@@ -115,8 +255,8 @@ func (c *config) InstallBundle(bundleName string) error {
 			return errors.New("Bundle is already installed")
 		}
 	}
-	//Otherwise append
-	b = append(b, bundleName)
+	//Otherwise append, to a new list, since the old one may still be in use
+	b = append(append(Bundles{}, b...), bundleName)
 	//Reset the bundle list on the config object
 	c.BundlesInstalled = b
 
@@ -130,8 +270,8 @@ func (c *config) UnInstallBundle(bundleName string) error {
 	//Search for the bundle to be uninstalled
 	for index, a := range b {
 		if a == bundleName {
-			//If found, splice it out
-			c.BundlesInstalled = append(b[:index], b[index+1:]...)
+			//If found, splice it out into a new list, since the old one may still be in use
+			c.BundlesInstalled = append(append(Bundles{}, b[:index]...), b[index+1:]...)
 			return nil
 		}
 	}
@@ -140,6 +280,34 @@ func (c *config) UnInstallBundle(bundleName string) error {
 
 }
 
+//changedSettings lists the config settings, by their JSON names, which differ between two configs
+func changedSettings(old, fresh config) []string {
+
+	var changed []string
+
+	o, f := reflect.ValueOf(old), reflect.ValueOf(fresh)
+	for i := 0; i < o.NumField(); i++ {
+		if !reflect.DeepEqual(o.Field(i).Interface(), f.Field(i).Interface()) {
+			changed = append(changed, strings.Split(o.Type().Field(i).Tag.Get("json"), ",")[0])
+		}
+	}
+
+	return changed
+
+}
+
+//keepSettings copies the named settings, by their names in the config file, from old to fresh
+func keepSettings(fresh *config, old config, settings map[string]bool) {
+
+	o, f := reflect.ValueOf(old), reflect.ValueOf(fresh).Elem()
+	for i := 0; i < o.NumField(); i++ {
+		if settings[strings.Split(o.Type().Field(i).Tag.Get("json"), ",")[0]] {
+			f.Field(i).Set(o.Field(i))
+		}
+	}
+
+}
+
 func compareBundles(b1, b2 Bundles) bool {
 	//If lengths are not equal
 	if len(b1) != len(b2) {
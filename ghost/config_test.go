@@ -0,0 +1,91 @@
+package ghost
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestChangedSettings(t *testing.T) {
+
+	old := Defaults
+	fresh := Defaults
+	fresh.ApiPort = "4000"
+	fresh.CorsAllowedOrigins = []string{"https://example.com"}
+	fresh.BundlesInstalled = Bundles{"shop"}
+
+	expected := "apiPort,bundlesInstalled,corsAllowedOrigins"
+	if got := strings.Join(changedSettings(old, fresh), ","); got != expected {
+		TestErrorFatal(t, "Changed settings are listed by JSON name", got, expected)
+	}
+
+	if got := changedSettings(old, old); len(got) != 0 {
+		TestErrorFatal(t, "No changes", strings.Join(got, ","), "")
+	}
+
+}
+
+func TestKeepRestartSettings(t *testing.T) {
+
+	old := Defaults
+	fresh := Defaults
+	fresh.ApiPort, fresh.TLSCertFile, fresh.AccessLogMaxAge, fresh.HTTP2 = "4000", "cert.pem", 1, !old.HTTP2
+	fresh.CorsMaxAge = 60
+
+	keepSettings(&fresh, old, restartSettings)
+
+	//Only the settings which can change on a running server differ
+	if got := strings.Join(changedSettings(old, fresh), ","); got != "corsMaxAge" {
+		TestErrorFatal(t, "Restart settings kept", got, "corsMaxAge")
+	}
+
+	//Every restart setting is a setting, so none is silently never kept
+	known := map[string]bool{}
+	c := reflect.TypeOf(config{})
+	for i := 0; i < c.NumField(); i++ {
+		known[strings.Split(c.Field(i).Tag.Get("json"), ",")[0]] = true
+	}
+	for setting := range restartSettings {
+		if !known[setting] {
+			TestErrorFatal(t, "Restart setting", setting, "a setting in the config file")
+		}
+	}
+
+}
+
+func TestSetupCacheTTL(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "ghostconfig")
+	if err != nil {
+		TestErrorFatal(t, "Making a folder", err.Error(), "no error")
+	}
+	defer os.RemoveAll(dir)
+
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	testCases := []struct {
+		desc, file string
+		exp        int
+	}{
+		{"Config written before cacheTTL", `{"apiPort": "3000"}`, Defaults.CacheTTL},
+		{"Caching switched off", `{"apiPort": "3000", "cacheTTL": 0}`, 0},
+		{"Set", `{"apiPort": "3000", "cacheTTL": 60}`, 60},
+	}
+
+	for i, c := range testCases {
+		name := "cachettl" + strconv.Itoa(i)
+		ioutil.WriteFile(filepath.Join(dir, name+".json"), []byte(c.file), 0600)
+		var conf config
+		conf.Setup(name)
+		if conf.CacheTTL != c.exp {
+			TestErrorFatal(t, c.desc, strconv.Itoa(conf.CacheTTL), strconv.Itoa(c.exp))
+		}
+	}
+
+}
@@ -16,6 +16,7 @@ package ghost
 
 import (
 	"database/sql"
+	"errors"
 
 	_ "github.com/lib/pq"
 	"github.com/spf13/viper"
@@ -49,14 +50,14 @@ func (d *dbConfig) SetupConnection(isSuperUser bool) {
 
 	//Default configuration
 	d.user = "server"
-	d.server = App.Config.PgServer
-	d.port = App.Config.PgPort
-	d.dbName = App.Config.PgDBName
-	d.disableSSL = App.Config.PgDisableSSL
+	d.server = App.Config().PgServer
+	d.port = App.Config().PgPort
+	d.dbName = App.Config().PgDBName
+	d.disableSSL = App.Config().PgDisableSSL
 
 	//For super user
 	if isSuperUser {
-		d.user = viper.GetString("pgSuperUser")
+		d.user = App.Config().PgSuperUser
 		d.pw = viper.GetString("pgpw")
 	}
 
@@ -71,6 +72,25 @@ func (d dbConfig) ReturnDBConnection(serverPW string) *sql.DB {
 
 }
 
+//TryDBConnection is the same as ReturnDBConnection, except that a failure to connect is returned
+//as an error rather than exiting the program.  Use it from within a running server
+func (d dbConfig) TryDBConnection(serverPW string) (*sql.DB, error) {
+
+	dbConnection, err := sql.Open("postgres", d.getDBConnectionString(serverPW))
+	if err != nil {
+		return nil, err
+	}
+
+	//Ping database to check connectivity
+	if err := dbConnection.Ping(); err != nil {
+		dbConnection.Close()
+		return nil, errors.New("Error connecting to Postgres as " + d.user + ": " + err.Error())
+	}
+
+	return dbConnection, nil
+
+}
+
 //getDBConnectionString returns a correctly formated Postgres connection string from
 //the config struct.  If there is no pw in the struct (as is the case for )
 func (d dbConfig) getDBConnectionString(serverPW string) (dbConnectionString string) {
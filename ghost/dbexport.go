@@ -0,0 +1,306 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/spf13/afero"
+)
+
+//sqlToSelectSchemaTables lists the tables in a schema
+const sqlToSelectSchemaTables = `SELECT table_name FROM information_schema.tables
+	WHERE table_schema = $1 AND table_type = 'BASE TABLE' ORDER BY table_name;`
+
+//ExportOptions control what ExportData writes
+type ExportOptions struct {
+	//Format is "csv" or "json"
+	Format string
+	//Filter limits the export to rows in which any of the columns has the value given.
+	//Tables with none of the columns are left out
+	Filter map[string]string
+	//Dir is the folder the files are written to
+	Dir string
+}
+
+//ExportResult reports a table which has been exported
+type ExportResult struct {
+	Table string
+	File  string
+	Rows  int
+}
+
+//ExportData writes tables, as the super user, to one file per table.  Targets are
+//schemas, for all their tables, or tables given as schema.table
+func ExportData(fs afero.Fs, targets []string, opts ExportOptions) ([]ExportResult, error) {
+
+	if opts.Format != "csv" && opts.Format != "json" {
+		return nil, errors.New("Unknown export format '" + opts.Format + "'. Use csv or json")
+	}
+
+	//Establish a temporary connection as the super user
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	tables, err := exportTables(db, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fs.MkdirAll(opts.Dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	var results []ExportResult
+	for _, table := range tables {
+
+		columns, types, err := columnTypes(db, table[0], table[1])
+		if err != nil {
+			return results, err
+		}
+
+		query, args := exportQuery(table[0], table[1], columns, types, opts.Filter, opts.Format)
+		if query == "" {
+			continue
+		}
+
+		result := ExportResult{
+			Table: table[0] + "." + table[1],
+			File:  filepath.Join(opts.Dir, table[0]+"."+table[1]+"."+opts.Format),
+		}
+
+		if result.Rows, err = exportTable(fs, db, result.File, query, args, columns, opts.Format); err != nil {
+			return results, fmt.Errorf("Exporting %s: %s", result.Table, err)
+		}
+
+		results = append(results, result)
+
+	}
+
+	return results, nil
+
+}
+
+//exportTables resolves schemas and schema.table names into a list of tables
+func exportTables(db *sql.DB, targets []string) ([][2]string, error) {
+
+	if len(targets) == 0 {
+		return nil, errors.New("Name the schemas, or schema.tables, to export")
+	}
+
+	var tables [][2]string
+	for _, target := range targets {
+
+		parts := strings.SplitN(target, ".", 2)
+		for _, part := range parts {
+			if !IsValidIdentifier(part) {
+				return nil, errors.New("Invalid schema or table '" + target + "'")
+			}
+		}
+
+		if len(parts) == 2 {
+			tables = append(tables, [2]string{parts[0], parts[1]})
+			continue
+		}
+
+		rows, err := db.Query(sqlToSelectSchemaTables, target)
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for rows.Next() {
+			var tableName string
+			if err := rows.Scan(&tableName); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			tables = append(tables, [2]string{target, tableName})
+			found = true
+		}
+		rows.Close()
+
+		if !found {
+			return nil, errors.New("Schema " + target + " has no tables")
+		}
+
+	}
+
+	return tables, nil
+
+}
+
+//exportQuery builds the query which reads a table for export: every row as JSON, or
+//every column as text for CSV.  With a filter, only rows matching one of the filter
+//columns the table has are read, and if it has none the query is empty
+func exportQuery(schemaName, tableName string, columns []string, types map[string]string, filter map[string]string, format string) (string, []interface{}) {
+
+	var conditions []string
+	var args []interface{}
+
+	//Sort the filter columns, so that the query is always the same
+	filterColumns := make([]string, 0, len(filter))
+	for column := range filter {
+		filterColumns = append(filterColumns, column)
+	}
+	sort.Strings(filterColumns)
+
+	for _, column := range filterColumns {
+		if _, ok := types[column]; ok {
+			args = append(args, filter[column])
+			conditions = append(conditions, fmt.Sprintf("t.%s::text = $%d", pq.QuoteIdentifier(column), len(args)))
+		}
+	}
+
+	if len(filter) > 0 && len(conditions) == 0 {
+		return "", nil
+	}
+
+	selected := "row_to_json(t)"
+	if format == "csv" {
+		quoted := make([]string, len(columns))
+		for i, column := range columns {
+			quoted[i] = "t." + pq.QuoteIdentifier(column) + "::text"
+		}
+		selected = strings.Join(quoted, ", ")
+	}
+
+	query := "SELECT " + selected + " FROM " + pq.QuoteIdentifier(schemaName) + "." + pq.QuoteIdentifier(tableName) + " t"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " OR ")
+	}
+
+	return query, args
+
+}
+
+//exportTable runs an export query and writes the rows to a file, returning how many there were
+func exportTable(fs afero.Fs, db *sql.DB, fileName, query string, args []interface{}, columns []string, format string) (int, error) {
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	file, err := fs.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+
+	var count int
+	if format == "csv" {
+		count, err = writeCSVExport(w, rows, columns)
+	} else {
+		count, err = writeJSONExport(w, rows)
+	}
+	if err != nil {
+		return count, err
+	}
+
+	if err := w.Flush(); err != nil {
+		return count, err
+	}
+
+	return count, file.Close()
+
+}
+
+//writeCSVExport writes rows of text columns as CSV, with a header.  NULLs are empty
+func writeCSVExport(w io.Writer, rows *sql.Rows, columns []string) (int, error) {
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return 0, err
+	}
+
+	values := make([]sql.NullString, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	record := make([]string, len(columns))
+
+	var count int
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return count, err
+		}
+		for i, value := range values {
+			record[i] = value.String
+		}
+		if err := writer.Write(record); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	writer.Flush()
+	return count, writer.Error()
+
+}
+
+//writeJSONExport writes rows of JSON objects as a JSON array, one object per line
+func writeJSONExport(w io.Writer, rows *sql.Rows) (int, error) {
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return 0, err
+	}
+
+	var count int
+	for rows.Next() {
+
+		var object string
+		if err := rows.Scan(&object); err != nil {
+			return count, err
+		}
+
+		separator := ",\n"
+		if count == 0 {
+			separator = "\n"
+		}
+		if _, err := io.WriteString(w, separator+object); err != nil {
+			return count, err
+		}
+		count++
+
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	_, err := io.WriteString(w, "\n]\n")
+	return count, err
+
+}
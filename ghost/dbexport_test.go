@@ -0,0 +1,33 @@
+package ghost
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestExportQuery(t *testing.T) {
+
+	columns := []string{"id", "user_id", "total"}
+	types := map[string]string{"id": "uuid", "user_id": "uuid", "total": "numeric"}
+
+	testCases := []struct {
+		desc, format string
+		filter       map[string]string
+		exp          string
+	}{
+		{"json", "json", nil, `SELECT row_to_json(t) FROM "shop"."orders" t []`},
+		{"csv", "csv", nil, `SELECT t."id"::text, t."user_id"::text, t."total"::text FROM "shop"."orders" t []`},
+		{"filtered", "json", map[string]string{"user_id": "42", "email": "a@b.com"}, `SELECT row_to_json(t) FROM "shop"."orders" t WHERE t."user_id"::text = $1 [42]`},
+		{"several filter columns", "json", map[string]string{"user_id": "42", "id": "42"}, `SELECT row_to_json(t) FROM "shop"."orders" t WHERE t."id"::text = $1 OR t."user_id"::text = $2 [42 42]`},
+		{"no filter columns", "json", map[string]string{"email": "a@b.com"}, ` []`},
+	}
+
+	for _, c := range testCases {
+		query, args := exportQuery("shop", "orders", columns, types, c.filter, c.format)
+		got := query + " " + fmt.Sprint(args)
+		if got != c.exp {
+			TestErrorFatal(t, c.desc, got, c.exp)
+		}
+	}
+
+}
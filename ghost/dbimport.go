@@ -0,0 +1,585 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+//sqlToSelectTableColumns lists the columns of a table, with their types
+const sqlToSelectTableColumns = `SELECT column_name, data_type FROM information_schema.columns
+	WHERE table_schema = $1 AND table_name = $2 ORDER BY ordinal_position;`
+
+//defaultImportBatchSize is the number of rows sent in each COPY
+const defaultImportBatchSize = 1000
+
+//ImportOptions control how ImportData reads and loads a file
+type ImportOptions struct {
+	//Format is "csv" or "json".  If empty, it is taken from the file name
+	Format string
+	//Mapping renames source columns to table columns.  Mapping a column to "" leaves it out
+	Mapping map[string]string
+	//BatchSize is the number of rows sent in each COPY
+	BatchSize int
+	//DryRun loads the data in a transaction which is then rolled back
+	DryRun bool
+	//Delimiter separates CSV fields, a comma by default
+	Delimiter rune
+}
+
+//ImportResult reports what ImportData loaded, or would have loaded on a dry run
+type ImportResult struct {
+	Columns []string
+	Rows    int
+	Batches int
+}
+
+//importSource is a file of records being imported.  next returns io.EOF once all
+//the records, which are in the same order as columns, have been read
+type importSource interface {
+	columns() []string
+	next() ([]interface{}, error)
+}
+
+//ImportFormat works out the format of a file from its extension
+func ImportFormat(fileName string) (string, error) {
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return "csv", nil
+	case ".json", ".jsonl", ".ndjson":
+		return "json", nil
+	}
+
+	return "", errors.New("Can't tell the format of " + fileName + ". Use a .csv or .json file, or say which with --format")
+
+}
+
+//newImportSource reads the header of a CSV file, or all the objects of a JSON file
+func newImportSource(r io.Reader, format string, delimiter rune) (importSource, error) {
+
+	switch format {
+	case "csv":
+		return newCSVSource(r, delimiter)
+	case "json":
+		return newJSONSource(r)
+	}
+
+	return nil, errors.New("Unknown import format '" + format + "'. Use csv or json")
+
+}
+
+//csvSource streams the rows of a CSV file, whose first row names the columns
+type csvSource struct {
+	reader *csv.Reader
+	header []string
+}
+
+func newCSVSource(r io.Reader, delimiter rune) (*csvSource, error) {
+
+	reader := csv.NewReader(r)
+	if delimiter != 0 {
+		reader.Comma = delimiter
+	}
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("The CSV file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+
+	return &csvSource{reader: reader, header: header}, nil
+
+}
+
+func (s *csvSource) columns() []string {
+	return s.header
+}
+
+func (s *csvSource) next() ([]interface{}, error) {
+
+	record, err := s.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	row := make([]interface{}, len(record))
+	for i, field := range record {
+		row[i] = field
+	}
+
+	return row, nil
+
+}
+
+//jsonSource holds the objects of a JSON file, which is either an array of objects or
+//one object per line.  Objects needn't all have the same keys: the columns are every
+//key found, in the order they first appear, and missing keys are NULL
+type jsonSource struct {
+	keys    []string
+	objects []map[string]interface{}
+	read    int
+}
+
+func newJSONSource(r io.Reader) (*jsonSource, error) {
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &jsonSource{}
+	seen := map[string]bool{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	//An array is opened, and its objects decoded one by one
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+	}
+
+	for decoder.More() {
+
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("Record %d: %s", len(s.objects)+1, err)
+		}
+
+		var object map[string]interface{}
+		objectDecoder := json.NewDecoder(bytes.NewReader(raw))
+		objectDecoder.UseNumber()
+		if err := objectDecoder.Decode(&object); err != nil || object == nil {
+			return nil, fmt.Errorf("Record %d is not a JSON object", len(s.objects)+1)
+		}
+
+		//The order of the keys is lost in the map, so it is read from the raw object
+		keys, err := jsonKeys(raw)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				s.keys = append(s.keys, key)
+			}
+		}
+
+		s.objects = append(s.objects, object)
+
+	}
+
+	return s, nil
+
+}
+
+func (s *jsonSource) columns() []string {
+	return s.keys
+}
+
+func (s *jsonSource) next() ([]interface{}, error) {
+
+	if s.read == len(s.objects) {
+		return nil, io.EOF
+	}
+
+	object := s.objects[s.read]
+	s.read++
+
+	row := make([]interface{}, len(s.keys))
+	for i, key := range s.keys {
+		row[i] = object[key]
+	}
+
+	return row, nil
+
+}
+
+//jsonKeys returns the keys of a JSON object in the order they are written
+func jsonKeys(raw json.RawMessage) ([]string, error) {
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+
+	//Skip the opening brace
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for decoder.More() {
+
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key.(string))
+
+		//Skip the value
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+
+	}
+
+	return keys, nil
+
+}
+
+//mapImportColumns works out the table column each source column goes into.
+//Source columns mapped to "" are left out, which is shown by an index of -1
+func mapImportColumns(source []string, mapping map[string]string, tableColumns map[string]string) (targets []string, indexes []int, err error) {
+
+	var unknown []string
+
+	for i, column := range source {
+
+		target := column
+		if mapped, ok := mapping[column]; ok {
+			target = mapped
+		}
+		if target == "" {
+			indexes = append(indexes, -1)
+			continue
+		}
+
+		if _, ok := tableColumns[target]; !ok {
+			unknown = append(unknown, target)
+		}
+
+		targets = append(targets, target)
+		indexes = append(indexes, i)
+
+	}
+
+	for column := range mapping {
+		if !containsString(source, column) {
+			return nil, nil, errors.New("Mapped column '" + column + "' is not in the file")
+		}
+	}
+
+	if len(unknown) > 0 {
+		return nil, nil, errors.New("No column " + strings.Join(unknown, ", ") + " in the table.  Map file columns to table columns with --map file_column=table_column, or leave them out with --map file_column=")
+	}
+
+	if len(targets) == 0 {
+		return nil, nil, errors.New("There are no columns to import")
+	}
+
+	return targets, indexes, nil
+
+}
+
+//coerceImportValue converts a value read from a file into one Postgres accepts for a
+//column of the given type (as in information_schema.columns).  Most values are passed
+//as text, for Postgres to parse, but booleans are accepted in more spellings, empty
+//CSV fields are NULL in anything but text columns, numbers in date and time columns are
+//unix timestamps, JSON arrays go into array columns and objects become JSON text
+func coerceImportValue(value interface{}, dataType string) (interface{}, error) {
+
+	switch v := value.(type) {
+
+	case nil:
+		return nil, nil
+
+	case string:
+		if v == "" && !isTextType(dataType) {
+			return nil, nil
+		}
+		if dataType == "boolean" {
+			return parseImportBool(v)
+		}
+		if isNumericType(dataType) {
+			return strings.TrimSpace(v), nil
+		}
+		return v, nil
+
+	case json.Number:
+		if isTimeType(dataType) {
+			seconds, err := v.Float64()
+			if err != nil {
+				return nil, err
+			}
+			return time.Unix(0, int64(seconds*float64(time.Second))).UTC().Format(time.RFC3339Nano), nil
+		}
+		if dataType == "boolean" {
+			return parseImportBool(v.String())
+		}
+		return v.String(), nil
+
+	case bool:
+		if isNumericType(dataType) {
+			if v {
+				return "1", nil
+			}
+			return "0", nil
+		}
+		return strconv.FormatBool(v), nil
+
+	case []interface{}:
+		if dataType == "ARRAY" {
+			return arrayLiteral(v)
+		}
+		j, err := json.Marshal(v)
+		return string(j), err
+
+	case map[string]interface{}:
+		j, err := json.Marshal(v)
+		return string(j), err
+
+	}
+
+	return nil, fmt.Errorf("Can't import a value of type %T", value)
+
+}
+
+//parseImportBool accepts the usual ways of writing true and false in a spreadsheet
+func parseImportBool(s string) (interface{}, error) {
+
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "t", "yes", "y", "1", "on":
+		return "true", nil
+	case "false", "f", "no", "n", "0", "off":
+		return "false", nil
+	case "":
+		return nil, nil
+	}
+
+	return nil, errors.New("'" + s + "' is not true or false")
+
+}
+
+//arrayLiteral writes a JSON array as a Postgres array literal
+func arrayLiteral(values []interface{}) (string, error) {
+
+	elements := make([]string, len(values))
+	for i, value := range values {
+
+		switch v := value.(type) {
+		case nil:
+			elements[i] = "NULL"
+		case string:
+			elements[i] = `"` + strings.Replace(strings.Replace(v, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+		case json.Number:
+			elements[i] = v.String()
+		case bool:
+			elements[i] = strconv.FormatBool(v)
+		default:
+			return "", errors.New("Arrays can only hold strings, numbers and booleans")
+		}
+
+	}
+
+	return "{" + strings.Join(elements, ",") + "}", nil
+
+}
+
+func isTextType(dataType string) bool {
+	return dataType == "text" || dataType == "character varying" || dataType == "character"
+}
+
+func isNumericType(dataType string) bool {
+	switch dataType {
+	case "smallint", "integer", "bigint", "numeric", "real", "double precision":
+		return true
+	}
+	return false
+}
+
+func isTimeType(dataType string) bool {
+	return dataType == "date" || strings.HasPrefix(dataType, "timestamp")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+//ImportData loads a CSV or JSON file into a table, as the super user, using COPY in
+//batches.  Everything is loaded in a single transaction, so a bad record anywhere in
+//the file means nothing is imported
+func ImportData(schemaName, tableName string, r io.Reader, opts ImportOptions) (ImportResult, error) {
+
+	if !IsValidIdentifier(schemaName) || !IsValidIdentifier(tableName) {
+		return ImportResult{}, errors.New("Invalid table '" + schemaName + "." + tableName + "'")
+	}
+
+	source, err := newImportSource(r, opts.Format, opts.Delimiter)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	//Establish a temporary connection as the super user
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer db.Close()
+
+	return importData(db, schemaName, tableName, source, opts)
+
+}
+
+func importData(db *sql.DB, schemaName, tableName string, source importSource, opts ImportOptions) (ImportResult, error) {
+
+	_, tableColumns, err := columnTypes(db, schemaName, tableName)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	targets, indexes, err := mapImportColumns(source.columns(), opts.Mapping, tableColumns)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultImportBatchSize
+	}
+
+	result := ImportResult{Columns: targets}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	var stmt *sql.Stmt
+	flush := func() error {
+		if stmt == nil {
+			return nil
+		}
+		defer func() { stmt = nil }()
+		if _, err := stmt.Exec(); err != nil {
+			stmt.Close()
+			return err
+		}
+		result.Batches++
+		return stmt.Close()
+	}
+
+	for {
+
+		row, err := source.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("Record %d: %s", result.Rows+1, err)
+		}
+
+		values := make([]interface{}, 0, len(targets))
+		for i, index := range indexes {
+			if index < 0 {
+				continue
+			}
+			var value interface{}
+			if index < len(row) {
+				value = row[index]
+			}
+			column := targets[len(values)]
+			coerced, err := coerceImportValue(value, tableColumns[column])
+			if err != nil {
+				return result, fmt.Errorf("Record %d, column %s: %s", result.Rows+1, source.columns()[i], err)
+			}
+			values = append(values, coerced)
+		}
+
+		if stmt == nil {
+			if stmt, err = tx.Prepare(pq.CopyInSchema(schemaName, tableName, targets...)); err != nil {
+				return result, err
+			}
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return result, fmt.Errorf("Record %d: %s", result.Rows+1, err)
+		}
+		result.Rows++
+
+		if result.Rows%batchSize == 0 {
+			if err := flush(); err != nil {
+				return result, fmt.Errorf("Batch ending at record %d: %s", result.Rows, err)
+			}
+		}
+
+	}
+
+	if err := flush(); err != nil {
+		return result, fmt.Errorf("Batch ending at record %d: %s", result.Rows, err)
+	}
+
+	if opts.DryRun {
+		return result, tx.Rollback()
+	}
+
+	return result, tx.Commit()
+
+}
+
+//columnTypes lists the columns of a table, in order, and maps them to their types
+func columnTypes(db *sql.DB, schemaName, tableName string) ([]string, map[string]string, error) {
+
+	rows, err := db.Query(sqlToSelectTableColumns, schemaName, tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	types := map[string]string{}
+	for rows.Next() {
+		var name, dataType string
+		if err := rows.Scan(&name, &dataType); err != nil {
+			return nil, nil, err
+		}
+		columns = append(columns, name)
+		types[name] = dataType
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(columns) == 0 {
+		return nil, nil, errors.New("Table " + schemaName + "." + tableName + " does not exist")
+	}
+
+	return columns, types, nil
+
+}
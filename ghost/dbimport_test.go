@@ -0,0 +1,113 @@
+package ghost
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestImportSources(t *testing.T) {
+
+	testCases := []struct {
+		desc, format, data, exp string
+	}{
+		{"csv", "csv", "\ufeffname, price\nwidget,1.50\n\"big, blue\",\n", "[name price] [widget 1.50] [big, blue ]"},
+		{"json array", "json", `[{"name": "widget", "price": 1.50}, {"price": 2, "tags": ["a"]}]`, "[name price tags] [widget 1.50 <nil>] [<nil> 2 [a]]"},
+		{"json lines", "json", "{\"b\": true, \"a\": null}\n{\"a\": {\"x\": 1}}\n", "[b a] [true <nil>] [<nil> map[x:1]]"},
+	}
+
+	for _, c := range testCases {
+
+		source, err := newImportSource(strings.NewReader(c.data), c.format, 0)
+		if err != nil {
+			TestErrorFatal(t, c.desc, err.Error(), c.exp)
+		}
+
+		got := fmt.Sprint(source.columns())
+		for {
+			row, err := source.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				TestErrorFatal(t, c.desc, err.Error(), c.exp)
+			}
+			got += " " + fmt.Sprint(row)
+		}
+
+		if got != c.exp {
+			TestErrorFatal(t, c.desc, got, c.exp)
+		}
+
+	}
+
+}
+
+func TestMapImportColumns(t *testing.T) {
+
+	table := map[string]string{"id": "integer", "email": "text", "name": "text"}
+
+	testCases := []struct {
+		desc    string
+		source  []string
+		mapping map[string]string
+		exp     string
+	}{
+		{"same names", []string{"id", "name"}, nil, "[id name] [0 1]"},
+		{"mapped and skipped", []string{"Email Address", "notes", "name"}, map[string]string{"Email Address": "email", "notes": ""}, "[email name] [0 -1 2]"},
+		{"unknown column", []string{"id", "notes"}, nil, "No column notes in the table."},
+		{"mapped column missing", []string{"id"}, map[string]string{"mail": "email"}, "Mapped column 'mail' is not in the file"},
+	}
+
+	for _, c := range testCases {
+		targets, indexes, err := mapImportColumns(c.source, c.mapping, table)
+		got := fmt.Sprint(targets, " ", indexes)
+		if err != nil {
+			got = strings.SplitAfter(err.Error(), ".")[0]
+		}
+		if got != c.exp {
+			TestErrorFatal(t, c.desc, got, c.exp)
+		}
+	}
+
+}
+
+func TestCoerceImportValue(t *testing.T) {
+
+	testCases := []struct {
+		desc     string
+		value    interface{}
+		dataType string
+		exp      string
+	}{
+		{"text", "hello", "text", "hello"},
+		{"empty text", "", "text", ""},
+		{"empty number", "", "integer", "<nil>"},
+		{"number with spaces", " 42 ", "integer", "42"},
+		{"yes", "Yes", "boolean", "true"},
+		{"off", "off", "boolean", "false"},
+		{"not a boolean", "maybe", "boolean", "'maybe' is not true or false"},
+		{"json number", json.Number("3.5"), "numeric", "3.5"},
+		{"unix timestamp", json.Number("1500000000"), "timestamp with time zone", "2017-07-14T02:40:00Z"},
+		{"json bool into integer", true, "integer", "1"},
+		{"json bool", false, "boolean", "false"},
+		{"array", []interface{}{"a \"b\"", json.Number("1"), nil}, "ARRAY", `{"a \"b\"",1,NULL}`},
+		{"array into jsonb", []interface{}{"a"}, "jsonb", `["a"]`},
+		{"object", map[string]interface{}{"x": json.Number("1")}, "jsonb", `{"x":1}`},
+		{"null", nil, "integer", "<nil>"},
+	}
+
+	for _, c := range testCases {
+		value, err := coerceImportValue(c.value, c.dataType)
+		got := fmt.Sprint(value)
+		if err != nil {
+			got = err.Error()
+		}
+		if got != c.exp {
+			TestErrorFatal(t, c.desc, got, c.exp)
+		}
+	}
+
+}
@@ -15,7 +15,7 @@
 package ghost
 
 //Defaults the the app wide default settings
-var Defaults = config{
+var Defaults = Config{
 
 	//PG Settings
 	PgSuperUser:  "postgres",
@@ -30,20 +30,107 @@ var Defaults = config{
 	Host:     "localhost",
 	Protocol: "http",
 
+	//Serve from the root and listen on all interfaces
+	BasePath:    "",
+	BindAddress: "",
+
+	//TLS Settings
+	TLSCertFile:      "",
+	TLSKeyFile:       "",
+	AutocertDomains:  []string{},
+	AutocertEmail:    "",
+	AutocertCacheDir: "certs",
+	HTTPRedirectPort: "",
+
 	//Email Settings
-	ActivateEmail: false,
-	SmtpHost:      "smtp",
-	SmtpPort:      "25",
-	SmtpUserName:  "info@yourdomain.com",
-	SmtpFrom:      "info@yourdomain.com",
-	EmailFrom:     "Your Name",
+	ActivateEmail:  false,
+	SmtpHost:       "smtp",
+	SmtpPort:       "25",
+	SmtpUserName:   "info@yourdomain.com",
+	SmtpFrom:       "info@yourdomain.com",
+	EmailFrom:      "Your Name",
+	SmtpTLS:        "starttls",
+	SmtpAuth:       "plain",
+	SmtpTimeout:    30,
+	SmtpSkipVerify: false,
+	EmailAPIRoles:  []string{"admin"},
 
 	//Bundles installed
 	BundlesInstalled: make([]string, 0, 0),
 
+	//Admin panel
+	AdminPanelDir:    "admin-panel",
+	AdminPanelMounts: map[string]string{},
+
+	//Access log, kept apart from the application log
+	AccessLog:           "",
+	AccessLogFormat:     "combined",
+	AccessLogMaxSize:    100,
+	AccessLogMaxBackups: 10,
+	AccessLogMaxAge:     30,
+
+	//Server timeouts.  There is no write timeout by default, since handlers are
+	//already limited by the per route timeouts, which are longer for exports
+	ServerReadTimeout:       60,
+	ServerReadHeaderTimeout: 10,
+	ServerWriteTimeout:      0,
+	ServerIdleTimeout:       120,
+	MaxHeaderBytes:          1 << 20,
+	HTTP2:                   true,
+
+	//Cache query results in memory for 5 seconds
+	Cache:       "memory",
+	RedisURL:    "",
+	CacheTTL:    5,
+	CacheTables: map[string]int{},
+
+	//Files are kept on local disk
+	Storage:         "local",
+	StorageDir:      "files",
+	StorageBucket:   "",
+	StorageRegion:   "",
+	StorageEndpoint: "",
+	FileRoles:       []string{"admin"},
+
+	//Request body limits: 1MB for API calls, 32MB for uploads
+	MaxBodySize:   1 << 20,
+	MaxUploadSize: 32 << 20,
+
+	//Maintenance mode
+	Maintenance:           false,
+	MaintenanceRetryAfter: 300,
+	MaintenanceTemplate:   "templates/maintenance.html",
+
+	//No scheduled jobs
+	ScheduledJobs: []ScheduledJob{},
+
+	//Job queue workers
+	JobWorkers:      4,
+	JobPollInterval: 5,
+	JobTimeout:      600,
+
+	//Change events kept for clients to catch up on
+	ChangeHistory: 1000,
+
+	//No audit trail
+	Audit: false,
+
+	//Report the version in a response header
+	VersionHeader: true,
+
+	//Reload the config when the file changes
+	WatchConfig: true,
+
 	//Global Middleware
 	GlobalMiddleware: []string{"RequestID", "RealIP", "Logger", "Recoverer", "CloseNotify", "Timeout"},
 	Timeout:          60,
+	Timeouts: map[string]int{
+		"read":   10,
+		"write":  30,
+		"export": 300,
+		"image":  60,
+	},
+	TimeoutRoutes: map[string]string{},
 
 	//CORS Settings
 	ActivateCors:         false,
@@ -0,0 +1,249 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	sqlToInsertDemoJSON         = `INSERT INTO %s.%s (%s) SELECT %s FROM json_populate_recordset(NULL::%s.%s, $1);`
+	sqlToGetDemoDataLoaded      = `SELECT demodata_loaded FROM ghost_bundles WHERE name = $1;`
+	sqlToMarkDemoDataLoaded     = `UPDATE ghost_bundles SET demodata_loaded = true WHERE name = $1;`
+	sqlToListBundlesWithoutDemo = `SELECT name FROM ghost_bundles WHERE NOT demodata_loaded ORDER BY name;`
+)
+
+//demoFileOrderPrefix is the optional numeric prefix which orders demo data files,
+//e.g. 00_products.json loads into the products table
+var demoFileOrderPrefix = regexp.MustCompile(`^[0-9]+_`)
+
+//demoDataFiles lists the .sql and .json files in a bundle's demodata folder, sorted by name
+func demoDataFiles(bundleName string) []string {
+
+	folder := BundlePath(bundleName, bundleDemoFolder)
+	filesInDirectory, err := afero.ReadDir(App.FileSystem, folder)
+	if err != nil {
+		return nil
+	}
+
+	var files []string
+	for _, file := range filesInDirectory {
+		if ext := path.Ext(file.Name()); !file.IsDir() && (ext == ".sql" || ext == ".json") {
+			files = append(files, path.Join(folder, file.Name()))
+		}
+	}
+
+	sort.Strings(files)
+	return files
+
+}
+
+//HasDemoData reports whether a bundle has any demo data files
+func HasDemoData(bundleName string) bool {
+	return len(demoDataFiles(bundleName)) > 0
+}
+
+//InstallBundleDemoData loads the files in the bundle's demodata folder, in name order.
+//SQL files are run as they are.  JSON files hold an array of records for the table
+//they are named after (with an optional numeric prefix for ordering), so
+//demodata/01_products.json is loaded into the bundle's products table
+func InstallBundleDemoData(tx *sql.Tx, bundleName string) error {
+
+	Log("INSTALL", true, "Installing demo data", nil)
+
+	files := demoDataFiles(bundleName)
+	if len(files) == 0 {
+		return errors.New("No demo data files could be read for bundle '" + bundleName + "'")
+	}
+
+	//Set the search path so that unqualified names in SQL files refer to the bundle schema
+	if _, err := tx.Exec(fmt.Sprintf(sqlToSetSearchPathForBundle, bundleName)); err != nil {
+		return fmt.Errorf("Failed to set schema search path: %s", err)
+	}
+
+	for _, file := range files {
+
+		var err error
+		if path.Ext(file) == ".json" {
+			err = processDemoJSONFile(tx, bundleName, file)
+		} else {
+			err = processBundleFile(tx, file)
+		}
+		if err != nil {
+			return fmt.Errorf("Installation of '%s' failed: %s", path.Base(file), err)
+		}
+
+		Log("INSTALL", true, path.Base(file)+" installed OK", nil)
+
+	}
+
+	return nil
+
+}
+
+func processDemoJSONFile(tx *sql.Tx, bundleName, filename string) error {
+
+	b, err := afero.ReadFile(App.FileSystem, filename)
+	if err != nil {
+		return err
+	}
+
+	query, err := demoJSONInsert(bundleName, path.Base(filename), b)
+	if err != nil || query == "" {
+		return err
+	}
+
+	_, err = tx.Exec(query, string(b))
+	return err
+
+}
+
+//demoJSONInsert returns the SQL to insert the records in a demo data JSON file, with the
+//JSON itself as its only parameter.  Only columns which appear in the records are inserted,
+//so column defaults apply to the rest.  An empty array gives no SQL
+func demoJSONInsert(schema, fileName string, b []byte) (string, error) {
+
+	table := demoFileOrderPrefix.ReplaceAllString(strings.TrimSuffix(fileName, ".json"), "")
+	if !IsValidIdentifier(table) {
+		return "", errors.New("Demo data file name '" + fileName + "' is not a valid table name")
+	}
+
+	var records []map[string]json.RawMessage
+	if err := json.Unmarshal(b, &records); err != nil {
+		return "", errors.New("Demo data JSON must be an array of records: " + err.Error())
+	}
+
+	columnSet := map[string]bool{}
+	for _, record := range records {
+		for col := range record {
+			if !IsValidIdentifier(col) {
+				return "", errors.New("Invalid column '" + col + "'")
+			}
+			columnSet[col] = true
+		}
+	}
+
+	if len(columnSet) == 0 {
+		return "", nil
+	}
+
+	var columns []string
+	for col := range columnSet {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+	cols := strings.Join(columns, ", ")
+
+	return fmt.Sprintf(sqlToInsertDemoJSON, schema, table, cols, cols, schema, table), nil
+
+}
+
+//LoadBundleDemoData loads the demo data of an installed bundle in a transaction.
+//Demo data is only loaded once unless force is set
+func LoadBundleDemoData(bundleName string, force bool) error {
+
+	if !IsBundleInstalled(bundleName) {
+		return errors.New("Bundle '" + bundleName + "' is not installed")
+	}
+
+	//Establish a temporary connection as the super user
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(SQLToCreateBundleRegistry); err != nil {
+		return err
+	}
+
+	if !force {
+		var loaded bool
+		if err := db.QueryRow(sqlToGetDemoDataLoaded, bundleName).Scan(&loaded); err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if loaded {
+			return errors.New("Demo data for bundle '" + bundleName + "' has already been loaded")
+		}
+	}
+
+	return loadDemoData(db, bundleName)
+
+}
+
+func loadDemoData(db *sql.DB, bundleName string) error {
+
+	return inTransaction(db, func(tx *sql.Tx) error {
+
+		if err := InstallBundleDemoData(tx, bundleName); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(sqlToMarkDemoDataLoaded, bundleName)
+		return err
+
+	})
+
+}
+
+//loadMissingDemoData loads demo data for every installed bundle which has some and
+//hasn't had it loaded yet.  It runs when the server starts in demo mode
+func loadMissingDemoData(db *sql.DB) error {
+
+	if _, err := db.Exec(SQLToCreateBundleRegistry); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(sqlToListBundlesWithoutDemo)
+	if err != nil {
+		return err
+	}
+
+	var bundleNames []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		bundleNames = append(bundleNames, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, bundleName := range bundleNames {
+		if !IsBundleInstalled(bundleName) || !HasDemoData(bundleName) {
+			continue
+		}
+		if err := loadDemoData(db, bundleName); err != nil {
+			return fmt.Errorf("Demo data for bundle '%s' could not be loaded: %s", bundleName, err)
+		}
+		Log("SERVE", true, "Demo data loaded for bundle '"+bundleName+"'", nil)
+	}
+
+	return nil
+
+}
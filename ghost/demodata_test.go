@@ -0,0 +1,30 @@
+package ghost
+
+import "testing"
+
+func TestDemoJSONInsert(t *testing.T) {
+
+	testCases := []struct {
+		description, fileName, json, expected string
+		isError                               bool
+	}{
+		{"Table from file name", "products.json", `[{"name": "Hat", "price": 10}]`,
+			`INSERT INTO shop.products (name, price) SELECT name, price FROM json_populate_recordset(NULL::shop.products, $1);`, false},
+		{"Ordering prefix is dropped and columns are combined", "02_orders.json", `[{"total": 5}, {"customer": "Jo"}]`,
+			`INSERT INTO shop.orders (customer, total) SELECT customer, total FROM json_populate_recordset(NULL::shop.orders, $1);`, false},
+		{"Empty array", "products.json", `[]`, "", false},
+		{"Not an array", "products.json", `{"name": "Hat"}`, "", true},
+		{"Bad table name", "my-products.json", `[]`, "", true},
+		{"Bad column name", "products.json", `[{"name; DROP TABLE x": 1}]`, "", true},
+	}
+
+	for _, c := range testCases {
+		got, err := demoJSONInsert("shop", c.fileName, []byte(c.json))
+		if (err != nil) != c.isError {
+			TestErrorFatal(t, c.description, boolString(err != nil), boolString(c.isError))
+		} else if got != c.expected {
+			TestErrorFatal(t, c.description, got, c.expected)
+		}
+	}
+
+}
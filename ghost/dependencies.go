@@ -0,0 +1,251 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const sqlToListRegisteredBundles = `SELECT name, version FROM ghost_bundles ORDER BY name;`
+
+//DependencyError reports every problem found while resolving bundle dependencies
+type DependencyError struct {
+	//Missing lists dependencies which are neither installed nor in the bundles folder
+	Missing []string
+	//Conflicts lists dependencies whose version does not meet a constraint
+	Conflicts []string
+	//Cycles lists circular dependencies
+	Cycles []string
+	//Dependents lists installed bundles which depend on a bundle being removed
+	Dependents []string
+}
+
+func (e DependencyError) Error() string {
+
+	report := []string{"Bundle dependencies could not be resolved:"}
+	for _, m := range e.Missing {
+		report = append(report, "  missing: "+m)
+	}
+	for _, c := range e.Conflicts {
+		report = append(report, "  conflict: "+c)
+	}
+	for _, c := range e.Cycles {
+		report = append(report, "  circular dependency: "+c)
+	}
+	for _, d := range e.Dependents {
+		report = append(report, "  required by: "+d)
+	}
+
+	return strings.Join(report, "\n")
+
+}
+
+func (e DependencyError) isEmpty() bool {
+	return len(e.Missing)+len(e.Conflicts)+len(e.Cycles)+len(e.Dependents) == 0
+}
+
+//manifestReader returns the manifest for a bundle in the bundles folder, and false if there is no such bundle
+type manifestReader func(bundleName string) (BundleManifest, bool, error)
+
+//readManifestFromBundlesFolder is the manifestReader used outside of tests
+func readManifestFromBundlesFolder(bundleName string) (BundleManifest, bool, error) {
+
+	if !IsValidIdentifier(bundleName) {
+		return BundleManifest{}, false, nil
+	}
+
+	if _, err := App.FileSystem.Stat(BundlePath(bundleName)); err != nil {
+		return BundleManifest{}, false, nil
+	}
+
+	m, err := ReadBundleManifest(bundleName)
+	return m, true, err
+
+}
+
+//resolveInstallOrder works out which bundles need installing to install the requested ones,
+//and returns them in dependency order - every bundle comes after the bundles it depends on.
+//installed maps already installed bundles to their versions; these are checked against
+//constraints but not reinstalled.  All problems are collected and returned together
+func resolveInstallOrder(requested []string, installed map[string]string, read manifestReader) ([]string, error) {
+
+	var (
+		order    []string
+		problems DependencyError
+		//visiting tracks the current path through the graph, to detect cycles
+		visiting = map[string]bool{}
+		visited  = map[string]bool{}
+	)
+
+	var visit func(bundleName string, path []string)
+	visit = func(bundleName string, path []string) {
+
+		if visiting[bundleName] {
+			problems.Cycles = append(problems.Cycles, strings.Join(append(path[:len(path):len(path)], bundleName), " -> "))
+			return
+		}
+		if visited[bundleName] {
+			return
+		}
+
+		manifest, found, err := read(bundleName)
+		if err != nil {
+			problems.Conflicts = append(problems.Conflicts, fmt.Sprintf("%s has an unreadable bundle.json: %s", bundleName, err))
+			return
+		}
+		if !found {
+			problems.Missing = append(problems.Missing, missingDescription(bundleName, path))
+			return
+		}
+
+		visiting[bundleName] = true
+
+		//Visit dependencies in a stable order so that the install order is predictable
+		var dependencies []string
+		for d := range manifest.Dependencies {
+			dependencies = append(dependencies, d)
+		}
+		sort.Strings(dependencies)
+
+		for _, d := range dependencies {
+
+			constraint := manifest.Dependencies[d]
+
+			//Installed dependencies only need their version checking
+			if installedVersion, ok := installed[d]; ok {
+				if ok, err := VersionSatisfies(installedVersion, constraint); err != nil || !ok {
+					problems.Conflicts = append(problems.Conflicts, fmt.Sprintf("%s requires %s %s, but version %s is installed", bundleName, d, constraint, installedVersion))
+				}
+				continue
+			}
+
+			dependency, found, err := read(d)
+			if err == nil && found {
+				if ok, err := VersionSatisfies(dependency.Version, constraint); err != nil || !ok {
+					problems.Conflicts = append(problems.Conflicts, fmt.Sprintf("%s requires %s %s, but version %s is in the bundles folder", bundleName, d, constraint, dependency.Version))
+				}
+			}
+
+			visit(d, append(path[:len(path):len(path)], bundleName))
+
+		}
+
+		visiting[bundleName] = false
+		visited[bundleName] = true
+		order = append(order, bundleName)
+
+	}
+
+	for _, bundleName := range requested {
+		visit(bundleName, nil)
+	}
+
+	if !problems.isEmpty() {
+		return nil, problems
+	}
+
+	return order, nil
+
+}
+
+//missingDescription describes a missing bundle, including what needed it
+func missingDescription(bundleName string, path []string) string {
+
+	if len(path) == 0 {
+		return bundleName + " is not in the bundles folder"
+	}
+
+	return bundleName + " (required by " + path[len(path)-1] + ")"
+
+}
+
+//registeredBundles returns the name and version of each bundle in the bundle registry
+func registeredBundles(db *sql.DB) (map[string]string, error) {
+
+	if _, err := db.Exec(SQLToCreateBundleRegistry); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(sqlToListRegisteredBundles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	registered := map[string]string{}
+	for rows.Next() {
+		var name, version string
+		if err := rows.Scan(&name, &version); err != nil {
+			return nil, err
+		}
+		registered[name] = version
+	}
+
+	return registered, rows.Err()
+
+}
+
+//installedBundleVersions combines the bundle registry with the installed bundle list in
+//the config, which can have bundles installed before there was a registry.  Those are
+//taken to be the version in the bundles folder
+func installedBundleVersions(registered map[string]string, listed Bundles, read manifestReader) map[string]string {
+
+	installed := map[string]string{}
+	for name, version := range registered {
+		installed[name] = version
+	}
+
+	for _, name := range listed {
+		if _, ok := installed[name]; ok {
+			continue
+		}
+		installed[name] = defaultBundleVersion
+		if manifest, found, err := read(name); err == nil && found {
+			installed[name] = manifest.Version
+		}
+	}
+
+	return installed
+
+}
+
+//checkNoDependents makes sure that no other installed bundle depends on the one being removed
+func checkNoDependents(bundleName string, read manifestReader) error {
+
+	var problems DependencyError
+
+	for _, installed := range InstalledBundles() {
+		if installed == bundleName {
+			continue
+		}
+		manifest, found, err := read(installed)
+		if err != nil || !found {
+			continue
+		}
+		if _, ok := manifest.Dependencies[bundleName]; ok {
+			problems.Dependents = append(problems.Dependents, installed)
+		}
+	}
+
+	if !problems.isEmpty() {
+		return problems
+	}
+
+	return nil
+
+}
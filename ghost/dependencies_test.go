@@ -0,0 +1,136 @@
+package ghost
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVersionSatisfies(t *testing.T) {
+
+	testCases := []struct {
+		version, constraint string
+		expected            bool
+	}{
+		{"1.2.3", "", true},
+		{"1.2.3", "*", true},
+		{"1.2.3", "1.2.3", true},
+		{"1.2.3", "=1.2", false},
+		{"1.2.0", "=1.2", true},
+		{"v1.2.3", ">=1.2.0", true},
+		{"1.10.0", ">1.9.0", true},
+		{"1.2.3", "<1.2.3", false},
+		{"1.2.3", "<=1.2.3", true},
+		{"1.9.0", "^1.2.0", true},
+		{"2.0.0", "^1.2.0", false},
+		{"1.1.0", "^1.2.0", false},
+		{"0.3.1", "^0.3.0", true},
+		{"0.4.0", "^0.3.0", false},
+		{"1.2.9", "~1.2.3", true},
+		{"1.3.0", "~1.2.3", false},
+		{"1.5.0", ">=1.0.0 <2.0.0", true},
+		{"2.5.0", ">=1.0.0, <2.0.0", false},
+		{"1.0.0-beta", "1.0.0", true},
+	}
+
+	for _, c := range testCases {
+		ok, err := VersionSatisfies(c.version, c.constraint)
+		if err != nil || ok != c.expected {
+			TestErrorFatal(t, c.version+" satisfies '"+c.constraint+"'", boolString(ok), boolString(c.expected))
+		}
+	}
+
+	if _, err := VersionSatisfies("one.two", ">=1.0.0"); err == nil {
+		t.Error("Invalid version should return an error")
+	}
+
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+//testManifests is a fake bundles folder
+func testManifests(manifests ...BundleManifest) manifestReader {
+
+	return func(bundleName string) (BundleManifest, bool, error) {
+		for _, m := range manifests {
+			if m.Name == bundleName {
+				return m, true, nil
+			}
+		}
+		return BundleManifest{}, false, nil
+	}
+
+}
+
+func TestResolveInstallOrder(t *testing.T) {
+
+	bundlesFolder := testManifests(
+		BundleManifest{Name: "shop", Version: "1.0.0", Dependencies: map[string]string{"products": "^2.0.0", "customers": ">=1.0.0"}},
+		BundleManifest{Name: "products", Version: "2.1.0", Dependencies: map[string]string{"media": ""}},
+		BundleManifest{Name: "customers", Version: "1.0.0"},
+		BundleManifest{Name: "media", Version: "0.1.0"},
+		BundleManifest{Name: "blog", Version: "1.0.0", Dependencies: map[string]string{"comments": ""}},
+		BundleManifest{Name: "legacy", Version: "1.0.0", Dependencies: map[string]string{"products": "^1.0.0"}},
+		BundleManifest{Name: "chicken", Version: "1.0.0", Dependencies: map[string]string{"egg": ""}},
+		BundleManifest{Name: "egg", Version: "1.0.0", Dependencies: map[string]string{"chicken": ""}},
+	)
+
+	testCases := []struct {
+		description   string
+		requested     []string
+		installed     map[string]string
+		expectedOrder string
+		expectedError string
+	}{
+		{"Dependencies come first", []string{"shop"}, nil, "customers,media,products,shop", ""},
+		{"Installed dependencies are skipped", []string{"shop"}, map[string]string{"customers": "1.2.0"}, "media,products,shop", ""},
+		{"Dependencies only in the config are skipped", []string{"shop"}, installedBundleVersions(map[string]string{"media": "0.1.0"}, Bundles{"customers", "media"}, bundlesFolder), "products,shop", ""},
+		{"Dependencies only in the config have their version checked", []string{"legacy"}, installedBundleVersions(nil, Bundles{"products"}, bundlesFolder), "", "conflict: legacy requires products ^1.0.0, but version 2.1.0 is installed"},
+		{"Shared dependencies appear once", []string{"products", "shop"}, nil, "media,products,customers,shop", ""},
+		{"Missing dependency", []string{"blog"}, nil, "", "missing: comments (required by blog)"},
+		{"Missing requested bundle", []string{"nothere"}, nil, "", "missing: nothere is not in the bundles folder"},
+		{"Conflict with installed version", []string{"shop"}, map[string]string{"customers": "0.9.0"}, "", "conflict: shop requires customers >=1.0.0, but version 0.9.0 is installed"},
+		{"Conflict with available version", []string{"legacy"}, nil, "", "conflict: legacy requires products ^1.0.0, but version 2.1.0 is in the bundles folder"},
+		{"Cycle", []string{"chicken"}, nil, "", "circular dependency: chicken -> egg -> chicken"},
+	}
+
+	for _, c := range testCases {
+
+		order, err := resolveInstallOrder(c.requested, c.installed, bundlesFolder)
+
+		if c.expectedError != "" {
+			if err == nil || !strings.Contains(err.Error(), c.expectedError) {
+				got := strings.Join(order, ",")
+				if err != nil {
+					got = err.Error()
+				}
+				TestErrorFatal(t, c.description, got, c.expectedError)
+			}
+			continue
+		}
+
+		if err != nil {
+			TestErrorFatal(t, c.description, err.Error(), c.expectedOrder)
+		} else if strings.Join(order, ",") != c.expectedOrder {
+			TestErrorFatal(t, c.description, strings.Join(order, ","), c.expectedOrder)
+		}
+
+	}
+
+}
+
+func TestUninstallBundleNotInstalled(t *testing.T) {
+
+	defer changeConfig(func(c *config) { c.BundlesInstalled = []string{"shop"} })()
+
+	//Refused before anything is dropped, so no database is needed
+	err := UninstallBundle("accounts")
+	if exp := "Bundle 'accounts' is not installed"; errorString(err) != exp {
+		TestErrorFatal(t, "Uninstalling a bundle which isn't installed", errorString(err), exp)
+	}
+
+}
@@ -0,0 +1,314 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+//Outcomes of the doctor's checks
+const (
+	DoctorOK      = "ok"
+	DoctorWarning = "warning"
+	DoctorProblem = "problem"
+)
+
+//minimumSecretLength is the shortest secret the doctor accepts: 256 bits, base64 encoded
+const minimumSecretLength = 43
+
+const sqlToCheckBuiltIns = `SELECT
+	(SELECT array_to_string(array_agg(r), ',') FROM unnest(ARRAY['admin', 'anon', 'server']) r WHERE NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = r)),
+	to_regclass('public.users') IS NOT NULL,
+	to_regclass('public.ghost_bundles') IS NOT NULL;`
+
+//DoctorResult is the outcome of one of the doctor's checks, and what to do about it
+type DoctorResult struct {
+	Check  string
+	Status string
+	Detail string
+}
+
+//RunDoctor checks the whole environment the server runs in: the config file (given as
+//its raw contents), the database and its built in roles, the secret, email, bundles and
+//whether the ports are free
+func RunDoctor(configFile []byte) []DoctorResult {
+
+	results := []DoctorResult{checkConfigFile(configFile)}
+	results = append(results, checkDatabase()...)
+	results = append(results,
+		checkSecret(viper.GetString("secret")),
+		checkEmail(),
+		checkCache(),
+		checkStorage(),
+	)
+	results = append(results, checkBundles()...)
+	results = append(results, checkPorts()...)
+
+	return results
+
+}
+
+func checkOK(check, detail string) DoctorResult {
+	return DoctorResult{check, DoctorOK, detail}
+}
+
+func checkWarning(check, detail string) DoctorResult {
+	return DoctorResult{check, DoctorWarning, detail}
+}
+
+func checkProblem(check, detail string) DoctorResult {
+	return DoctorResult{check, DoctorProblem, detail}
+}
+
+//checkConfigFile lists the settings missing from the config file, which are zero rather
+//than their defaults, along with the default values to add
+func checkConfigFile(configFile []byte) DoctorResult {
+
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(configFile, &present); err != nil {
+		return checkProblem("Config file", "Not valid JSON: "+err.Error())
+	}
+
+	var missing []string
+	d := reflect.ValueOf(Defaults)
+	for i := 0; i < d.NumField(); i++ {
+		name := strings.Split(d.Type().Field(i).Tag.Get("json"), ",")[0]
+		if _, found := present[name]; !found {
+			value, _ := json.Marshal(d.Field(i).Interface())
+			missing = append(missing, fmt.Sprintf("%q: %s", name, value))
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return checkWarning("Config file", "Missing settings, which are empty or zero until added. The defaults are:\n"+strings.Join(missing, ",\n"))
+	}
+
+	return checkOK("Config file", "Complete")
+
+}
+
+//checkDatabase connects as the super user and looks for the built in roles and tables
+func checkDatabase() []DoctorResult {
+
+	where := fmt.Sprintf("%s:%s, database %s, as %s", App.Config().PgServer, App.Config().PgPort, App.Config().PgDBName, App.Config().PgSuperUser)
+
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err == nil {
+		err = db.Ping()
+	}
+	if err != nil {
+		return []DoctorResult{checkProblem("Database", "Could not connect to "+where+": "+err.Error()+
+			". Check pgServer, pgPort, pgDBName and pgSuperUser in the config, and pass the password with --pgpw")}
+	}
+	defer db.Close()
+
+	missing, err := missingBuiltIns(db)
+	if err != nil {
+		return []DoctorResult{checkOK("Database", "Connected to "+where), checkProblem("Database setup", err.Error())}
+	}
+
+	setup := checkOK("Database setup", "Built in roles and tables are in place")
+	if len(missing) > 0 {
+		setup = checkProblem("Database setup", "Missing "+strings.Join(missing, " and ")+". Run 'ghost init db'")
+	}
+
+	return []DoctorResult{checkOK("Database", "Connected to "+where), setup}
+
+}
+
+//missingBuiltIns lists the built in roles and tables which 'ghost init db' creates but the database lacks
+func missingBuiltIns(db *sql.DB) ([]string, error) {
+
+	var missingRoles *string
+	var users, registry bool
+	if err := db.QueryRow(sqlToCheckBuiltIns).Scan(&missingRoles, &users, &registry); err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	if missingRoles != nil && *missingRoles != "" {
+		missing = append(missing, "roles "+*missingRoles)
+	}
+	if !users {
+		missing = append(missing, "the users table")
+	}
+	if !registry {
+		missing = append(missing, "the bundle registry")
+	}
+
+	return missing, nil
+
+}
+
+//checkSecret makes sure the signing secret is long enough not to be guessed
+func checkSecret(secret string) DoctorResult {
+
+	switch {
+	case secret == "":
+		return checkWarning("Secret", "No secret in "+SecretEnvVar+" or the config, so it must be passed to the server with --secret. Run 'ghost gensecret' to make one")
+	case len(secret) < minimumSecretLength:
+		return checkProblem("Secret", fmt.Sprintf("The secret is only %d characters long, so tokens could be forged. Run 'ghost gensecret' to make a strong one", len(secret)))
+	}
+
+	return checkOK("Secret", "Strong enough")
+
+}
+
+//checkEmail checks the email settings and that the SMTP server accepts them
+func checkEmail() DoctorResult {
+
+	if !App.Config().ActivateEmail {
+		return checkOK("Email", "Not activated")
+	}
+
+	s := *App.MailServer()
+	if err := s.loadSettings(); err != nil {
+		return checkProblem("Email", err.Error())
+	}
+	if err := s.TestConnection(); err != nil {
+		return checkProblem("Email", "Could not connect to "+net.JoinHostPort(s.host, s.port)+": "+err.Error()+". Run 'ghost email test' to see the conversation with the server")
+	}
+
+	return checkOK("Email", "Connected to "+net.JoinHostPort(s.host, s.port))
+
+}
+
+//checkCache checks that Redis can be reached, if results are cached there
+func checkCache() DoctorResult {
+
+	if App.Config().Cache != cacheRedis {
+		return checkOK("Cache", "Caching in memory")
+	}
+
+	cache, ok := newResultCache(*App.Config()).(*redisCache)
+	if !ok {
+		return checkProblem("Cache", "Invalid redisURL '"+App.Config().RedisURL+"'. Use e.g. redis://localhost:6379/0")
+	}
+	defer cache.client.Close()
+
+	if err := cache.ping(); err != nil {
+		return checkProblem("Cache", "Could not connect to Redis at "+App.Config().RedisURL+": "+err.Error())
+	}
+
+	return checkOK("Cache", "Connected to Redis at "+App.Config().RedisURL)
+
+}
+
+//checkStorage checks that files can be written to, read from and deleted from storage
+func checkStorage() DoctorResult {
+
+	storage, err := newStorage(*App.Config())
+	if err != nil {
+		return checkProblem("Storage", err.Error())
+	}
+
+	where := App.Config().Storage
+	switch App.Config().Storage {
+	case "", storageLocal:
+		where = "the " + App.Config().StorageDir + " folder"
+		if App.Config().StorageDir == "" {
+			where = "the " + Defaults.StorageDir + " folder"
+		}
+	case storageS3, storageGCS:
+		where = App.Config().Storage + " bucket " + App.Config().StorageBucket
+	}
+
+	ctx := context.Background()
+	const testFile = ".ghost-doctor"
+	if err := storage.Put(ctx, testFile, strings.NewReader("ok"), "text/plain"); err != nil {
+		return checkProblem("Storage", "Could not write to "+where+": "+err.Error())
+	}
+	f, _, err := storage.Get(ctx, testFile)
+	if err != nil {
+		return checkProblem("Storage", "Could not read from "+where+": "+err.Error())
+	}
+	f.Close()
+	if err := storage.Delete(ctx, testFile); err != nil {
+		return checkProblem("Storage", "Could not delete from "+where+": "+err.Error())
+	}
+
+	return checkOK("Storage", "Files are stored in "+where)
+
+}
+
+//checkBundles validates every installed bundle
+func checkBundles() []DoctorResult {
+
+	var results []DoctorResult
+	for _, bundleName := range InstalledBundles() {
+		if err := ValidateBundle(bundleName); err != nil {
+			results = append(results, checkProblem("Bundle "+bundleName, err.Error()))
+		} else {
+			results = append(results, checkOK("Bundle "+bundleName, "Valid"))
+		}
+	}
+
+	if len(results) == 0 {
+		results = append(results, checkOK("Bundles", "None installed"))
+	}
+
+	return results
+
+}
+
+//checkPorts makes sure nothing else is listening where the server will
+func checkPorts() []DoctorResult {
+
+	ports := []string{App.Config().ApiPort}
+	if App.Config().HTTPRedirectPort != "" {
+		ports = append(ports, App.Config().HTTPRedirectPort)
+	}
+
+	var results []DoctorResult
+	for _, port := range ports {
+
+		network, address := listenAddress(App.Config().BindAddress, port)
+		check := "Listen on " + address
+
+		//A unix socket might belong to a running server, so it is only ever dialled
+		if network == "unix" {
+			if conn, err := net.DialTimeout(network, address, time.Second); err == nil {
+				conn.Close()
+				results = append(results, checkWarning(check, "Something is already listening there, perhaps a running server"))
+			} else {
+				results = append(results, checkOK(check, "Free"))
+			}
+			continue
+		}
+
+		l, err := net.Listen(network, address)
+		if err != nil {
+			results = append(results, checkProblem(check, err.Error()+". Stop whatever is using it, or change apiPort or bindAddress"))
+			continue
+		}
+		l.Close()
+		results = append(results, checkOK(check, "Free"))
+
+	}
+
+	return results
+
+}
@@ -0,0 +1,46 @@
+package ghost
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfigFile(t *testing.T) {
+
+	complete, _ := json.Marshal(Defaults)
+
+	testCases := []struct {
+		description, config, status, detail string
+	}{
+		{"Complete", string(complete), DoctorOK, ""},
+		{"Missing settings", `{"apiPort": "3000"}`, DoctorWarning, `"maxBodySize": 1048576`},
+		{"Not JSON", `{"apiPort": }`, DoctorProblem, "Not valid JSON"},
+	}
+
+	for _, c := range testCases {
+		result := checkConfigFile([]byte(c.config))
+		if result.Status != c.status || !strings.Contains(result.Detail, c.detail) {
+			TestErrorFatal(t, c.description, result.Status+": "+result.Detail, c.status+": "+c.detail)
+		}
+	}
+
+}
+
+func TestCheckSecret(t *testing.T) {
+
+	testCases := []struct {
+		secret, status string
+	}{
+		{"", DoctorWarning},
+		{"secret", DoctorProblem},
+		{"Zr2mV3nF8qLk0T9wXy7cB4dE6gH1jK5pQsUuWvYaZbC", DoctorOK},
+	}
+
+	for _, c := range testCases {
+		if result := checkSecret(c.secret); result.Status != c.status {
+			TestErrorFatal(t, c.secret, result.Status, c.status)
+		}
+	}
+
+}
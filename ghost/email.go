@@ -16,59 +16,122 @@ package ghost
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"html/template"
-	"net/smtp"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
+//ErrEmailHeaderLineBreak is returned when a recipient or subject contains a line break,
+//which would let it add headers to the email
+var ErrEmailHeaderLineBreak = errors.New("Email recipients and subjects can't contain line breaks")
+
+//hasLineBreak reports whether any of the strings contains a carriage return or line feed
+func hasLineBreak(values ...string) bool {
+
+	for _, v := range values {
+		if strings.ContainsAny(v, "\r\n") {
+			return true
+		}
+	}
+
+	return false
+
+}
+
 //SMTPServer holds all the necessary connection configuration for an SMTP server
 type smtpServer struct {
 	host, port, userName, password, from, FromName string
+	tlsMode, authMechanism                         string
+	timeout                                        time.Duration
+	skipVerify                                     bool
 	Working                                        bool
+
+	//trace, when set, is given each step of the conversation with the SMTP server
+	trace func(step string)
 }
 
-func (s *smtpServer) Setup() {
+//Setup configures the email system, returning an error if the SMTP server can't be used
+func (s *smtpServer) Setup() error {
 
 	Log("EMAIL", true, "Initialising email system...", nil)
 
-	//Setup the smtp config struct, and mark as not working
-	//Read in the configuration parameters from Viper
-	s.host = App.Config.SmtpHost
-	s.port = App.Config.SmtpPort
-	s.password = viper.GetString("smtpPW")
-	s.userName = App.Config.SmtpUserName
-	s.from = App.Config.SmtpFrom
-	s.FromName = App.Config.SmtpFrom
-	s.Working = false
+	if err := s.configure(); err != nil {
+		return fmt.Errorf("Error initialising email server: %s", err)
+	}
+
+	Log("EMAIL", true, "Email system correctly initialised", nil)
+	return nil
+
+}
+
+//configure reads the SMTP settings from the config and tests the connection.
+//The server is only marked as working if the test passes
+func (s *smtpServer) configure() error {
+
+	if err := s.loadSettings(); err != nil {
+		return err
+	}
 
 	//Test the SMTP connection
 	if err := s.TestConnection(); err != nil {
-		LogFatal("EMAIL", false, "Error initialising email server", err)
+		return err
 	}
 
 	//If it passes, setup the config
 	s.Working = true
-	Log("EMAIL", true, "Email system correctly initialised", nil)
+	return nil
 
 }
 
-//SendEmail is used internally by ghost modules to send transactional emails
-func (s smtpServer) SendEmail(to []string, subject string, data map[string]string, templates *template.Template, templateToUse string) (err error) {
+//loadSettings reads the SMTP settings from the config and checks them,
+//marking the server as not working until its connection has been tested
+func (s *smtpServer) loadSettings() error {
+
+	//Setup the smtp config struct, and mark as not working
+	//Read in the configuration parameters from Viper
+	s.host = App.Config().SmtpHost
+	s.port = App.Config().SmtpPort
+	s.password = viper.GetString("smtpPW")
+	s.userName = App.Config().SmtpUserName
+	s.from = App.Config().SmtpFrom
+	s.FromName = App.Config().SmtpFrom
+	s.tlsMode = App.Config().SmtpTLS
+	s.authMechanism = App.Config().SmtpAuth
+	s.timeout = time.Duration(App.Config().SmtpTimeout) * time.Second
+	s.skipVerify = App.Config().SmtpSkipVerify
+	s.Working = false
+
+	if err := validateSMTPSettings(s.tlsMode, s.authMechanism); err != nil {
+		return err
+	}
+	if s.skipVerify {
+		Log("EMAIL", false, "SMTP server certificate will not be verified (smtpSkipVerify)", nil)
+	}
+
+	return nil
+
+}
+
+//SendEmail is used internally by ghost modules to send transactional emails.
+//Attachments, including images embedded in the HTML, can be added at the end
+func (s smtpServer) SendEmail(to []string, subject string, data map[string]string, templates *template.Template, templateToUse string, attachments ...Attachment) (err error) {
+
+	//html/template doesn't escape line breaks, so they could add headers, e.g. a Bcc
+	if hasLineBreak(append([]string{subject, s.FromName}, to...)...) {
+		return ErrEmailHeaderLineBreak
+	}
 
 	//Prepare the date for the email template
-	parameters := struct {
-		From    string
-		To      string
-		Subject string
-		Data    map[string]string
-	}{
-		s.FromName,
-		strings.Join([]string(to), ","),
-		subject,
-		data,
+	parameters := EmailData{
+		From:    s.FromName,
+		To:      strings.Join([]string(to), ","),
+		Subject: subject,
+		SiteURL: AbsoluteURL("/"),
+		Data:    data,
 	}
 
 	//Email templating.  Note that the strcuture of the header part of the email template is extremely important if fields
@@ -89,30 +152,58 @@ func (s smtpServer) SendEmail(to []string, subject string, data map[string]strin
 		return err
 	}
 
-	auth := smtp.PlainAuth("", s.userName, s.password, s.host)
+	message, err := buildMessage(buffer.Bytes(), attachments)
+	if err != nil {
+		return err
+	}
+
+	return s.send(to, message)
+}
+
+//send delivers a message to the SMTP server
+func (s smtpServer) send(to []string, message []byte) error {
 
-	err = smtp.SendMail(
-		fmt.Sprintf("%s:%s", s.host, s.port),
-		auth,
-		s.from,
-		to,
-		buffer.Bytes())
+	c, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := s.step("MAIL FROM:<"+s.from+">", c.Mail(s.from)); err != nil {
+		return err
+	}
+	for _, address := range to {
+		if err := s.step("RCPT TO:<"+address+">", c.Rcpt(address)); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err := s.step("DATA", err); err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return s.step(fmt.Sprintf("Message (%d bytes)", len(message)), err)
+	}
+	if err := s.step(fmt.Sprintf("Message (%d bytes)", len(message)), w.Close()); err != nil {
+		return err
+	}
+
+	return s.step("QUIT", c.Quit())
 
-	return err
 }
 
 //testConnection tests an SMTP connection
 func (s smtpServer) TestConnection() error {
-	//First try connecting
-	c, err := smtp.Dial(fmt.Sprintf("%s:%s", s.host, s.port))
+
+	//Connecting also secures the connection and authenticates
+	c, err := s.dial()
 	if err != nil {
 		return err
 	}
-	//If that works, try authenticating
-	auth := smtp.PlainAuth("", s.userName, s.password, s.host)
-	if err := c.Auth(auth); err != nil {
-		return err
-	}
+	defer c.Close()
+
 	//If that all worked, return no error
-	return nil
+	return s.step("QUIT", c.Quit())
+
 }
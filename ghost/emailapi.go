@@ -0,0 +1,127 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pressly/chi"
+)
+
+//emailRequest is the body of a request to the email endpoint.  The template is
+//looked up as for SendBundleEmail, or among the built in templates if there is no bundle
+type emailRequest struct {
+	Bundle   string            `json:"bundle"`
+	Template string            `json:"template"`
+	To       []string          `json:"to"`
+	Subject  string            `json:"subject"`
+	Data     map[string]string `json:"data"`
+}
+
+//emailSent is the response from the email endpoint
+type emailSent struct {
+	Sent int `json:"sent"`
+}
+
+//ActivateEmailAPI adds POST /email, which sends a templated email through the email
+//system, e.g. for order confirmations triggered by a front end.  Pass the middleware
+//which authenticates the request and sets the 'role' on the request context; only the
+//roles in the emailAPIRoles setting may send
+func ActivateEmailAPI(authentication ...func(http.Handler) http.Handler) {
+
+	App.Router.Route("/email", func(r chi.Router) {
+		for _, m := range authentication {
+			r.Use(m)
+		}
+		r.Post("/", sendEmail)
+	})
+
+}
+
+//sendEmail sends the email described in the request body
+func sendEmail(w http.ResponseWriter, r *http.Request) {
+
+	//The roles are read on every request, so that they can be changed by reloading the config
+	role, _ := r.Context().Value("role").(string)
+	if !isPermittedRole(role, App.Config().EmailAPIRoles) {
+		WriteError(w, http.StatusForbidden, "This resource requires one of the roles: "+strings.Join(App.Config().EmailAPIRoles, ", "))
+		return
+	}
+
+	var req emailRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid email request: "+err.Error())
+		return
+	}
+
+	if req.Template == "" || len(req.To) == 0 || req.Subject == "" {
+		WriteError(w, http.StatusBadRequest, "An email request needs a template, at least one recipient in 'to' and a subject")
+		return
+	}
+
+	if !isValidEmailTemplateName(req.Template) {
+		WriteError(w, http.StatusBadRequest, "Invalid email template name '"+req.Template+"'")
+		return
+	}
+
+	if hasLineBreak(append([]string{req.Subject}, req.To...)...) {
+		WriteError(w, http.StatusBadRequest, ErrEmailHeaderLineBreak.Error())
+		return
+	}
+
+	bundleName := req.Bundle
+	if bundleName == "" {
+		bundleName = "ghost"
+	} else if !IsBundleEnabled(bundleName) {
+		WriteError(w, http.StatusNotFound, "Bundle '"+bundleName+"' is not installed or is disabled")
+		return
+	}
+
+	if !App.MailServer().Working {
+		WriteError(w, http.StatusServiceUnavailable, "The email system is not working")
+		return
+	}
+
+	t, err := EmailTemplate(bundleName, req.Template)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := App.MailServer().SendEmail(req.To, req.Subject, req.Data, t, req.Template); err != nil {
+		Log("EMAIL", false, "Could not send '"+req.Template+"' email", err)
+		WriteError(w, http.StatusBadGateway, "The email could not be sent")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, emailSent{len(req.To)})
+
+}
+
+func isPermittedRole(role string, roles []string) bool {
+
+	for _, permitted := range roles {
+		if role == permitted {
+			return true
+		}
+	}
+
+	return false
+
+}
@@ -0,0 +1,54 @@
+package ghost
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSendEmailEndpoint(t *testing.T) {
+
+	defer changeConfig(func(c *config) { c.EmailAPIRoles = []string{"admin", "shop"} })()
+	App.mailServer.Store(&smtpServer{})
+
+	testCases := []struct {
+		description, role, body string
+		status                  int
+	}{
+		{"Role not allowed", "anon", `{}`, http.StatusForbidden},
+		{"Unknown field", "shop", `{"template": "message", "cc": ["a@example.com"]}`, http.StatusBadRequest},
+		{"No recipients", "shop", `{"template": "message", "subject": "Hello"}`, http.StatusBadRequest},
+		{"Template outside the template folders", "shop", `{"template": "../../../etc/secret", "to": ["a@example.com"], "subject": "Hello"}`, http.StatusBadRequest},
+		{"Header in the subject", "shop", `{"template": "message", "to": ["a@example.com"], "subject": "Hello\r\nBcc: b@example.com"}`, http.StatusBadRequest},
+		{"Header in a recipient", "shop", `{"template": "message", "to": ["a@example.com\nBcc: b@example.com"], "subject": "Hello"}`, http.StatusBadRequest},
+		{"Bundle not installed", "shop", `{"bundle": "nosuchbundle", "template": "receipt", "to": ["a@example.com"], "subject": "Hello"}`, http.StatusNotFound},
+		{"Email not working", "admin", `{"template": "message", "to": ["a@example.com"], "subject": "Hello"}`, http.StatusServiceUnavailable},
+	}
+
+	for _, c := range testCases {
+		r := httptest.NewRequest("POST", "/email", strings.NewReader(c.body))
+		r = r.WithContext(context.WithValue(r.Context(), "role", c.role))
+		w := httptest.NewRecorder()
+		sendEmail(w, r)
+		if w.Code != c.status {
+			TestErrorFatal(t, c.description, strconv.Itoa(w.Code), strconv.Itoa(c.status))
+		}
+	}
+
+}
+
+func TestSendEmailHeaderLineBreaks(t *testing.T) {
+
+	//Checked before anything is rendered or sent
+	s := smtpServer{FromName: "shop@example.com"}
+	if err := s.SendEmail([]string{"a@example.com"}, "Hello\r\nBcc: b@example.com", nil, nil, "message"); err != ErrEmailHeaderLineBreak {
+		TestErrorFatal(t, "Line break in the subject", errorString(err), ErrEmailHeaderLineBreak.Error())
+	}
+	if err := s.SendEmail([]string{"a@example.com\nBcc: b@example.com"}, "Hello", nil, nil, "message"); err != ErrEmailHeaderLineBreak {
+		TestErrorFatal(t, "Line break in a recipient", errorString(err), ErrEmailHeaderLineBreak.Error())
+	}
+
+}
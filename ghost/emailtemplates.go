@@ -0,0 +1,133 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"errors"
+	"html/template"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+//emailTemplatesFolder holds email templates, in a bundle's templates folder and, for
+//overrides, in the site's templates folder under the bundle's name
+const emailTemplatesFolder = "email"
+
+//EmailData is what every email template is given:
+//  {{.From}}, {{.To}} and {{.Subject}} for the headers,
+//  {{.SiteURL}} for links back to the site, and
+//  {{.Data.xxx}} for whatever the sender passes, e.g. {{.Data.orderNumber}}
+type EmailData struct {
+	From    string
+	To      string
+	Subject string
+	SiteURL string
+	Data    map[string]string
+}
+
+//Built in email templates, used when neither the site nor the bundle has its own
+var (
+	defaultEmailTemplates = map[string]string{
+		"message": `To: {{.To}}
+From: {{.From}}
+Subject: {{.Subject}}
+MIME-version: 1.0
+Content-Type: text/html; charset="UTF-8"
+
+<p>{{.Data.message}}</p>
+<p><a href="{{.SiteURL}}">{{.SiteURL}}</a></p>
+`,
+	}
+	defaultEmailTemplatesMutex sync.RWMutex
+)
+
+//RegisterDefaultEmailTemplate adds a built in email template, for packages which send
+//emails without a bundle folder of their own to keep them in
+func RegisterDefaultEmailTemplate(name, text string) {
+
+	defaultEmailTemplatesMutex.Lock()
+	defaultEmailTemplates[name] = text
+	defaultEmailTemplatesMutex.Unlock()
+
+}
+
+//emailTemplatePaths returns the places an email template is looked for, in order:
+//the site's override in templates/email/BUNDLE/NAME.html, then the bundle's own
+//in bundles/BUNDLE/templates/email/NAME.html
+func emailTemplatePaths(bundleName, templateName string) []string {
+
+	fileName := templateName + ".html"
+
+	return []string{
+		path.Join("templates", emailTemplatesFolder, bundleName, fileName),
+		BundlePath(bundleName, "templates", emailTemplatesFolder, fileName),
+	}
+
+}
+
+//isValidEmailTemplateName reports whether a template name is a plain file name, so that
+//looking it up can't read files outside the template folders
+func isValidEmailTemplateName(name string) bool {
+	return name != "" && len(name) < 64 && !strings.ContainsAny(name, `/\`) && !strings.Contains(name, "..")
+}
+
+//EmailTemplate finds and parses an email template, so that site operators can restyle
+//a bundle's emails without editing the bundle.  Built in templates come last
+func EmailTemplate(bundleName, templateName string) (*template.Template, error) {
+
+	if !IsValidIdentifier(bundleName) {
+		return nil, errors.New("Invalid bundle name '" + bundleName + "'")
+	}
+	if !isValidEmailTemplateName(templateName) {
+		return nil, errors.New("Invalid email template name '" + templateName + "'")
+	}
+
+	for _, p := range emailTemplatePaths(bundleName, templateName) {
+		b, err := afero.ReadFile(App.FileSystem, p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return template.New(templateName).Parse(string(b))
+	}
+
+	defaultEmailTemplatesMutex.RLock()
+	text, ok := defaultEmailTemplates[templateName]
+	defaultEmailTemplatesMutex.RUnlock()
+	if !ok {
+		return nil, errors.New("No email template '" + templateName + "' for bundle '" + bundleName + "'")
+	}
+
+	return template.New(templateName).Parse(text)
+
+}
+
+//SendBundleEmail sends an email using one of a bundle's templates, looked up with EmailTemplate
+func (s smtpServer) SendBundleEmail(bundleName, templateName string, to []string, subject string, data map[string]string, attachments ...Attachment) error {
+
+	t, err := EmailTemplate(bundleName, templateName)
+	if err != nil {
+		return err
+	}
+
+	return s.SendEmail(to, subject, data, t, templateName, attachments...)
+
+}
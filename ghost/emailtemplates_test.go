@@ -0,0 +1,52 @@
+package ghost
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestEmailTemplate(t *testing.T) {
+
+	App.FileSystem = afero.NewMemMapFs()
+	afero.WriteFile(App.FileSystem, "bundles/shop/templates/email/receipt.html", []byte("Bundle receipt {{.Data.order}}"), 0644)
+	afero.WriteFile(App.FileSystem, "bundles/shop/templates/email/invoice.html", []byte("Bundle invoice {{.Data.order}}"), 0644)
+	afero.WriteFile(App.FileSystem, "templates/email/shop/invoice.html", []byte("Site invoice {{.Data.order}}"), 0644)
+
+	testCases := []struct {
+		template, exp string
+	}{
+		{"receipt", "Bundle receipt 42"},
+		{"invoice", "Site invoice 42"},
+		{"message", "<p>Thanks</p>"},
+	}
+
+	data := EmailData{Data: map[string]string{"order": "42", "message": "Thanks"}}
+
+	for _, c := range testCases {
+		tmpl, err := EmailTemplate("shop", c.template)
+		if err != nil {
+			TestErrorFatal(t, c.template, err.Error(), c.exp)
+		}
+		b := new(bytes.Buffer)
+		tmpl.Execute(b, data)
+		if !bytes.Contains(b.Bytes(), []byte(c.exp)) {
+			TestErrorFatal(t, c.template, b.String(), c.exp)
+		}
+	}
+
+	if _, err := EmailTemplate("shop", "missing"); err == nil {
+		TestErrorFatal(t, "Missing template", "found", "an error")
+	}
+
+	//Template names can't reach outside the template folders
+	afero.WriteFile(App.FileSystem, "secret.html", []byte("Secret"), 0644)
+	for _, name := range []string{"../../../secret", "../secret", "email/../receipt", `..\secret`, ""} {
+		_, err := EmailTemplate("shop", name)
+		if exp := "Invalid email template name '" + name + "'"; errorString(err) != exp {
+			TestErrorFatal(t, "Template name "+name, errorString(err), exp)
+		}
+	}
+
+}
@@ -0,0 +1,51 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"errors"
+	"strings"
+)
+
+//SendTestEmail checks the email settings and sends a test message to an address, using the
+//built in 'message' template.  It returns each step of the conversation with the SMTP
+//server, so that a failure can be tracked down without reading any code
+func SendTestEmail(to string) ([]string, error) {
+
+	var transcript []string
+
+	if !App.Config().ActivateEmail {
+		return transcript, errors.New("Email is not activated. Set activateEmail to true in the config")
+	}
+
+	s := *App.MailServer()
+	if err := s.loadSettings(); err != nil {
+		return transcript, err
+	}
+	s.trace = func(step string) {
+		transcript = append(transcript, step)
+	}
+
+	t, err := EmailTemplate("ghost", "message")
+	if err != nil {
+		return transcript, err
+	}
+
+	data := map[string]string{"message": "This is a test message. If you can read it, email is working."}
+	err = s.SendEmail([]string{strings.TrimSpace(to)}, "Test message from "+s.FromName, data, t, "message")
+
+	return transcript, err
+
+}
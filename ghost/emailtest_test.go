@@ -0,0 +1,80 @@
+package ghost
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+//fakeSMTPServer answers one connection, offering PLAIN authentication and then refusing it
+func fakeSMTPServer(t *testing.T) string {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("Cannot listen for the fake SMTP server: ", err)
+	}
+
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("220 fake ESMTP\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				conn.Write([]byte("250-fake\r\n250 AUTH PLAIN LOGIN\r\n"))
+			case strings.HasPrefix(line, "AUTH"):
+				conn.Write([]byte("535 5.7.8 Authentication credentials invalid\r\n"))
+			case strings.HasPrefix(line, "QUIT"):
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("502 not implemented\r\n"))
+			}
+		}
+	}()
+
+	return l.Addr().String()
+
+}
+
+func TestSendTestEmail(t *testing.T) {
+
+	host, port, _ := net.SplitHostPort(fakeSMTPServer(t))
+	defer changeConfig(func(c *config) {
+		c.ActivateEmail = true
+		c.SmtpHost = host
+		c.SmtpPort = port
+		c.SmtpUserName = "info@example.com"
+		c.SmtpTLS = "none"
+		c.SmtpAuth = "plain"
+		c.SmtpTimeout = 5
+	})()
+
+	transcript, err := SendTestEmail("someone@example.com")
+	if err == nil {
+		TestErrorFatal(t, "Refused authentication", "sent", "an error")
+	}
+
+	exp := []string{
+		"Connect to " + net.JoinHostPort(host, port) + ": OK",
+		"Server greeting: OK",
+		"EHLO: OK",
+		"Server offers AUTH PLAIN LOGIN: OK",
+		`AUTH PLAIN as info@example.com: 535 "5.7.8 Authentication credentials invalid"`,
+	}
+	if strings.Join(transcript, "\n") != strings.Join(exp, "\n") {
+		TestErrorFatal(t, "Transcript", strings.Join(transcript, "\n"), strings.Join(exp, "\n"))
+	}
+
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+//embeddedFiles holds bundle files compiled into the binary with RegisterEmbeddedFiles
+var (
+	embeddedFiles afero.Fs
+	embeddedMutex sync.Mutex
+)
+
+//RegisterEmbeddedFiles adds files to the binary's embedded file system, keyed by path
+//(e.g. bundles/shop/public/app.js).  It is called from the init function of the file
+//generated by 'ghost bundle embed' - there's no need to call it yourself
+func RegisterEmbeddedFiles(files map[string][]byte) {
+
+	embeddedMutex.Lock()
+	defer embeddedMutex.Unlock()
+
+	if embeddedFiles == nil {
+		embeddedFiles = afero.NewMemMapFs()
+	}
+
+	for name, contents := range files {
+		embeddedFiles.MkdirAll(path.Dir(name), os.ModePerm)
+		afero.WriteFile(embeddedFiles, name, contents, 0644)
+	}
+
+}
+
+//newFileSystem returns the app file system.  Without embedded files, this is just the
+//OS file system.  With them, files on disk take precedence over embedded ones, so
+//a deployment can be a single binary and still have individual files overridden
+func newFileSystem() afero.Fs {
+
+	embeddedMutex.Lock()
+	defer embeddedMutex.Unlock()
+
+	if embeddedFiles == nil {
+		return afero.NewOsFs()
+	}
+
+	return afero.NewCopyOnWriteFs(afero.NewReadOnlyFs(embeddedFiles), afero.NewOsFs())
+
+}
+
+//embedSkip lists files which are never embedded: version control placeholders
+//and plugins, which can't be loaded from memory
+var embedSkip = map[string]bool{
+	".gitkeep": true,
+	".so":      true,
+}
+
+//GenerateEmbeddedBundles writes Go source for the given package which compiles the
+//named bundles' folders into the binary.  If folders are given, only those folders
+//of each bundle (e.g. templates, public) are embedded, otherwise everything is
+func GenerateEmbeddedBundles(fs afero.Fs, w io.Writer, packageName string, bundleNames []string, folders []string) error {
+
+	files := map[string][]byte{}
+
+	for _, bundleName := range bundleNames {
+
+		roots := []string{BundlePath(bundleName)}
+		if len(folders) > 0 {
+			roots = nil
+			for _, folder := range folders {
+				roots = append(roots, BundlePath(bundleName, folder))
+			}
+		}
+
+		if exists, _ := afero.DirExists(fs, BundlePath(bundleName)); !exists {
+			return fmt.Errorf("Bundle '%s' is not in the bundles folder", bundleName)
+		}
+
+		for _, root := range roots {
+			if exists, _ := afero.DirExists(fs, root); !exists {
+				continue
+			}
+			err := afero.Walk(fs, root, func(p string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() || embedSkip[info.Name()] || embedSkip[path.Ext(p)] {
+					return err
+				}
+				contents, err := afero.ReadFile(fs, p)
+				if err != nil {
+					return err
+				}
+				files[filepath.ToSlash(p)] = contents
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by ghost bundle embed. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&src, "package %s\n\n", packageName)
+	fmt.Fprintf(&src, "import \"github.com/jpincas/ghost/ghost\"\n\n")
+	fmt.Fprintf(&src, "func init() {\n\tghost.RegisterEmbeddedFiles(map[string][]byte{\n")
+	for _, name := range names {
+		fmt.Fprintf(&src, "\t\t%q: []byte(%q),\n", name, files[name])
+	}
+	fmt.Fprintf(&src, "\t})\n}\n")
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(formatted)
+	return err
+
+}
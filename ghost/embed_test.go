@@ -0,0 +1,56 @@
+package ghost
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestGenerateEmbeddedBundles(t *testing.T) {
+
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "bundles/shop/install.sql", []byte("CREATE TABLE products();"), 0644)
+	afero.WriteFile(fs, "bundles/shop/public/app.js", []byte("console.log(\"hi\")\n"), 0644)
+	afero.WriteFile(fs, "bundles/shop/public/.gitkeep", nil, 0644)
+	afero.WriteFile(fs, "bundles/shop/templates/product.html", []byte("<h1>{{.Name}}</h1>"), 0644)
+
+	var src bytes.Buffer
+	if err := GenerateEmbeddedBundles(fs, &src, "main", []string{"shop"}, []string{"public", "templates"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		description, expected string
+		present               bool
+	}{
+		{"Package clause", "package main", true},
+		{"Public files", `[]byte("console.log(\"hi\")\n")`, true},
+		{"Templates", `"bundles/shop/templates/product.html"`, true},
+		{"Only the selected folders", "install.sql", false},
+		{"No placeholders", ".gitkeep", false},
+	} {
+		if strings.Contains(src.String(), c.expected) != c.present {
+			TestErrorFatal(t, c.description, src.String(), c.expected)
+		}
+	}
+
+	if err := GenerateEmbeddedBundles(fs, &src, "main", []string{"blog"}, nil); err == nil {
+		t.Error("Embedding a missing bundle should fail")
+	}
+
+}
+
+func TestEmbeddedFileSystem(t *testing.T) {
+
+	defer func() { embeddedFiles = nil }()
+
+	RegisterEmbeddedFiles(map[string][]byte{"bundles/embedded_test_bundle/bundle.json": []byte(`{"version": "1.0.0"}`)})
+
+	b, err := afero.ReadFile(newFileSystem(), "bundles/embedded_test_bundle/bundle.json")
+	if err != nil || string(b) != `{"version": "1.0.0"}` {
+		t.Error("Embedded files should be readable from the app file system")
+	}
+
+}
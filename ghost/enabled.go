@@ -0,0 +1,167 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+)
+
+const (
+	sqlToSetBundleEnabled    = `UPDATE ghost_bundles SET enabled = $2, updated_at = now() WHERE name = $1;`
+	sqlToListDisabledBundles = `SELECT name FROM ghost_bundles WHERE NOT enabled ORDER BY name;`
+)
+
+//disabledBundles holds the installed bundles which are switched off, guarded by bundlesMutex.
+//The registry in the database is the record, this is loaded from it when the server
+//starts and when the config is reloaded
+var disabledBundles = map[string]bool{}
+
+//IsBundleEnabled reports whether a bundle is installed and not disabled
+func IsBundleEnabled(bundleName string) bool {
+
+	if !IsBundleInstalled(bundleName) {
+		return false
+	}
+
+	bundlesMutex.RLock()
+	defer bundlesMutex.RUnlock()
+
+	return !disabledBundles[bundleName]
+
+}
+
+//EnabledBundles returns the installed bundles which are not disabled
+func EnabledBundles() Bundles {
+
+	var enabled Bundles
+	for _, bundleName := range InstalledBundles() {
+		if IsBundleEnabled(bundleName) {
+			enabled = append(enabled, bundleName)
+		}
+	}
+
+	return enabled
+
+}
+
+//SetBundleEnabled switches an installed bundle on or off without touching its schema.
+//A disabled bundle's public assets, admin panel configuration, hooks and any routes
+//it added through an OnServe hook are unavailable until it is enabled again.
+//The change is recorded in the bundle registry so that it survives a restart;
+//a running server picks up changes made from the command line when its config is reloaded
+func SetBundleEnabled(bundleName string, enabled bool) error {
+
+	if !IsBundleInstalled(bundleName) {
+		return errors.New("Bundle '" + bundleName + "' is not installed")
+	}
+
+	//Establish a temporary connection as the super user
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(SQLToCreateBundleRegistry); err != nil {
+		return err
+	}
+
+	result, err := db.Exec(sqlToSetBundleEnabled, bundleName, enabled)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.New("Bundle '" + bundleName + "' is not in the bundle registry")
+	}
+
+	bundlesMutex.Lock()
+	if enabled {
+		delete(disabledBundles, bundleName)
+	} else {
+		disabledBundles[bundleName] = true
+	}
+	bundlesMutex.Unlock()
+
+	bundlesChanged()
+	return nil
+
+}
+
+//loadBundleStates reads which bundles are disabled from the bundle registry
+func loadBundleStates(db *sql.DB) error {
+
+	if _, err := db.Exec(SQLToCreateBundleRegistry); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(sqlToListDisabledBundles)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	disabled := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		disabled[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	bundlesMutex.Lock()
+	disabledBundles = disabled
+	bundlesMutex.Unlock()
+
+	return nil
+
+}
+
+//refreshBundleStates reloads which bundles are disabled using a temporary super user connection
+func refreshBundleStates() error {
+
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return loadBundleStates(db)
+
+}
+
+//RequireBundleEnabled is middleware which responds with 503 Service Unavailable
+//while the bundle is disabled.  Routes added by OnServe hooks are wrapped in it
+func RequireBundleEnabled(bundleName string) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if !IsBundleEnabled(bundleName) {
+				WriteError(w, http.StatusServiceUnavailable, "Bundle '"+bundleName+"' is disabled")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+
+		})
+	}
+
+}
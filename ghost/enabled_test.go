@@ -0,0 +1,49 @@
+package ghost
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDisabledBundles(t *testing.T) {
+
+	restore := changeConfig(func(c *config) { c.BundlesInstalled = Bundles{"shop", "blog"} })
+	disabledBundles = map[string]bool{"blog": true}
+	defer func() {
+		restore()
+		disabledBundles = map[string]bool{}
+		registeredHooks = nil
+	}()
+
+	if got := EnabledBundles(); len(got) != 1 || got[0] != "shop" {
+		TestErrorFatal(t, "Disabled bundles are not enabled", boolString(IsBundleEnabled("blog")), "false")
+	}
+
+	//Hooks of disabled bundles don't run
+	ran := false
+	RegisterHooks("blog", Hooks{AfterInsert: func(e *RecordEvent) { ran = true }})
+	RunAfterHooks(OperationInsert, &RecordEvent{})
+	if ran {
+		t.Error("Hooks of a disabled bundle should not run")
+	}
+
+	//Routes of disabled bundles are unavailable
+	handler := RequireBundleEnabled("blog")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, c := range []struct {
+		bundle   string
+		disabled bool
+		expected int
+	}{
+		{"blog", true, http.StatusServiceUnavailable},
+		{"blog", false, http.StatusOK},
+	} {
+		disabledBundles[c.bundle] = c.disabled
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/blog/posts", nil))
+		if w.Code != c.expected {
+			TestErrorFatal(t, "Bundle routes follow the enabled flag", http.StatusText(w.Code), http.StatusText(c.expected))
+		}
+	}
+
+}
@@ -0,0 +1,199 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//Events published by the core on the event bus.  The core publishes the record events
+//for changes made through the Store; other writes, like the API's bundle SQL endpoints,
+//need to call PublishEvent themselves
+const (
+	EventRecordInserted = "RecordInserted"
+	EventRecordUpdated  = "RecordUpdated"
+	EventRecordDeleted  = "RecordDeleted"
+	EventUserLoggedIn   = "UserLoggedIn"
+	//EventAny subscribes to every event
+	EventAny = "*"
+)
+
+//Event is something which has happened in the application, published on the event bus.
+//Handlers switch on its type to get at the details
+type Event interface {
+	EventName() string
+}
+
+//RecordInserted is published once a record added through the Store is committed
+type RecordInserted struct {
+	RecordEvent
+	//Result is the record as it was saved
+	Result json.RawMessage
+	Time   time.Time
+}
+
+//RecordUpdated is published once a change to a record made through the Store is committed
+type RecordUpdated struct {
+	RecordEvent
+	Result json.RawMessage
+	Time   time.Time
+}
+
+//RecordDeleted is published once a record deleted through the Store is gone
+type RecordDeleted struct {
+	RecordEvent
+	//Result is the record as it was before it was deleted
+	Result json.RawMessage
+	Time   time.Time
+}
+
+//UserLoggedIn is published when a user is given a token
+type UserLoggedIn struct {
+	UserID, Email string
+	Time          time.Time
+}
+
+func (RecordInserted) EventName() string { return EventRecordInserted }
+func (RecordUpdated) EventName() string  { return EventRecordUpdated }
+func (RecordDeleted) EventName() string  { return EventRecordDeleted }
+func (UserLoggedIn) EventName() string   { return EventUserLoggedIn }
+
+//EventHandler is called with each event it is subscribed to
+type EventHandler func(e Event)
+
+type eventSubscription struct {
+	id           int
+	name, bundle string
+	handler      EventHandler
+}
+
+var (
+	eventSubscriptions      []eventSubscription
+	eventSubscriptionID     int
+	eventSubscriptionsMutex sync.RWMutex
+)
+
+//Subscribe calls the handler with every event of the given name, or every event for
+//EventAny, until the returned function is called.  Handlers run one after the other in
+//the goroutine publishing the event, so the request which caused it waits for them.
+//Hand anything slow to the job queue, e.g. with EnqueueOnEvent
+func Subscribe(name string, handler EventHandler) (unsubscribe func()) {
+	return subscribeEvent("", name, handler)
+}
+
+//SubscribeBundle subscribes a bundle's handler, which is only called while the bundle is enabled
+func SubscribeBundle(bundleName, name string, handler EventHandler) (unsubscribe func()) {
+	return subscribeEvent(bundleName, name, handler)
+}
+
+//EnqueueOnEvent adds a job of the given type to the job queue for every event of the
+//given name, with the event as its payload
+func EnqueueOnEvent(name, jobType string, opts JobOptions) (unsubscribe func()) {
+
+	return Subscribe(name, func(e Event) {
+		if _, err := EnqueueJobWithOptions(jobType, e, opts); err != nil {
+			Log("EVENTS", false, "Could not enqueue '"+jobType+"' job for "+e.EventName()+" event", err)
+		}
+	})
+
+}
+
+func subscribeEvent(bundleName, name string, handler EventHandler) func() {
+
+	eventSubscriptionsMutex.Lock()
+	defer eventSubscriptionsMutex.Unlock()
+
+	eventSubscriptionID++
+	id := eventSubscriptionID
+	eventSubscriptions = append(eventSubscriptions, eventSubscription{id, name, bundleName, handler})
+
+	return func() {
+		eventSubscriptionsMutex.Lock()
+		defer eventSubscriptionsMutex.Unlock()
+
+		for k, s := range eventSubscriptions {
+			if s.id == id {
+				eventSubscriptions = append(eventSubscriptions[:k:k], eventSubscriptions[k+1:]...)
+				return
+			}
+		}
+	}
+
+}
+
+//PublishEvent calls the handlers subscribed to an event, in the order they subscribed.
+//A handler which panics is logged and doesn't stop the others
+func PublishEvent(e Event) {
+
+	for _, s := range eventSubscribers(e.EventName()) {
+		callEventHandler(s, e)
+	}
+
+}
+
+//eventSubscribers returns the subscriptions to an event, leaving out those of disabled bundles
+func eventSubscribers(name string) []eventSubscription {
+
+	eventSubscriptionsMutex.RLock()
+	defer eventSubscriptionsMutex.RUnlock()
+
+	var subscribers []eventSubscription
+	for _, s := range eventSubscriptions {
+		if s.name != name && s.name != EventAny {
+			continue
+		}
+		if s.bundle != "" && !IsBundleEnabled(s.bundle) {
+			continue
+		}
+		subscribers = append(subscribers, s)
+	}
+
+	return subscribers
+
+}
+
+func callEventHandler(s eventSubscription, e Event) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			message := "Handler for " + e.EventName() + " event panicked"
+			if s.bundle != "" {
+				message = "Handler in bundle '" + s.bundle + "' for " + e.EventName() + " event panicked"
+			}
+			Log("EVENTS", false, message, fmt.Errorf("%v", r))
+		}
+	}()
+
+	s.handler(e)
+
+}
+
+//recordEvent builds the event published after a Store write
+func recordEvent(operation string, e *RecordEvent, result string) Event {
+
+	now := time.Now()
+	switch operation {
+	case OperationInsert:
+		return RecordInserted{*e, json.RawMessage(result), now}
+	case OperationUpdate:
+		return RecordUpdated{*e, json.RawMessage(result), now}
+	default:
+		return RecordDeleted{*e, json.RawMessage(result), now}
+	}
+
+}
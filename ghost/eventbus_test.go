@@ -0,0 +1,62 @@
+package ghost
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPublishEvent(t *testing.T) {
+
+	var got []string
+	record := func(prefix string) EventHandler {
+		return func(e Event) {
+			got = append(got, prefix+e.EventName())
+		}
+	}
+
+	unsubscribeInserted := Subscribe(EventRecordInserted, record("a:"))
+	unsubscribeAny := Subscribe(EventAny, record("b:"))
+	unsubscribePanic := Subscribe(EventUserLoggedIn, func(e Event) { panic("handler failed") })
+	defer unsubscribeAny()
+	defer unsubscribePanic()
+
+	testCases := []struct {
+		description string
+		publish     func()
+		exp         string
+	}{
+		{"Handlers run in the order they subscribed", func() { PublishEvent(RecordInserted{}) }, "a:RecordInserted b:RecordInserted"},
+		{"Only handlers for the event or any event run", func() { PublishEvent(RecordDeleted{}) }, "b:RecordDeleted"},
+		{"A panicking handler doesn't stop the others", func() { PublishEvent(UserLoggedIn{}) }, "b:UserLoggedIn"},
+		{"Unsubscribed handlers don't run", func() { unsubscribeInserted(); PublishEvent(RecordInserted{}) }, "b:RecordInserted"},
+	}
+
+	for _, c := range testCases {
+		got = nil
+		c.publish()
+		if strings.Join(got, " ") != c.exp {
+			TestErrorFatal(t, c.description, strings.Join(got, " "), c.exp)
+		}
+	}
+
+}
+
+func TestRecordEvent(t *testing.T) {
+
+	e := &RecordEvent{Schema: "shop", Table: "products", ID: "1"}
+
+	testCases := []struct {
+		operation, exp string
+	}{
+		{OperationInsert, EventRecordInserted},
+		{OperationUpdate, EventRecordUpdated},
+		{OperationDelete, EventRecordDeleted},
+	}
+
+	for _, c := range testCases {
+		if got := recordEvent(c.operation, e, `{"id":1}`).EventName(); got != c.exp {
+			TestErrorFatal(t, c.operation, got, c.exp)
+		}
+	}
+
+}
@@ -0,0 +1,176 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pressly/chi"
+)
+
+//eventsFilterPrefix marks the query parameters which filter an event stream, e.g.
+//?filter.status=paid
+const eventsFilterPrefix = "filter."
+
+//eventsKeepAlive is how often a comment is sent on a quiet stream, so that proxies
+//don't close it
+const eventsKeepAlive = 30 * time.Second
+
+//ActivateEvents adds GET /events/{schema}/{table}, a Server-Sent Events stream of
+//the same change events as the /subscribe websocket, for clients which can't use
+//websockets.  Pass the middleware which authenticates the request and sets the 'role'
+//on the request context.  Clients reconnecting with a Last-Event-ID header are sent
+//the events they missed, as long as they are still in the change history
+func ActivateEvents(authentication ...func(http.Handler) http.Handler) {
+
+	App.Router.Route("/events", func(r chi.Router) {
+		for _, m := range authentication {
+			r.Use(m)
+		}
+		r.Get("/{schema}/{table}", streamEvents)
+	})
+
+}
+
+//streamEvents streams changes to a table until the client goes away
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+
+	role, _ := r.Context().Value("role").(string)
+	if role == "" {
+		WriteError(w, http.StatusUnauthorized, "Streaming events requires authentication")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	topic, err := subscriptionTopic(role, subscriptionRequest{
+		Schema: chi.URLParam(r, "schema"),
+		Table:  chi.URLParam(r, "table"),
+		Filter: eventsFilter(r),
+	})
+	if err != nil {
+		WriteError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	//Subscribe before looking at the history, so that nothing falls in between
+	subscriber := newChangeSubscriber()
+	defer subscriber.close()
+	subscriber.subscribe(0, topic)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+
+	//Catch up on anything missed since the last event the client had
+	sent := map[int64]bool{}
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		missed, complete := changesSince(id)
+		if err != nil || !complete {
+			//Tell the client to reload, since it can't be told everything it missed
+			fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+		}
+
+		for _, e := range missed {
+			if !topic.matches(e, recordFields(e.Record)) {
+				continue
+			}
+			if !topic.WithRecord {
+				e.Record = nil
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			sent[e.ID] = true
+		}
+
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+
+		select {
+
+		case <-r.Context().Done():
+			return
+
+		case change, ok := <-subscriber.events:
+			//A closed subscription couldn't keep up.  The client will reconnect and catch up
+			if !ok {
+				return
+			}
+			if sent[change.Event.ID] {
+				delete(sent, change.Event.ID)
+				continue
+			}
+			if err := writeEvent(w, change.Event); err != nil {
+				return
+			}
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep alive\n\n"); err != nil {
+				return
+			}
+
+		}
+
+		flusher.Flush()
+
+	}
+
+}
+
+//eventsFilter reads the filter for an event stream from the query string
+func eventsFilter(r *http.Request) map[string]string {
+
+	filter := map[string]string{}
+	for key, values := range r.URL.Query() {
+		if strings.HasPrefix(key, eventsFilterPrefix) && len(values) > 0 {
+			filter[strings.TrimPrefix(key, eventsFilterPrefix)] = values[0]
+		}
+	}
+
+	return filter
+
+}
+
+//writeEvent writes a change event in the Server-Sent Events format
+func writeEvent(w http.ResponseWriter, e ChangeEvent) error {
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", e.ID, data)
+	return err
+
+}
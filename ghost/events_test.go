@@ -0,0 +1,65 @@
+package ghost
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChangesSince(t *testing.T) {
+
+	restore := changeConfig(func(c *config) { c.ChangeHistory = 3 })
+	defer func() {
+		restore()
+		changeHistory.events = nil
+	}()
+
+	//IDs come in commit order, not always in number order
+	for _, id := range []int64{1, 2, 4, 3, 5} {
+		recordChange(ChangeEvent{ID: id})
+	}
+
+	testCases := []struct {
+		desc string
+		id   int64
+		exp  string
+	}{
+		{"Up to date", 5, "[] true"},
+		{"Missed some", 4, "[3 5] true"},
+		{"Dropped from history", 2, "[] false"},
+		{"Unknown", 99, "[] false"},
+	}
+
+	for _, c := range testCases {
+		events, complete := changesSince(c.id)
+		ids := []int64{}
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		if got := fmt.Sprint(ids, " ", complete); got != c.exp {
+			TestErrorFatal(t, c.desc, got, c.exp)
+		}
+	}
+
+}
+
+func TestEventsFilter(t *testing.T) {
+
+	r := httptest.NewRequest("GET", "/events/shop/orders?filter.status=paid&token=abc&filter.customer=7", nil)
+	if got := fmt.Sprint(eventsFilter(r)); got != "map[customer:7 status:paid]" {
+		TestErrorFatal(t, "Filter from query string", got, "map[customer:7 status:paid]")
+	}
+
+}
+
+func TestWriteEvent(t *testing.T) {
+
+	w := httptest.NewRecorder()
+	writeEvent(w, ChangeEvent{ID: 12, Schema: "shop", Table: "orders", Operation: OperationDelete, RecordID: "3"})
+
+	exp := "id: 12\nevent: change\ndata: {\"id\":12,\"schema\":\"shop\",\"table\":\"orders\",\"operation\":\"delete\",\"recordId\":\"3\"}\n\n"
+	if got := w.Body.String(); got != exp {
+		TestErrorFatal(t, "Event format", got, exp)
+	}
+
+}
@@ -0,0 +1,364 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//archiveExtensions are the archive formats a bundle can be fetched as
+var archiveExtensions = []string{".zip", ".tar.gz", ".tgz"}
+
+//bundleSource is a parsed 'ghost bundle get' argument
+type bundleSource struct {
+	//location is the clone URL, archive URL or local archive path
+	location string
+	//ref is an optional git branch or tag
+	ref       string
+	isArchive bool
+	//name is the bundle name derived from the source
+	name string
+}
+
+//parseBundleSource works out how to fetch a bundle and what to call it.
+//Sources are either archives (.zip, .tar.gz or .tgz, by URL or local path) or git
+//repositories, which can be given as a full clone URL or in the short form
+//github.com/org/bundle, optionally followed by @branch or @tag
+func parseBundleSource(source string) (bundleSource, error) {
+
+	s := bundleSource{location: source}
+
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(source, ext) {
+			s.isArchive = true
+			s.name = strings.TrimSuffix(path.Base(source), ext)
+			break
+		}
+	}
+
+	if !s.isArchive {
+
+		//A trailing @ref selects a branch or tag (but not the user in git@host:...)
+		if i := strings.LastIndex(source, "@"); i > strings.LastIndex(source, "/") && i > strings.LastIndex(source, ":") {
+			s.location, s.ref = source[:i], source[i+1:]
+		}
+
+		if !strings.Contains(s.location, "://") && !strings.HasPrefix(s.location, "git@") {
+			s.location = "https://" + s.location
+		}
+
+		s.name = strings.TrimSuffix(path.Base(strings.Replace(s.location, ":", "/", -1)), ".git")
+
+	}
+
+	//Schema names can't have hyphens, and bundle repositories often do
+	s.name = HyphensToUnderscores(strings.ToLower(s.name))
+	if !IsValidIdentifier(s.name) {
+		return s, errors.New("Could not work out a valid bundle name from '" + source + "'")
+	}
+
+	return s, nil
+
+}
+
+//FetchBundle downloads a bundle into the bundles folder and verifies its manifest.
+//If name is blank, the bundle is named after the repository or archive.
+//It returns the name of the bundle
+func FetchBundle(source, name string) (string, error) {
+
+	s, err := parseBundleSource(source)
+	if err != nil && name == "" {
+		return "", err
+	}
+	if name != "" {
+		s.name = name
+	}
+	if !IsValidIdentifier(s.name) {
+		return "", errors.New("Invalid bundle name '" + s.name + "'")
+	}
+
+	target := BundlePath(s.name)
+	if _, err := os.Stat(target); err == nil {
+		return "", errors.New("There is already a bundle called '" + s.name + "' in the bundles folder")
+	}
+
+	if err := os.MkdirAll("bundles", os.ModePerm); err != nil {
+		return "", err
+	}
+
+	if s.isArchive {
+		err = fetchArchive(s.location, target)
+	} else {
+		err = cloneRepository(s.location, s.ref, target)
+	}
+	if err != nil {
+		os.RemoveAll(target)
+		return "", err
+	}
+
+	if err := verifyFetchedBundle(s.name); err != nil {
+		os.RemoveAll(target)
+		return "", err
+	}
+
+	return s.name, nil
+
+}
+
+//verifyFetchedBundle checks that a downloaded bundle has a valid manifest
+func verifyFetchedBundle(bundleName string) error {
+
+	if _, err := os.Stat(BundlePath(bundleName, bundleManifestFile)); err != nil {
+		return errors.New("Downloaded bundle has no " + bundleManifestFile)
+	}
+
+	return ValidateBundle(bundleName)
+
+}
+
+//cloneRepository makes a shallow clone of a git repository, without its history
+func cloneRepository(location, ref, target string) error {
+
+	args := []string{"clone", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	args = append(args, location, target)
+
+	Log("GET", true, "Cloning "+location, nil)
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git clone failed: %s", err)
+	}
+
+	return os.RemoveAll(filepath.Join(target, ".git"))
+
+}
+
+//fetchArchive downloads (if necessary) and extracts an archive into the target folder
+func fetchArchive(location, target string) error {
+
+	var archive io.ReadCloser
+
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+
+		Log("GET", true, "Downloading "+location, nil)
+		resp, err := http.Get(location)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return errors.New("Download failed: " + resp.Status)
+		}
+		archive = resp.Body
+
+	} else {
+
+		f, err := os.Open(location)
+		if err != nil {
+			return err
+		}
+		archive = f
+
+	}
+	defer archive.Close()
+
+	if strings.HasSuffix(location, ".zip") {
+		//Zip files need random access, so buffer to a temporary file first
+		tmp, err := ioutil.TempFile("", "ghost-bundle")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		size, err := io.Copy(tmp, archive)
+		if err != nil {
+			return err
+		}
+		return extractZip(tmp, size, target)
+	}
+
+	return extractTarGz(archive, target)
+
+}
+
+//archiveEntry is a file from an archive, with its path inside the archive
+type archiveEntry struct {
+	name  string
+	isDir bool
+	mode  os.FileMode
+	open  func() (io.ReadCloser, error)
+}
+
+func extractZip(r io.ReaderAt, size int64, target string) error {
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	var entries []archiveEntry
+	for _, f := range zr.File {
+		entries = append(entries, archiveEntry{
+			name:  f.Name,
+			isDir: f.FileInfo().IsDir(),
+			mode:  f.Mode(),
+			open:  f.Open,
+		})
+	}
+
+	return extractEntries(entries, target)
+
+}
+
+func extractTarGz(r io.Reader, target string) error {
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	//Tar files can only be read in order, so read each file into memory as we go
+	var entries []archiveEntry
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeDir {
+			continue
+		}
+
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, archiveEntry{
+			name:  header.Name,
+			isDir: header.Typeflag == tar.TypeDir,
+			mode:  os.FileMode(header.Mode),
+			open: func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(strings.NewReader(string(contents))), nil
+			},
+		})
+	}
+
+	return extractEntries(entries, target)
+
+}
+
+//extractEntries writes archive entries into the target folder.  If everything in the
+//archive is inside one top level folder (as in archives downloaded from GitHub),
+//that folder is stripped.  Entries that would be written outside the target are rejected
+func extractEntries(entries []archiveEntry, target string) error {
+
+	prefix := commonTopLevelFolder(entries)
+
+	for _, e := range entries {
+
+		name := strings.TrimPrefix(path.Clean("/"+e.name), "/")
+		name = strings.TrimPrefix(strings.TrimPrefix(name, prefix), "/")
+		if name == "" || name == "." {
+			continue
+		}
+
+		destination := filepath.Join(target, filepath.FromSlash(name))
+		if !strings.HasPrefix(destination, filepath.Clean(target)+string(os.PathSeparator)) {
+			return errors.New("Archive contains an invalid path: " + e.name)
+		}
+
+		if e.isDir {
+			if err := os.MkdirAll(destination, os.ModePerm); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(destination), os.ModePerm); err != nil {
+			return err
+		}
+
+		if err := writeArchiveEntry(e, destination); err != nil {
+			return err
+		}
+
+	}
+
+	return nil
+
+}
+
+func writeArchiveEntry(e archiveEntry, destination string) error {
+
+	src, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, e.mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+
+}
+
+//commonTopLevelFolder returns the single folder containing every entry, or "" if there isn't one
+func commonTopLevelFolder(entries []archiveEntry) string {
+
+	prefix := ""
+	for _, e := range entries {
+		name := strings.TrimPrefix(path.Clean("/"+e.name), "/")
+		top := strings.SplitN(name, "/", 2)
+		//A file at the top level means there is no common folder
+		if len(top) == 1 && !e.isDir {
+			return ""
+		}
+		if prefix == "" {
+			prefix = top[0]
+		} else if prefix != top[0] {
+			return ""
+		}
+	}
+
+	return prefix
+
+}
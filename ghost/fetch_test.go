@@ -0,0 +1,78 @@
+package ghost
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseBundleSource(t *testing.T) {
+
+	testCases := []struct {
+		source, location, ref, name string
+		isArchive                   bool
+	}{
+		{"github.com/org/shop", "https://github.com/org/shop", "", "shop", false},
+		{"github.com/org/ghost-blog@v1.2.0", "https://github.com/org/ghost-blog", "v1.2.0", "ghost_blog", false},
+		{"git@github.com:org/shop.git", "git@github.com:org/shop.git", "", "shop", false},
+		{"https://example.com/bundles/shop.tar.gz", "https://example.com/bundles/shop.tar.gz", "", "shop", true},
+		{"./downloads/media.zip", "./downloads/media.zip", "", "media", true},
+	}
+
+	for _, c := range testCases {
+		s, err := parseBundleSource(c.source)
+		if err != nil {
+			TestErrorFatal(t, c.source, err.Error(), c.name)
+			continue
+		}
+		if s.location != c.location || s.ref != c.ref || s.name != c.name || s.isArchive != c.isArchive {
+			TestErrorFatal(t, c.source, s.location+" "+s.ref+" "+s.name, c.location+" "+c.ref+" "+c.name)
+		}
+	}
+
+}
+
+func testEntry(name, contents string) archiveEntry {
+	return archiveEntry{
+		name:  name,
+		isDir: strings.HasSuffix(name, "/"),
+		mode:  0644,
+		open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(contents)), nil
+		},
+	}
+}
+
+func TestExtractEntries(t *testing.T) {
+
+	target, _ := ioutil.TempDir("", "ghost-extract")
+	defer os.RemoveAll(target)
+
+	//GitHub style archive with everything in one top level folder
+	err := extractEntries([]archiveEntry{
+		testEntry("shop-1.0.0/", ""),
+		testEntry("shop-1.0.0/bundle.json", `{"name":"shop"}`),
+		testEntry("shop-1.0.0/install/00_install.sql", "CREATE TABLE products();"),
+	}, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(target, "install", "00_install.sql"))
+	if err != nil || string(b) != "CREATE TABLE products();" {
+		t.Error("Top level folder should be stripped from extracted files")
+	}
+
+	//Path traversal is cleaned up rather than escaping the target
+	err = extractEntries([]archiveEntry{testEntry("../../evil.sql", "DROP TABLE users;")}, target)
+	if _, statErr := os.Stat(filepath.Join(target, "..", "..", "evil.sql")); statErr == nil {
+		t.Error("Archive entries must not be written outside the target folder")
+	}
+	if err != nil {
+		t.Error(err)
+	}
+
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pressly/chi"
+)
+
+//filesRoute is where stored files are served and uploaded
+const filesRoute = "/files"
+
+//ActivateFiles serves the files in storage from /files/{name}, and lets the roles in the
+//fileRoles setting upload them with a PUT of the file's contents and remove them with a
+//DELETE.  Pass the middleware which authenticates the request and sets the 'role' on the
+//request context
+func ActivateFiles(authentication ...func(http.Handler) http.Handler) {
+
+	App.Router.Route(filesRoute, func(r chi.Router) {
+		for _, m := range authentication {
+			r.Use(m)
+		}
+		r.Get("/*", serveStoredFile)
+		r.Head("/*", serveStoredFile)
+		r.Put("/*", uploadFile)
+		r.Delete("/*", deleteFile)
+	})
+
+}
+
+//serveStoredFile sends the file named in the path
+func serveStoredFile(w http.ResponseWriter, r *http.Request) {
+
+	err := sendStoredFile(w, r, chi.URLParam(r, "*"))
+	if err == ErrFileNotFound {
+		WriteError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		Log("STORAGE", false, "Could not read file '"+chi.URLParam(r, "*")+"'", err)
+		WriteError(w, http.StatusBadGateway, "The file could not be read")
+	}
+
+}
+
+//sendStoredFile sends a file from storage.  Nothing is written if there is an error
+func sendStoredFile(w http.ResponseWriter, r *http.Request, name string) error {
+
+	f, info, err := App.Storage.Get(r.Context(), name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	if info.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	if !info.Modified.IsZero() {
+		w.Header().Set("Last-Modified", info.Modified.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method != http.MethodHead {
+		io.Copy(w, f)
+	}
+
+	return nil
+
+}
+
+//uploadFile stores the request body under the name in the path
+func uploadFile(w http.ResponseWriter, r *http.Request) {
+
+	if !requireFileRole(w, r) {
+		return
+	}
+
+	name, err := cleanStorageName(chi.URLParam(r, "*"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" || strings.HasPrefix(contentType, "application/octet-stream") {
+		contentType = storageContentType(name)
+	}
+
+	body := &countingReader{r: r.Body}
+	if err := App.Storage.Put(r.Context(), name, body, contentType); err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			WriteError(w, http.StatusRequestEntityTooLarge, "File is too large")
+			return
+		}
+		Log("STORAGE", false, "Could not store file '"+name+"'", err)
+		WriteError(w, http.StatusBadGateway, "The file could not be stored")
+		return
+	}
+
+	WriteJSON(w, http.StatusCreated, StoredFile{Name: name, Size: body.n, ContentType: contentType})
+
+}
+
+//deleteFile removes the file named in the path
+func deleteFile(w http.ResponseWriter, r *http.Request) {
+
+	if !requireFileRole(w, r) {
+		return
+	}
+
+	if err := App.Storage.Delete(r.Context(), chi.URLParam(r, "*")); err != nil {
+		Log("STORAGE", false, "Could not delete file '"+chi.URLParam(r, "*")+"'", err)
+		WriteError(w, http.StatusBadGateway, "The file could not be deleted")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+}
+
+//countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+//requireFileRole turns away requests from roles which can't change files
+func requireFileRole(w http.ResponseWriter, r *http.Request) bool {
+
+	role, _ := r.Context().Value("role").(string)
+	if !isPermittedRole(role, App.Config().FileRoles) {
+		WriteError(w, http.StatusForbidden, "This resource requires one of the roles: "+strings.Join(App.Config().FileRoles, ", "))
+		return false
+	}
+
+	return true
+
+}
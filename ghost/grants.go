@@ -0,0 +1,117 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	sqlToGrantSchemaUsage     = `GRANT USAGE ON SCHEMA %s TO %s;`
+	sqlToGrantOnTable         = `GRANT %s ON TABLE %s.%s TO %s;`
+	sqlToGrantOnAllTables     = `GRANT %s ON ALL TABLES IN SCHEMA %s TO %s;`
+	sqlToRevokeOnTable        = `REVOKE %s ON TABLE %s.%s FROM %s;`
+	sqlToRevokeOnAllTables    = `REVOKE %s ON ALL TABLES IN SCHEMA %s FROM %s;`
+	allTablesInSchemaWildcard = "*"
+)
+
+//tablePrivileges are the privileges which can be granted on a table
+var tablePrivileges = map[string]bool{
+	"SELECT":     true,
+	"INSERT":     true,
+	"UPDATE":     true,
+	"DELETE":     true,
+	"TRUNCATE":   true,
+	"REFERENCES": true,
+	"TRIGGER":    true,
+	"ALL":        true,
+}
+
+//Grant describes a set of privileges for a role on a table.
+//Use '*' as the table to mean all tables in the schema
+type Grant struct {
+	Role       string   `json:"role"`
+	Schema     string   `json:"schema"`
+	Table      string   `json:"table"`
+	Privileges []string `json:"privileges"`
+}
+
+//GrantSQL returns the SQL to grant the privileges.  Usage on the schema is
+//granted as well, since table privileges are useless without it
+func (g Grant) GrantSQL() (string, error) {
+
+	privileges, err := g.validate()
+	if err != nil {
+		return "", err
+	}
+
+	usage := fmt.Sprintf(sqlToGrantSchemaUsage, g.Schema, g.Role)
+	if g.Table == allTablesInSchemaWildcard {
+		return usage + " " + fmt.Sprintf(sqlToGrantOnAllTables, privileges, g.Schema, g.Role), nil
+	}
+
+	return usage + " " + fmt.Sprintf(sqlToGrantOnTable, privileges, g.Schema, g.Table, g.Role), nil
+
+}
+
+//RevokeSQL returns the SQL to revoke the privileges
+func (g Grant) RevokeSQL() (string, error) {
+
+	privileges, err := g.validate()
+	if err != nil {
+		return "", err
+	}
+
+	if g.Table == allTablesInSchemaWildcard {
+		return fmt.Sprintf(sqlToRevokeOnAllTables, privileges, g.Schema, g.Role), nil
+	}
+
+	return fmt.Sprintf(sqlToRevokeOnTable, privileges, g.Schema, g.Table, g.Role), nil
+
+}
+
+//validate checks every identifier and privilege, since they all end up in the SQL,
+//and returns the privileges as a comma separated list
+func (g Grant) validate() (string, error) {
+
+	if !IsValidIdentifier(g.Role) {
+		return "", errors.New("Invalid role '" + g.Role + "'")
+	}
+
+	if !IsValidIdentifier(g.Schema) {
+		return "", errors.New("Invalid schema '" + g.Schema + "'")
+	}
+
+	if g.Table != allTablesInSchemaWildcard && !IsValidIdentifier(g.Table) {
+		return "", errors.New("Invalid table '" + g.Table + "'")
+	}
+
+	if len(g.Privileges) == 0 {
+		return "", errors.New("No privileges specified")
+	}
+
+	privileges := make([]string, len(g.Privileges))
+	for k, p := range g.Privileges {
+		privileges[k] = strings.ToUpper(strings.TrimSpace(p))
+		if !tablePrivileges[privileges[k]] {
+			return "", errors.New("Invalid privilege '" + p + "'")
+		}
+	}
+
+	return strings.Join(privileges, ", "), nil
+
+}
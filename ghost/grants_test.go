@@ -0,0 +1,54 @@
+package ghost
+
+import "testing"
+
+func TestGrantSQL(t *testing.T) {
+
+	testCases := []struct {
+		description    string
+		grant          Grant
+		expectedGrant  string
+		expectedRevoke string
+		expectError    bool
+	}{
+		{
+			"Single table",
+			Grant{Role: "anon", Schema: "shop", Table: "products", Privileges: []string{"select"}},
+			`GRANT USAGE ON SCHEMA shop TO anon; GRANT SELECT ON TABLE shop.products TO anon;`,
+			`REVOKE SELECT ON TABLE shop.products FROM anon;`,
+			false,
+		},
+		{
+			"All tables in schema",
+			Grant{Role: "web", Schema: "shop", Table: "*", Privileges: []string{"SELECT", "insert"}},
+			`GRANT USAGE ON SCHEMA shop TO web; GRANT SELECT, INSERT ON ALL TABLES IN SCHEMA shop TO web;`,
+			`REVOKE SELECT, INSERT ON ALL TABLES IN SCHEMA shop FROM web;`,
+			false,
+		},
+		{"Invalid privilege", Grant{Role: "anon", Schema: "shop", Table: "products", Privileges: []string{"DROP"}}, "", "", true},
+		{"No privileges", Grant{Role: "anon", Schema: "shop", Table: "products"}, "", "", true},
+		{"Injection in role", Grant{Role: "anon; DROP TABLE users", Schema: "shop", Table: "products", Privileges: []string{"SELECT"}}, "", "", true},
+		{"Injection in table", Grant{Role: "anon", Schema: "shop", Table: "products TO anon; --", Privileges: []string{"SELECT"}}, "", "", true},
+	}
+
+	for _, c := range testCases {
+
+		grantSQL, err := c.grant.GrantSQL()
+		if c.expectError {
+			if err == nil {
+				TestErrorFatal(t, c.description, grantSQL, "an error")
+			}
+			continue
+		}
+
+		if grantSQL != c.expectedGrant {
+			TestErrorFatal(t, c.description, grantSQL, c.expectedGrant)
+		}
+
+		revokeSQL, _ := c.grant.RevokeSQL()
+		if revokeSQL != c.expectedRevoke {
+			TestErrorFatal(t, c.description, revokeSQL, c.expectedRevoke)
+		}
+	}
+
+}
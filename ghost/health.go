@@ -0,0 +1,74 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+//readinessTimeout limits how long the readiness check waits for the database
+const readinessTimeout = 2 * time.Second
+
+//bundlesLoaded is set to 1 once bundle plugins and hooks have been loaded at startup
+var bundlesLoaded int32
+
+//healthReport is the response from the readiness endpoint
+type healthReport struct {
+	Status  string            `json:"status"`
+	Version string            `json:"version"`
+	Checks  map[string]string `json:"checks"`
+}
+
+//healthz is the liveness check: if the process can respond at all, it is alive
+func healthz(w http.ResponseWriter, r *http.Request) {
+
+	WriteJSON(w, http.StatusOK, healthReport{Status: "ok", Version: VersionString(), Checks: map[string]string{}})
+
+}
+
+//readyz is the readiness check: the server is only ready to take traffic once its
+//bundles have been loaded and while the database can be reached
+func readyz(w http.ResponseWriter, r *http.Request) {
+
+	report := healthReport{Status: "ready", Version: VersionString(), Checks: map[string]string{"database": "ok", "bundles": "ok"}}
+
+	if atomic.LoadInt32(&bundlesLoaded) == 0 {
+		report.Status = "not ready"
+		report.Checks["bundles"] = "loading"
+	}
+
+	if App.DB == nil {
+		report.Status = "not ready"
+		report.Checks["database"] = "not connected"
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+		if err := App.DB.PingContext(ctx); err != nil {
+			report.Status = "not ready"
+			report.Checks["database"] = err.Error()
+		}
+	}
+
+	if report.Status != "ready" {
+		WriteJSON(w, http.StatusServiceUnavailable, report)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, report)
+
+}
@@ -0,0 +1,32 @@
+package ghost
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+
+	w := httptest.NewRecorder()
+	healthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		TestErrorFatal(t, "Liveness", http.StatusText(w.Code), http.StatusText(http.StatusOK))
+	}
+
+	//Before startup has finished, with no database connection
+	atomic.StoreInt32(&bundlesLoaded, 0)
+	w = httptest.NewRecorder()
+	readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		TestErrorFatal(t, "Readiness before startup", http.StatusText(w.Code), http.StatusText(http.StatusServiceUnavailable))
+	}
+	for _, expected := range []string{`"bundles":"loading"`, `"database":"not connected"`, `"version":"dev (commit unknown`} {
+		if !strings.Contains(w.Body.String(), expected) {
+			TestErrorFatal(t, "Readiness report", w.Body.String(), expected)
+		}
+	}
+
+}
@@ -16,11 +16,13 @@ package ghost
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -44,6 +46,31 @@ type ResponseError struct {
 	Record       string       `json:"record"`
 }
 
+//WriteJSON marshals v and writes it as the JSON response body with the given status code
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(status)
+	w.Write(b)
+
+}
+
+//WriteError writes a ResponseError with the given status code and message
+func WriteError(w http.ResponseWriter, status int, message string) {
+
+	b, _ := json.Marshal(ResponseError{HTTPCode: status, ErrorMessage: message})
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(status)
+	w.Write(b)
+
+}
+
 //AllOK Takes any number of bools and returns true if all are true, or false if ANY are false
 func AllOK(oks ...bool) bool {
 	for _, ok := range oks {
@@ -114,6 +141,15 @@ func RandomString(strlen int) string {
 
 // }
 
+//validIdentifier matches unquoted Postgres identifiers as used for bundle, schema and table names
+var validIdentifier = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+//IsValidIdentifier reports whether a string can be safely used as an unquoted
+//schema, table or role name in generated SQL
+func IsValidIdentifier(s string) bool {
+	return len(s) < 64 && validIdentifier.MatchString(s)
+}
+
 //HyphensToUnderscores replaces all hyphens in the string with underscores.
 //This is so you can use pretty URLs with hyphens (as recommended by Google)
 //whilst still using underscores in App.DB table names - which means they don't have to be quoted all the time
@@ -164,6 +200,27 @@ func AskForConfirmation(s string) bool {
 	}
 }
 
+//stdin is shared by the prompts, so that answers piped in together aren't lost in a buffer
+var stdin = bufio.NewReader(os.Stdin)
+
+//AskForString prompts for a value on the command line, returning the default if none is entered
+func AskForString(s, defaultValue string) string {
+
+	fmt.Printf("%s [%s]: ", s, defaultValue)
+
+	response, err := stdin.ReadString('\n')
+	if err != nil && response == "" {
+		return defaultValue
+	}
+
+	if response = strings.TrimSpace(response); response == "" {
+		return defaultValue
+	}
+
+	return response
+
+}
+
 //commaSeparatedStringify takes a list of any type and returns a
 //comma separated array as a string e.g., [1, 2, 3, 4]
 func commaSeparatedStringify(i ...interface{}) string {
@@ -0,0 +1,240 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"plugin"
+	"sync"
+
+	"github.com/pressly/chi"
+	"github.com/pressly/chi/middleware"
+	"github.com/spf13/afero"
+)
+
+//Record operations which hooks can run before and after
+const (
+	OperationInsert = "insert"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+//bundlePluginSymbol is the name of the exported Hooks variable in a bundle plugin
+const bundlePluginSymbol = "Hooks"
+
+//RecordEvent describes a change to a record made through the Store
+type RecordEvent struct {
+	Schema, Table string
+	//ID identifies the record for updates and deletes
+	ID string
+	//Record holds the fields being written.  Before hooks can change it
+	Record map[string]interface{}
+	//Role and UserID are those the query runs as
+	Role, UserID string
+	//RequestID identifies the request making the change in the audit trail
+	RequestID string
+}
+
+//NewRecordEvent starts an event for a change to a record requested by an API call,
+//taking the role, user and request ID from the request
+func NewRecordEvent(r *http.Request, schema, table string) *RecordEvent {
+
+	e := &RecordEvent{
+		Schema:    schema,
+		Table:     table,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+	e.Role, _ = r.Context().Value("role").(string)
+	if userID := r.Context().Value("userID"); userID != nil {
+		e.UserID = fmt.Sprint(userID)
+	}
+
+	return e
+
+}
+
+//Hooks lets a bundle run its own logic during the request lifecycle.  Record hooks
+//are called for changes made through App.Store to any table, so check the event's
+//Schema and Table.  Changes made other ways, such as by the API's bundle SQL endpoints,
+//only call them if the handler uses RunBeforeHooks and RunAfterHooks.  A before hook returning an error stops the change and the error
+//is returned to the caller.  Any hook can be left nil.
+//
+//Register hooks from a package with RegisterHooks, or build the bundle as a Go plugin
+//(go build -buildmode=plugin -o bundles/mybundle/mybundle.so) exporting
+//	var Hooks = ghost.Hooks{...}
+//which is loaded when the server starts.  Hooks only run while their bundle is installed and enabled
+type Hooks struct {
+	BeforeInsert func(e *RecordEvent) error
+	AfterInsert  func(e *RecordEvent)
+	BeforeUpdate func(e *RecordEvent) error
+	AfterUpdate  func(e *RecordEvent)
+	BeforeDelete func(e *RecordEvent) error
+	AfterDelete  func(e *RecordEvent)
+	//OnServe is called once the core routes are set up, before the server starts
+	OnServe func(r chi.Router) error
+}
+
+type bundleHooks struct {
+	bundle string
+	hooks  Hooks
+}
+
+var (
+	registeredHooks []bundleHooks
+	hooksMutex      sync.RWMutex
+)
+
+//RegisterHooks registers the hooks for a bundle, replacing any registered before
+func RegisterHooks(bundleName string, h Hooks) {
+
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+
+	for k, bh := range registeredHooks {
+		if bh.bundle == bundleName {
+			registeredHooks[k].hooks = h
+			return
+		}
+	}
+
+	registeredHooks = append(registeredHooks, bundleHooks{bundleName, h})
+
+}
+
+//activeHooks returns the hooks of enabled bundles, in the order they were registered
+func activeHooks() []bundleHooks {
+
+	hooksMutex.RLock()
+	defer hooksMutex.RUnlock()
+
+	var active []bundleHooks
+	for _, bh := range registeredHooks {
+		if IsBundleEnabled(bh.bundle) {
+			active = append(active, bh)
+		}
+	}
+
+	return active
+
+}
+
+//RunBeforeHooks runs the before hooks for an operation, stopping at the first error.
+//The Store runs hooks itself - call this when changing records some other way
+func RunBeforeHooks(operation string, e *RecordEvent) error {
+
+	for _, bh := range activeHooks() {
+
+		var hook func(*RecordEvent) error
+		switch operation {
+		case OperationInsert:
+			hook = bh.hooks.BeforeInsert
+		case OperationUpdate:
+			hook = bh.hooks.BeforeUpdate
+		case OperationDelete:
+			hook = bh.hooks.BeforeDelete
+		}
+
+		if hook != nil {
+			if err := hook(e); err != nil {
+				return err
+			}
+		}
+
+	}
+
+	return nil
+
+}
+
+//RunAfterHooks runs the after hooks for an operation
+func RunAfterHooks(operation string, e *RecordEvent) {
+
+	for _, bh := range activeHooks() {
+
+		var hook func(*RecordEvent)
+		switch operation {
+		case OperationInsert:
+			hook = bh.hooks.AfterInsert
+		case OperationUpdate:
+			hook = bh.hooks.AfterUpdate
+		case OperationDelete:
+			hook = bh.hooks.AfterDelete
+		}
+
+		if hook != nil {
+			hook(e)
+		}
+
+	}
+
+}
+
+//runServeHooks calls the OnServe hooks of installed bundles.  Disabled bundles get
+//to add their routes too, but they only respond once the bundle is enabled
+func runServeHooks() error {
+
+	hooksMutex.RLock()
+	hooks := registeredHooks
+	hooksMutex.RUnlock()
+
+	for _, bh := range hooks {
+		if bh.hooks.OnServe == nil || !IsBundleInstalled(bh.bundle) {
+			continue
+		}
+		if err := bh.hooks.OnServe(App.Router.With(RequireBundleEnabled(bh.bundle))); err != nil {
+			return fmt.Errorf("OnServe hook for bundle '%s' failed: %s", bh.bundle, err)
+		}
+	}
+
+	return nil
+
+}
+
+//loadBundlePlugins opens the plugin of each installed bundle that has one
+//(bundles/mybundle/mybundle.so) and registers the hooks it exports
+func loadBundlePlugins() error {
+
+	for _, bundleName := range InstalledBundles() {
+
+		pluginFile := BundlePath(bundleName, bundleName+".so")
+		if exists, _ := afero.Exists(App.FileSystem, pluginFile); !exists {
+			continue
+		}
+
+		p, err := plugin.Open(pluginFile)
+		if err != nil {
+			return fmt.Errorf("Could not load plugin for bundle '%s': %s", bundleName, err)
+		}
+
+		symbol, err := p.Lookup(bundlePluginSymbol)
+		if err != nil {
+			return fmt.Errorf("Plugin for bundle '%s' does not export %s", bundleName, bundlePluginSymbol)
+		}
+
+		hooks, ok := symbol.(*Hooks)
+		if !ok {
+			return errors.New("Plugin for bundle '" + bundleName + "' exports " + bundlePluginSymbol + " with the wrong type, it must be a ghost.Hooks")
+		}
+
+		RegisterHooks(bundleName, *hooks)
+		Log("SERVE", true, "Loaded plugin for bundle '"+bundleName+"'", nil)
+
+	}
+
+	return nil
+
+}
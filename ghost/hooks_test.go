@@ -0,0 +1,93 @@
+package ghost
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRecordHooks(t *testing.T) {
+
+	restore := changeConfig(func(c *config) { c.BundlesInstalled = Bundles{"shop", "audit"} })
+	defer func() {
+		restore()
+		registeredHooks = nil
+	}()
+
+	var calls []string
+
+	RegisterHooks("shop", Hooks{
+		BeforeInsert: func(e *RecordEvent) error {
+			calls = append(calls, "shop:before")
+			if e.Record["price"] == nil {
+				return errors.New("price is required")
+			}
+			e.Record["currency"] = "GBP"
+			return nil
+		},
+	})
+	RegisterHooks("audit", Hooks{
+		BeforeInsert: func(e *RecordEvent) error {
+			calls = append(calls, "audit:before:"+e.Record["currency"].(string))
+			return nil
+		},
+		AfterInsert: func(e *RecordEvent) {
+			calls = append(calls, "audit:after")
+		},
+	})
+	//Hooks of bundles which aren't installed never run
+	RegisterHooks("blog", Hooks{
+		BeforeInsert: func(e *RecordEvent) error {
+			calls = append(calls, "blog:before")
+			return nil
+		},
+	})
+
+	e := &RecordEvent{Schema: "shop", Table: "products", Record: map[string]interface{}{"price": 10}}
+	if err := RunBeforeHooks(OperationInsert, e); err != nil {
+		t.Fatal(err)
+	}
+	RunAfterHooks(OperationInsert, e)
+
+	if got, expected := strings.Join(calls, ","), "shop:before,audit:before:GBP,audit:after"; got != expected {
+		TestErrorFatal(t, "Hooks run in order with changes passed along", got, expected)
+	}
+
+	//A failing before hook stops the rest
+	calls = nil
+	err := RunBeforeHooks(OperationInsert, &RecordEvent{Record: map[string]interface{}{}})
+	if err == nil || strings.Join(calls, ",") != "shop:before" {
+		TestErrorFatal(t, "Failing hook stops the chain", strings.Join(calls, ","), "shop:before")
+	}
+
+	//Hooks for other operations are not called
+	calls = nil
+	if err := RunBeforeHooks(OperationDelete, e); err != nil || len(calls) != 0 {
+		TestErrorFatal(t, "No delete hooks", strings.Join(calls, ","), "")
+	}
+
+}
+
+func TestRecordColumns(t *testing.T) {
+
+	cols, placeholders, args, err := recordColumns(map[string]interface{}{
+		"title": "Hello",
+		"tags":  []interface{}{"a", "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(cols, ",") + " " + strings.Join(placeholders, ","); got != "tags,title $1,$2" {
+		TestErrorFatal(t, "Columns are sorted with placeholders", got, "tags,title $1,$2")
+	}
+
+	if args[0] != `["a","b"]` {
+		TestErrorFatal(t, "Arrays are passed as JSON", args[0].(string), `["a","b"]`)
+	}
+
+	if _, _, _, err := recordColumns(map[string]interface{}{"title; DROP TABLE x": 1}); err == nil {
+		t.Error("Invalid column names should be rejected")
+	}
+
+}
@@ -0,0 +1,425 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+//The job queue lives in the database, so jobs survive restarts and can be worked on
+//by several servers.  Workers claim jobs with SKIP LOCKED, so no job is run twice at once
+const (
+	sqlToCreateJobQueue = `CREATE TABLE IF NOT EXISTS public.ghost_jobs (id bigserial PRIMARY KEY, type text NOT NULL, payload jsonb NOT NULL DEFAULT '{}', status text NOT NULL DEFAULT 'pending', attempts int NOT NULL DEFAULT 0, max_attempts int NOT NULL DEFAULT 5, run_at timestamptz NOT NULL DEFAULT now(), last_error text, created_at timestamptz NOT NULL DEFAULT now(), updated_at timestamptz NOT NULL DEFAULT now());
+	CREATE INDEX IF NOT EXISTS ghost_jobs_pending ON public.ghost_jobs (run_at) WHERE status IN ('pending', 'running');
+	GRANT SELECT, INSERT, UPDATE, DELETE ON public.ghost_jobs TO server;
+	GRANT USAGE ON SEQUENCE public.ghost_jobs_id_seq TO server;`
+
+	sqlToEnqueueJob = `INSERT INTO public.ghost_jobs (type, payload, max_attempts, run_at) VALUES ($1, $2, $3, $4) RETURNING id;`
+
+	//Running jobs which haven't finished within the job timeout belonged to a server
+	//which stopped part way through, so they are claimed again
+	sqlToClaimJob = `UPDATE public.ghost_jobs SET status = 'running', attempts = attempts + 1, updated_at = now()
+	WHERE id = (SELECT id FROM public.ghost_jobs
+		WHERE type = ANY($1) AND ((status = 'pending' AND run_at <= now()) OR (status = 'running' AND updated_at < now() - $2 * interval '1 second'))
+		ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED)
+	RETURNING id, type, payload, attempts, max_attempts;`
+
+	sqlToCompleteJob = `UPDATE public.ghost_jobs SET status = 'done', last_error = NULL, updated_at = now() WHERE id = $1;`
+	sqlToRetryJob    = `UPDATE public.ghost_jobs SET status = 'pending', last_error = $2, run_at = now() + $3 * interval '1 second', updated_at = now() WHERE id = $1;`
+	sqlToFailJob     = `UPDATE public.ghost_jobs SET status = 'failed', last_error = $2, updated_at = now() WHERE id = $1;`
+
+	sqlToCountJobs     = `SELECT type, status, count(*) FROM public.ghost_jobs GROUP BY type, status;`
+	sqlToListJobs      = `SELECT id, type, payload, status, attempts, max_attempts, run_at, COALESCE(last_error, ''), created_at, updated_at FROM public.ghost_jobs WHERE ($1 = '' OR status = $1) AND ($2 = '' OR type = $2) ORDER BY id DESC LIMIT $3;`
+	sqlToRequeueJob    = `UPDATE public.ghost_jobs SET status = 'pending', attempts = 0, run_at = now(), updated_at = now() WHERE id = $1 AND status <> 'running';`
+	sqlToDeleteJob     = `DELETE FROM public.ghost_jobs WHERE id = $1 AND status <> 'running';`
+	sqlToPurgeDoneJobs = `DELETE FROM public.ghost_jobs WHERE status = 'done' AND updated_at < now() - interval '7 days';`
+)
+
+//Job statuses
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+//defaultMaxAttempts is the number of times a job is tried before it is marked as failed
+const defaultMaxAttempts = 5
+
+//ErrJobNotQueued is returned when retrying or deleting a job which doesn't exist or is running
+var ErrJobNotQueued = errors.New("No such job, or the job is running")
+
+//JobHandler does the work for a type of job.  The payload is the JSON the job was
+//enqueued with.  Returning an error means the job is tried again later, until it has
+//been tried MaxAttempts times.  The context is cancelled if the job takes longer than
+//jobTimeout seconds, or the server stops
+type JobHandler func(ctx context.Context, payload json.RawMessage) error
+
+//JobOptions change when and how often a job is tried
+type JobOptions struct {
+	//RunAt delays the job until then, rather than running it straight away
+	RunAt time.Time
+	//MaxAttempts is the number of tries before the job is marked as failed, 5 by default
+	MaxAttempts int
+}
+
+//QueuedJob is a job in the queue
+type QueuedJob struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	RunAt       time.Time       `json:"runAt"`
+	LastError   string          `json:"lastError,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+var (
+	jobHandlers      = map[string]JobHandler{}
+	jobHandlersMutex sync.RWMutex
+
+	//jobWakeUp tells idle workers that a job has been enqueued, rather than having
+	//them wait for the next poll
+	jobWakeUp = make(chan struct{}, 1)
+)
+
+func init() {
+
+	//Done jobs are kept for a week, to see what has been happening
+	RegisterTask("purgejobs", func(ctx context.Context) error {
+		_, err := App.DB.ExecContext(ctx, sqlToPurgeDoneJobs)
+		return err
+	})
+
+}
+
+//RegisterJobHandler sets the handler for a type of job.  Workers only claim jobs of the
+//types which have handlers, so several applications can share the queue
+func RegisterJobHandler(jobType string, handler JobHandler) {
+
+	jobHandlersMutex.Lock()
+	defer jobHandlersMutex.Unlock()
+
+	jobHandlers[jobType] = handler
+
+}
+
+//handledJobTypes lists the types of job this server has handlers for
+func handledJobTypes() []string {
+
+	jobHandlersMutex.RLock()
+	defer jobHandlersMutex.RUnlock()
+
+	jobTypes := make([]string, 0, len(jobHandlers))
+	for jobType := range jobHandlers {
+		jobTypes = append(jobTypes, jobType)
+	}
+	sort.Strings(jobTypes)
+
+	return jobTypes
+
+}
+
+func jobHandler(jobType string) (JobHandler, bool) {
+
+	jobHandlersMutex.RLock()
+	defer jobHandlersMutex.RUnlock()
+
+	handler, ok := jobHandlers[jobType]
+	return handler, ok
+
+}
+
+//EnqueueJob adds a job to the queue, to be run as soon as a worker is free.  The
+//payload is stored as JSON and given to the job type's handler
+func EnqueueJob(jobType string, payload interface{}) (int64, error) {
+	return EnqueueJobWithOptions(jobType, payload, JobOptions{})
+}
+
+//EnqueueJobWithOptions adds a job to the queue, to be run at a later time or tried a
+//different number of times
+func EnqueueJobWithOptions(jobType string, payload interface{}, opts JobOptions) (int64, error) {
+
+	if jobType == "" {
+		return 0, errors.New("Jobs must have a type")
+	}
+	if App.DB == nil {
+		return 0, errors.New("The job queue is only available while the server is running")
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.RunAt.IsZero() {
+		opts.RunAt = time.Now()
+	}
+
+	var id int64
+	if err := App.DB.QueryRow(sqlToEnqueueJob, jobType, string(data), opts.MaxAttempts, opts.RunAt).Scan(&id); err != nil {
+		return 0, err
+	}
+
+	//Don't wait if no worker is listening
+	select {
+	case jobWakeUp <- struct{}{}:
+	default:
+	}
+
+	return id, nil
+
+}
+
+//setupJobQueue creates the job queue table, if need be, as the super user
+func setupJobQueue(db *sql.DB) error {
+	_, err := db.Exec(sqlToCreateJobQueue)
+	return err
+}
+
+//startJobWorkers starts the configured number of workers, which run until the context is done
+func startJobWorkers(ctx context.Context) {
+
+	if App.Config().JobWorkers <= 0 {
+		return
+	}
+
+	jobTypes := handledJobTypes()
+	if len(jobTypes) == 0 {
+		return
+	}
+
+	Log("JOBS", true, fmt.Sprintf("%d job workers started for %v", App.Config().JobWorkers, jobTypes), nil)
+
+	for i := 0; i < App.Config().JobWorkers; i++ {
+		go jobWorker(ctx, App.DB, jobTypes)
+	}
+
+}
+
+//jobWorker claims and runs jobs until there are none left, then waits for one to be
+//enqueued or for the next poll
+func jobWorker(ctx context.Context, db *sql.DB, jobTypes []string) {
+
+	poll := time.Duration(App.Config().JobPollInterval) * time.Second
+	if poll <= 0 {
+		poll = time.Second
+	}
+
+	for {
+
+		job, err := claimJob(ctx, db, jobTypes)
+		if err != nil && ctx.Err() == nil {
+			Log("JOBS", false, "Could not claim a job", err)
+		}
+
+		if job != nil {
+			finishJob(db, job, runJob(ctx, job))
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-jobWakeUp:
+		case <-time.After(poll):
+		}
+
+	}
+
+}
+
+//claimJob takes the next job due which no other worker has, or returns nil if there isn't one
+func claimJob(ctx context.Context, db *sql.DB, jobTypes []string) (*QueuedJob, error) {
+
+	var job QueuedJob
+	var payload []byte
+	err := db.QueryRowContext(ctx, sqlToClaimJob, pq.Array(jobTypes), jobTimeout().Seconds()).Scan(&job.ID, &job.Type, &payload, &job.Attempts, &job.MaxAttempts)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	job.Payload = payload
+	return &job, nil
+
+}
+
+//runJob runs a job's handler, with the job timeout, recovering from a panic
+func runJob(ctx context.Context, job *QueuedJob) (err error) {
+
+	handler, ok := jobHandler(job.Type)
+	if !ok {
+		return errors.New("No handler for jobs of type '" + job.Type + "'")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Job panicked: %v", r)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, jobTimeout())
+	defer cancel()
+
+	return handler(ctx, job.Payload)
+
+}
+
+//finishJob records the outcome of a job: done, to be tried again later or failed
+func finishJob(db *sql.DB, job *QueuedJob, jobErr error) {
+
+	var err error
+	description := "Job " + strconv.FormatInt(job.ID, 10) + " (" + job.Type + ")"
+
+	switch {
+	case jobErr == nil:
+		_, err = db.Exec(sqlToCompleteJob, job.ID)
+	case job.Attempts < job.MaxAttempts:
+		delay := jobRetryDelay(job.Attempts)
+		Log("JOBS", false, fmt.Sprintf("%s failed on attempt %d of %d, trying again in %s", description, job.Attempts, job.MaxAttempts, delay), jobErr)
+		_, err = db.Exec(sqlToRetryJob, job.ID, jobErr.Error(), delay.Seconds())
+	default:
+		Log("JOBS", false, fmt.Sprintf("%s failed on its last attempt", description), jobErr)
+		_, err = db.Exec(sqlToFailJob, job.ID, jobErr.Error())
+	}
+
+	if err != nil {
+		Log("JOBS", false, "Could not record the outcome of "+description, err)
+	}
+
+}
+
+//jobRetryDelay backs off between attempts: 30 seconds, 2 minutes, 4.5 minutes and so
+//on, up to an hour
+func jobRetryDelay(attempts int) time.Duration {
+
+	delay := time.Duration(attempts*attempts) * 30 * time.Second
+	if delay > time.Hour {
+		return time.Hour
+	}
+
+	return delay
+
+}
+
+//jobTimeout is how long a job can run for
+func jobTimeout() time.Duration {
+
+	if App.Config().JobTimeout <= 0 {
+		return 10 * time.Minute
+	}
+
+	return time.Duration(App.Config().JobTimeout) * time.Second
+
+}
+
+//JobCounts counts the jobs in the queue of each type, by status
+func JobCounts() (map[string]map[string]int, error) {
+
+	rows, err := App.DB.Query(sqlToCountJobs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]map[string]int{}
+	for rows.Next() {
+		var jobType, status string
+		var count int
+		if err := rows.Scan(&jobType, &status, &count); err != nil {
+			return nil, err
+		}
+		if counts[jobType] == nil {
+			counts[jobType] = map[string]int{}
+		}
+		counts[jobType][status] = count
+	}
+
+	return counts, rows.Err()
+
+}
+
+//ListJobs returns the latest jobs, optionally only those with a status or of a type
+func ListJobs(status, jobType string, limit int) ([]QueuedJob, error) {
+
+	rows, err := App.DB.Query(sqlToListJobs, status, jobType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []QueuedJob{}
+	for rows.Next() {
+		var job QueuedJob
+		var payload []byte
+		if err := rows.Scan(&job.ID, &job.Type, &payload, &job.Status, &job.Attempts, &job.MaxAttempts, &job.RunAt, &job.LastError, &job.CreatedAt, &job.UpdatedAt); err != nil {
+			return nil, err
+		}
+		job.Payload = payload
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+
+}
+
+//RetryJob puts a job, usually a failed one, back in the queue with its attempts reset
+func RetryJob(id int64) error {
+	return changeJob(sqlToRequeueJob, id)
+}
+
+//DeleteJob takes a job out of the queue
+func DeleteJob(id int64) error {
+	return changeJob(sqlToDeleteJob, id)
+}
+
+func changeJob(query string, id int64) error {
+
+	result, err := App.DB.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrJobNotQueued
+	}
+
+	select {
+	case jobWakeUp <- struct{}{}:
+	default:
+	}
+
+	return nil
+
+}
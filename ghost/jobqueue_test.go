@@ -0,0 +1,69 @@
+package ghost
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestJobRetryDelay(t *testing.T) {
+
+	testCases := []struct {
+		attempts int
+		exp      time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, 2 * time.Minute},
+		{3, 4*time.Minute + 30*time.Second},
+		{12, time.Hour},
+	}
+
+	for _, c := range testCases {
+		if got := jobRetryDelay(c.attempts); got != c.exp {
+			TestErrorFatal(t, "Attempt "+strconv.Itoa(c.attempts), got.String(), c.exp.String())
+		}
+	}
+
+}
+
+func TestRunJob(t *testing.T) {
+
+	RegisterJobHandler("test_echo", func(ctx context.Context, payload json.RawMessage) error {
+		var p struct{ Fail, Panic bool }
+		json.Unmarshal(payload, &p)
+		if p.Panic {
+			panic("oh no")
+		}
+		if p.Fail {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	testCases := []struct {
+		desc, jobType, payload, exp string
+	}{
+		{"Success", "test_echo", `{}`, ""},
+		{"Failure", "test_echo", `{"fail": true}`, "failed"},
+		{"Panic", "test_echo", `{"panic": true}`, "Job panicked: oh no"},
+		{"No handler", "test_other", `{}`, "No handler for jobs of type 'test_other'"},
+	}
+
+	for _, c := range testCases {
+		var got string
+		if err := runJob(context.Background(), &QueuedJob{Type: c.jobType, Payload: json.RawMessage(c.payload)}); err != nil {
+			got = err.Error()
+		}
+		if got != c.exp {
+			TestErrorFatal(t, c.desc, got, c.exp)
+		}
+	}
+
+	if types := handledJobTypes(); len(types) == 0 || types[0] != "test_echo" {
+		TestErrorFatal(t, "Handled job types", "missing", "test_echo")
+	}
+
+}
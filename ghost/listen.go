@@ -0,0 +1,76 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+//unixSocketPrefix marks a bind address as a unix socket path, e.g. unix:/run/ghost.sock
+const unixSocketPrefix = "unix:"
+
+//listenAddress works out the network and address to listen on from the bind address
+//and port.  A blank bind address means all interfaces
+func listenAddress(bindAddress, port string) (network, address string) {
+
+	if strings.HasPrefix(bindAddress, unixSocketPrefix) {
+		return "unix", strings.TrimPrefix(bindAddress, unixSocketPrefix)
+	}
+
+	return "tcp", net.JoinHostPort(bindAddress, port)
+
+}
+
+//listen opens the listener for a port according to the bindAddress setting.
+//For unix sockets, any stale socket file from a previous run is removed first and
+//the socket is made group writable, so that a reverse proxy in the group can connect
+func listen(port string) (net.Listener, error) {
+
+	network, address := listenAddress(App.Config().BindAddress, port)
+
+	if network == "unix" {
+		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "unix" {
+		if err := os.Chmod(address, 0660); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+
+	return l, nil
+
+}
+
+//describeListener describes where the server is listening, for the logs
+func describeListener(l net.Listener) string {
+
+	if l.Addr().Network() == "unix" {
+		return "socket " + l.Addr().String()
+	}
+
+	return l.Addr().String()
+
+}
@@ -0,0 +1,23 @@
+package ghost
+
+import "testing"
+
+func TestListenAddress(t *testing.T) {
+
+	testCases := []struct {
+		bindAddress, network, address string
+	}{
+		{"", "tcp", ":3000"},
+		{"127.0.0.1", "tcp", "127.0.0.1:3000"},
+		{"::1", "tcp", "[::1]:3000"},
+		{"unix:/run/ghost/api.sock", "unix", "/run/ghost/api.sock"},
+	}
+
+	for _, c := range testCases {
+		network, address := listenAddress(c.bindAddress, "3000")
+		if network != c.network || address != c.address {
+			TestErrorFatal(t, c.bindAddress, network+" "+address, c.network+" "+c.address)
+		}
+	}
+
+}
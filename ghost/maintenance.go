@@ -0,0 +1,119 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/spf13/afero"
+)
+
+//maintenanceMode is 1 while the server is in maintenance mode.  It starts out as the
+//maintenance setting in the config and can be switched at runtime with SetMaintenanceMode
+var maintenanceMode int32
+
+//maintenanceExemptPrefixes are the routes that keep working in maintenance mode, so that
+//admins can log in and switch it off again, and so that health checks keep answering
+var maintenanceExemptPrefixes = []string{"/admin/", "/auth/", "/healthz", "/readyz"}
+
+//InMaintenance reports whether the server is in maintenance mode
+func InMaintenance() bool {
+
+	return atomic.LoadInt32(&maintenanceMode) == 1
+
+}
+
+//SetMaintenanceMode switches maintenance mode on or off until the next restart,
+//or until the maintenance setting in the config is changed
+func SetMaintenanceMode(on bool) {
+
+	var state int32
+	if on {
+		state = 1
+	}
+
+	if atomic.SwapInt32(&maintenanceMode, state) != state {
+		if on {
+			Log("MAINTENANCE", true, "Maintenance mode on", nil)
+		} else {
+			Log("MAINTENANCE", true, "Maintenance mode off", nil)
+		}
+	}
+
+}
+
+//maintenance turns requests away while the server is in maintenance mode.  It runs after
+//any registered API middleware, so requests whose role has already been found to be admin
+//(e.g. by authentication middleware added with RegisterAPIMiddleware) are let through
+func maintenance(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		role, _ := r.Context().Value("role").(string)
+		if !InMaintenance() || role == "admin" || hasRoutePrefix(r.URL.Path, maintenanceExemptPrefixes) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		retryAfter := App.Config().MaintenanceRetryAfter
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		}
+
+		if wantsHTML(r) {
+			writeMaintenancePage(w, App.Config().MaintenanceTemplate, retryAfter)
+			return
+		}
+
+		WriteError(w, http.StatusServiceUnavailable, "The server is down for maintenance")
+
+	})
+}
+
+//wantsHTML reports whether a request is from a browser rather than an API client
+func wantsHTML(r *http.Request) bool {
+
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+
+}
+
+//writeMaintenancePage renders the maintenance template, if there is one,
+//with the number of seconds after which to try again
+func writeMaintenancePage(w http.ResponseWriter, templateFile string, retryAfter int) {
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	b, err := afero.ReadFile(App.FileSystem, templateFile)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("<h1>Down for maintenance</h1><p>Please try again shortly.</p>"))
+		return
+	}
+
+	t, err := template.New("maintenance").Parse(string(b))
+	if err != nil {
+		Log("MAINTENANCE", false, "Could not parse "+templateFile, err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	t.Execute(w, struct{ RetryAfter int }{retryAfter})
+
+}
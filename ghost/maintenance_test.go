@@ -0,0 +1,64 @@
+package ghost
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestMaintenance(t *testing.T) {
+
+	App.FileSystem = afero.NewMemMapFs()
+	afero.WriteFile(App.FileSystem, "templates/maintenance.html", []byte("Back in {{.RetryAfter}} seconds"), 0644)
+	defer changeConfig(func(c *config) {
+		c.MaintenanceTemplate = "templates/maintenance.html"
+		c.MaintenanceRetryAfter = 120
+	})()
+	defer SetMaintenanceMode(false)
+
+	handler := maintenance(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	testCases := []struct {
+		description string
+		on          bool
+		path, role  string
+		html        bool
+		status      int
+		body        string
+	}{
+		{"Maintenance off", false, "/shop/products", "", false, http.StatusOK, ""},
+		{"API request", true, "/shop/products", "", false, http.StatusServiceUnavailable, `{"httpCode":503,"dbCode":"","message":"The server is down for maintenance","schema":"","table":"","record":""}`},
+		{"Browser request", true, "/shop", "", true, http.StatusServiceUnavailable, "Back in 120 seconds"},
+		{"Admin role", true, "/shop/products", "admin", false, http.StatusOK, ""},
+		{"Admin routes", true, "/admin/maintenance", "", false, http.StatusOK, ""},
+		{"Health checks", true, "/readyz", "", false, http.StatusOK, ""},
+	}
+
+	for _, c := range testCases {
+
+		SetMaintenanceMode(c.on)
+
+		r := httptest.NewRequest("GET", c.path, nil)
+		if c.html {
+			r.Header.Set("Accept", "text/html,application/xhtml+xml")
+		}
+		if c.role != "" {
+			r = r.WithContext(context.WithValue(r.Context(), "role", c.role))
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.status || w.Body.String() != c.body {
+			TestErrorFatal(t, c.description, strconv.Itoa(w.Code)+" "+w.Body.String(), strconv.Itoa(c.status)+" "+c.body)
+		}
+		if c.status == http.StatusServiceUnavailable && w.Header().Get("Retry-After") != "120" {
+			TestErrorFatal(t, c.description, w.Header().Get("Retry-After"), "120")
+		}
+
+	}
+
+}
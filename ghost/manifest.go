@@ -0,0 +1,246 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	bundleManifestFile   = "bundle.json"
+	defaultBundleVersion = "0.0.0"
+)
+
+//routeMethods are the HTTP methods a bundle can declare routes for
+var routeMethods = map[string]bool{
+	"GET":    true,
+	"POST":   true,
+	"PUT":    true,
+	"PATCH":  true,
+	"DELETE": true,
+}
+
+//BundleManifest describes a bundle.  It is read from bundle.json in the bundle's folder:
+//
+//	{
+//		"name": "shop",
+//		"version": "1.2.0",
+//		"description": "Products, orders and checkout",
+//		"dependencies": {"media": "^1.0.0"},
+//		"tables": ["products", "orders"],
+//		"routes": [{"method": "GET", "path": "/shop/products"}],
+//		"permissions": [{"role": "web", "table": "products", "privileges": ["SELECT"]}]
+//	}
+type BundleManifest struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	//Dependencies maps the names of other bundles to version constraints, e.g. {"shop": "^1.2.0"}
+	Dependencies map[string]string `json:"dependencies"`
+	//Tables lists the tables the bundle creates in its schema
+	Tables []string `json:"tables"`
+	//Routes lists the routes the bundle adds to the router
+	Routes []BundleRoute `json:"routes"`
+	//Permissions are the privileges the bundle needs roles to have on its tables
+	Permissions []BundlePermission `json:"permissions"`
+}
+
+//BundleRoute is a route declared by a bundle
+type BundleRoute struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+//BundlePermission grants privileges on one of the bundle's tables, or '*' for all of them
+type BundlePermission struct {
+	Role       string   `json:"role"`
+	Table      string   `json:"table"`
+	Privileges []string `json:"privileges"`
+}
+
+//Grant returns the permission as a grant on a table in the bundle's schema
+func (p BundlePermission) Grant(bundleName string) Grant {
+	return Grant{Role: p.Role, Schema: bundleName, Table: p.Table, Privileges: p.Privileges}
+}
+
+//PermissionsSQL returns the GRANT statements for the permissions the bundle declares
+func (m BundleManifest) PermissionsSQL(bundleName string) ([]string, error) {
+
+	var statements []string
+	for _, permission := range m.Permissions {
+		statement, err := permission.Grant(bundleName).GrantSQL()
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+
+	return statements, nil
+
+}
+
+//ManifestError reports every problem found in a bundle's manifest
+type ManifestError struct {
+	Bundle   string
+	Problems []string
+}
+
+func (e ManifestError) Error() string {
+
+	report := []string{"Invalid " + bundleManifestFile + " for bundle '" + e.Bundle + "':"}
+	for _, p := range e.Problems {
+		report = append(report, "  "+p)
+	}
+
+	return strings.Join(report, "\n")
+
+}
+
+//ReadBundleManifest reads and validates the manifest of a bundle in the bundles folder.
+//Bundles without a bundle.json are treated as version 0.0.0
+func ReadBundleManifest(bundleName string) (BundleManifest, error) {
+
+	b, err := afero.ReadFile(App.FileSystem, BundlePath(bundleName, bundleManifestFile))
+	if os.IsNotExist(err) {
+		return BundleManifest{Name: bundleName, Version: defaultBundleVersion}, nil
+	} else if err != nil {
+		return BundleManifest{Name: bundleName, Version: defaultBundleVersion}, err
+	}
+
+	return parseBundleManifest(bundleName, b)
+
+}
+
+//parseBundleManifest decodes and validates a manifest.  Unknown fields are rejected,
+//so that a misspelt key fails loudly rather than being silently ignored
+func parseBundleManifest(bundleName string, b []byte) (BundleManifest, error) {
+
+	manifest := BundleManifest{Name: bundleName, Version: defaultBundleVersion}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&manifest); err != nil {
+		return manifest, ManifestError{Bundle: bundleName, Problems: []string{err.Error()}}
+	}
+
+	if manifest.Version == "" {
+		manifest.Version = defaultBundleVersion
+	}
+
+	if problems := manifest.validate(bundleName); len(problems) > 0 {
+		return manifest, ManifestError{Bundle: bundleName, Problems: problems}
+	}
+
+	return manifest, nil
+
+}
+
+//validate returns a description of everything wrong with the manifest
+func (m BundleManifest) validate(bundleName string) (problems []string) {
+
+	if m.Name != bundleName {
+		problems = append(problems, "name '"+m.Name+"' does not match the bundle folder '"+bundleName+"'")
+	}
+
+	if _, err := parseVersion(m.Version); err != nil {
+		problems = append(problems, "version: "+err.Error())
+	}
+
+	for dependency, constraint := range m.Dependencies {
+		if !IsValidIdentifier(dependency) {
+			problems = append(problems, "dependencies: invalid bundle name '"+dependency+"'")
+		} else if dependency == bundleName {
+			problems = append(problems, "dependencies: a bundle can't depend on itself")
+		}
+		if _, err := VersionSatisfies(defaultBundleVersion, constraint); err != nil {
+			problems = append(problems, "dependencies: "+dependency+": "+err.Error())
+		}
+	}
+
+	tables := map[string]bool{}
+	for _, table := range m.Tables {
+		if !IsValidIdentifier(table) {
+			problems = append(problems, "tables: invalid table name '"+table+"'")
+		} else if tables[table] {
+			problems = append(problems, "tables: '"+table+"' is listed more than once")
+		}
+		tables[table] = true
+	}
+
+	for _, route := range m.Routes {
+		if !routeMethods[strings.ToUpper(route.Method)] {
+			problems = append(problems, "routes: invalid method '"+route.Method+"' for "+route.Path)
+		}
+		if !strings.HasPrefix(route.Path, "/") || path.Clean(route.Path) != route.Path {
+			problems = append(problems, "routes: invalid path '"+route.Path+"'")
+		}
+	}
+
+	for _, permission := range m.Permissions {
+		if _, err := permission.Grant(bundleName).validate(); err != nil {
+			problems = append(problems, "permissions: "+err.Error())
+		} else if len(m.Tables) > 0 && permission.Table != allTablesInSchemaWildcard && !tables[permission.Table] {
+			problems = append(problems, "permissions: table '"+permission.Table+"' is not one of the bundle's tables")
+		}
+	}
+
+	return problems
+
+}
+
+//ValidateBundle checks that a bundle in the bundles folder is complete: it must have
+//installation files and, if it has a bundle.json, a valid one
+func ValidateBundle(bundleName string) error {
+
+	if !IsValidIdentifier(bundleName) {
+		return errors.New("Invalid bundle name '" + bundleName + "'")
+	}
+
+	if _, err := App.FileSystem.Stat(BundlePath(bundleName)); err != nil {
+		return errors.New("Bundle '" + bundleName + "' is not in the bundles folder")
+	}
+
+	if _, err := ReadBundleManifest(bundleName); err != nil {
+		return err
+	}
+
+	if len(bundleInstallFiles(bundleName)) == 0 {
+		return errors.New("Bundle '" + bundleName + "' has no installation files")
+	}
+
+	return nil
+
+}
+
+//ValidateInstalledBundles validates every installed bundle, so that a server doesn't
+//start with bundles which would only partially work
+func ValidateInstalledBundles() error {
+
+	for _, bundleName := range InstalledBundles() {
+		if err := ValidateBundle(bundleName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
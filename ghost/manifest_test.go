@@ -0,0 +1,67 @@
+package ghost
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBundleManifest(t *testing.T) {
+
+	testCases := []struct {
+		description, manifest, expected string
+	}{
+		{"Full manifest", `{"name": "shop", "version": "1.2.0", "description": "Shop", "dependencies": {"media": "^1.0.0"},
+			"tables": ["products", "orders"], "routes": [{"method": "GET", "path": "/shop/products"}],
+			"permissions": [{"role": "web", "table": "products", "privileges": ["SELECT"]}]}`, ""},
+		{"Name defaults to the folder", `{"version": "1.0.0"}`, ""},
+		{"Malformed JSON", `{"name": "shop",}`, "invalid character"},
+		{"Misspelt key", `{"name": "shop", "dependancies": {}}`, "unknown field"},
+		{"Wrong name", `{"name": "blog"}`, "does not match"},
+		{"Bad version", `{"version": "one"}`, "version"},
+		{"Bad constraint", `{"dependencies": {"media": ">>1"}}`, "dependencies: media"},
+		{"Duplicate table", `{"tables": ["products", "products"]}`, "more than once"},
+		{"Bad route method", `{"routes": [{"method": "FETCH", "path": "/shop"}]}`, "invalid method"},
+		{"Bad route path", `{"routes": [{"method": "GET", "path": "shop/../x"}]}`, "invalid path"},
+		{"Bad privilege", `{"permissions": [{"role": "web", "table": "products", "privileges": ["OWN"]}]}`, "Invalid privilege"},
+		{"Undeclared table", `{"tables": ["orders"], "permissions": [{"role": "web", "table": "products", "privileges": ["SELECT"]}]}`, "not one of the bundle's tables"},
+	}
+
+	for _, c := range testCases {
+		_, err := parseBundleManifest("shop", []byte(c.manifest))
+		if c.expected == "" {
+			if err != nil {
+				TestErrorFatal(t, c.description, err.Error(), "no error")
+			}
+		} else if err == nil {
+			TestErrorFatal(t, c.description, "no error", c.expected)
+		} else if !strings.Contains(err.Error(), c.expected) {
+			TestErrorFatal(t, c.description, err.Error(), c.expected)
+		}
+	}
+
+}
+
+func TestManifestPermissionsSQL(t *testing.T) {
+
+	manifest, err := parseBundleManifest("shop", []byte(`{"tables": ["products"], "permissions": [
+		{"role": "anon", "table": "products", "privileges": ["select"]},
+		{"role": "admin", "table": "*", "privileges": ["ALL"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statements, err := manifest.PermissionsSQL("shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"GRANT USAGE ON SCHEMA shop TO anon; GRANT SELECT ON TABLE shop.products TO anon;",
+		"GRANT USAGE ON SCHEMA shop TO admin; GRANT ALL ON ALL TABLES IN SCHEMA shop TO admin;",
+	}
+
+	if got := strings.Join(statements, "\n"); got != strings.Join(expected, "\n") {
+		TestErrorFatal(t, "Declared permissions become grants on the bundle's schema", got, strings.Join(expected, "\n"))
+	}
+
+}
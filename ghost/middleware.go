@@ -16,8 +16,8 @@ package ghost
 
 import (
 	"context"
-
 	"net/http"
+	"strings"
 
 	"github.com/pressly/chi"
 )
@@ -39,3 +39,86 @@ func AddRecordToContext(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+//RequireRole only lets a request through if the role set on the request context
+//(normally by auth.Authorizator) is one of the permitted roles.  It must therefore
+//be used after the authorisation middleware
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			role, _ := r.Context().Value("role").(string)
+			for _, permitted := range roles {
+				if role == permitted {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			WriteError(w, http.StatusForbidden, "This resource requires one of the roles: "+strings.Join(roles, ", "))
+
+		})
+	}
+}
+
+//LimitBodySize rejects requests with bodies larger than limit bytes with
+//413 Request Entity Too Large.  A limit of 0 or less means no limit.
+//Use it on individual routes which need a smaller limit than the global ones; the global
+//limits are applied first, so use RegisterUploadRoutes for routes which need more
+func LimitBodySize(limit int64) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limitBody(w, r, next, limit)
+		})
+	}
+}
+
+//limitBodySizes applies the maxBodySize setting to API requests and the larger
+//maxUploadSize setting to upload routes.  The settings are read on each request so
+//that they can be changed by reloading the config
+func limitBodySizes(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		limit := App.Config().MaxBodySize
+		if isUpload(r) {
+			limit = App.Config().MaxUploadSize
+		}
+
+		limitBody(w, r, next, limit)
+
+	})
+}
+
+func limitBody(w http.ResponseWriter, r *http.Request, next http.Handler, limit int64) {
+
+	if limit <= 0 || r.Body == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	//Turn away requests which declare a body that is too big before reading any of it.
+	//Anything else is cut off when the handler reads past the limit
+	if r.ContentLength > limit {
+		WriteError(w, http.StatusRequestEntityTooLarge, "Request body is too large")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	next.ServeHTTP(w, r)
+
+}
+
+//isUpload reports whether a request is a file upload rather than an API call.  It goes by
+//the route, not the Content-Type, which the client could set to get the larger limit
+func isUpload(r *http.Request) bool {
+
+	if r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, filesRoute+"/") {
+		return true
+	}
+
+	return hasRoutePrefix(r.URL.Path, currentMiddleware.Load().(*registeredMiddleware).uploadRoutePrefixes)
+
+}
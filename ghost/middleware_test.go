@@ -0,0 +1,53 @@
+package ghost
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLimitBodySizes(t *testing.T) {
+
+	defer changeConfig(func(c *config) { c.MaxBodySize, c.MaxUploadSize = 10, 100 })()
+
+	handler := limitBodySizes(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ioutil.ReadAll(r.Body); err != nil {
+			WriteError(w, http.StatusRequestEntityTooLarge, err.Error())
+		}
+	}))
+
+	old := currentMiddleware.Load().(*registeredMiddleware)
+	defer currentMiddleware.Store(old)
+	RegisterUploadRoutes("/shop/images/")
+
+	testCases := []struct {
+		description, method, path, contentType string
+		size                                   int
+		chunked                                bool
+		expected                               int
+	}{
+		{"Small API call", "POST", "/", "application/json", 10, false, http.StatusOK},
+		{"Large API call", "POST", "/", "application/json", 11, false, http.StatusRequestEntityTooLarge},
+		{"Large API call without a content length", "POST", "/", "application/json", 11, true, http.StatusRequestEntityTooLarge},
+		{"API call claiming to be an upload", "POST", "/", "multipart/form-data; boundary=x", 11, false, http.StatusRequestEntityTooLarge},
+		{"Upload within the upload limit", "PUT", "/files/products/1.jpg", "image/jpeg", 100, false, http.StatusOK},
+		{"Upload over the upload limit", "PUT", "/files/products/1.jpg", "image/jpeg", 101, false, http.StatusRequestEntityTooLarge},
+		{"Upload to a registered upload route", "POST", "/shop/images/1", "multipart/form-data; boundary=x", 100, false, http.StatusOK},
+	}
+
+	for _, c := range testCases {
+		r := httptest.NewRequest(c.method, c.path, strings.NewReader(strings.Repeat("x", c.size)))
+		r.Header.Set("Content-Type", c.contentType)
+		if c.chunked {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.expected {
+			TestErrorFatal(t, c.description, http.StatusText(w.Code), http.StatusText(c.expected))
+		}
+	}
+
+}
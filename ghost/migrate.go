@@ -0,0 +1,224 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+//manifestVersion matches the version in a bundle.json, so that it can be changed
+//without disturbing the rest of the file
+var manifestVersion = regexp.MustCompile(`("version"\s*:\s*)"[^"]*"`)
+
+//CheckBundleUpgrade plans the upgrade of an installed bundle without running anything
+func CheckBundleUpgrade(bundleName string) (BundleUpgrade, error) {
+
+	//Establish a temporary connection as the super user
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return BundleUpgrade{Bundle: bundleName}, err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(SQLToCreateBundleRegistry); err != nil {
+		return BundleUpgrade{Bundle: bundleName}, err
+	}
+
+	return PlanBundleUpgrade(db, bundleName)
+
+}
+
+//DowngradeBundle undoes the last migration run on an installed bundle, by running its
+//.down.sql file.  The bundle's installed version goes back to that of the migration before,
+//or to 0.0.0 if there isn't one, so that upgrading runs the migration again.
+//It returns the versions the bundle went from and to
+func DowngradeBundle(bundleName string) (string, string, error) {
+
+	if !IsValidIdentifier(bundleName) {
+		return "", "", errors.New("Invalid bundle name '" + bundleName + "'")
+	}
+
+	//Establish a temporary connection as the super user
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return "", "", err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(SQLToCreateBundleRegistry); err != nil {
+		return "", "", err
+	}
+
+	var installed string
+	if err := db.QueryRow(sqlToGetBundleVersion, bundleName).Scan(&installed); err == sql.ErrNoRows {
+		return "", "", errors.New("Bundle '" + bundleName + "' is not in the bundle registry")
+	} else if err != nil {
+		return "", "", err
+	}
+
+	migrations, err := bundleMigrations(bundleName)
+	if err != nil {
+		return installed, installed, err
+	}
+
+	last, previous, err := lastMigration(migrations, installed)
+	if err != nil {
+		return installed, installed, errors.New("Cannot roll back bundle '" + bundleName + "': " + err.Error())
+	}
+
+	err = inTransaction(db, func(tx *sql.Tx) error {
+
+		if err := runBundleFiles(tx, bundleName, []string{last.Down}); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(sqlToUpdateBundleVersion, bundleName, previous)
+		return err
+
+	})
+	if err != nil {
+		return installed, installed, err
+	}
+
+	Log("MIGRATE", true, "Bundle '"+bundleName+"' rolled back from "+installed+" to "+previous, nil)
+	return installed, previous, nil
+
+}
+
+//lastMigration finds the migration which brought a bundle to its installed version,
+//and the version to go back to when it is undone
+func lastMigration(migrations []bundleMigration, installed string) (bundleMigration, string, error) {
+
+	previous := defaultBundleVersion
+
+	for _, m := range migrations {
+		c, _ := CompareVersions(m.Version, installed)
+		if c < 0 {
+			previous = m.Version
+			continue
+		}
+		if c > 0 {
+			break
+		}
+		if m.Down == "" {
+			return m, "", errors.New("migration " + path.Base(m.File) + " has no " + downMigrationSuffix + " file to undo it")
+		}
+		return m, previous, nil
+	}
+
+	return bundleMigration{}, "", errors.New("installed version " + installed + " was not reached by a migration")
+
+}
+
+//CreateMigration adds an empty migration, and the file to undo it, to a bundle's migrations
+//folder and moves the version in its bundle.json on to the migration's version, so that the
+//next upgrade runs it.  The description becomes part of the file names
+func CreateMigration(bundleName, description string) ([]string, error) {
+
+	if !IsValidIdentifier(bundleName) {
+		return nil, errors.New("Invalid bundle name '" + bundleName + "'")
+	}
+
+	description = strings.ToLower(HyphensToUnderscores(strings.Replace(strings.TrimSpace(description), " ", "_", -1)))
+	if !IsValidIdentifier(description) {
+		return nil, errors.New("Invalid migration name '" + description + "'. Use letters, numbers and underscores")
+	}
+
+	manifest, err := ReadBundleManifest(bundleName)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := bundleMigrations(bundleName)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := nextMigrationVersion(manifest.Version, migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	base := BundlePath(bundleName, bundleMigrationsFolder, version+"_"+description)
+	files := []string{base + ".sql", base + downMigrationSuffix}
+	contents := []string{
+		"-- Upgrades " + bundleName + " to " + version + "\n",
+		"-- Undoes the " + version + " migration of " + bundleName + "\n",
+	}
+
+	if err := App.FileSystem.MkdirAll(BundlePath(bundleName, bundleMigrationsFolder), os.ModePerm); err != nil {
+		return nil, err
+	}
+	for k, file := range files {
+		if err := afero.WriteFile(App.FileSystem, file, []byte(contents[k]), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := setManifestVersion(bundleName, version); err != nil {
+		return files, err
+	}
+
+	return files, nil
+
+}
+
+//nextMigrationVersion is the next patch version after both the bundle's version and its last migration
+func nextMigrationVersion(current string, migrations []bundleMigration) (string, error) {
+
+	latest := current
+	if len(migrations) > 0 {
+		if c, _ := CompareVersions(migrations[len(migrations)-1].Version, latest); c > 0 {
+			latest = migrations[len(migrations)-1].Version
+		}
+	}
+
+	v, err := parseVersion(latest)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2]+1), nil
+
+}
+
+//setManifestVersion changes the version in a bundle's bundle.json, creating it if need be
+func setManifestVersion(bundleName, version string) error {
+
+	manifestFile := BundlePath(bundleName, bundleManifestFile)
+
+	b, err := afero.ReadFile(App.FileSystem, manifestFile)
+	if os.IsNotExist(err) {
+		b = []byte(fmt.Sprintf("{\n\t\"name\": %q,\n\t\"version\": %q\n}\n", bundleName, version))
+	} else if err != nil {
+		return err
+	} else if loc := manifestVersion.FindSubmatchIndex(b); loc == nil {
+		return errors.New(bundleManifestFile + " of bundle '" + bundleName + "' has no version to update")
+	} else {
+		//Only the first match, which is the bundle's own version
+		b = append(append(append([]byte{}, b[:loc[3]]...), fmt.Sprintf("%q", version)...), b[loc[1]:]...)
+	}
+
+	return afero.WriteFile(App.FileSystem, manifestFile, b, 0644)
+
+}
@@ -0,0 +1,63 @@
+package ghost
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestLastMigration(t *testing.T) {
+
+	migrations, err := parseMigrationFiles([]string{
+		"bundles/shop/migrations/1.1.0.down.sql",
+		"bundles/shop/migrations/1.1.0.sql",
+		"bundles/shop/migrations/1.2.0_add_stock.down.sql",
+		"bundles/shop/migrations/1.2.0_add_stock.sql",
+		"bundles/shop/migrations/1.3.0_new_pricing.sql",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		description, installed, down, previous string
+		ok                                     bool
+	}{
+		{"Back to the migration before", "1.2.0", "1.2.0_add_stock.down.sql", "1.1.0", true},
+		{"Back to before any migration", "1.1.0", "1.1.0.down.sql", "0.0.0", true},
+		{"No down file", "1.3.0", "", "", false},
+		{"Installed without a migration", "1.0.0", "", "", false},
+	}
+
+	for _, c := range testCases {
+		m, previous, err := lastMigration(migrations, c.installed)
+		if (err == nil) != c.ok || (c.ok && (!strings.HasSuffix(m.Down, "/"+c.down) || previous != c.previous)) {
+			TestErrorFatal(t, c.description, m.Down+" "+previous, c.down+" "+c.previous)
+		}
+	}
+
+}
+
+func TestCreateMigration(t *testing.T) {
+
+	App.FileSystem = afero.NewMemMapFs()
+	afero.WriteFile(App.FileSystem, "bundles/shop/bundle.json", []byte("{\n\t\"name\": \"shop\",\n\t\"version\": \"1.2.0\",\n\t\"dependencies\": {\"version\": \"1.0.0\"}\n}\n"), 0644)
+	afero.WriteFile(App.FileSystem, "bundles/shop/migrations/1.2.4.sql", []byte(""), 0644)
+
+	files, err := CreateMigration("shop", "Add stock levels")
+	if err != nil {
+		TestErrorFatal(t, "Creating a migration", err.Error(), "no error")
+	}
+
+	exp := "bundles/shop/migrations/1.2.5_add_stock_levels.sql bundles/shop/migrations/1.2.5_add_stock_levels.down.sql"
+	if strings.Join(files, " ") != exp {
+		TestErrorFatal(t, "Migration files", strings.Join(files, " "), exp)
+	}
+
+	manifest, err := ReadBundleManifest("shop")
+	if err != nil || manifest.Version != "1.2.5" || manifest.Dependencies["version"] != "1.0.0" {
+		TestErrorFatal(t, "Bundle version", manifest.Version, "1.2.5")
+	}
+
+}
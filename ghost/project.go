@@ -0,0 +1,94 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+//projectSkeleton is the layout of a new project, besides its config and starter bundle
+var projectSkeleton = []struct {
+	path, contents string
+}{
+	{"main.go", `package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jpincas/ghost/ghost"
+)
+
+func main() {
+
+	//Add your own routes here, e.g. ghost.App.Router.Get("/hello", hello)
+	ghost.BeforeServe = func() {
+	}
+
+	//Start the server: go build, then run with --secret (or GHOST_SECRET set) and --pgpw
+	if err := ghost.ServeCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+}
+`},
+	{".gitignore", `# Build output and bundle plugins
+/PROJECT
+*.so
+
+# Secrets and generated certificates
+jwt_private.pem
+certs/
+
+# Logs
+*.log
+`},
+}
+
+//ScaffoldProject creates a new project in a folder: a config file, main.go, a .gitignore
+//and a bundles folder holding a starter bundle.  The folder must be new or empty
+func ScaffoldProject(fs afero.Fs, dir string, c config, starterBundle string) error {
+
+	if entries, err := afero.ReadDir(fs, dir); err == nil && len(entries) > 0 {
+		return errors.New("Folder '" + dir + "' already exists and is not empty")
+	}
+
+	if err := fs.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	project := afero.NewBasePathFs(fs, dir)
+
+	c.BundlesInstalled = Bundles{}
+	if err := WriteConfigFile(project, "config.json", c); err != nil {
+		return err
+	}
+
+	for _, f := range projectSkeleton {
+		contents := strings.Replace(f.contents, "PROJECT", path.Base(dir), -1)
+		if err := afero.WriteFile(project, f.path, []byte(contents), 0644); err != nil {
+			return err
+		}
+	}
+
+	return ScaffoldBundle(project, starterBundle)
+
+}
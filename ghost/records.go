@@ -0,0 +1,222 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	sqlToInsertRecord   = `INSERT INTO %s.%s (%s) VALUES (%s) RETURNING row_to_json(%s);`
+	sqlToUpdateRecord   = `UPDATE %s.%s SET %s WHERE id = $%d RETURNING row_to_json(%s);`
+	sqlToDeleteRecord   = `DELETE FROM %s.%s WHERE id = $1 RETURNING row_to_json(%s);`
+	sqlToSetLocalUser   = `SELECT set_config('my.user_id', $1, true);`
+	sqlToSetLocalRoleTo = `SET LOCAL ROLE %s;`
+)
+
+//Insert adds a record, running the insert hooks either side, and returns the new record as JSON
+func (s store) Insert(e *RecordEvent) (string, error) {
+
+	if err := RunBeforeHooks(OperationInsert, e); err != nil {
+		return "", err
+	}
+
+	cols, placeholders, args, err := recordColumns(e.Record)
+	if err != nil {
+		return "", err
+	}
+	if len(cols) == 0 {
+		return "", errors.New("Nothing to insert")
+	}
+
+	result, err := s.writeRecord(OperationInsert, e, fmt.Sprintf(sqlToInsertRecord, e.Schema, e.Table, strings.Join(cols, ", "), strings.Join(placeholders, ", "), e.Table), args)
+	if err != nil {
+		return "", err
+	}
+
+	invalidateCache(e.Schema, e.Table)
+	RunAfterHooks(OperationInsert, e)
+	if result != "" {
+		PublishEvent(recordEvent(OperationInsert, e, result))
+	}
+	return result, nil
+
+}
+
+//Update changes the fields in the event's record on the record with the event's ID,
+//running the update hooks either side, and returns the updated record as JSON
+func (s store) Update(e *RecordEvent) (string, error) {
+
+	if err := RunBeforeHooks(OperationUpdate, e); err != nil {
+		return "", err
+	}
+
+	cols, placeholders, args, err := recordColumns(e.Record)
+	if err != nil {
+		return "", err
+	}
+	if len(cols) == 0 {
+		return "", errors.New("Nothing to update")
+	}
+
+	set := make([]string, len(cols))
+	for k := range cols {
+		set[k] = cols[k] + " = " + placeholders[k]
+	}
+
+	result, err := s.writeRecord(OperationUpdate, e, fmt.Sprintf(sqlToUpdateRecord, e.Schema, e.Table, strings.Join(set, ", "), len(args)+1, e.Table), append(args, e.ID))
+	if err != nil {
+		return "", err
+	}
+
+	invalidateCache(e.Schema, e.Table)
+	RunAfterHooks(OperationUpdate, e)
+	if result != "" {
+		PublishEvent(recordEvent(OperationUpdate, e, result))
+	}
+	return result, nil
+
+}
+
+//Delete removes the record with the event's ID, running the delete hooks either side,
+//and returns the deleted record as JSON
+func (s store) Delete(e *RecordEvent) (string, error) {
+
+	if err := RunBeforeHooks(OperationDelete, e); err != nil {
+		return "", err
+	}
+
+	result, err := s.writeRecord(OperationDelete, e, fmt.Sprintf(sqlToDeleteRecord, e.Schema, e.Table, e.Table), []interface{}{e.ID})
+	if err != nil {
+		return "", err
+	}
+
+	invalidateCache(e.Schema, e.Table)
+	RunAfterHooks(OperationDelete, e)
+	if result != "" {
+		PublishEvent(recordEvent(OperationDelete, e, result))
+	}
+	return result, nil
+
+}
+
+//writeRecord runs a write in a transaction as the event's role and user, and sends
+//the change to the change feed and, if it is switched on, the audit trail.  An empty
+//result means no record was affected
+func (s store) writeRecord(operation string, e *RecordEvent, query string, args []interface{}) (string, error) {
+
+	if !IsValidIdentifier(e.Schema) || !IsValidIdentifier(e.Table) {
+		return "", errors.New("Invalid schema or table")
+	}
+
+	if e.Role != "" && !IsValidIdentifier(e.Role) {
+		return "", errors.New("Invalid role '" + e.Role + "'")
+	}
+
+	var result string
+	err := inTransaction(App.DB, func(tx *sql.Tx) error {
+
+		if e.Role != "" {
+			if _, err := tx.Exec(fmt.Sprintf(sqlToSetLocalRoleTo, e.Role)); err != nil {
+				return err
+			}
+		}
+
+		if e.UserID != "" {
+			if _, err := tx.Exec(sqlToSetLocalUser, e.UserID); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Exec(sqlToMarkStoreWrite); err != nil {
+			return err
+		}
+
+		//Updates are audited with the record as it was before
+		audit := App.Config().Audit
+		if audit {
+			if _, err := tx.Exec(sqlToMarkStoreAudit); err != nil {
+				return err
+			}
+		}
+		var before string
+		if audit && operation == OperationUpdate {
+			err := tx.QueryRow(fmt.Sprintf(sqlToLockRecord, e.Table, e.Schema, e.Table), e.ID).Scan(&before)
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		err := tx.QueryRow(query, args...).Scan(&result)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := notifyChange(tx, operation, e, result); err != nil {
+			return err
+		}
+
+		if audit {
+			return auditChange(tx, operation, e, before, result)
+		}
+		return nil
+
+	})
+
+	return result, err
+
+}
+
+//recordColumns returns the columns of a record, sorted, with matching placeholders and values
+func recordColumns(record map[string]interface{}) (cols, placeholders []string, args []interface{}, err error) {
+
+	for col := range record {
+		if !IsValidIdentifier(col) {
+			return nil, nil, nil, errors.New("Invalid column '" + col + "'")
+		}
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	for k, col := range cols {
+		placeholders = append(placeholders, "$"+strconv.Itoa(k+1))
+		value := record[col]
+		//JSON objects and arrays go into json/jsonb columns as they came
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			b, err := json.Marshal(value)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			value = string(b)
+		}
+		args = append(args, value)
+	}
+
+	return cols, placeholders, args, nil
+
+}
@@ -15,56 +15,296 @@
 package ghost
 
 import (
-	"time"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/goware/cors"
 	"github.com/pressly/chi"
 	"github.com/pressly/chi/middleware"
 )
 
+//corsHandler is the currently active CORS configuration (nil when CORS is not activated)
+//It is swapped out when the configuration is reloaded, so it is guarded by a mutex
+var (
+	corsHandler *cors.Cors
+	corsMutex   sync.RWMutex
+)
+
+//registeredMiddleware is the middleware registered by embedding applications and bundles,
+//and the global middleware named in the config.  Requests under one of the web route
+//prefixes (static files and single page apps) get the web middleware, all others get the
+//API middleware.  Requests under one of the upload route prefixes get the upload size limit
+type registeredMiddleware struct {
+	global              []func(http.Handler) http.Handler
+	api                 []func(http.Handler) http.Handler
+	web                 []func(http.Handler) http.Handler
+	webRoutePrefixes    []string
+	uploadRoutePrefixes []string
+}
+
+//currentMiddleware holds a *registeredMiddleware, which is never changed: registering
+//stores a new one, so requests read it without locking.  The mutex stops two
+//registrations at once from losing one of them
+var (
+	currentMiddleware atomic.Value
+	middlewareMutex   sync.Mutex
+)
+
 func init() {
 
-	App.Router = chi.NewRouter()
+	currentMiddleware.Store(&registeredMiddleware{webRoutePrefixes: []string{"/bundles/"}})
+	App.Router = newRouter()
 
-	if App.Config.ActivateCors {
+}
 
-		// for more ideas, see: https://developer.github.com/v3/#cross-origin-resource-sharing
-		cors := cors.New(cors.Options{
-			AllowedOrigins:   App.Config.CorsAllowedOrigins,
-			AllowedMethods:   App.Config.CorsAllowedMethods,
-			AllowedHeaders:   App.Config.CorsAllowedHeaders,
-			ExposedHeaders:   App.Config.CorsExposedHeaders,
-			AllowCredentials: App.Config.CorsAllowCredentials,
-			MaxAge:           App.Config.CorsMaxAge, // Maximum value not ignored by any of major browsers
-		})
+//newRouter returns a router with the core middleware and no routes
+func newRouter() *chi.Mux {
 
-		App.Router.Use(cors.Handler) //Activate CORS middleware
+	router := chi.NewRouter()
 
-	}
+	//CORS is always in the chain but only does anything once activated in config
+	router.Use(corsMiddleware)
+
+	//The version goes on every response, unless switched off in config
+	router.Use(versionHeader)
+
+	//Request bodies are limited according to the config
+	router.Use(limitBodySizes)
+
+	//The config hasn't been read yet and extensions haven't registered anything,
+	//so the rest of the middleware is looked up when requests come in
+	router.Use(routerMiddleware)
+
+	return router
+
+}
+
+//RegisterAPIMiddleware adds middleware to every API request, after the global middleware
+//from the config.  Chi routers only accept middleware before their first route, so use this
+//rather than App.Router.Use from applications and bundles
+func RegisterAPIMiddleware(middleware ...func(http.Handler) http.Handler) {
+
+	updateMiddleware(func(m *registeredMiddleware) {
+		m.api = append(m.api[:len(m.api):len(m.api)], middleware...)
+	})
+
+}
+
+//RegisterWebMiddleware adds middleware to every request for static files or
+//single page apps, after the global middleware from the config
+func RegisterWebMiddleware(middleware ...func(http.Handler) http.Handler) {
+
+	updateMiddleware(func(m *registeredMiddleware) {
+		m.web = append(m.web[:len(m.web):len(m.web)], middleware...)
+	})
+
+}
+
+//RegisterWebRoutes marks the routes under the given prefixes as web routes, which get
+//the web middleware rather than the API middleware, e.g. RegisterWebRoutes("/shop/")
+func RegisterWebRoutes(prefixes ...string) {
 
-	//Global router middleware setup
-	for _, v := range App.Config.GlobalMiddleware {
+	updateMiddleware(func(m *registeredMiddleware) {
+		m.webRoutePrefixes = append(m.webRoutePrefixes[:len(m.webRoutePrefixes):len(m.webRoutePrefixes)], prefixes...)
+	})
+
+}
+
+//RegisterUploadRoutes marks the routes under the given prefixes as taking uploads, so that
+//their request bodies are limited by maxUploadSize rather than maxBodySize,
+//e.g. RegisterUploadRoutes("/shop/images/")
+func RegisterUploadRoutes(prefixes ...string) {
+
+	updateMiddleware(func(m *registeredMiddleware) {
+		m.uploadRoutePrefixes = append(m.uploadRoutePrefixes[:len(m.uploadRoutePrefixes):len(m.uploadRoutePrefixes)], prefixes...)
+	})
+
+}
+
+//applyGlobalMiddleware (re)builds the global middleware from the current config
+func applyGlobalMiddleware() {
+
+	global := configMiddleware(App.Config().GlobalMiddleware)
+
+	updateMiddleware(func(m *registeredMiddleware) {
+		m.global = global
+	})
+
+}
+
+//updateMiddleware publishes a changed copy of the registered middleware.  The slices are
+//shared with the previous copy, so they must be replaced rather than changed in place
+func updateMiddleware(change func(m *registeredMiddleware)) {
+
+	middlewareMutex.Lock()
+	defer middlewareMutex.Unlock()
+
+	m := *currentMiddleware.Load().(*registeredMiddleware)
+	change(&m)
+	currentMiddleware.Store(&m)
+
+}
+
+//configMiddleware returns the middleware named in the config, in order
+func configMiddleware(names []string) []func(http.Handler) http.Handler {
+
+	var chain []func(http.Handler) http.Handler
+
+	for _, v := range names {
 
 		switch v {
 		case "RequestID":
-			App.Router.Use(middleware.RequestID)
+			chain = append(chain, middleware.RequestID)
 		case "RealIP":
-			App.Router.Use(middleware.RealIP)
+			chain = append(chain, middleware.RealIP)
 		case "Logger":
-			App.Router.Use(middleware.Logger)
+			chain = append(chain, middleware.Logger)
 		case "Recoverer":
-			App.Router.Use(middleware.Recoverer)
+			chain = append(chain, middleware.Recoverer)
 		case "CloseNotify":
 			// When a client closes their connection midway through a request, the
 			// http.CloseNotifier will cancel the request context (ctx).
-			App.Router.Use(middleware.CloseNotify)
+			chain = append(chain, middleware.CloseNotify)
 		case "Timeout":
 			// Set a timeout value on the request context (ctx), that will signal
 			// through ctx.Done() that the request has timed out and further
-			// processing should be stopped.
-			App.Router.Use(middleware.Timeout(60 * time.Second))
+			// processing should be stopped.  The timeout depends on the route
+			chain = append(chain, routeTimeouts)
+		default:
+			Log("ROUTER", false, "Unknown global middleware '"+v+"'", nil)
+		}
+
+	}
+
+	return chain
+
+}
+
+//hasRoutePrefix reports whether a path is under one of the prefixes
+func hasRoutePrefix(urlPath string, prefixes []string) bool {
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(urlPath, prefix) {
+			return true
 		}
+	}
+
+	return false
+
+}
 
+//chainMiddleware wraps a handler in middleware, the first being the outermost
+func chainMiddleware(h http.Handler, middleware ...[]func(http.Handler) http.Handler) http.Handler {
+
+	var all []func(http.Handler) http.Handler
+	for _, m := range middleware {
+		all = append(all, m...)
+	}
+
+	for i := len(all) - 1; i >= 0; i-- {
+		h = all[i](h)
 	}
 
+	return h
+
+}
+
+//middlewareChains are the handlers built from one set of registered middleware
+type middlewareChains struct {
+	registered *registeredMiddleware
+	api, web   http.Handler
+}
+
+//routerMiddleware runs the global, API and web middleware.  The chains are only
+//built again when something has changed, so that middleware keeps its state.
+//Requests read the chains without locking; only rebuilding them takes the mutex
+func routerMiddleware(next http.Handler) http.Handler {
+
+	var (
+		chains atomic.Value
+		mutex  sync.Mutex
+	)
+
+	current := func() *middlewareChains {
+
+		m := currentMiddleware.Load().(*registeredMiddleware)
+		if c, _ := chains.Load().(*middlewareChains); c != nil && c.registered == m {
+			return c
+		}
+
+		//Something has changed, so build the chains, unless another request just has
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		m = currentMiddleware.Load().(*registeredMiddleware)
+		if c, _ := chains.Load().(*middlewareChains); c != nil && c.registered == m {
+			return c
+		}
+
+		c := &middlewareChains{
+			registered: m,
+			api:        chainMiddleware(next, m.global, m.api, []func(http.Handler) http.Handler{maintenance}),
+			web:        chainMiddleware(next, m.global, m.web, []func(http.Handler) http.Handler{maintenance}),
+		}
+		chains.Store(c)
+		return c
+
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		c := current()
+		if hasRoutePrefix(r.URL.Path, c.registered.webRoutePrefixes) {
+			c.web.ServeHTTP(w, r)
+			return
+		}
+		c.api.ServeHTTP(w, r)
+
+	})
+}
+
+//applyCors (re)builds the CORS handler from the current config
+func applyCors() {
+
+	var c *cors.Cors
+
+	if App.Config().ActivateCors {
+
+		// for more ideas, see: https://developer.github.com/v3/#cross-origin-resource-sharing
+		c = cors.New(cors.Options{
+			AllowedOrigins:   App.Config().CorsAllowedOrigins,
+			AllowedMethods:   App.Config().CorsAllowedMethods,
+			AllowedHeaders:   App.Config().CorsAllowedHeaders,
+			ExposedHeaders:   App.Config().CorsExposedHeaders,
+			AllowCredentials: App.Config().CorsAllowCredentials,
+			MaxAge:           App.Config().CorsMaxAge, // Maximum value not ignored by any of major browsers
+		})
+
+	}
+
+	corsMutex.Lock()
+	corsHandler = c
+	corsMutex.Unlock()
+
+}
+
+//corsMiddleware delegates to the active CORS handler, if there is one
+func corsMiddleware(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		corsMutex.RLock()
+		c := corsHandler
+		corsMutex.RUnlock()
+
+		if c == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		c.Handler(next).ServeHTTP(w, r)
+
+	})
 }
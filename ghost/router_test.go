@@ -0,0 +1,59 @@
+package ghost
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouterMiddleware(t *testing.T) {
+
+	//Each middleware adds its name to a header, so the header shows the order they ran in
+	mark := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Middleware", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	old := currentMiddleware.Load().(*registeredMiddleware)
+	defer currentMiddleware.Store(old)
+
+	updateMiddleware(func(m *registeredMiddleware) {
+		m.global = []func(http.Handler) http.Handler{mark("global")}
+	})
+	RegisterAPIMiddleware(mark("api"))
+	RegisterWebMiddleware(mark("web"))
+	RegisterWebRoutes("/shop/")
+
+	handler := routerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	testCases := []struct {
+		path, exp string
+	}{
+		{"/hello", "global api"},
+		{"/bundles/shop/logo.png", "global web"},
+		{"/shop/index.html", "global web"},
+	}
+
+	for _, c := range testCases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		got := w.Header()["X-Middleware"]
+		if len(got) != 2 || got[0]+" "+got[1] != c.exp {
+			TestErrorFatal(t, c.path, fmt.Sprint(got), c.exp)
+		}
+	}
+
+	//Middleware registered once requests are coming in is picked up
+	RegisterAPIMiddleware(mark("late"))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil))
+	if got, exp := fmt.Sprint(w.Header()["X-Middleware"]), "[global api late]"; got != exp {
+		TestErrorFatal(t, "Middleware registered later", got, exp)
+	}
+
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/pressly/chi"
+	"github.com/spf13/afero"
+)
+
+//setCoreRoutes adds the routes that every ghost server provides
+func setCoreRoutes() {
+
+	//Public assets for all installed bundles are served from a single route
+	//which checks the installed bundle list on each request, so bundles
+	//installed or removed at runtime appear and disappear without a restart
+	App.Router.Get("/bundles/{bundle}/*", serveBundleAssets)
+
+	//Liveness and readiness checks for load balancers and orchestrators
+	App.Router.Get("/healthz", healthz)
+	App.Router.Get("/readyz", readyz)
+
+}
+
+//serveBundleAssets serves files from the 'public' folder of an installed, enabled bundle
+func serveBundleAssets(w http.ResponseWriter, r *http.Request) {
+
+	bundleName := chi.URLParam(r, "bundle")
+	if !IsBundleEnabled(bundleName) {
+		http.NotFound(w, r)
+		return
+	}
+
+	//Serve the remainder of the path from the bundle's public folder
+	assetRequest := new(http.Request)
+	*assetRequest = *r
+	assetRequest.URL = new(url.URL)
+	*assetRequest.URL = *r.URL
+	assetRequest.URL.Path = "/" + chi.URLParam(r, "*")
+
+	//Assets which aren't shipped with the bundle, e.g. added while it runs, can be in storage
+	publicFolder := BundlePath(bundleName, "public")
+	if _, err := App.FileSystem.Stat(path.Join(publicFolder, assetRequest.URL.Path)); os.IsNotExist(err) {
+		err := sendStoredFile(w, r, path.Join("bundles", bundleName, "public", assetRequest.URL.Path))
+		if err == nil {
+			return
+		}
+		if err != ErrFileNotFound {
+			Log("STORAGE", false, "Could not read asset for bundle '"+bundleName+"'", err)
+		}
+	}
+
+	publicDir := afero.NewHttpFs(App.FileSystem).Dir(publicFolder)
+	http.FileServer(publicDir).ServeHTTP(w, assetRequest)
+
+}
@@ -0,0 +1,334 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Where buckets are if the storageEndpoint setting isn't given
+const (
+	gcsEndpoint     = "https://storage.googleapis.com"
+	s3DefaultRegion = "us-east-1"
+)
+
+//s3Storage keeps files in an S3 bucket, or any service with the S3 API.  Google Cloud
+//Storage is used through its S3 compatible XML API, with HMAC keys.  Requests are signed
+//with AWS signature version 4
+type s3Storage struct {
+	//base is the URL of the bucket, without a trailing slash
+	base                         *url.URL
+	region, accessKey, secretKey string
+	client                       *http.Client
+}
+
+func newS3Storage(c config, accessKey, secretKey string) (*s3Storage, error) {
+
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("storageAccessKey and storageSecretKey must be set to store files in " + c.Storage)
+	}
+
+	region := c.StorageRegion
+	if region == "" {
+		region = s3DefaultRegion
+		if c.Storage == storageGCS {
+			region = "auto"
+		}
+	}
+
+	var bucketURL string
+	switch {
+	case c.StorageEndpoint != "":
+		//Other services, like MinIO, have the bucket in the path
+		bucketURL = strings.TrimSuffix(c.StorageEndpoint, "/") + "/" + url.PathEscape(c.StorageBucket)
+	case c.Storage == storageGCS:
+		bucketURL = gcsEndpoint + "/" + url.PathEscape(c.StorageBucket)
+	default:
+		bucketURL = "https://" + c.StorageBucket + ".s3." + region + ".amazonaws.com"
+	}
+
+	base, err := url.Parse(bucketURL)
+	if err != nil || base.Host == "" {
+		return nil, errors.New("Invalid storageEndpoint '" + c.StorageEndpoint + "'")
+	}
+
+	return &s3Storage{base, region, accessKey, secretKey, &http.Client{Timeout: 5 * time.Minute}}, nil
+
+}
+
+//Put uploads a file.  The file is read into memory first, since the upload needs its length
+func (s *s3Storage) Put(ctx context.Context, name string, r io.Reader, contentType string) error {
+
+	name, err := cleanStorageName(name)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if contentType == "" {
+		contentType = storageContentType(name)
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, name, nil, body, contentType)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+
+}
+
+func (s *s3Storage) Get(ctx context.Context, name string) (io.ReadCloser, StoredFile, error) {
+
+	name, err := cleanStorageName(name)
+	if err != nil {
+		return nil, StoredFile{}, err
+	}
+
+	resp, err := s.do(ctx, http.MethodGet, name, nil, nil, "")
+	if err != nil {
+		return nil, StoredFile{}, err
+	}
+
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return resp.Body, StoredFile{name, resp.ContentLength, resp.Header.Get("Content-Type"), modified}, nil
+
+}
+
+func (s *s3Storage) Delete(ctx context.Context, name string) error {
+
+	name, err := cleanStorageName(name)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodDelete, name, nil, nil, "")
+	if err == ErrFileNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+
+}
+
+//s3ListResult is the response to a ListObjectsV2 request
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *s3Storage) List(ctx context.Context, prefix string) ([]StoredFile, error) {
+
+	query := url.Values{"list-type": {"2"}, "prefix": {strings.TrimPrefix(prefix, "/")}}
+
+	files := []StoredFile{}
+	for {
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, "")
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			files = append(files, StoredFile{object.Key, object.Size, storageContentType(object.Key), object.LastModified})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+
+	}
+
+	return files, nil
+
+}
+
+//s3ErrorResponse is the body of an error from the S3 API
+type s3ErrorResponse struct {
+	Code, Message string
+}
+
+//do sends a signed request for an object, or for the bucket if the name is "".
+//Responses other than 2xx are turned into errors, with a 404 as ErrFileNotFound
+func (s *s3Storage) do(ctx context.Context, method, name string, query url.Values, body []byte, contentType string) (*http.Response, error) {
+
+	u := *s.base
+	u.Path = s.base.Path + "/" + name
+	u.RawPath = s3Escape(s.base.Path, false) + "/" + s3Escape(name, false)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	sum := sha256.Sum256(body)
+	signS3Request(req, hex.EncodeToString(sum[:]), s.accessKey, s.secretKey, s.region, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && name != "" {
+		return nil, ErrFileNotFound
+	}
+
+	var s3Err s3ErrorResponse
+	if xml.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&s3Err) == nil && s3Err.Code != "" {
+		return nil, fmt.Errorf("%s %s: %s (%s)", method, u.Path, s3Err.Message, s3Err.Code)
+	}
+
+	return nil, fmt.Errorf("%s %s: %s", method, u.Path, resp.Status)
+
+}
+
+//signS3Request adds the headers signing a request with AWS signature version 4.  Every
+//header already on the request is signed, along with the host
+func signS3Request(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+
+}
+
+//s3CanonicalQuery encodes query parameters the way they are signed: sorted, with every
+//character other than letters, digits and -_.~ escaped
+func s3CanonicalQuery(query url.Values) string {
+
+	var params []string
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+
+}
+
+//s3Escape escapes everything but letters, digits and -_.~, and slashes too unless it is a path
+func s3Escape(s string, escapeSlash bool) string {
+
+	var escaped strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			escaped.WriteByte(b)
+		case b == '/' && !escapeSlash:
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+
+	return escaped.String()
+
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+//bundleAdminPanelFolder holds a bundle's admin panel view and menu configuration
+const bundleAdminPanelFolder = "admin-panel"
+
+//bundleSkeleton is the folder layout of a new bundle.  Files are templates in which
+//BUNDLE is replaced by the bundle's name.  Entries ending in '/' are empty folders,
+//which are given a .gitkeep so that they survive version control
+var bundleSkeleton = []struct {
+	path, contents string
+}{
+	{bundleManifestFile, `{
+	"name": "BUNDLE",
+	"version": "0.1.0",
+	"description": "",
+	"dependencies": {},
+	"tables": [],
+	"routes": [],
+	"permissions": []
+}
+`},
+	{bundleInstallFile, `-- Installs the BUNDLE bundle.  Everything here runs in a single transaction
+-- with the search path set to the 'BUNDLE' schema, which is created for you.
+-- Declare the tables you create in bundle.json, along with the privileges roles
+-- need on them - they are granted for you, so there is no need for GRANTs here.
+`},
+	{bundleUninstallFile, `-- Runs before the 'BUNDLE' schema is dropped when the bundle is uninstalled.
+-- Clean up anything the bundle created outside of its own schema here.
+`},
+	{path.Join(bundleDemoFolder, "00_demodata.sql"), `-- Demo data for the BUNDLE bundle, installed with 'ghost bundle install BUNDLE --demodata'.
+-- Files in this folder run in name order.
+`},
+	{bundleMigrationsFolder + "/", ""},
+	{"templates/", ""},
+	{"public/", ""},
+	{path.Join(bundleAdminPanelFolder, "view.json"), "{}\n"},
+	{path.Join(bundleAdminPanelFolder, "menu.json"), "[]\n"},
+}
+
+//ScaffoldBundle creates the folder structure and starter files for a new bundle in the bundles folder
+func ScaffoldBundle(fs afero.Fs, bundleName string) error {
+
+	if !IsValidIdentifier(bundleName) {
+		return errors.New("Invalid bundle name '" + bundleName + "'. Use lower case letters, numbers and underscores only")
+	}
+
+	if exists, _ := afero.Exists(fs, BundlePath(bundleName)); exists {
+		return errors.New("Bundle " + bundleName + " already exists. Please provide a different name")
+	}
+
+	for _, f := range bundleSkeleton {
+
+		if strings.HasSuffix(f.path, "/") {
+			if err := fs.MkdirAll(BundlePath(bundleName, f.path), os.ModePerm); err != nil {
+				return err
+			}
+			if err := afero.WriteFile(fs, BundlePath(bundleName, f.path, ".gitkeep"), nil, 0644); err != nil {
+				return err
+			}
+			continue
+		}
+
+		filePath := BundlePath(bundleName, f.path)
+		if err := fs.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+			return err
+		}
+
+		contents := strings.Replace(f.contents, "BUNDLE", bundleName, -1)
+		if err := afero.WriteFile(fs, filePath, []byte(contents), 0644); err != nil {
+			return err
+		}
+
+	}
+
+	return nil
+
+}
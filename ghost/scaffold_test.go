@@ -0,0 +1,66 @@
+package ghost
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestScaffoldBundle(t *testing.T) {
+
+	fs := afero.NewMemMapFs()
+
+	if err := ScaffoldBundle(fs, "shop"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"bundle.json", "install.sql", "uninstall.sql", "demodata/00_demodata.sql",
+		"migrations", "templates", "public", "admin-panel/view.json", "admin-panel/menu.json"} {
+		if exists, _ := afero.Exists(fs, BundlePath("shop", p)); !exists {
+			TestErrorFatal(t, "Skeleton is complete", "missing", p)
+		}
+	}
+
+	//The generated manifest must pass validation
+	b, _ := afero.ReadFile(fs, BundlePath("shop", "bundle.json"))
+	if _, err := parseBundleManifest("shop", b); err != nil {
+		TestErrorFatal(t, "Generated manifest is valid", err.Error(), "no error")
+	}
+
+	if err := ScaffoldBundle(fs, "shop"); err == nil {
+		t.Error("Scaffolding an existing bundle should fail")
+	}
+
+	if err := ScaffoldBundle(fs, "my-shop"); err == nil {
+		t.Error("Invalid bundle names should be rejected")
+	}
+
+}
+
+func TestScaffoldProject(t *testing.T) {
+
+	fs := afero.NewMemMapFs()
+	c := Defaults
+	c.ApiPort = "8080"
+
+	if err := ScaffoldProject(fs, "projects/myshop", c, "shop"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"config.json", "main.go", ".gitignore", "bundles/shop/bundle.json"} {
+		if exists, _ := afero.Exists(fs, "projects/myshop/"+p); !exists {
+			TestErrorFatal(t, "Project is complete", "missing", p)
+		}
+	}
+
+	b, _ := afero.ReadFile(fs, "projects/myshop/config.json")
+	if !strings.Contains(string(b), `"apiPort": "8080"`) {
+		TestErrorFatal(t, "Config has the answers", string(b), `"apiPort": "8080"`)
+	}
+
+	if err := ScaffoldProject(fs, "projects/myshop", c, "shop"); err == nil {
+		t.Error("Scaffolding into a folder which is not empty should fail")
+	}
+
+}
@@ -0,0 +1,418 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+//Scheduled jobs can also be kept in the database, where they can be changed without
+//editing the config.  The table is read when the server starts and when the config is reloaded
+const (
+	sqlToCreateScheduledJobsTable = `CREATE TABLE IF NOT EXISTS public.ghost_scheduled_jobs (name text PRIMARY KEY, schedule text NOT NULL, sql text, task text, enabled boolean NOT NULL DEFAULT true);`
+	sqlToSelectScheduledJobs      = `SELECT name, schedule, COALESCE(sql, ''), COALESCE(task, '') FROM public.ghost_scheduled_jobs WHERE enabled ORDER BY name;`
+)
+
+//Where a scheduled job was configured
+const (
+	jobSourceConfig = "config"
+	jobSourceTable  = "table"
+)
+
+//ErrJobNotFound and ErrJobRunning are returned by RunScheduledJob
+var (
+	ErrJobNotFound = errors.New("No such scheduled job")
+	ErrJobRunning  = errors.New("The job is already running")
+)
+
+//ScheduledJob runs SQL, as the super user, or a task registered with RegisterTask,
+//on a cron schedule: five fields (minute, hour, day of month, month, day of week),
+//e.g. "0 3 * * *" for 3am every day, or a descriptor such as "@hourly" or "@every 15m"
+type ScheduledJob struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	SQL      string `json:"sql"`
+	Task     string `json:"task"`
+}
+
+//ScheduledJobStatus reports on a scheduled job and its last run
+type ScheduledJobStatus struct {
+	ScheduledJob
+	Source       string     `json:"source"`
+	Running      bool       `json:"running"`
+	NextRun      time.Time  `json:"nextRun"`
+	LastRun      *time.Time `json:"lastRun,omitempty"`
+	LastDuration string     `json:"lastDuration,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+}
+
+//Tasks are Go functions which scheduled jobs can run
+var (
+	tasks      = map[string]func(ctx context.Context) error{}
+	tasksMutex sync.RWMutex
+)
+
+func init() {
+
+	//Built in tasks
+	RegisterTask("purgecache", func(ctx context.Context) error {
+		App.Cache.Purge()
+		return nil
+	})
+
+}
+
+//RegisterTask makes a function available to scheduled jobs, by name, e.g. a bundle
+//sending a daily digest email.  The context is cancelled when the server stops
+func RegisterTask(name string, task func(ctx context.Context) error) {
+
+	tasksMutex.Lock()
+	defer tasksMutex.Unlock()
+
+	tasks[name] = task
+
+}
+
+func registeredTask(name string) (func(ctx context.Context) error, bool) {
+
+	tasksMutex.RLock()
+	defer tasksMutex.RUnlock()
+
+	task, ok := tasks[name]
+	return task, ok
+
+}
+
+//scheduledJob is a job which has been checked and scheduled, with the state of its last run
+type scheduledJob struct {
+	ScheduledJob
+	source   string
+	schedule cron.Schedule
+
+	running      bool
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastError    error
+}
+
+//parseScheduledJob checks a job's schedule and that it has one thing to do
+func parseScheduledJob(j ScheduledJob, source string) (*scheduledJob, error) {
+
+	if j.Name == "" {
+		return nil, errors.New("Scheduled jobs must have a name")
+	}
+
+	schedule, err := cron.ParseStandard(j.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("Job '%s' has an invalid schedule: %s", j.Name, err)
+	}
+
+	if (j.SQL == "") == (j.Task == "") {
+		return nil, fmt.Errorf("Job '%s' must have either sql or a task to run", j.Name)
+	}
+
+	if j.Task != "" {
+		if _, ok := registeredTask(j.Task); !ok {
+			return nil, fmt.Errorf("Job '%s' runs task '%s', which has not been registered", j.Name, j.Task)
+		}
+	}
+
+	return &scheduledJob{ScheduledJob: j, source: source, schedule: schedule}, nil
+
+}
+
+//scheduler runs the scheduled jobs inside the server process
+type scheduler struct {
+	mutex sync.Mutex
+	cron  *cron.Cron
+	jobs  map[string]*scheduledJob
+	ctx   context.Context
+}
+
+var jobScheduler = &scheduler{}
+
+//startScheduler starts running the scheduled jobs from the config and the jobs table,
+//until the context is done
+func startScheduler(ctx context.Context) {
+
+	jobScheduler.mutex.Lock()
+	jobScheduler.ctx = ctx
+	jobScheduler.mutex.Unlock()
+
+	jobScheduler.load(App.Config().ScheduledJobs)
+
+	go func() {
+		<-ctx.Done()
+		jobScheduler.stop()
+	}()
+
+}
+
+//reloadScheduler picks up changes to the scheduled jobs, if the scheduler is running
+func reloadScheduler() {
+
+	jobScheduler.mutex.Lock()
+	running := jobScheduler.ctx != nil && jobScheduler.ctx.Err() == nil
+	jobScheduler.mutex.Unlock()
+
+	if running {
+		jobScheduler.load(App.Config().ScheduledJobs)
+	}
+
+}
+
+//load replaces the scheduled jobs.  Jobs which are invalid are logged and left out.
+//A job in the table replaces a job of the same name in the config
+func (s *scheduler) load(configJobs []ScheduledJob) {
+
+	jobs := map[string]*scheduledJob{}
+	add := func(list []ScheduledJob, source string) {
+		for _, sj := range list {
+			j, err := parseScheduledJob(sj, source)
+			if err != nil {
+				Log("SCHEDULER", false, "Job will not run", err)
+				continue
+			}
+			jobs[j.Name] = j
+		}
+	}
+
+	add(configJobs, jobSourceConfig)
+
+	tableJobs, err := readScheduledJobsTable()
+	if err != nil {
+		Log("SCHEDULER", false, "Could not read the scheduled jobs table", err)
+	}
+	add(tableJobs, jobSourceTable)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+
+	//Keep the jobs which are still scheduled, with the state of their last run, since a
+	//run in progress will mark its own job as finished
+	for name, j := range jobs {
+		if old, ok := s.jobs[name]; ok {
+			old.ScheduledJob, old.source, old.schedule = j.ScheduledJob, j.source, j.schedule
+			jobs[name] = old
+		}
+	}
+
+	s.jobs = jobs
+	s.cron = cron.New()
+	for name, j := range jobs {
+		name := name
+		s.cron.Schedule(j.schedule, cron.FuncJob(func() { s.run(name) }))
+	}
+	s.cron.Start()
+
+	if len(jobs) > 0 {
+		Log("SCHEDULER", true, fmt.Sprintf("%d scheduled jobs running", len(jobs)), nil)
+	}
+
+}
+
+func (s *scheduler) stop() {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+
+}
+
+//start marks a job as running, unless it already is
+func (s *scheduler) start(name string) (*scheduledJob, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j, ok := s.jobs[name]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	if j.running {
+		return nil, ErrJobRunning
+	}
+
+	j.running = true
+	return j, nil
+
+}
+
+//run runs a job, skipping it if the last run hasn't finished, and logs the outcome
+func (s *scheduler) run(name string) {
+
+	j, err := s.start(name)
+	if err != nil {
+		Log("SCHEDULER", false, "Job '"+name+"' skipped", err)
+		return
+	}
+
+	s.execute(j)
+
+}
+
+//execute runs a job which has been marked as running
+func (s *scheduler) execute(j *scheduledJob) {
+
+	started := time.Now()
+	err := s.do(j)
+	duration := time.Since(started)
+
+	s.mutex.Lock()
+	j.running, j.lastRun, j.lastDuration, j.lastError = false, started, duration, err
+	s.mutex.Unlock()
+
+	if err != nil {
+		Log("SCHEDULER", false, "Job '"+j.Name+"' failed after "+duration.String(), err)
+		return
+	}
+	Log("SCHEDULER", true, "Job '"+j.Name+"' finished in "+duration.String(), nil)
+
+}
+
+//do runs a job's SQL or task, recovering from a panicking task
+func (s *scheduler) do(j *scheduledJob) (err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Job panicked: %v", r)
+		}
+	}()
+
+	//The job can be changed by a reload while it runs, so this run sticks to what it started with
+	s.mutex.Lock()
+	ctx := s.ctx
+	job := j.ScheduledJob
+	s.mutex.Unlock()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if job.Task != "" {
+		task, ok := registeredTask(job.Task)
+		if !ok {
+			return errors.New("Task '" + job.Task + "' has not been registered")
+		}
+		return task(ctx)
+	}
+
+	//Establish a temporary connection as the super user
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, job.SQL)
+	return err
+
+}
+
+//RunScheduledJob starts a scheduled job straight away, in the background
+func RunScheduledJob(name string) error {
+
+	j, err := jobScheduler.start(name)
+	if err != nil {
+		return err
+	}
+
+	Log("SCHEDULER", true, "Job '"+name+"' started by hand", nil)
+	go jobScheduler.execute(j)
+	return nil
+
+}
+
+//ScheduledJobs reports on the scheduled jobs, in name order
+func ScheduledJobs() []ScheduledJobStatus {
+
+	jobScheduler.mutex.Lock()
+	defer jobScheduler.mutex.Unlock()
+
+	statuses := []ScheduledJobStatus{}
+	now := time.Now()
+
+	for _, j := range jobScheduler.jobs {
+
+		status := ScheduledJobStatus{
+			ScheduledJob: j.ScheduledJob,
+			Source:       j.source,
+			Running:      j.running,
+			NextRun:      j.schedule.Next(now),
+		}
+		if !j.lastRun.IsZero() {
+			lastRun := j.lastRun
+			status.LastRun = &lastRun
+			status.LastDuration = j.lastDuration.String()
+		}
+		if j.lastError != nil {
+			status.LastError = j.lastError.Error()
+		}
+
+		statuses = append(statuses, status)
+
+	}
+
+	sort.Slice(statuses, func(i, k int) bool { return statuses[i].Name < statuses[k].Name })
+
+	return statuses
+
+}
+
+//readScheduledJobsTable reads the enabled jobs in the jobs table, creating it if need be
+func readScheduledJobsTable() ([]ScheduledJob, error) {
+
+	//Establish a temporary connection as the super user
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(sqlToCreateScheduledJobsTable); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(sqlToSelectScheduledJobs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []ScheduledJob
+	for rows.Next() {
+		var j ScheduledJob
+		if err := rows.Scan(&j.Name, &j.Schedule, &j.SQL, &j.Task); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+
+}
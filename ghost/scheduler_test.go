@@ -0,0 +1,134 @@
+package ghost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseScheduledJob(t *testing.T) {
+
+	RegisterTask("test_digest", func(ctx context.Context) error { return nil })
+
+	testCases := []struct {
+		desc string
+		job  ScheduledJob
+		exp  string
+	}{
+		{"sql", ScheduledJob{Name: "cleanup", Schedule: "0 3 * * *", SQL: "DELETE FROM sessions"}, ""},
+		{"task", ScheduledJob{Name: "digest", Schedule: "@every 15m", Task: "test_digest"}, ""},
+		{"no name", ScheduledJob{Schedule: "@daily", SQL: "SELECT 1"}, "Scheduled jobs must have a name"},
+		{"bad schedule", ScheduledJob{Name: "x", Schedule: "* * *", SQL: "SELECT 1"}, "Job 'x' has an invalid schedule: Expected exactly 5 fields, found 3: * * *"},
+		{"nothing to do", ScheduledJob{Name: "x", Schedule: "@daily"}, "Job 'x' must have either sql or a task to run"},
+		{"both", ScheduledJob{Name: "x", Schedule: "@daily", SQL: "SELECT 1", Task: "test_digest"}, "Job 'x' must have either sql or a task to run"},
+		{"unknown task", ScheduledJob{Name: "x", Schedule: "@daily", Task: "nope"}, "Job 'x' runs task 'nope', which has not been registered"},
+	}
+
+	for _, c := range testCases {
+		var got string
+		if _, err := parseScheduledJob(c.job, jobSourceConfig); err != nil {
+			got = err.Error()
+		}
+		if got != c.exp {
+			TestErrorFatal(t, c.desc, got, c.exp)
+		}
+	}
+
+}
+
+func TestRunScheduledJob(t *testing.T) {
+
+	release := make(chan struct{})
+	RegisterTask("test_slow", func(ctx context.Context) error {
+		<-release
+		return errors.New("went wrong")
+	})
+
+	j, err := parseScheduledJob(ScheduledJob{Name: "slow", Schedule: "@hourly", Task: "test_slow"}, jobSourceConfig)
+	if err != nil {
+		TestErrorFatal(t, "Parse job", err.Error(), "")
+	}
+	jobScheduler = &scheduler{jobs: map[string]*scheduledJob{"slow": j}}
+	defer func() { jobScheduler = &scheduler{} }()
+
+	testCases := []struct {
+		desc, name string
+		exp        error
+	}{
+		{"Unknown job", "other", ErrJobNotFound},
+		{"Start job", "slow", nil},
+		{"Job already running", "slow", ErrJobRunning},
+	}
+
+	for _, c := range testCases {
+		if err := RunScheduledJob(c.name); err != c.exp {
+			TestErrorFatal(t, c.desc, errorString(err), errorString(c.exp))
+		}
+	}
+
+	if statuses := ScheduledJobs(); len(statuses) != 1 || !statuses[0].Running {
+		TestErrorFatal(t, "Job reported as running", "not running", "running")
+	}
+
+	//The scheduled run is skipped, rather than waiting, while the job is running
+	jobScheduler.run("slow")
+
+	close(release)
+	for ScheduledJobs()[0].Running {
+		time.Sleep(time.Millisecond)
+	}
+
+	status := ScheduledJobs()[0]
+	if status.LastRun == nil || status.LastError != "went wrong" || status.Source != jobSourceConfig {
+		TestErrorFatal(t, "Last run recorded", status.LastError, "went wrong")
+	}
+
+}
+
+func TestReloadWhileJobRunning(t *testing.T) {
+
+	release := make(chan struct{})
+	RegisterTask("test_reload", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	j, err := parseScheduledJob(ScheduledJob{Name: "reload", Schedule: "@hourly", Task: "test_reload"}, jobSourceConfig)
+	if err != nil {
+		TestErrorFatal(t, "Parse job", err.Error(), "")
+	}
+	jobScheduler = &scheduler{jobs: map[string]*scheduledJob{"reload": j}}
+	defer func() {
+		jobScheduler.stop()
+		jobScheduler = &scheduler{}
+	}()
+
+	if err := RunScheduledJob("reload"); err != nil {
+		TestErrorFatal(t, "Start job", err.Error(), "<nil>")
+	}
+
+	//Reloading while the job runs changes its schedule, and it is still running
+	jobScheduler.load([]ScheduledJob{{Name: "reload", Schedule: "@daily", Task: "test_reload"}})
+	if status := ScheduledJobs()[0]; !status.Running || status.Schedule != "@daily" {
+		TestErrorFatal(t, "Job reloaded while running", fmt.Sprint(status.Running, " ", status.Schedule), "true @daily")
+	}
+
+	//Once the run finishes, the job can run again
+	close(release)
+	for ScheduledJobs()[0].Running {
+		time.Sleep(time.Millisecond)
+	}
+	if err := RunScheduledJob("reload"); err != nil {
+		TestErrorFatal(t, "Job runs again after the reload", err.Error(), "<nil>")
+	}
+
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+)
+
+const (
+	//SecretEnvVar can hold the signing secret instead of the --secret flag
+	SecretEnvVar = "GHOST_SECRET"
+
+	//secretBytes is the amount of randomness in a generated secret: 256 bits, as HS256 needs
+	secretBytes = 32
+)
+
+//configSecret matches the secret in a config file, so that it can be replaced
+var configSecret = regexp.MustCompile(`("secret"\s*:\s*)"[^"]*"`)
+
+//GenerateSecret returns a random secret for signing JWTs
+func GenerateSecret() (string, error) {
+
+	b := make([]byte, secretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+
+}
+
+//GenerateRSAKeys returns a new RSA private key and its public key, PEM encoded, for signing JWTs with RS256
+func GenerateRSAKeys(bits int) ([]byte, []byte, error) {
+
+	if bits < 2048 {
+		return nil, nil, errors.New("RSA keys must be at least 2048 bits")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}),
+		nil
+
+}
+
+//WriteSecretToConfig sets the secret in a config file, leaving the rest of the file as it is
+func WriteSecretToConfig(configFile, secret string) error {
+
+	b, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+
+	b, err = setConfigSecret(b, secret)
+	if err != nil {
+		return err
+	}
+
+	//The file now holds a secret, so only its owner may read it
+	return ioutil.WriteFile(configFile, b, 0600)
+
+}
+
+//readConfigSecret returns the secret in a JSON config, if it has one
+func readConfigSecret(config []byte) (string, bool) {
+
+	var c struct {
+		Secret string `json:"secret"`
+	}
+	if err := json.Unmarshal(config, &c); err != nil || c.Secret == "" {
+		return "", false
+	}
+
+	return c.Secret, true
+
+}
+
+//setConfigSecret replaces the secret in a JSON config, or adds it at the top
+func setConfigSecret(config []byte, secret string) ([]byte, error) {
+
+	if loc := configSecret.FindSubmatchIndex(config); loc != nil {
+		return append(append(append([]byte{}, config[:loc[3]]...), fmt.Sprintf("%q", secret)...), config[loc[1]:]...), nil
+	}
+
+	re := regexp.MustCompile(`^\s*\{`)
+	loc := re.FindIndex(config)
+	if loc == nil {
+		return nil, errors.New("Config file is not a JSON object")
+	}
+
+	return append(append(append([]byte{}, config[:loc[1]]...), fmt.Sprintf("\n\t\"secret\": %q,", secret)...), config[loc[1]:]...), nil
+
+}
@@ -0,0 +1,31 @@
+package ghost
+
+import (
+	"testing"
+)
+
+func TestSetConfigSecret(t *testing.T) {
+
+	testCases := []struct {
+		description, config, exp string
+	}{
+		{"Added at the top", "{\n\t\"apiPort\": \"3000\"\n}", "{\n\t\"secret\": \"abc\",\n\t\"apiPort\": \"3000\"\n}"},
+		{"Replaced", "{\n\t\"secret\": \"old\",\n\t\"apiPort\": \"3000\"\n}", "{\n\t\"secret\": \"abc\",\n\t\"apiPort\": \"3000\"\n}"},
+	}
+
+	for _, c := range testCases {
+		got, err := setConfigSecret([]byte(c.config), "abc")
+		if err != nil || string(got) != c.exp {
+			TestErrorFatal(t, c.description, string(got), c.exp)
+		}
+	}
+
+	if secret, ok := readConfigSecret([]byte(testCases[1].exp)); !ok || secret != "abc" {
+		TestErrorFatal(t, "Reading the secret back", secret, "abc")
+	}
+
+	if secret, _ := GenerateSecret(); len(secret) != 43 {
+		TestErrorFatal(t, "Generated secret length", secret, "43 characters")
+	}
+
+}
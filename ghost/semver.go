@@ -0,0 +1,147 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+//parseVersion parses a major.minor.patch version string.  A leading 'v' is allowed,
+//missing minor or patch numbers count as 0 and any pre-release or build suffix is ignored
+func parseVersion(version string) ([3]int, error) {
+
+	var parsed [3]int
+
+	v := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(v, "-+"); i != -1 {
+		v = v[:i]
+	}
+
+	parts := strings.Split(v, ".")
+	if v == "" || len(parts) > 3 {
+		return parsed, errors.New("Invalid version '" + version + "'")
+	}
+
+	for k, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, errors.New("Invalid version '" + version + "'")
+		}
+		parsed[k] = n
+	}
+
+	return parsed, nil
+
+}
+
+//CompareVersions returns -1, 0 or 1 depending on whether version a is lower than,
+//equal to or higher than version b
+func CompareVersions(a, b string) (int, error) {
+
+	va, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+
+	vb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for k := range va {
+		if va[k] < vb[k] {
+			return -1, nil
+		} else if va[k] > vb[k] {
+			return 1, nil
+		}
+	}
+
+	return 0, nil
+
+}
+
+//VersionSatisfies reports whether a version meets a constraint.  A constraint is one or
+//more space separated clauses which must all hold, each being a version optionally
+//prefixed by one of =, >, >=, <, <=, ^ (same major version) or ~ (same minor version).
+//An empty constraint or '*' matches any version
+func VersionSatisfies(version, constraint string) (bool, error) {
+
+	for _, clause := range strings.Fields(strings.Replace(constraint, ",", " ", -1)) {
+
+		if clause == "*" {
+			continue
+		}
+
+		ok, err := satisfiesClause(version, clause)
+		if err != nil || !ok {
+			return false, err
+		}
+
+	}
+
+	return true, nil
+
+}
+
+//satisfiesClause checks a version against a single constraint clause
+func satisfiesClause(version, clause string) (bool, error) {
+
+	//Longest operators first, so that '>=' isn't read as '>'
+	for _, op := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+
+		if !strings.HasPrefix(clause, op) {
+			continue
+		}
+
+		target := strings.TrimPrefix(clause, op)
+		c, err := CompareVersions(version, target)
+		if err != nil {
+			return false, err
+		}
+
+		switch op {
+		case ">=":
+			return c >= 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		case "<":
+			return c < 0, nil
+		case "=":
+			return c == 0, nil
+		}
+
+		//For ^ and ~ the version must be at least the target,
+		//and below the next breaking version
+		v, _ := parseVersion(version)
+		t, _ := parseVersion(target)
+		if c < 0 {
+			return false, nil
+		}
+		if op == "~" || t[0] == 0 {
+			return v[0] == t[0] && v[1] == t[1], nil
+		}
+		return v[0] == t[0], nil
+
+	}
+
+	//A bare version means exactly that version
+	c, err := CompareVersions(version, clause)
+	return c == 0, err
+
+}
@@ -17,10 +17,20 @@
 package ghost
 
 import (
+	"context"
+	"errors"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
 
 	"fmt"
 )
@@ -30,7 +40,7 @@ const sqlToSetServerRolePassword = `ALTER ROLE server NOINHERIT LOGIN PASSWORD '
 func init() {
 
 	ServeCmd.Flags().String("smtppw", "", "SMTP server password for outgoing mail")
-	ServeCmd.Flags().BoolP("demomode", "d", false, "Run server in demo mode")
+	ServeCmd.Flags().BoolP("demomode", "d", false, "Run server in demo mode, loading demo data for installed bundles")
 	ServeCmd.Flags().BoolP("debug", "b", false, "Run server in debug mode")
 	ServeCmd.Flags().StringP("secret", "s", "", "Secure secret for signing JWT")
 	ServeCmd.Flags().StringP("pgpw", "p", "", "Postgres superuser password")
@@ -38,6 +48,7 @@ func init() {
 	ServeCmd.Flags().BoolP("noprompt", "n", false, "Override prompt for confirmation")
 
 	viper.BindPFlags(ServeCmd.Flags())
+	viper.BindEnv("secret", SecretEnvVar)
 
 }
 
@@ -53,8 +64,42 @@ func serve(cmd *cobra.Command, args []string) error {
 
 	App.Setup(viper.GetString("configfile"))
 	preServe()
-	startServer()
-	return nil
+	reloadOnHangup()
+	if App.Config().WatchConfig {
+		watchConfig()
+	}
+	return startServer(context.Background())
+
+}
+
+//reloadOnHangup reloads the config whenever the process receives SIGHUP
+func reloadOnHangup() {
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		for range hangup {
+			Log("CONFIG", true, "SIGHUP received, reloading config", nil)
+			if err := App.ReloadConfig(); err != nil {
+				Log("CONFIG", false, "Could not reload config", err)
+			}
+		}
+	}()
+
+}
+
+//watchConfig reloads the config whenever the config file is saved
+func watchConfig() {
+
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		Log("CONFIG", true, "Config file changed, reloading", nil)
+		if err := App.ReloadConfig(); err != nil {
+			Log("CONFIG", false, "Could not reload config", err)
+		}
+	})
+	viper.WatchConfig()
+
 }
 
 //ActivatePackages is a hook for activating packages from main
@@ -62,39 +107,217 @@ var BeforeServe func()
 
 func preServe() {
 
+	if err := App.prepare(); err != nil {
+		LogFatal("SERVE", false, "Could not start the server", err)
+	}
+
+}
+
+//prepare gets everything the server needs ready: bundles, email, the database and routes
+func (a *Application) prepare() error {
+
+	//Refuse to start with malformed bundles, rather than have them partially work
+	if err := ValidateInstalledBundles(); err != nil {
+		return fmt.Errorf("Bundle validation failed: %s", err)
+	}
+
 	//Setup the email system if required
-	if App.Config.ActivateEmail {
-		App.MailServer.Setup()
+	if a.Config().ActivateEmail {
+		if err := a.SetupMailServer(); err != nil {
+			return err
+		}
+	}
+
+	//Rather than failing to start when Redis is down, cache in memory, which the change
+	//feed keeps in step with other servers
+	if cache, ok := a.Cache.(*redisCache); ok {
+		if err := cache.ping(); err != nil {
+			Log("CACHE", false, "Could not connect to Redis, caching in memory instead", err)
+			a.Cache = newMemoryCache()
+		} else {
+			Log("CACHE", true, "Caching query results in Redis", nil)
+		}
 	}
 
-	//Check to make sure a secret has been provided
+	//Check to make sure a secret has been provided, with --secret, GHOST_SECRET or in the config
 	//No default provided as a security measure, server will exit of nothing provided
 	if viper.GetString("secret") == "" {
-		LogFatal("SERVE", false, "No signing secret provided", nil)
+		return errors.New("No signing secret provided. Use 'ghost gensecret' to make one")
 	}
 
 	//Establish a temporary connection as the super user
-	dbTemp := SuperUserDBConfig.ReturnDBConnection("")
+	dbTemp, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return err
+	}
+	defer dbTemp.Close()
 
 	//Generate a random server password, set it and get out
 	serverPW := RandomString(16)
-	_, err := dbTemp.Exec(fmt.Sprintf(sqlToSetServerRolePassword, serverPW))
-	if err != nil {
-		LogFatal("SERVE", false, "Error setting server role password:", err)
+	if _, err := dbTemp.Exec(fmt.Sprintf(sqlToSetServerRolePassword, serverPW)); err != nil {
+		return fmt.Errorf("Error setting server role password: %s", err)
+	}
+
+	//Find out which bundles are switched off
+	if err := loadBundleStates(dbTemp); err != nil {
+		return fmt.Errorf("Error reading the bundle registry: %s", err)
 	}
 
-	dbTemp.Close()
+	//Make sure the job queue is there for handlers to add to
+	if err := setupJobQueue(dbTemp); err != nil {
+		return fmt.Errorf("Error setting up the job queue: %s", err)
+	}
+
+	//Changes made outside the Store can be sent to subscribers with this trigger function
+	if err := setupChangeFeed(dbTemp); err != nil {
+		return fmt.Errorf("Error setting up the change feed: %s", err)
+	}
+
+	if err := setupAuditTrail(dbTemp); err != nil {
+		return fmt.Errorf("Error setting up the audit trail: %s", err)
+	}
+
+	//In demo mode, installed bundles get their demo data the first time the server starts
+	if viper.GetBool("demomode") {
+		if err := loadMissingDemoData(dbTemp); err != nil {
+			return fmt.Errorf("Error loading demo data: %s", err)
+		}
+	}
 
 	//Establish a permanent connection
-	App.DB = ServerUserDBConfig.ReturnDBConnection(serverPW)
+	if a.DB, err = ServerUserDBConfig.TryDBConnection(serverPW); err != nil {
+		return err
+	}
+	Log("DB", true, "Connected to Postgres as the server role", nil)
 
-	BeforeServe()
+	setCoreRoutes()
+
+	//Bundles can add their own logic and routes through hooks
+	if err := loadBundlePlugins(); err != nil {
+		return fmt.Errorf("Error loading bundle plugins: %s", err)
+	}
+	if err := runServeHooks(); err != nil {
+		return fmt.Errorf("Error running bundle hooks: %s", err)
+	}
+
+	if BeforeServe != nil {
+		BeforeServe()
+	}
+
+	//Everything is loaded, so the server can report itself ready
+	atomic.StoreInt32(&bundlesLoaded, 1)
+	return nil
 
 }
 
-func startServer() {
+//startServer runs the server, and the HTTP redirect server if there is one, until one
+//of them fails or the context is done.  If one fails, the others are closed and the error
+//is returned.  When the context is done they are shut down gracefully, letting requests
+//in progress finish, and nil is returned
+func startServer(parent context.Context) error {
+
+	accessLog, err := accessLogWriter(*App.Config())
+	if err != nil {
+		return fmt.Errorf("Could not open access log: %s", err)
+	}
+
+	server := newServer(withAccessLog(accessLog, App.Config().AccessLogFormat, withBasePath(App.Config().BasePath, App.Router)), *App.Config())
+
+	redirect, err := configureTLS(server)
+	if err != nil {
+		return fmt.Errorf("Could not set up TLS: %s", err)
+	}
+	configureHTTP2(server, App.Config().HTTP2)
+
+	//Under systemd socket activation the sockets are already open, otherwise we open our own
+	activated, err := systemdListeners()
+	if err != nil {
+		return fmt.Errorf("Could not use the sockets passed in by systemd: %s", err)
+	}
+
+	var l net.Listener
+	if len(activated) > 0 {
+		l = activated[0]
+	} else if l, err = listen(App.Config().ApiPort); err != nil {
+		return fmt.Errorf("Could not listen: %s", err)
+	}
+
+	servers := []*http.Server{server}
+	g, ctx := errgroup.WithContext(parent)
+
+	if server.TLSConfig == nil {
+
+		//Plain HTTP with no TLS configured
+		g.Go(func() error {
+			Log("SERVE", true, "Server started on "+describeListener(l), nil)
+			return server.Serve(l)
+		})
+
+	} else {
+
+		g.Go(func() error {
+			Log("SERVE", true, "Server started with TLS on "+describeListener(l), nil)
+			return server.ServeTLS(l, "", "")
+		})
+
+		if len(activated) > 1 {
+
+			redirectServer := newServer(redirect, *App.Config())
+			servers = append(servers, redirectServer)
+			g.Go(func() error {
+				Log("SERVE", true, "Redirecting HTTP to HTTPS from "+describeListener(activated[1]), nil)
+				if err := redirectServer.Serve(activated[1]); err != http.ErrServerClosed {
+					return fmt.Errorf("HTTP redirect server stopped: %s", err)
+				}
+				return nil
+			})
+
+		} else if App.Config().HTTPRedirectPort != "" {
 
-	Log("SERVE", true, "Server started on port "+viper.GetString("apiPort"), nil)
-	http.ListenAndServe(":"+viper.GetString("apiPort"), App.Router)
+			//The redirect server is public by nature, so it only follows a TCP bind address
+			_, address := listenAddress(App.Config().BindAddress, App.Config().HTTPRedirectPort)
+			if strings.HasPrefix(App.Config().BindAddress, unixSocketPrefix) {
+				address = ":" + App.Config().HTTPRedirectPort
+			}
+
+			redirectServer := newServer(redirect, *App.Config())
+			redirectServer.Addr = address
+			servers = append(servers, redirectServer)
+			g.Go(func() error {
+				Log("SERVE", true, "Redirecting HTTP to HTTPS from "+address, nil)
+				if err := redirectServer.ListenAndServe(); err != http.ErrServerClosed {
+					return fmt.Errorf("HTTP redirect server stopped: %s", err)
+				}
+				return nil
+			})
+
+		}
+
+	}
+
+	notifySystemd(ctx)
+	startScheduler(ctx)
+	startJobWorkers(ctx)
+	startChangeFeed(ctx)
+
+	//If any server stops, stop the rest so that the process exits rather than limping on
+	g.Go(func() error {
+		<-ctx.Done()
+		if parent.Err() != nil {
+			shutdownServers(servers)
+			return nil
+		}
+		for _, s := range servers {
+			s.Close()
+		}
+		return nil
+	})
+
+	err = g.Wait()
+	if parent.Err() != nil && err == http.ErrServerClosed {
+		err = nil
+	}
+	Log("SERVE", err == nil, "Server stopped", err)
+	return err
 
 }
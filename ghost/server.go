@@ -0,0 +1,83 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+)
+
+//newServer returns an http.Server for the handler, with the timeouts, header size limit
+//and HTTP/2 setting from the config.  Timeouts of 0 mean no timeout
+func newServer(handler http.Handler, c config) *http.Server {
+
+	return &http.Server{
+		Handler:           handler,
+		ReadTimeout:       seconds(c.ServerReadTimeout),
+		ReadHeaderTimeout: seconds(c.ServerReadHeaderTimeout),
+		WriteTimeout:      seconds(c.ServerWriteTimeout),
+		IdleTimeout:       seconds(c.ServerIdleTimeout),
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+	}
+
+}
+
+//configureHTTP2 switches HTTP/2 off if the config says so.  It must be called once the
+//TLS config is in place, since HTTP/2 is only offered over TLS
+func configureHTTP2(server *http.Server, enabled bool) {
+
+	if enabled {
+		return
+	}
+
+	//A non-nil map stops net/http from setting up HTTP/2 itself
+	server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+
+	//and the protocol must not be offered to clients either
+	if server.TLSConfig != nil {
+		var protos []string
+		for _, p := range server.TLSConfig.NextProtos {
+			if p != "h2" {
+				protos = append(protos, p)
+			}
+		}
+		server.TLSConfig.NextProtos = protos
+	}
+
+}
+
+//shutdownTimeout is how long requests in progress get to finish when the server is shut down
+const shutdownTimeout = 30 * time.Second
+
+//shutdownServers stops the servers accepting requests and waits for those in progress
+//to finish, closing any connections still open after the shutdown timeout
+func shutdownServers(servers []*http.Server) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			s.Close()
+		}
+	}
+
+}
+
+func seconds(s int) time.Duration {
+	return time.Duration(s) * time.Second
+}
@@ -0,0 +1,34 @@
+package ghost
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestConfigureHTTP2(t *testing.T) {
+
+	testCases := []struct {
+		description string
+		enabled     bool
+		exp         string
+		disabled    bool
+	}{
+		{"HTTP/2 on", true, "h2 http/1.1 acme-tls/1", false},
+		{"HTTP/2 off", false, "http/1.1 acme-tls/1", true},
+	}
+
+	for _, c := range testCases {
+		server := newServer(http.NotFoundHandler(), Defaults)
+		server.TLSConfig = &tls.Config{NextProtos: []string{"h2", "http/1.1", "acme-tls/1"}}
+		configureHTTP2(server, c.enabled)
+		if got := strings.Join(server.TLSConfig.NextProtos, " "); got != c.exp {
+			TestErrorFatal(t, c.description, got, c.exp)
+		}
+		if (server.TLSNextProto != nil) != c.disabled {
+			TestErrorFatal(t, c.description, "HTTP/2 left to net/http", "HTTP/2 switched off")
+		}
+	}
+
+}
@@ -0,0 +1,173 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package ghost
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+//SetupAnswers are the answers to the questions 'ghost setup' asks, which can also be given
+//in a JSON file to set up without any prompts.  The passwords are used during setup but,
+//like the secret flags, never written to the config file
+type SetupAnswers struct {
+	PgServer      string `json:"pgServer"`
+	PgPort        string `json:"pgPort"`
+	PgDBName      string `json:"pgDBName"`
+	PgSuperUser   string `json:"pgSuperUser"`
+	PgPassword    string `json:"pgPassword"`
+	Host          string `json:"host"`
+	ApiPort       string `json:"apiPort"`
+	ActivateEmail bool   `json:"activateEmail"`
+	SmtpHost      string `json:"smtpHost"`
+	SmtpPort      string `json:"smtpPort"`
+	SmtpUserName  string `json:"smtpUserName"`
+	SmtpPassword  string `json:"smtpPassword"`
+	SmtpFrom      string `json:"smtpFrom"`
+	AdminEmail    string `json:"adminEmail"`
+}
+
+//setupSetting is an answer, named by its setting in the config, for checking
+type setupSetting struct {
+	setting, value string
+}
+
+//DefaultSetupAnswers are the answers suggested by the prompts, taken from the default config
+func DefaultSetupAnswers() SetupAnswers {
+
+	return SetupAnswers{
+		PgServer:     Defaults.PgServer,
+		PgPort:       Defaults.PgPort,
+		PgDBName:     Defaults.PgDBName,
+		PgSuperUser:  Defaults.PgSuperUser,
+		Host:         Defaults.Host,
+		ApiPort:      Defaults.ApiPort,
+		SmtpHost:     Defaults.SmtpHost,
+		SmtpPort:     Defaults.SmtpPort,
+		SmtpUserName: Defaults.SmtpUserName,
+		SmtpFrom:     Defaults.SmtpFrom,
+	}
+
+}
+
+//ReadSetupAnswers reads answers from a JSON file.  Any left out take their default
+func ReadSetupAnswers(fs afero.Fs, fileName string) (SetupAnswers, error) {
+
+	answers := DefaultSetupAnswers()
+
+	b, err := afero.ReadFile(fs, fileName)
+	if err != nil {
+		return answers, err
+	}
+	if err := json.Unmarshal(b, &answers); err != nil {
+		return answers, errors.New("Could not read answers from " + fileName + ": " + err.Error())
+	}
+
+	return answers, answers.Validate()
+
+}
+
+//Validate checks that the answers are complete enough to set up with
+func (s SetupAnswers) Validate() error {
+
+	required := []setupSetting{
+		{"pgServer", s.PgServer},
+		{"pgDBName", s.PgDBName},
+		{"pgSuperUser", s.PgSuperUser},
+		{"host", s.Host},
+	}
+	if s.ActivateEmail {
+		required = append(required, setupSetting{"smtpHost", s.SmtpHost})
+	}
+	for _, r := range required {
+		if strings.TrimSpace(r.value) == "" {
+			return errors.New(r.setting + " must be given")
+		}
+	}
+
+	ports := []setupSetting{{"pgPort", s.PgPort}, {"apiPort", s.ApiPort}}
+	if s.ActivateEmail {
+		ports = append(ports, setupSetting{"smtpPort", s.SmtpPort})
+	}
+	for _, p := range ports {
+		if n, err := strconv.Atoi(p.value); err != nil || n < 1 || n > 65535 {
+			return errors.New("Invalid " + p.setting + " '" + p.value + "'. Use a port number")
+		}
+	}
+
+	if !strings.Contains(s.AdminEmail, "@") {
+		return errors.New("adminEmail must be the first admin's email address")
+	}
+
+	return nil
+
+}
+
+//Config returns the default config with the answers applied
+func (s SetupAnswers) Config() Config {
+
+	c := Defaults
+	c.PgServer = s.PgServer
+	c.PgPort = s.PgPort
+	c.PgDBName = s.PgDBName
+	c.PgSuperUser = s.PgSuperUser
+	c.Host = s.Host
+	c.ApiPort = s.ApiPort
+	c.ActivateEmail = s.ActivateEmail
+	if s.ActivateEmail {
+		c.SmtpHost = s.SmtpHost
+		c.SmtpPort = s.SmtpPort
+		c.SmtpUserName = s.SmtpUserName
+		c.SmtpFrom = s.SmtpFrom
+	}
+
+	return c
+
+}
+
+//Apply makes the answers the application's config, along with the passwords, so that
+//each step of setup can be checked before anything is written
+func (s SetupAnswers) Apply() {
+
+	App.SetConfig(s.Config())
+	viper.Set("pgpw", s.PgPassword)
+	viper.Set("smtpPW", s.SmtpPassword)
+
+	SuperUserDBConfig.SetupConnection(true)
+	ServerUserDBConfig.SetupConnection(false)
+
+}
+
+//VerifyDatabaseSetup returns an error if any of the built in roles and tables are missing
+func VerifyDatabaseSetup(db *sql.DB) error {
+
+	missing, err := missingBuiltIns(db)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return errors.New("Missing " + strings.Join(missing, " and "))
+	}
+
+	return nil
+
+}
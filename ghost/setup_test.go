@@ -0,0 +1,44 @@
+package ghost
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestReadSetupAnswers(t *testing.T) {
+
+	testCases := []struct {
+		desc, answers string
+		valid         bool
+		pgDBName      string
+	}{
+		{"Defaults filled in", `{"adminEmail": "me@example.com"}`, true, "testdb"},
+		{"Answers given", `{"pgDBName": "shop", "pgPassword": "pw", "adminEmail": "me@example.com"}`, true, "shop"},
+		{"No admin", `{"pgDBName": "shop"}`, false, "shop"},
+		{"Bad port", `{"pgPort": "postgres", "adminEmail": "me@example.com"}`, false, "testdb"},
+		{"Email without a server", `{"activateEmail": true, "smtpHost": "", "adminEmail": "me@example.com"}`, false, "testdb"},
+		{"Email", `{"activateEmail": true, "smtpHost": "mail", "smtpPort": "587", "adminEmail": "me@example.com"}`, true, "testdb"},
+		{"Not JSON", `pgDBName: shop`, false, "testdb"},
+	}
+
+	for _, c := range testCases {
+
+		fs := afero.NewMemMapFs()
+		afero.WriteFile(fs, "answers.json", []byte(c.answers), 0644)
+
+		answers, err := ReadSetupAnswers(fs, "answers.json")
+		if (err == nil) != c.valid {
+			TestErrorFatal(t, c.desc, fmt.Sprint(err), fmt.Sprint("valid: ", c.valid))
+		}
+		if answers.PgDBName != c.pgDBName {
+			TestErrorFatal(t, c.desc, answers.PgDBName, c.pgDBName)
+		}
+		if c.valid && answers.Config().PgDBName != c.pgDBName {
+			TestErrorFatal(t, c.desc+" config", answers.Config().PgDBName, c.pgDBName)
+		}
+
+	}
+
+}
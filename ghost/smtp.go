@@ -0,0 +1,195 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+//SMTP connection security: STARTTLS upgrades a plain connection (usually on port 587 or 25),
+//implicit TLS connects over TLS from the start (usually port 465).  When not set,
+//STARTTLS is used if the server offers it, as net/smtp does
+const (
+	smtpTLSStartTLS = "starttls"
+	smtpTLSImplicit = "tls"
+	smtpTLSNone     = "none"
+)
+
+//SMTP authentication mechanisms
+const (
+	smtpAuthPlain   = "plain"
+	smtpAuthLogin   = "login"
+	smtpAuthCRAMMD5 = "cram-md5"
+	smtpAuthNone    = "none"
+)
+
+//validateSMTPSettings checks the TLS mode and authentication mechanism are ones we know
+func validateSMTPSettings(tlsMode, authMechanism string) error {
+
+	switch tlsMode {
+	case "", smtpTLSStartTLS, smtpTLSImplicit, smtpTLSNone:
+	default:
+		return errors.New("Unknown smtpTLS '" + tlsMode + "'. Use 'starttls', 'tls' or 'none'")
+	}
+
+	switch authMechanism {
+	case "", smtpAuthPlain, smtpAuthLogin, smtpAuthCRAMMD5, smtpAuthNone:
+	default:
+		return errors.New("Unknown smtpAuth '" + authMechanism + "'. Use 'plain', 'login', 'cram-md5' or 'none'")
+	}
+
+	return nil
+
+}
+
+//dial connects to the SMTP server, secures the connection and authenticates.
+//The whole conversation must finish within the timeout
+func (s smtpServer) dial() (*smtp.Client, error) {
+
+	address := net.JoinHostPort(s.host, s.port)
+	tlsConfig := &tls.Config{ServerName: s.host, InsecureSkipVerify: s.skipVerify}
+	dialer := &net.Dialer{Timeout: s.timeout}
+
+	var conn net.Conn
+	var err error
+	if s.tlsMode == smtpTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+		err = s.step("Connect to "+address+" over TLS", err)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+		err = s.step("Connect to "+address, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err := s.step("Server greeting", err); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := s.step("EHLO", c.Hello("localhost")); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if ok, mechanisms := c.Extension("AUTH"); ok {
+		s.step("Server offers AUTH "+mechanisms, nil)
+	}
+
+	if s.tlsMode == smtpTLSStartTLS || s.tlsMode == "" {
+		offered, _ := c.Extension("STARTTLS")
+		if !offered && s.tlsMode == smtpTLSStartTLS {
+			c.Close()
+			return nil, s.step("STARTTLS", errors.New("SMTP server does not support STARTTLS. Set smtpTLS to 'tls' or 'none'"))
+		}
+		if offered {
+			if err := s.step("STARTTLS", c.StartTLS(tlsConfig)); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+	}
+
+	if auth := s.auth(); auth != nil {
+		mechanism := s.authMechanism
+		if mechanism == "" {
+			mechanism = smtpAuthPlain
+		}
+		if err := s.step("AUTH "+strings.ToUpper(mechanism)+" as "+s.userName, c.Auth(auth)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+
+}
+
+//step passes a step of the conversation with the SMTP server, and how it went, to the trace
+//if there is one.  It returns the step's error, so that it can wrap the call it describes
+func (s smtpServer) step(description string, err error) error {
+
+	if s.trace == nil {
+		return err
+	}
+
+	if err != nil {
+		s.trace(description + ": " + err.Error())
+	} else {
+		s.trace(description + ": OK")
+	}
+
+	return err
+
+}
+
+//auth returns the configured authentication mechanism, or nil for none
+func (s smtpServer) auth() smtp.Auth {
+
+	switch s.authMechanism {
+	case smtpAuthLogin:
+		return loginAuth{s.userName, s.password}
+	case smtpAuthCRAMMD5:
+		return smtp.CRAMMD5Auth(s.userName, s.password)
+	case smtpAuthNone:
+		return nil
+	default:
+		return smtp.PlainAuth("", s.userName, s.password, s.host)
+	}
+
+}
+
+//loginAuth is the LOGIN mechanism, which is not in net/smtp but is all that
+//some servers (e.g. older Exchange servers) offer.  Like PLAIN, it sends the
+//password as it is, so it is only used over TLS
+type loginAuth struct {
+	userName, password string
+}
+
+func (a loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+
+	if !server.TLS {
+		return "", nil, errors.New("LOGIN authentication needs an encrypted connection")
+	}
+	return "LOGIN", nil, nil
+
+}
+
+func (a loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+
+	if !more {
+		return nil, nil
+	}
+
+	switch string(fromServer) {
+	case "Username:":
+		return []byte(a.userName), nil
+	case "Password:":
+		return []byte(a.password), nil
+	}
+
+	return nil, errors.New("Unexpected LOGIN challenge from SMTP server: " + string(fromServer))
+
+}
@@ -0,0 +1,53 @@
+package ghost
+
+import (
+	"net/smtp"
+	"strconv"
+	"testing"
+)
+
+func TestValidateSMTPSettings(t *testing.T) {
+
+	testCases := []struct {
+		tlsMode, auth string
+		valid         bool
+	}{
+		{"", "", true},
+		{"starttls", "plain", true},
+		{"tls", "login", true},
+		{"none", "none", true},
+		{"ssl", "plain", false},
+		{"tls", "xoauth2", false},
+	}
+
+	for _, c := range testCases {
+		if err := validateSMTPSettings(c.tlsMode, c.auth); (err == nil) != c.valid {
+			TestErrorFatal(t, c.tlsMode+"/"+c.auth, strconv.FormatBool(err == nil), strconv.FormatBool(c.valid))
+		}
+	}
+
+}
+
+func TestLoginAuth(t *testing.T) {
+
+	a := loginAuth{"info@example.com", "secret"}
+
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "smtp.example.com"}); err == nil {
+		TestErrorFatal(t, "Unencrypted connection", "allowed", "refused")
+	}
+
+	testCases := []struct {
+		challenge, exp string
+	}{
+		{"Username:", "info@example.com"},
+		{"Password:", "secret"},
+	}
+
+	for _, c := range testCases {
+		got, err := a.Next([]byte(c.challenge), true)
+		if err != nil || string(got) != c.exp {
+			TestErrorFatal(t, c.challenge, string(got), c.exp)
+		}
+	}
+
+}
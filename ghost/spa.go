@@ -0,0 +1,66 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/spf13/afero"
+)
+
+//SPAHandler serves a single page application from a folder on the app filesystem.
+//Files that exist are served as normal.  Any other request that doesn't look like
+//a request for an asset falls back to the folder's index.html, so that client-side
+//routing with the history API works when a deep link is opened or refreshed
+func SPAHandler(dir string) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		requested := path.Clean("/" + r.URL.Path)
+
+		//Existing files are served directly
+		if info, err := App.FileSystem.Stat(path.Join(dir, requested)); err == nil && !info.IsDir() {
+			http.FileServer(afero.NewHttpFs(App.FileSystem).Dir(dir)).ServeHTTP(w, r)
+			return
+		}
+
+		//Missing assets are a genuine 404 rather than the app shell
+		if path.Ext(requested) != "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		index, err := afero.ReadFile(App.FileSystem, path.Join(dir, "index.html"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		//The shell must always be revalidated so that new releases are picked up
+		w.Header().Set("Content-Type", ContentTypeHTML)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(index)
+
+	})
+}
+
+//StaticHandler serves the files in a folder on the app filesystem, without any fallback
+func StaticHandler(dir string) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.FileServer(afero.NewHttpFs(App.FileSystem).Dir(dir)).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,40 @@
+package ghost
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestSPAHandler(t *testing.T) {
+
+	App.FileSystem = afero.NewMemMapFs()
+	afero.WriteFile(App.FileSystem, "panel/index.html", []byte("shell"), 0644)
+	afero.WriteFile(App.FileSystem, "panel/app.js", []byte("script"), 0644)
+
+	testCases := []struct {
+		description, path string
+		expectedCode      int
+		expectedBody      string
+	}{
+		{"Existing file", "/app.js", http.StatusOK, "script"},
+		{"Root", "/", http.StatusOK, "shell"},
+		{"Deep link falls back to index", "/bundles/mybundle", http.StatusOK, "shell"},
+		{"Missing asset", "/missing.css", http.StatusNotFound, ""},
+	}
+
+	handler := SPAHandler("panel")
+	for _, c := range testCases {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", c.path, nil))
+		if rr.Code != c.expectedCode {
+			TestErrorFatal(t, c.description, http.StatusText(rr.Code), http.StatusText(c.expectedCode))
+		}
+		if c.expectedBody != "" && rr.Body.String() != c.expectedBody {
+			TestErrorFatal(t, c.description, rr.Body.String(), c.expectedBody)
+		}
+	}
+
+}
@@ -5,6 +5,12 @@ const (
 	SQLToFindUserByEmail = `SELECT id from users WHERE email = '%s';`
 	SQLToGetUsersRole    = `SELECT role from users WHERE id = '%s';`
 
+	//Bundle registry
+	//Columns added after the registry was introduced are added to existing registries here
+	SQLToCreateBundleRegistry = `CREATE TABLE IF NOT EXISTS public.ghost_bundles (name text PRIMARY KEY, version text NOT NULL, installed_at timestamptz NOT NULL DEFAULT now(), updated_at timestamptz NOT NULL DEFAULT now());
+	ALTER TABLE public.ghost_bundles ADD COLUMN IF NOT EXISTS demodata_loaded boolean NOT NULL DEFAULT false;
+	ALTER TABLE public.ghost_bundles ADD COLUMN IF NOT EXISTS enabled boolean NOT NULL DEFAULT true;`
+
 	//General
 	//NO SEMI COLONS AT THE END
 	SQLToSelectAllFieldsFrom = `SELECT * FROM %s.%s`
@@ -0,0 +1,161 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"database/sql"
+	"sort"
+
+	"github.com/spf13/afero"
+)
+
+const sqlToListBundleRegistry = `SELECT name, version, enabled FROM ghost_bundles ORDER BY name;`
+
+//BundleStatus describes a bundle in the bundles folder, the bundle registry, or both
+type BundleStatus struct {
+	Name string `json:"name"`
+	//OnDisk is true if the bundle is in the bundles folder
+	OnDisk bool `json:"onDisk"`
+	//Installed is true if the bundle is in the installed bundle list in the config
+	Installed bool `json:"installed"`
+	//InstalledVersion is the version in the bundle registry, blank if it isn't registered
+	InstalledVersion string `json:"installedVersion"`
+	//PackagedVersion is the version in the bundle's bundle.json
+	PackagedVersion string   `json:"packagedVersion"`
+	Enabled         bool     `json:"enabled"`
+	Tables          []string `json:"tables"`
+	//PendingMigrations are the migrations needed to bring the installed version up to date
+	PendingMigrations []string `json:"pendingMigrations"`
+	//Problem describes anything preventing the status from being worked out, e.g. an invalid manifest
+	Problem string `json:"problem,omitempty"`
+}
+
+//MigrationsUpToDate reports whether the installed bundle has no migrations waiting to run
+func (s BundleStatus) MigrationsUpToDate() bool {
+	return len(s.PendingMigrations) == 0
+}
+
+//registryEntry is a bundle's row in the bundle registry
+type registryEntry struct {
+	version string
+	enabled bool
+}
+
+//BundleStatuses returns the status of every bundle which is in the bundles folder,
+//the installed bundle list or the bundle registry, sorted by name
+func BundleStatuses() ([]BundleStatus, error) {
+
+	//Establish a temporary connection as the super user
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	registry, err := readBundleRegistry(db)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for name := range registry {
+		names[name] = true
+	}
+	for _, name := range InstalledBundles() {
+		names[name] = true
+	}
+	if folders, err := afero.ReadDir(App.FileSystem, "bundles"); err == nil {
+		for _, folder := range folders {
+			if folder.IsDir() {
+				names[folder.Name()] = true
+			}
+		}
+	}
+
+	var statuses []BundleStatus
+	for name := range names {
+		statuses = append(statuses, bundleStatus(name, registry))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	return statuses, nil
+
+}
+
+func readBundleRegistry(db *sql.DB) (map[string]registryEntry, error) {
+
+	if _, err := db.Exec(SQLToCreateBundleRegistry); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(sqlToListBundleRegistry)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	registry := map[string]registryEntry{}
+	for rows.Next() {
+		var name string
+		var entry registryEntry
+		if err := rows.Scan(&name, &entry.version, &entry.enabled); err != nil {
+			return nil, err
+		}
+		registry[name] = entry
+	}
+
+	return registry, rows.Err()
+
+}
+
+//bundleStatus works out the status of a bundle from the bundles folder, the config and the registry
+func bundleStatus(bundleName string, registry map[string]registryEntry) BundleStatus {
+
+	status := BundleStatus{Name: bundleName, Installed: IsBundleInstalled(bundleName)}
+
+	if entry, ok := registry[bundleName]; ok {
+		status.InstalledVersion = entry.version
+		status.Enabled = entry.enabled
+	}
+
+	if exists, _ := afero.DirExists(App.FileSystem, BundlePath(bundleName)); !exists {
+		return status
+	}
+	status.OnDisk = true
+
+	manifest, err := ReadBundleManifest(bundleName)
+	if err != nil {
+		status.Problem = err.Error()
+		return status
+	}
+	status.PackagedVersion = manifest.Version
+	status.Tables = manifest.Tables
+
+	if status.InstalledVersion == "" {
+		return status
+	}
+
+	migrations, err := bundleMigrations(bundleName)
+	if err != nil {
+		status.Problem = err.Error()
+		return status
+	}
+	for _, m := range pendingMigrations(migrations, status.InstalledVersion, status.PackagedVersion) {
+		status.PendingMigrations = append(status.PendingMigrations, m.File)
+	}
+
+	return status
+
+}
@@ -0,0 +1,46 @@
+package ghost
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestBundleStatus(t *testing.T) {
+
+	fileSystem := App.FileSystem
+	defer changeConfig(func(c *config) { c.BundlesInstalled = Bundles{"shop", "blog"} })()
+	defer func() { App.FileSystem = fileSystem }()
+
+	App.FileSystem = afero.NewMemMapFs()
+	afero.WriteFile(App.FileSystem, "bundles/shop/bundle.json", []byte(`{"version": "1.2.0", "tables": ["products"]}`), 0644)
+	afero.WriteFile(App.FileSystem, "bundles/shop/migrations/1.1.0.sql", nil, 0644)
+	afero.WriteFile(App.FileSystem, "bundles/shop/migrations/1.2.0.sql", nil, 0644)
+	afero.WriteFile(App.FileSystem, "bundles/media/bundle.json", []byte(`{"version": "2.0.0"}`), 0644)
+
+	registry := map[string]registryEntry{
+		"shop": {"1.1.0", true},
+		"blog": {"1.0.0", false},
+	}
+
+	testCases := []struct {
+		bundle, expected string
+	}{
+		{"shop", "disk:true installed:true 1.1.0->1.2.0 enabled:true tables:products pending:1"},
+		{"blog", "disk:false installed:true 1.0.0-> enabled:false tables: pending:0"},
+		{"media", "disk:true installed:false ->2.0.0 enabled:false tables: pending:0"},
+	}
+
+	for _, c := range testCases {
+		s := bundleStatus(c.bundle, registry)
+		got := "disk:" + boolString(s.OnDisk) + " installed:" + boolString(s.Installed) +
+			" " + s.InstalledVersion + "->" + s.PackagedVersion + " enabled:" + boolString(s.Enabled) +
+			" tables:" + strings.Join(s.Tables, ",") + " pending:" + strconv.Itoa(len(s.PendingMigrations))
+		if got != c.expected {
+			TestErrorFatal(t, c.bundle, got, c.expected)
+		}
+	}
+
+}
@@ -0,0 +1,254 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+//Places files can be stored
+const (
+	storageLocal  = "local"
+	storageMemory = "memory"
+	storageS3     = "s3"
+	storageGCS    = "gcs"
+)
+
+//ErrFileNotFound is returned when a stored file doesn't exist
+var ErrFileNotFound = errors.New("File not found")
+
+//Storage is where the application keeps the files it is given or generates - uploads,
+//images, exports and so on.  It is set up from the storage settings, so that the same
+//code works with local disk during development and a bucket in a cloud deployment
+//without a persistent file system.  Names are slash separated paths, e.g. products/1.jpg.
+//Bundles themselves - their SQL, templates and shipped assets - are deployed with the
+//server and stay in App.FileSystem
+type Storage interface {
+	//Put saves a file, replacing any with the same name
+	Put(ctx context.Context, name string, r io.Reader, contentType string) error
+	//Get opens a file for reading, returning ErrFileNotFound if there isn't one.  Close it when done
+	Get(ctx context.Context, name string) (io.ReadCloser, StoredFile, error)
+	//Delete removes a file.  Deleting a file which doesn't exist isn't an error
+	Delete(ctx context.Context, name string) error
+	//List returns the files whose names start with the prefix, in name order
+	List(ctx context.Context, prefix string) ([]StoredFile, error)
+}
+
+//StoredFile describes a file in storage
+type StoredFile struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"contentType"`
+	Modified    time.Time `json:"modified"`
+}
+
+//newStorage sets up the storage described by the config
+func newStorage(c config) (Storage, error) {
+
+	switch c.Storage {
+
+	case "", storageLocal:
+		dir := c.StorageDir
+		if dir == "" {
+			dir = Defaults.StorageDir
+		}
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+		return fsStorage{afero.NewBasePathFs(afero.NewOsFs(), dir)}, nil
+
+	case storageMemory:
+		return fsStorage{afero.NewMemMapFs()}, nil
+
+	case storageS3, storageGCS:
+		if c.StorageBucket == "" {
+			return nil, errors.New("storageBucket must be set to store files in " + c.Storage)
+		}
+		accessKey, secretKey := storageAccessKeys(c.Storage)
+		s, err := newS3Storage(c, accessKey, secretKey)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+
+	}
+
+	return nil, errors.New("Unknown storage '" + c.Storage + "'. Use local, memory, s3 or gcs")
+
+}
+
+//storageAccessKeys returns the keys for a storage bucket.  Like the SMTP password, they
+//are kept out of the config struct, so they are never written back to the config file.
+//For S3 they can also come from the usual AWS environment variables
+func storageAccessKeys(storage string) (accessKey, secretKey string) {
+
+	accessKey = viper.GetString("storageAccessKey")
+	secretKey = viper.GetString("storageSecretKey")
+
+	if storage == storageS3 && accessKey == "" && secretKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	return accessKey, secretKey
+
+}
+
+//cleanStorageName checks a file name, returning it without any leading slash
+func cleanStorageName(name string) (string, error) {
+
+	cleaned := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if cleaned == "" || strings.HasSuffix(name, "/") || cleaned != strings.TrimPrefix(name, "/") {
+		return "", errors.New("Invalid file name '" + name + "'")
+	}
+
+	return cleaned, nil
+
+}
+
+//storageContentType guesses the content type of a file from its extension
+func storageContentType(name string) string {
+
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType
+	}
+
+	return "application/octet-stream"
+
+}
+
+//fsStorage keeps files on an afero file system: a folder on local disk, or in memory
+type fsStorage struct {
+	fs afero.Fs
+}
+
+func (s fsStorage) Put(ctx context.Context, name string, r io.Reader, contentType string) error {
+
+	name, err := cleanStorageName(name)
+	if err != nil {
+		return err
+	}
+
+	//Names are rooted, since the in memory file system treats relative paths as different files
+	name = "/" + name
+	if err := s.fs.MkdirAll(path.Dir(name), os.ModePerm); err != nil {
+		return err
+	}
+
+	//Write to a temporary file first, so that a failed upload doesn't leave half a file
+	f, err := afero.TempFile(s.fs, path.Dir(name), "."+path.Base(name))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = s.fs.Rename(f.Name(), name)
+	}
+	if err != nil {
+		s.fs.Remove(f.Name())
+	}
+
+	return err
+
+}
+
+func (s fsStorage) Get(ctx context.Context, name string) (io.ReadCloser, StoredFile, error) {
+
+	name, err := cleanStorageName(name)
+	if err != nil {
+		return nil, StoredFile{}, err
+	}
+
+	f, err := s.fs.Open("/" + name)
+	if os.IsNotExist(err) {
+		return nil, StoredFile{}, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, StoredFile{}, err
+	}
+
+	info, err := f.Stat()
+	if err == nil && info.IsDir() {
+		err = ErrFileNotFound
+	}
+	if err != nil {
+		f.Close()
+		return nil, StoredFile{}, err
+	}
+
+	return f, StoredFile{name, info.Size(), storageContentType(name), info.ModTime()}, nil
+
+}
+
+func (s fsStorage) Delete(ctx context.Context, name string) error {
+
+	name, err := cleanStorageName(name)
+	if err != nil {
+		return err
+	}
+
+	if err := s.fs.Remove("/" + name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+
+}
+
+func (s fsStorage) List(ctx context.Context, prefix string) ([]StoredFile, error) {
+
+	prefix = strings.TrimPrefix(prefix, "/")
+
+	//Only walk the folders which can hold files with the prefix
+	root := "/"
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		root = "/" + prefix[:i]
+	}
+
+	files := []StoredFile{}
+	err := afero.Walk(s.fs, root, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(p, "/")
+		if info.IsDir() || !strings.HasPrefix(name, prefix) || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		files = append(files, StoredFile{name, info.Size(), storageContentType(name), info.ModTime()})
+		return nil
+	})
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, err
+
+}
@@ -14,13 +14,19 @@ func (s store) Execute(q *Query) (string, error) {
 	}
 
 	//Caching case
-	//Return the cached result if there is a cache key present
-	//AND there is a result from the cache
-	if q.cacheKey != "" {
-		cacheResult, ok := App.Cache.Get(q.cacheKey)
+	//Return the cached result if there is a cache key present, the table's
+	//results are cached AND there is a result from the cache
+	table := q.cacheTable()
+	ttl := cacheTTL(*App.Config(), table, q.CacheExpiry)
+	useCache := q.cacheKey != "" && ttl > 0
+	var slot CacheSlot
+	if useCache {
+		var cacheResult string
+		var ok bool
+		cacheResult, ok, slot = App.Cache.Get(table, q.cacheKey)
 		if ok {
 			LogDebug("STORE", true, "Returning from cache, using key: "+q.cacheKey, nil)
-			return cacheResult.(string), nil
+			return cacheResult, nil
 		}
 	}
 
@@ -39,9 +45,9 @@ func (s store) Execute(q *Query) (string, error) {
 	}
 
 	//Set the cache if a cache key has been provided
-	if q.cacheKey != "" {
+	if useCache {
 		LogDebug("STORE", true, "Caching result with key: "+q.cacheKey, nil)
-		App.Cache.Set(q.cacheKey, JSONResponse)
+		App.Cache.Set(slot, JSONResponse, ttl)
 	}
 	return JSONResponse, nil
 
@@ -0,0 +1,269 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pressly/chi"
+)
+
+//sqlToCheckSubscription checks that a role can read a table, and whether row level
+//security means it might not see every record
+const sqlToCheckSubscription = `SELECT has_table_privilege($1, c.oid, 'SELECT'), c.relrowsecurity
+	FROM pg_class c WHERE c.oid = to_regclass($2);`
+
+//Keep alive timings for subscription connections
+const (
+	subscriptionPingInterval = 30 * time.Second
+	subscriptionPongWait     = 60 * time.Second
+	subscriptionWriteWait    = 10 * time.Second
+)
+
+//subscriptionRequest is a message from a client, subscribing to changes to a table
+//or unsubscribing.  The ID is chosen by the client, to tell its subscriptions apart
+type subscriptionRequest struct {
+	Action string            `json:"action"`
+	ID     int               `json:"id"`
+	Schema string            `json:"schema"`
+	Table  string            `json:"table"`
+	Filter map[string]string `json:"filter"`
+}
+
+//subscriptionMessage is a message to a client: "subscribed", "unsubscribed", "error"
+//or "change", which carries the event and the IDs of the subscriptions it matched
+type subscriptionMessage struct {
+	Type    string       `json:"type"`
+	ID      int          `json:"id,omitempty"`
+	IDs     []int        `json:"ids,omitempty"`
+	Message string       `json:"message,omitempty"`
+	Event   *ChangeEvent `json:"event,omitempty"`
+}
+
+//ActivateSubscriptions adds the /subscribe websocket, over which clients subscribe to
+//changes to tables.  Pass the middleware which authenticates the request and sets the
+//'role' on the request context (browsers can't set headers on a websocket, so it will
+//need to accept a token in the query string).  Clients can only subscribe to tables
+//their role can read, and only see the ID of records changed in tables with row level security
+func ActivateSubscriptions(authentication ...func(http.Handler) http.Handler) {
+
+	App.Router.Route("/subscribe", func(r chi.Router) {
+		for _, m := range authentication {
+			r.Use(m)
+		}
+		r.Get("/", subscribe)
+	})
+
+}
+
+//subscriptionUpgrader accepts websockets from the same origin, or from the CORS
+//allowed origins if CORS is on
+var subscriptionUpgrader = websocket.Upgrader{
+	CheckOrigin: checkSubscriptionOrigin,
+}
+
+func checkSubscriptionOrigin(r *http.Request) bool {
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	if App.Config().ActivateCors {
+		for _, allowed := range App.Config().CorsAllowedOrigins {
+			if allowed == "*" || strings.EqualFold(allowed, origin) {
+				return true
+			}
+		}
+	}
+
+	return false
+
+}
+
+//subscribe upgrades the request to a websocket and relays changes on the topics the
+//client subscribes to until either side closes the connection
+func subscribe(w http.ResponseWriter, r *http.Request) {
+
+	role, _ := r.Context().Value("role").(string)
+	if role == "" {
+		WriteError(w, http.StatusUnauthorized, "Subscribing requires authentication")
+		return
+	}
+
+	conn, err := subscriptionUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		//The upgrader has already responded
+		return
+	}
+	defer conn.Close()
+
+	subscriber := newChangeSubscriber()
+	defer subscriber.close()
+
+	//Replies to requests go through the writing loop below, which owns the connection
+	replies := make(chan subscriptionMessage, 16)
+	done, stopped := make(chan struct{}), make(chan struct{})
+	defer close(stopped)
+
+	go func() {
+		defer close(done)
+		readSubscriptionRequests(conn, role, subscriber, replies, stopped)
+	}()
+
+	ping := time.NewTicker(subscriptionPingInterval)
+	defer ping.Stop()
+
+	for {
+
+		var message interface{}
+
+		select {
+
+		case <-done:
+			return
+
+		case reply := <-replies:
+			message = reply
+
+		case change, ok := <-subscriber.events:
+			if !ok {
+				conn.SetWriteDeadline(time.Now().Add(subscriptionWriteWait))
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "Not keeping up with changes"))
+				return
+			}
+			event := change.Event
+			message = subscriptionMessage{Type: "change", IDs: change.Subscriptions, Event: &event}
+
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(subscriptionWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+			continue
+
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(subscriptionWriteWait))
+		if err := conn.WriteJSON(message); err != nil {
+			return
+		}
+
+	}
+
+}
+
+//readSubscriptionRequests handles the client's requests until the connection closes,
+//or the writing loop has stopped
+func readSubscriptionRequests(conn *websocket.Conn, role string, subscriber *changeSubscriber, replies chan<- subscriptionMessage, stopped <-chan struct{}) {
+
+	conn.SetReadLimit(4096)
+	conn.SetReadDeadline(time.Now().Add(subscriptionPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(subscriptionPongWait))
+	})
+
+	for {
+
+		var req subscriptionRequest
+		var reply subscriptionMessage
+
+		err := conn.ReadJSON(&req)
+		switch err.(type) {
+		case nil:
+			reply = handleSubscriptionRequest(role, subscriber, req)
+		case *json.SyntaxError, *json.UnmarshalTypeError:
+			reply = subscriptionMessage{Type: "error", Message: "Invalid request: " + err.Error()}
+		default:
+			//The connection has closed
+			return
+		}
+
+		select {
+		case replies <- reply:
+		case <-stopped:
+			return
+		}
+
+	}
+
+}
+
+//handleSubscriptionRequest subscribes or unsubscribes, returning the reply to send
+func handleSubscriptionRequest(role string, subscriber *changeSubscriber, req subscriptionRequest) subscriptionMessage {
+
+	switch req.Action {
+
+	case "subscribe":
+		topic, err := subscriptionTopic(role, req)
+		if err != nil {
+			return subscriptionMessage{Type: "error", ID: req.ID, Message: err.Error()}
+		}
+		subscriber.subscribe(req.ID, topic)
+		return subscriptionMessage{Type: "subscribed", ID: req.ID}
+
+	case "unsubscribe":
+		if !subscriber.unsubscribe(req.ID) {
+			return subscriptionMessage{Type: "error", ID: req.ID, Message: "No such subscription"}
+		}
+		return subscriptionMessage{Type: "unsubscribed", ID: req.ID}
+
+	}
+
+	return subscriptionMessage{Type: "error", ID: req.ID, Message: "Unknown action '" + req.Action + "'. Use subscribe or unsubscribe"}
+
+}
+
+//subscriptionTopic checks a subscription request against what the role may read
+func subscriptionTopic(role string, req subscriptionRequest) (changeTopic, error) {
+
+	if !IsValidIdentifier(req.Schema) || !IsValidIdentifier(req.Table) {
+		return changeTopic{}, errors.New("Invalid schema or table")
+	}
+	if !IsValidIdentifier(role) {
+		return changeTopic{}, errors.New("Invalid role '" + role + "'")
+	}
+
+	var canRead, rowSecurity bool
+	if err := App.DB.QueryRow(sqlToCheckSubscription, role, req.Schema+"."+req.Table).Scan(&canRead, &rowSecurity); err != nil || !canRead {
+		return changeTopic{}, errors.New("No such table, or no permission to read it")
+	}
+
+	if rowSecurity && len(req.Filter) > 0 {
+		return changeTopic{}, errors.New("Changes to " + req.Schema + "." + req.Table + " can't be filtered, since it has row level security")
+	}
+
+	return changeTopic{
+		Schema:     req.Schema,
+		Table:      req.Table,
+		Filter:     req.Filter,
+		WithRecord: !rowSecurity,
+	}, nil
+
+}
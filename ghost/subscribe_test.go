@@ -0,0 +1,59 @@
+package ghost
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckSubscriptionOrigin(t *testing.T) {
+
+	defer changeConfig(func(c *config) {
+		c.ActivateCors = true
+		c.CorsAllowedOrigins = []string{"https://app.example.com"}
+	})()
+
+	testCases := []struct {
+		origin string
+		exp    bool
+	}{
+		{"", true},
+		{"https://api.example.com", true},
+		{"https://app.example.com", true},
+		{"https://evil.example.com", false},
+	}
+
+	for _, c := range testCases {
+		r := httptest.NewRequest("GET", "https://api.example.com/subscribe", nil)
+		r.Header.Set("Origin", c.origin)
+		if got := checkSubscriptionOrigin(r); got != c.exp {
+			TestErrorFatal(t, c.origin, boolString(got), boolString(c.exp))
+		}
+	}
+
+}
+
+func TestHandleSubscriptionRequest(t *testing.T) {
+
+	s := newChangeSubscriber()
+	defer s.close()
+	s.subscribe(1, changeTopic{Schema: "shop", Table: "orders"})
+
+	testCases := []struct {
+		desc string
+		req  subscriptionRequest
+		exp  string
+	}{
+		{"Unsubscribe", subscriptionRequest{Action: "unsubscribe", ID: 1}, "unsubscribed "},
+		{"Unsubscribe again", subscriptionRequest{Action: "unsubscribe", ID: 1}, "error No such subscription"},
+		{"Invalid table", subscriptionRequest{Action: "subscribe", ID: 2, Schema: "shop", Table: "orders; DROP"}, "error Invalid schema or table"},
+		{"Unknown action", subscriptionRequest{Action: "listen", ID: 3}, "error Unknown action 'listen'. Use subscribe or unsubscribe"},
+	}
+
+	for _, c := range testCases {
+		reply := handleSubscriptionRequest("web", s, c.req)
+		if got := reply.Type + " " + reply.Message; got != c.exp {
+			TestErrorFatal(t, c.desc, got, c.exp)
+		}
+	}
+
+}
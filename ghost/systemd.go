@@ -0,0 +1,78 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/coreos/go-systemd/activation"
+	"github.com/coreos/go-systemd/daemon"
+)
+
+//systemdListeners returns the sockets passed in by systemd socket activation, if any.
+//The first is used for the server and the second, if there is one, for the HTTP redirect server
+func systemdListeners() ([]net.Listener, error) {
+
+	listeners, err := activation.Listeners(true)
+	if err != nil {
+		return nil, err
+	}
+
+	var usable []net.Listener
+	for _, l := range listeners {
+		if l != nil {
+			usable = append(usable, l)
+		}
+	}
+
+	return usable, nil
+
+}
+
+//notifySystemd tells systemd the server is ready and, if the unit has WatchdogSec set,
+//keeps the watchdog fed for as long as the database can be reached, so that systemd
+//restarts a server which has lost it.  Outside of systemd, this does nothing
+func notifySystemd(ctx context.Context) {
+
+	if sent, err := daemon.SdNotify(false, "READY=1"); err != nil {
+		Log("SERVE", false, "Could not notify systemd", err)
+	} else if sent {
+		Log("SERVE", true, "Notified systemd that the server is ready", nil)
+	}
+
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil || interval == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				daemon.SdNotify(false, "STOPPING=1")
+				return
+			case <-ticker.C:
+				if App.DB == nil || App.DB.PingContext(ctx) == nil {
+					daemon.SdNotify(false, "WATCHDOG=1")
+				}
+			}
+		}
+	}()
+
+}
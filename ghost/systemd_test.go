@@ -0,0 +1,30 @@
+package ghost
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestSystemdListeners(t *testing.T) {
+
+	testCases := []struct {
+		description, pid string
+	}{
+		{"Not started by systemd", ""},
+		{"Sockets meant for another process", strconv.Itoa(os.Getpid() + 1)},
+	}
+
+	for _, c := range testCases {
+		os.Setenv("LISTEN_PID", c.pid)
+		os.Setenv("LISTEN_FDS", "1")
+		listeners, err := systemdListeners()
+		if err != nil || len(listeners) != 0 {
+			TestErrorFatal(t, c.description, strconv.Itoa(len(listeners)), "0")
+		}
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"database/sql"
+	"sync/atomic"
+)
+
+//SetupTestServer gets the application ready to handle requests in tests, without a config
+//file or the server role.  It uses the default settings, keeps files and cached results
+//in memory and runs queries on the given connection.  The core routes go on a new router,
+//so each test activates just the routes it needs.  The ghosttest package uses it with a
+//connection to a throwaway schema
+func SetupTestServer(db *sql.DB) error {
+
+	c := Defaults
+	c.Cache = cacheMemory
+	c.Storage = storageMemory
+	App.SetConfig(c)
+	App.configure()
+
+	App.Router = newRouter()
+	App.DB = db
+
+	if err := setupJobQueue(db); err != nil {
+		return err
+	}
+	if err := setupChangeFeed(db); err != nil {
+		return err
+	}
+	if err := setupAuditTrail(db); err != nil {
+		return err
+	}
+
+	setCoreRoutes()
+	if err := runServeHooks(); err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&bundlesLoaded, 1)
+	return nil
+
+}
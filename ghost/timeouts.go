@@ -0,0 +1,83 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//Timeout groups.  Requests are put in the read or write group by method,
+//unless the timeoutRoutes setting puts their path in a group of its own
+const (
+	timeoutRead  = "read"
+	timeoutWrite = "write"
+)
+
+//timeoutFor works out how long a request may take: the timeout of the group for the
+//longest matching prefix in timeoutRoutes, or of the read or write group.
+//Groups without a timeout fall back to the general timeout setting
+func (c config) timeoutFor(r *http.Request) time.Duration {
+
+	group := timeoutWrite
+	if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+		group = timeoutRead
+	}
+
+	longest := 0
+	for prefix, g := range c.TimeoutRoutes {
+		if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) > longest {
+			group, longest = g, len(prefix)
+		}
+	}
+
+	if seconds, ok := c.Timeouts[group]; ok {
+		return time.Duration(seconds) * time.Second
+	}
+
+	return time.Duration(c.Timeout) * time.Second
+
+}
+
+//routeTimeouts cancels the request context once the request has taken longer than
+//its timeout (see timeoutFor) and responds with 504 Gateway Timeout.  Handlers must
+//watch ctx.Done() - or pass the context on to the database - for this to have any effect.
+//A timeout of 0 means no timeout.  Websockets and event streams are left alone, since they stay open
+func routeTimeouts(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		timeout := App.Config().timeoutFor(r)
+		if timeout <= 0 || websocket.IsWebSocketUpgrade(r) || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer func() {
+			cancel()
+			if ctx.Err() == context.DeadlineExceeded {
+				w.WriteHeader(http.StatusGatewayTimeout)
+			}
+		}()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+	})
+}
@@ -0,0 +1,59 @@
+package ghost
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutFor(t *testing.T) {
+
+	c := config{
+		Timeout:  60,
+		Timeouts: map[string]int{"read": 10, "write": 30, "export": 300},
+		TimeoutRoutes: map[string]string{
+			"/shop/export":        "export",
+			"/shop/export/images": "image",
+		},
+	}
+
+	testCases := []struct {
+		method, path string
+		expected     time.Duration
+	}{
+		{"GET", "/shop/products", 10 * time.Second},
+		{"POST", "/shop/products", 30 * time.Second},
+		{"GET", "/shop/export/orders.csv", 300 * time.Second},
+		//Longest prefix wins, and groups without a timeout use the general one
+		{"GET", "/shop/export/images/1.png", 60 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		if got := c.timeoutFor(httptest.NewRequest(tc.method, tc.path, nil)); got != tc.expected {
+			TestErrorFatal(t, tc.method+" "+tc.path, got.String(), tc.expected.String())
+		}
+	}
+
+}
+
+func TestRouteTimeouts(t *testing.T) {
+
+	defer changeConfig(func(c *config) { c.Timeouts = map[string]int{"read": 0, "write": 1} })()
+
+	var deadline bool
+	handler := routeTimeouts(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, deadline = r.Context().Deadline()
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if deadline {
+		t.Error("A timeout of 0 should mean no deadline")
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+	if !deadline {
+		t.Error("Write requests should have a deadline")
+	}
+
+}
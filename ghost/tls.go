@@ -0,0 +1,107 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+//TLS modes
+const (
+	tlsOff      = ""
+	tlsFiles    = "files"
+	tlsAutocert = "autocert"
+)
+
+//tlsMode works out how HTTPS should be served from the config
+func (c config) tlsMode() (string, error) {
+
+	hasFiles := c.TLSCertFile != "" || c.TLSKeyFile != ""
+
+	switch {
+	case len(c.AutocertDomains) > 0 && hasFiles:
+		return tlsOff, errors.New("Use either tlsCertFile/tlsKeyFile or autocertDomains, not both")
+	case len(c.AutocertDomains) > 0:
+		return tlsAutocert, nil
+	case c.TLSCertFile == "" || c.TLSKeyFile == "":
+		if hasFiles {
+			return tlsOff, errors.New("Both tlsCertFile and tlsKeyFile must be set")
+		}
+		return tlsOff, nil
+	}
+
+	return tlsFiles, nil
+
+}
+
+//configureTLS sets up the server for HTTPS according to the config and returns the handler
+//for the HTTP redirect server.  When certificates come from Let's Encrypt, the redirect
+//server also answers its HTTP challenges
+func configureTLS(server *http.Server) (http.Handler, error) {
+
+	mode, err := App.Config().tlsMode()
+	if err != nil || mode == tlsOff {
+		return nil, err
+	}
+
+	redirect := http.HandlerFunc(redirectToHTTPS)
+
+	if mode == tlsFiles {
+		cert, err := tls.LoadX509KeyPair(App.Config().TLSCertFile, App.Config().TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		return redirect, nil
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(App.Config().AutocertDomains...),
+		Cache:      autocert.DirCache(App.Config().AutocertCacheDir),
+		Email:      App.Config().AutocertEmail,
+	}
+	server.TLSConfig = m.TLSConfig()
+
+	return m.HTTPHandler(redirect), nil
+
+}
+
+//redirectToHTTPS permanently redirects a request to the same URL over HTTPS on the API port
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+
+	http.Redirect(w, r, httpsURL(r.Host, App.Config().ApiPort, r.URL.RequestURI()), http.StatusMovedPermanently)
+
+}
+
+//httpsURL builds an HTTPS URL for a host, leaving out the port if it is the default
+func httpsURL(host, port, requestURI string) string {
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if port != "" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	return "https://" + host + requestURI
+
+}
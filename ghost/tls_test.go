@@ -0,0 +1,45 @@
+package ghost
+
+import "testing"
+
+func TestTLSMode(t *testing.T) {
+
+	testCases := []struct {
+		description string
+		c           config
+		expected    string
+		isError     bool
+	}{
+		{"No TLS", config{}, tlsOff, false},
+		{"Certificate files", config{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem"}, tlsFiles, false},
+		{"Let's Encrypt", config{AutocertDomains: []string{"example.com"}}, tlsAutocert, false},
+		{"Missing key", config{TLSCertFile: "cert.pem"}, tlsOff, true},
+		{"Both", config{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem", AutocertDomains: []string{"example.com"}}, tlsOff, true},
+	}
+
+	for _, c := range testCases {
+		mode, err := c.c.tlsMode()
+		if (err != nil) != c.isError || mode != c.expected {
+			TestErrorFatal(t, c.description, mode, c.expected)
+		}
+	}
+
+}
+
+func TestHTTPSURL(t *testing.T) {
+
+	testCases := []struct {
+		host, port, uri, expected string
+	}{
+		{"example.com", "443", "/shop?page=2", "https://example.com/shop?page=2"},
+		{"example.com:80", "443", "/", "https://example.com/"},
+		{"localhost:8080", "3000", "/api", "https://localhost:3000/api"},
+	}
+
+	for _, c := range testCases {
+		if got := httpsURL(c.host, c.port, c.uri); got != c.expected {
+			TestErrorFatal(t, c.host+c.uri, got, c.expected)
+		}
+	}
+
+}
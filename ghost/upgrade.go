@@ -0,0 +1,221 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"database/sql"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	sqlToGetBundleVersion    = `SELECT version FROM ghost_bundles WHERE name = $1;`
+	sqlToUpdateBundleVersion = `UPDATE ghost_bundles SET version = $2, updated_at = now() WHERE name = $1;`
+
+	//bundleMigrationsFolder holds a bundle's upgrade migrations.  Each file is named
+	//after the version it upgrades to, optionally followed by a description,
+	//e.g. 1.1.0.sql or 1.1.0_add_stock_levels.sql.  A file of the same name ending in
+	//.down.sql undoes the migration, e.g. 1.1.0_add_stock_levels.down.sql
+	bundleMigrationsFolder = "migrations"
+	downMigrationSuffix    = ".down.sql"
+)
+
+//bundleMigration is a single upgrade migration file, and the file which undoes it, if there is one
+type bundleMigration struct {
+	Version string
+	File    string
+	Down    string
+}
+
+//bundleMigrations returns a bundle's upgrade migrations, sorted by version
+func bundleMigrations(bundleName string) ([]bundleMigration, error) {
+
+	return parseMigrationFiles(sqlFilesInFolder(BundlePath(bundleName, bundleMigrationsFolder)))
+
+}
+
+//parseMigrationFiles reads the version from each migration file name and sorts them by version
+func parseMigrationFiles(files []string) ([]bundleMigration, error) {
+
+	var migrations []bundleMigration
+
+	downFiles := map[string]bool{}
+	for _, file := range files {
+		if strings.HasSuffix(file, downMigrationSuffix) {
+			downFiles[file] = true
+		}
+	}
+
+	for _, file := range files {
+		if downFiles[file] {
+			continue
+		}
+		version := strings.SplitN(strings.TrimSuffix(path.Base(file), ".sql"), "_", 2)[0]
+		if _, err := parseVersion(version); err != nil {
+			return nil, errors.New("Migration file '" + path.Base(file) + "' does not start with a version")
+		}
+		m := bundleMigration{Version: version, File: file}
+		if down := strings.TrimSuffix(file, ".sql") + downMigrationSuffix; downFiles[down] {
+			m.Down = down
+		}
+		migrations = append(migrations, m)
+	}
+
+	sort.SliceStable(migrations, func(i, j int) bool {
+		c, _ := CompareVersions(migrations[i].Version, migrations[j].Version)
+		return c < 0
+	})
+
+	return migrations, nil
+
+}
+
+//pendingMigrations returns the migrations needed to go from one version to another:
+//those above the from version, up to and including the to version
+func pendingMigrations(migrations []bundleMigration, from, to string) []bundleMigration {
+
+	var pending []bundleMigration
+
+	for _, m := range migrations {
+		aboveFrom, _ := CompareVersions(m.Version, from)
+		belowTo, _ := CompareVersions(m.Version, to)
+		if aboveFrom > 0 && belowTo <= 0 {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending
+
+}
+
+//BundleUpgrade describes the upgrade of an installed bundle to the version in the bundles folder
+type BundleUpgrade struct {
+	Bundle           string
+	InstalledVersion string
+	PackagedVersion  string
+	Migrations       []string
+}
+
+//UpToDate reports whether there is nothing to upgrade
+func (u BundleUpgrade) UpToDate() bool {
+
+	c, _ := CompareVersions(u.InstalledVersion, u.PackagedVersion)
+	return c >= 0
+
+}
+
+//PlanBundleUpgrade compares the installed version of a bundle with the version in
+//the bundles folder and lists the migrations needed to get from one to the other
+func PlanBundleUpgrade(db *sql.DB, bundleName string) (BundleUpgrade, error) {
+
+	upgrade := BundleUpgrade{Bundle: bundleName}
+
+	if err := db.QueryRow(sqlToGetBundleVersion, bundleName).Scan(&upgrade.InstalledVersion); err == sql.ErrNoRows {
+		return upgrade, errors.New("Bundle '" + bundleName + "' is not in the bundle registry. Reinstall it to start tracking its version")
+	} else if err != nil {
+		return upgrade, err
+	}
+
+	manifest, err := ReadBundleManifest(bundleName)
+	if err != nil {
+		return upgrade, err
+	}
+	upgrade.PackagedVersion = manifest.Version
+
+	c, err := CompareVersions(upgrade.InstalledVersion, upgrade.PackagedVersion)
+	if err != nil {
+		return upgrade, err
+	}
+	if c > 0 {
+		return upgrade, errors.New("Installed version " + upgrade.InstalledVersion + " of '" + bundleName + "' is newer than the packaged version " + upgrade.PackagedVersion + ". Downgrades are not supported")
+	}
+
+	migrations, err := bundleMigrations(bundleName)
+	if err != nil {
+		return upgrade, err
+	}
+
+	for _, m := range pendingMigrations(migrations, upgrade.InstalledVersion, upgrade.PackagedVersion) {
+		upgrade.Migrations = append(upgrade.Migrations, m.File)
+	}
+
+	return upgrade, nil
+
+}
+
+//UpgradeBundle upgrades an installed bundle to the version in the bundles folder by running
+//each of its migrations between the two versions and then applying the permissions in its
+//manifest, in a single transaction
+func UpgradeBundle(bundleName string) (BundleUpgrade, error) {
+
+	if !IsValidIdentifier(bundleName) {
+		return BundleUpgrade{}, errors.New("Invalid bundle name '" + bundleName + "'")
+	}
+
+	//Establish a temporary connection as the super user
+	db, err := SuperUserDBConfig.TryDBConnection("")
+	if err != nil {
+		return BundleUpgrade{}, err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(SQLToCreateBundleRegistry); err != nil {
+		return BundleUpgrade{}, err
+	}
+
+	upgrade, err := PlanBundleUpgrade(db, bundleName)
+	if err != nil || upgrade.UpToDate() {
+		return upgrade, err
+	}
+
+	manifest, err := ReadBundleManifest(bundleName)
+	if err != nil {
+		return upgrade, err
+	}
+
+	err = inTransaction(db, func(tx *sql.Tx) error {
+
+		if len(upgrade.Migrations) > 0 {
+			if err := runBundleFiles(tx, bundleName, upgrade.Migrations); err != nil {
+				return err
+			}
+		}
+
+		//Migrations may add tables, so the declared permissions are applied again
+		if err := applyBundlePermissions(tx, bundleName, manifest); err != nil {
+			return err
+		}
+
+		//And new tables need auditing
+		if App.Config().Audit {
+			if err := auditBundleTables(tx, bundleName, true); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(sqlToUpdateBundleVersion, bundleName, upgrade.PackagedVersion)
+		return err
+
+	})
+	if err != nil {
+		return upgrade, err
+	}
+
+	Log("UPGRADE", true, "Bundle '"+bundleName+"' upgraded from "+upgrade.InstalledVersion+" to "+upgrade.PackagedVersion, nil)
+	return upgrade, nil
+
+}
@@ -0,0 +1,43 @@
+package ghost
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPendingMigrations(t *testing.T) {
+
+	migrations, err := parseMigrationFiles([]string{
+		"bundles/shop/migrations/1.10.0.sql",
+		"bundles/shop/migrations/1.2.0_add_stock.sql",
+		"bundles/shop/migrations/1.1.0.sql",
+		"bundles/shop/migrations/2.0.0_new_pricing.sql",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		description, from, to, expected string
+	}{
+		{"Everything after the installed version", "1.0.0", "2.0.0", "1.1.0,1.2.0,1.10.0,2.0.0"},
+		{"Installed version is not rerun", "1.1.0", "1.10.0", "1.2.0,1.10.0"},
+		{"Nothing beyond the packaged version", "1.2.0", "1.9.0", ""},
+		{"Up to date", "2.0.0", "2.0.0", ""},
+	}
+
+	for _, c := range testCases {
+		var versions []string
+		for _, m := range pendingMigrations(migrations, c.from, c.to) {
+			versions = append(versions, m.Version)
+		}
+		if strings.Join(versions, ",") != c.expected {
+			TestErrorFatal(t, c.description, strings.Join(versions, ","), c.expected)
+		}
+	}
+
+	if _, err := parseMigrationFiles([]string{"bundles/shop/migrations/add_stock.sql"}); err == nil {
+		t.Error("Migration files without a version should be rejected")
+	}
+
+}
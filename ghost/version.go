@@ -0,0 +1,50 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"net/http"
+	"runtime"
+)
+
+//Build metadata, set when building with e.g.
+//  go build -ldflags "-X github.com/jpincas/ghost/ghost.Version=1.2.0 -X github.com/jpincas/ghost/ghost.Commit=$(git rev-parse --short HEAD) -X github.com/jpincas/ghost/ghost.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+//VersionHeader is the response header which carries the version string
+const VersionHeader = "X-Ghost-Version"
+
+//VersionString describes the build, e.g. "1.2.0 (commit 8f2c087, built 2017-06-01T12:00:00Z, go1.8)"
+func VersionString() string {
+
+	return Version + " (commit " + Commit + ", built " + BuildDate + ", " + runtime.Version() + ")"
+
+}
+
+//versionHeader adds the version string to every response, so that the version each
+//server in a fleet is running can be audited from outside
+func versionHeader(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if App.Config().VersionHeader {
+			w.Header().Set(VersionHeader, VersionString())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
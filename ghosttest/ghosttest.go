@@ -0,0 +1,383 @@
+// Copyright 2017 Jonathan Pincas
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//Package ghosttest runs the whole ghost router against a throwaway database schema,
+//so that handlers can be tested with real requests:
+//
+//	func TestOrders(t *testing.T) {
+//		h := ghosttest.New(t)
+//		defer h.Close()
+//		h.Exec(`CREATE TABLE orders (id serial PRIMARY KEY, total numeric)`)
+//		h.Seed("orders", map[string]interface{}{"total": 10})
+//		ghost.ActivateFiles(h.Authenticate)
+//		rec := h.AuthRequest("admin", "alice", "PUT", "/files/orders.csv", "id,total")
+//		...
+//	}
+package ghosttest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/viper"
+)
+
+//DBEnvVar holds the connection string of the database to test against, e.g.
+//postgres://postgres@localhost/testdb?sslmode=disable.  Connect as the super user, to a
+//database set up with 'ghost init' so that the built in roles are there.  Tests using
+//the harness are skipped when it isn't set
+const DBEnvVar = "GHOST_TEST_DB"
+
+//Harness is a ghost application set up for tests
+type Harness struct {
+	//Schema is the throwaway schema, which comes first on the search path, so tables
+	//created without a schema go in it
+	Schema string
+	//DB is the application's connection.  It is a super user connection, so grant roles
+	//any privileges the handlers being tested need
+	DB *sql.DB
+
+	t *testing.T
+}
+
+//sqlToCreateUsersTable gives the throwaway schema its own users table, in front of the
+//one in public, for the users tokens are made for.  IDs are text rather than uuid,
+//so that tests can use readable ones
+const sqlToCreateUsersTable = `CREATE TABLE users (id text PRIMARY KEY, email varchar(256) UNIQUE, role varchar(16) NOT NULL DEFAULT 'anon')`
+
+//sqlToSetUserRole adds a user with a role, or changes the role of an existing one
+const sqlToSetUserRole = `INSERT INTO users (id, role) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET role = $2`
+
+//sqlToGetUserRole looks up a user's role, as the auth package does
+const sqlToGetUserRole = `SELECT role FROM users WHERE id = $1`
+
+//New creates a throwaway schema and sets the application up to use it, with the core
+//routes on a fresh router.  Activate any other routes the test needs afterwards, and
+//Close the harness when done
+func New(t *testing.T) *Harness {
+
+	dsn := os.Getenv(DBEnvVar)
+	if dsn == "" {
+		t.Skip(DBEnvVar + " is not set, so there is no database to test against")
+	}
+
+	h := &Harness{
+		Schema: "ghosttest_" + ghost.RandomString(12),
+		t:      t,
+	}
+
+	db, err := sql.Open("postgres", withSearchPath(dsn, h.Schema))
+	if err != nil {
+		t.Fatalf("Could not connect to the test database: %s", err)
+	}
+	h.DB = db
+
+	if _, err := db.Exec("CREATE SCHEMA " + h.Schema); err != nil {
+		db.Close()
+		t.Fatalf("Could not create the test schema: %s", err)
+	}
+	if _, err := db.Exec(sqlToCreateUsersTable); err != nil {
+		h.Close()
+		t.Fatalf("Could not create the test users table: %s", err)
+	}
+
+	//The application signs and checks tokens with this secret, and so do harness tokens
+	viper.Set("secret", hex.EncodeToString(randomSecret()))
+
+	if err := ghost.SetupTestServer(db); err != nil {
+		h.Close()
+		t.Fatalf("Could not set up the application: %s", err)
+	}
+
+	return h
+
+}
+
+//Close drops the throwaway schema, and everything in it
+func (h *Harness) Close() {
+
+	if _, err := h.DB.Exec("DROP SCHEMA IF EXISTS " + h.Schema + " CASCADE"); err != nil {
+		h.t.Errorf("Could not drop the test schema %s: %s", h.Schema, err)
+	}
+	h.DB.Close()
+	ghost.App.DB = nil
+
+}
+
+//Exec runs SQL in the throwaway schema, failing the test if it doesn't work
+func (h *Harness) Exec(query string, args ...interface{}) {
+
+	if _, err := h.DB.Exec(query, args...); err != nil {
+		h.t.Fatalf("Could not run %s: %s", query, err)
+	}
+
+}
+
+//Seed inserts rows into a table.  Objects and arrays are stored as JSON
+func (h *Harness) Seed(table string, rows ...map[string]interface{}) {
+
+	for _, row := range rows {
+		query, args, err := insertQuery(table, row)
+		if err != nil {
+			h.t.Fatalf("Could not seed %s: %s", table, err)
+		}
+		h.Exec(query, args...)
+	}
+
+}
+
+//Token makes the user a user with the given role, in the throwaway schema, and returns a
+//token for them for the Authorization header.  It is signed with the application's
+//secret and carries the user ID, as the tokens the auth package gives out do, so the
+//auth middleware accepts it and looks the role up in the users table
+func (h *Harness) Token(role, userID string) string {
+
+	h.Exec(sqlToSetUserRole, userID, role)
+	return token(userID)
+
+}
+
+//Authenticate is middleware which does what the auth package's middleware does: it checks
+//the token is signed with the application's secret and puts its user ID, and the user's
+//role from the users table, on the request context.  Unknown users and requests without a
+//token are anon.  Pass it to the Activate functions, e.g. ghost.ActivateEmailAPI(h.Authenticate)
+func (h *Harness) Authenticate(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		role, userID := "anon", ""
+
+		if t := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); t != "" {
+
+			var err error
+			if userID, err = verify(t); err != nil {
+				ghost.WriteError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+
+			err = h.DB.QueryRow(sqlToGetUserRole, userID).Scan(&role)
+			if err == sql.ErrNoRows {
+				role = "anon"
+			} else if err != nil {
+				ghost.WriteError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+
+		}
+
+		ctx := context.WithValue(r.Context(), "role", role)
+		ctx = context.WithValue(ctx, "userID", userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+	})
+
+}
+
+//Request sends an anonymous request through the router.  The body can be a string,
+//bytes, or anything else, which is sent as JSON
+func (h *Harness) Request(method, path string, body interface{}) *httptest.ResponseRecorder {
+
+	return h.Do(h.NewRequest(method, path, body))
+
+}
+
+//AuthRequest sends a request through the router as a user, who is given the role in the users table
+func (h *Harness) AuthRequest(role, userID, method, path string, body interface{}) *httptest.ResponseRecorder {
+
+	r := h.NewRequest(method, path, body)
+	r.Header.Set("Authorization", "Bearer "+h.Token(role, userID))
+	return h.Do(r)
+
+}
+
+//NewRequest builds a request, for tests which need to set headers of their own before calling Do
+func (h *Harness) NewRequest(method, path string, body interface{}) *http.Request {
+
+	var reader io.Reader
+	isJSON := false
+
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			h.t.Fatalf("Could not encode the body of %s %s: %s", method, path, err)
+		}
+		reader = bytes.NewReader(data)
+		isJSON = true
+	}
+
+	r := httptest.NewRequest(method, path, reader)
+	if isJSON {
+		r.Header.Set("Content-Type", ghost.ContentTypeJSON)
+	}
+
+	return r
+
+}
+
+//Do sends a request through the router and records the response
+func (h *Harness) Do(r *http.Request) *httptest.ResponseRecorder {
+
+	rec := httptest.NewRecorder()
+	ghost.App.Router.ServeHTTP(rec, r)
+	return rec
+
+}
+
+//token returns an HS256 token for a user, signed with the application's secret
+func token(userID string) string {
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"userID": userID,
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	})
+
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sign(unsigned))
+
+}
+
+//sign signs with the secret as the application does, as the bytes of the string in viper
+func sign(unsigned string) []byte {
+
+	mac := hmac.New(sha256.New, []byte(viper.GetString("secret")))
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+
+}
+
+//verify checks a token is signed with the application's secret, returning its user ID
+func verify(token string) (string, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("Malformed token")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, sign(parts[0]+"."+parts[1])) {
+		return "", errors.New("Invalid token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("Malformed token")
+	}
+
+	var claims struct {
+		UserID interface{} `json:"userID"`
+		Exp    int64       `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.UserID == nil {
+		return "", errors.New("Malformed token")
+	}
+	if claims.Exp != 0 && claims.Exp < time.Now().Unix() {
+		return "", errors.New("Token has expired")
+	}
+
+	return fmt.Sprint(claims.UserID), nil
+
+}
+
+//insertQuery builds an insert of a row, with its columns in name order
+func insertQuery(table string, row map[string]interface{}) (string, []interface{}, error) {
+
+	for _, part := range strings.Split(table, ".") {
+		if !ghost.IsValidIdentifier(part) {
+			return "", nil, errors.New("Invalid table '" + table + "'")
+		}
+	}
+
+	var cols []string
+	for col := range row {
+		if !ghost.IsValidIdentifier(col) {
+			return "", nil, errors.New("Invalid column '" + col + "'")
+		}
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	placeholders := make([]string, len(cols))
+	args := make([]interface{}, len(cols))
+	for k, col := range cols {
+		placeholders[k] = "$" + strconv.Itoa(k+1)
+		args[k] = row[col]
+		switch row[col].(type) {
+		case map[string]interface{}, []interface{}:
+			b, err := json.Marshal(row[col])
+			if err != nil {
+				return "", nil, err
+			}
+			args[k] = string(b)
+		}
+	}
+
+	if len(cols) == 0 {
+		return "INSERT INTO " + table + " DEFAULT VALUES", nil, nil
+	}
+
+	return "INSERT INTO " + table + " (" + strings.Join(cols, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")", args, nil
+
+}
+
+//withSearchPath adds the schema to the front of the search path in a connection string,
+//which can be a URL or key=value pairs
+func withSearchPath(dsn, schema string) string {
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if u, err := url.Parse(dsn); err == nil {
+			q := u.Query()
+			q.Set("search_path", schema+",public")
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+
+	return dsn + " search_path=" + schema + ",public"
+
+}
+
+func randomSecret() []byte {
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+
+}
@@ -0,0 +1,139 @@
+package ghosttest
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jpincas/ghost/ghost"
+	"github.com/spf13/viper"
+)
+
+func TestAuthenticate(t *testing.T) {
+
+	h := &Harness{t: t}
+
+	viper.Set("secret", "another app's secret")
+	otherToken := token("42")
+	viper.Set("secret", hex.EncodeToString(randomSecret()))
+
+	//Tokens are signed with the application's secret and carry the user ID
+	if userID, err := verify(token("42")); err != nil || userID != "42" {
+		ghost.TestErrorFatal(t, "Tokens carry the user", userID, "42")
+	}
+
+	whoami := h.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Context().Value("role").(string) + " " + r.Context().Value("userID").(string)))
+	}))
+
+	//Looking up the role of users with valid tokens needs the database, so is in TestHarness
+	testCases := []struct {
+		description, authorization string
+		status                     int
+		body                       string
+	}{
+		{"No token is anon", "", http.StatusOK, "anon "},
+		{"Tokens signed with another secret are refused", "Bearer " + otherToken, http.StatusUnauthorized, ""},
+		{"Malformed tokens are refused", "Bearer nonsense", http.StatusUnauthorized, ""},
+	}
+
+	for _, c := range testCases {
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.authorization != "" {
+			r.Header.Set("Authorization", c.authorization)
+		}
+		rec := httptest.NewRecorder()
+		whoami.ServeHTTP(rec, r)
+		if rec.Code != c.status || (c.status == http.StatusOK && rec.Body.String() != c.body) {
+			ghost.TestErrorFatal(t, c.description, strconv.Itoa(rec.Code)+" "+rec.Body.String(), strconv.Itoa(c.status)+" "+c.body)
+		}
+	}
+
+}
+
+func TestInsertQuery(t *testing.T) {
+
+	testCases := []struct {
+		table string
+		row   map[string]interface{}
+		exp   string
+	}{
+		{"orders", map[string]interface{}{"total": 10, "customer": "Bob"}, "INSERT INTO orders (customer, total) VALUES ($1, $2)"},
+		{"shop.orders", map[string]interface{}{}, "INSERT INTO shop.orders DEFAULT VALUES"},
+		{"orders; DROP TABLE users", nil, "error"},
+		{"orders", map[string]interface{}{"total)": 10}, "error"},
+	}
+
+	for _, c := range testCases {
+		got, _, err := insertQuery(c.table, c.row)
+		if err != nil {
+			got = "error"
+		}
+		if got != c.exp {
+			ghost.TestErrorFatal(t, c.table, got, c.exp)
+		}
+	}
+
+}
+
+func TestWithSearchPath(t *testing.T) {
+
+	testCases := []struct {
+		dsn, exp string
+	}{
+		{"postgres://postgres@localhost/testdb?sslmode=disable", "postgres://postgres@localhost/testdb?search_path=ghosttest_a%2Cpublic&sslmode=disable"},
+		{"user=postgres dbname=testdb", "user=postgres dbname=testdb search_path=ghosttest_a,public"},
+	}
+
+	for _, c := range testCases {
+		if got := withSearchPath(c.dsn, "ghosttest_a"); got != c.exp {
+			ghost.TestErrorFatal(t, c.dsn, got, c.exp)
+		}
+	}
+
+}
+
+//TestHarness runs against the database in GHOST_TEST_DB, and is skipped without one
+func TestHarness(t *testing.T) {
+
+	h := New(t)
+	defer h.Close()
+
+	h.Exec(`CREATE TABLE orders (id serial PRIMARY KEY, total numeric, items jsonb)`)
+	h.Seed("orders", map[string]interface{}{"total": 10, "items": []interface{}{"pen"}}, map[string]interface{}{"total": 20})
+
+	var count int
+	if err := h.DB.QueryRow(`SELECT count(*) FROM orders`).Scan(&count); err != nil || count != 2 {
+		ghost.TestErrorFatal(t, "Seeded rows are in the throwaway schema", strconv.Itoa(count), "2")
+	}
+
+	ghost.ActivateFiles(h.Authenticate)
+
+	testCases := []struct {
+		description string
+		rec         *httptest.ResponseRecorder
+		status      int
+	}{
+		{"Core routes are there", h.Request("GET", "/readyz", nil), http.StatusOK},
+		{"Anonymous users can't upload", h.Request("PUT", "/files/orders.csv", "id,total"), http.StatusForbidden},
+		{"Admins can upload", h.AuthRequest("admin", "alice", "PUT", "/files/orders.csv", "id,total"), http.StatusCreated},
+		{"Other roles can't", h.AuthRequest("shop", "bob", "PUT", "/files/orders.csv", "id,total"), http.StatusForbidden},
+		{"Unknown users are anon", h.Do(authorized(h.NewRequest("PUT", "/files/orders.csv", "id,total"), token("carol"))), http.StatusForbidden},
+		{"Uploaded files can be read", h.Request("GET", "/files/orders.csv", nil), http.StatusOK},
+	}
+
+	for _, c := range testCases {
+		if c.rec.Code != c.status {
+			ghost.TestErrorFatal(t, c.description, strconv.Itoa(c.rec.Code)+" "+strings.TrimSpace(c.rec.Body.String()), strconv.Itoa(c.status))
+		}
+	}
+
+}
+
+func authorized(r *http.Request, token string) *http.Request {
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}